@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ask-23/go-wx/pkg/config"
+	"github.com/ask-23/go-wx/pkg/gw1000"
+)
+
+// runDiscover implements the discover command, which lists the Ecowitt
+// gateways on the local network and can write one into collector.device
+func runDiscover(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("discover", flag.ContinueOnError)
+	configFile := flags.String("config", "config/config.yaml", "Path to the configuration file to write the device to")
+	timeout := flags.Duration("timeout", 3*time.Second, "How long to wait for devices to answer")
+	address := flags.String("address", gw1000.DiscoveryAddress, "Address to broadcast the discovery request to")
+	write := flags.Bool("write", false, "Write the device into collector.device of the configuration file")
+	choice := flags.String("device", "", "MAC or IP address of the device to write when several answer")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	devices, err := gw1000.Discover(*address, *timeout)
+	if err != nil {
+		return err
+	}
+	if len(devices) == 0 {
+		return fmt.Errorf("no devices answered within %v", *timeout)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MAC\tIP\tPORT\tSSID\tFIRMWARE")
+	for _, d := range devices {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", d.MAC, d.IP, d.Port, d.SSID, d.Firmware)
+	}
+	w.Flush()
+
+	if !*write {
+		return nil
+	}
+
+	device, err := chooseDevice(devices, *choice)
+	if err != nil {
+		return err
+	}
+
+	// The port is only written when the device does not use the default
+	addr := device.IP
+	if device.Port != gw1000.DefaultPort {
+		addr = device.Address()
+	}
+	if err := config.UpdateDevice(*configFile, addr, device.Model()); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Wrote %s at %s to %s\n", device.MAC, addr, *configFile)
+
+	return nil
+}
+
+// chooseDevice returns the device matching choice by MAC or IP address, or
+// the only device when choice is empty
+func chooseDevice(devices []gw1000.Device, choice string) (gw1000.Device, error) {
+	if choice == "" {
+		if len(devices) > 1 {
+			return gw1000.Device{}, fmt.Errorf("%d devices answered; choose one with --device", len(devices))
+		}
+		return devices[0], nil
+	}
+
+	for _, d := range devices {
+		if strings.EqualFold(d.MAC, choice) || d.IP == choice || d.Address() == choice {
+			return d, nil
+		}
+	}
+	return gw1000.Device{}, fmt.Errorf("no device %s answered", strconv.Quote(choice))
+}
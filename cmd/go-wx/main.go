@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/ask-23/go-wx/internal/models"
+	"github.com/ask-23/go-wx/pkg/config"
+	"github.com/ask-23/go-wx/pkg/database"
+	"github.com/ask-23/go-wx/pkg/gw1000"
+	"github.com/ask-23/go-wx/pkg/interceptor"
+	"github.com/ask-23/go-wx/pkg/publisher"
+	"github.com/ask-23/go-wx/pkg/server"
+	"github.com/ask-23/go-wx/pkg/supervisor"
+	"github.com/ask-23/go-wx/pkg/tempest"
+	"github.com/ask-23/go-wx/pkg/weatherlink"
+)
+
+func main() {
+	// Subcommands take their own flags
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "replay":
+			if err := runReplay(os.Args[2:], os.Stdout); err != nil {
+				log.Fatalf("go-wx replay: %v", err)
+			}
+			return
+		case "discover":
+			if err := runDiscover(os.Args[2:], os.Stdout); err != nil {
+				log.Fatalf("go-wx discover: %v", err)
+			}
+			return
+		}
+	}
+
+	configFile := flag.String("config", "config/config.yaml", "Path to the configuration file")
+	flag.Parse()
+
+	if err := run(*configFile); err != nil {
+		log.Fatalf("go-wx: %v", err)
+	}
+}
+
+// run wires every subsystem together and blocks until a shutdown signal is received
+func run(configFile string) error {
+	// Load the configuration
+	cfg, err := config.LoadConfig(configFile)
+	if err != nil {
+		return err
+	}
+
+	// Send log output to the configured file as well as stdout
+	if cfg.Logging.File != "" {
+		logFile, err := openLogFile(cfg.Logging.File)
+		if err != nil {
+			return err
+		}
+		defer logFile.Close()
+		log.SetOutput(io.MultiWriter(os.Stdout, logFile))
+	}
+
+	log.Printf("Starting go-wx for station %q", cfg.Station.Name)
+
+	// Connect to the database
+	db, err := database.NewDatabase(cfg.Database)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			log.Printf("Error closing database: %v", err)
+		}
+		log.Printf("Database connection closed")
+	}()
+
+	// Collect data from the weather station
+	icpt, err := interceptor.NewInterceptor(cfg.Collector, db)
+	if err != nil {
+		return fmt.Errorf("failed to create interceptor: %w", err)
+	}
+	components := []supervisor.Component{icpt}
+
+	// Or poll the device, storing its readings like uploads
+	pollInterval := time.Duration(cfg.Collector.Interval) * time.Second
+	if pollInterval <= 0 {
+		pollInterval = time.Minute
+	}
+	switch cfg.Collector.Type {
+	case "gw1000":
+		components = append(components, gw1000.NewPoller(gw1000.NewClient(cfg.Collector.Device.Address), icpt, pollInterval))
+	case "weatherlink":
+		wl := cfg.Collector.WeatherLink
+		client := weatherlink.NewClient(cfg.Collector.Device.Address)
+		components = append(components, weatherlink.NewPoller(client, icpt, pollInterval, wl.Transmitter, !wl.DisableRealTime))
+	}
+
+	// Receive the broadcasts of Tempest hubs, instead of or alongside uploads
+	if cfg.Collector.Type == "tempest" || cfg.Collector.Tempest.Enabled {
+		address := fmt.Sprintf(":%d", cfg.Collector.Tempest.Port)
+		components = append(components, tempest.NewListener(address, icpt, cfg.Station.Location.Altitude))
+	}
+
+	// Keep the device's customized upload pointed at the interceptor
+	if upload := cfg.Collector.Device.Upload; upload.Configure {
+		target, err := uploadTarget(cfg, icpt)
+		if err != nil {
+			return err
+		}
+		interval := time.Duration(upload.CheckInterval) * time.Second
+		components = append(components, gw1000.NewConfigurator(gw1000.NewClient(cfg.Collector.Device.Address), target, interval))
+	}
+
+	// Publish to external services
+	pubs, err := publisher.InitializePublishers(cfg.Publishers, db)
+	if err != nil {
+		return fmt.Errorf("failed to initialize publishers: %w", err)
+	}
+	for _, pub := range pubs {
+		components = append(components, pub)
+	}
+
+	// Serve the web interface
+	srv, err := server.NewServer(cfg.Server, weatherStations(cfg), db)
+	if err != nil {
+		return fmt.Errorf("failed to create web server: %w", err)
+	}
+	components = append(components, srv)
+
+	// Accept uploads on the web server when an ingest path is configured
+	for path, handler := range icpt.Routes() {
+		srv.Handle(path, handler)
+	}
+
+	// Run until a shutdown signal is received. The components are then shut
+	// down in the reverse order they were started, draining their work
+	// before the database is closed.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		<-ctx.Done()
+		log.Printf("Received shutdown signal, shutting down")
+	}()
+
+	return supervisor.New(shutdownTimeout, components...).Run(ctx)
+}
+
+// shutdownTimeout is how long each component is given to drain its work
+const shutdownTimeout = 30 * time.Second
+
+// weatherStations lists the stations shown by the web server. A single
+// station setup has only the default station, described by the station
+// section; otherwise each configured station mapping is listed.
+func weatherStations(cfg *config.Config) []models.WeatherStation {
+	if len(cfg.Collector.Stations) == 0 {
+		return []models.WeatherStation{{
+			ID:        models.DefaultStationID,
+			Name:      cfg.Station.Name,
+			Latitude:  cfg.Station.Location.Latitude,
+			Longitude: cfg.Station.Location.Longitude,
+			Altitude:  cfg.Station.Location.Altitude,
+		}}
+	}
+
+	stations := make([]models.WeatherStation, len(cfg.Collector.Stations))
+	for i, st := range cfg.Collector.Stations {
+		name := st.Name
+		if name == "" {
+			name = st.ID
+		}
+		stations[i] = models.WeatherStation{
+			ID:        st.ID,
+			Name:      name,
+			Latitude:  st.Location.Latitude,
+			Longitude: st.Location.Longitude,
+			Altitude:  st.Location.Altitude,
+		}
+	}
+	return stations
+}
+
+// uploadTarget returns the customized upload settings that point the device
+// at where the interceptor accepts the configured upload protocol
+func uploadTarget(cfg *config.Config, icpt *interceptor.Interceptor) (gw1000.CustomServer, error) {
+	upload := cfg.Collector.Device.Upload
+	port, path, ok := icpt.Endpoint(upload.Protocol)
+	if !ok {
+		return gw1000.CustomServer{}, fmt.Errorf("device upload protocol %s is not accepted by any listener or the ingest path", upload.Protocol)
+	}
+	if port == 0 {
+		port = cfg.Server.Port
+	}
+	if upload.Port != 0 {
+		port = upload.Port
+	}
+
+	target := gw1000.CustomServer{
+		Enabled:  true,
+		Protocol: upload.Protocol,
+		Host:     upload.Host,
+		Port:     port,
+		Interval: upload.Interval,
+	}
+	if upload.Protocol == gw1000.ProtocolWunderground {
+		target.WundergroundPath = path
+		// Send credentials the interceptor accepts, if it checks them
+		if creds := cfg.Collector.Device.Credentials; len(creds) > 0 {
+			target.ID, target.Password = creds[0].ID, creds[0].Password
+		}
+	} else {
+		target.EcowittPath = path
+	}
+	return target, nil
+}
+
+// openLogFile opens the log file for appending, creating its directory if needed
+func openLogFile(path string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log file: %w", err)
+	}
+
+	return f, nil
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ask-23/go-wx/internal/models"
+	"github.com/ask-23/go-wx/pkg/config"
+	"github.com/ask-23/go-wx/pkg/interceptor"
+	"gopkg.in/yaml.v2"
+)
+
+// TestOpenLogFile tests that the log file and its directory are created
+func TestOpenLogFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "gowx.log")
+
+	f, err := openLogFile(path)
+	if err != nil {
+		t.Fatalf("openLogFile returned error: %v", err)
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString("test entry\n"); err != nil {
+		t.Fatalf("Failed to write to log file: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read log file: %v", err)
+	}
+
+	if string(data) != "test entry\n" {
+		t.Errorf("Expected log file to contain 'test entry', got %q", data)
+	}
+}
+
+// TestRunInvalidConfig tests that run fails cleanly when the config cannot be loaded
+func TestRunInvalidConfig(t *testing.T) {
+	if err := run(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Errorf("Expected error for missing config file, got nil")
+	}
+}
+
+// TestWeatherStations tests the station list for single and multi-station setups
+func TestWeatherStations(t *testing.T) {
+	cfg := &config.Config{
+		Station: config.StationConfig{
+			Name:     "Keller, Texas",
+			Location: config.LocationConfig{Latitude: 33.05, Longitude: -97.24, Altitude: 211},
+		},
+	}
+
+	stations := weatherStations(cfg)
+	if len(stations) != 1 {
+		t.Fatalf("Expected 1 station, got %d", len(stations))
+	}
+	if stations[0].ID != models.DefaultStationID || stations[0].Name != "Keller, Texas" || stations[0].Altitude != 211 {
+		t.Errorf("Expected default station from the station section, got %+v", stations[0])
+	}
+
+	cfg.Collector.Stations = []config.StationMapping{
+		{ID: "backyard", Name: "Backyard", Passkey: "ABC"},
+		{ID: "roof", MAC: "AA:BB:CC:DD:EE:FF"},
+	}
+	stations = weatherStations(cfg)
+	if len(stations) != 2 {
+		t.Fatalf("Expected 2 stations, got %d", len(stations))
+	}
+	if stations[0].ID != "backyard" || stations[0].Name != "Backyard" {
+		t.Errorf("Expected backyard station, got %+v", stations[0])
+	}
+	if stations[1].Name != "roof" {
+		t.Errorf("Expected unnamed station to use its ID, got %q", stations[1].Name)
+	}
+}
+
+// TestParseReplayRange tests parsing of the replay time range
+func TestParseReplayRange(t *testing.T) {
+	start, end, err := parseReplayRange("", "")
+	if err != nil {
+		t.Fatalf("parseReplayRange returned error: %v", err)
+	}
+	if !start.Equal(time.Unix(0, 0)) || time.Since(end) > time.Minute {
+		t.Errorf("Expected everything up to now, got %v to %v", start, end)
+	}
+
+	start, end, err = parseReplayRange("2023-05-01T00:00:00Z", "2023-05-02T00:00:00Z")
+	if err != nil {
+		t.Fatalf("parseReplayRange returned error: %v", err)
+	}
+	if !start.Equal(time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)) || !end.Equal(time.Date(2023, 5, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected May 1 to May 2, got %v to %v", start, end)
+	}
+
+	if _, _, err := parseReplayRange("yesterday", ""); err == nil {
+		t.Errorf("Expected error for an invalid --since time")
+	}
+	if _, _, err := parseReplayRange("2023-05-02T00:00:00Z", "2023-05-01T00:00:00Z"); err == nil {
+		t.Errorf("Expected error for --until before --since")
+	}
+}
+
+// TestReadCaptureFile tests filtering a capture file by receive time
+func TestReadCaptureFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.jsonl")
+	capture := `{"receivedAt":"2023-05-01T12:00:00Z","body":"tempf=70","decoder":"ecowitt"}
+{"receivedAt":"2023-05-03T12:00:00Z","body":"tempf=71","decoder":"ecowitt"}
+`
+	if err := os.WriteFile(path, []byte(capture), 0644); err != nil {
+		t.Fatalf("Failed to write capture file: %v", err)
+	}
+
+	uploads, err := readCaptureFile(path, time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC), time.Date(2023, 5, 2, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("readCaptureFile returned error: %v", err)
+	}
+	if len(uploads) != 1 || uploads[0].Body != "tempf=70" {
+		t.Errorf("Expected only the May 1 upload, got %d uploads", len(uploads))
+	}
+}
+
+// TestRunDiscover tests listing gateways and writing one into the config file
+func TestRunDiscover(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer conn.Close()
+
+	// Answer every request with the announcement of a GW1100 on port 45000
+	name := "GW1100B-WIFI5678 V2.1.4"
+	body := []byte{0x12, 0x00, byte(1 + 2 + 13 + len(name) + 1)}
+	body = append(body, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 192, 168, 1, 101, 0xAF, 0xC8, byte(len(name)))
+	body = append(body, name...)
+	var sum byte
+	for _, c := range body {
+		sum += c
+	}
+	response := append(append([]byte{0xFF, 0xFF}, body...), sum)
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			_, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteToUDP(response, from)
+		}
+	}()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	original := "collector:\n  type: gw1000\n  device:\n    # Gateway address\n    address: \"\"\n"
+	if err := os.WriteFile(path, []byte(original), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	var out bytes.Buffer
+	args := []string{"-config", path, "-address", conn.LocalAddr().String(), "-timeout", "200ms", "-write"}
+	if err := runDiscover(args, &out); err != nil {
+		t.Fatalf("runDiscover returned error: %v", err)
+	}
+	if !strings.Contains(out.String(), "11:22:33:44:55:66  192.168.1.101  45000  GW1100B-WIFI5678  V2.1.4") {
+		t.Errorf("Expected device in output, got %q", out.String())
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read config file: %v", err)
+	}
+	var cfg config.Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		t.Fatalf("Failed to parse updated config: %v", err)
+	}
+	if cfg.Collector.Device.Address != "192.168.1.101" {
+		t.Errorf("Expected device address 192.168.1.101, got %q", cfg.Collector.Device.Address)
+	}
+	if cfg.Collector.Device.Model != "GW1100B" {
+		t.Errorf("Expected device model GW1100B, got %q", cfg.Collector.Device.Model)
+	}
+
+	// Choosing a device that did not answer fails
+	args = append(args, "-device", "192.168.1.100")
+	if err := runDiscover(args, &out); err == nil {
+		t.Errorf("Expected error for unknown device, got nil")
+	}
+}
+
+// TestUploadTarget tests pointing the device at where the interceptor
+// accepts its uploads
+func TestUploadTarget(t *testing.T) {
+	cfg := &config.Config{
+		Server: config.ServerConfig{Port: 8080},
+		Collector: config.CollectorConfig{
+			Device: config.DeviceConfig{
+				Credentials: []config.CredentialConfig{{ID: "KTX1", Password: "secret"}},
+				Upload:      config.UploadConfig{Configure: true, Protocol: "ecowitt", Interval: 60},
+			},
+			Listeners: []config.ListenerConfig{{Port: 8000, Decoders: []string{"wunderground"}}},
+			Ingest:    config.IngestConfig{Path: "/ingest", Decoders: []string{"ecowitt"}},
+			Interval:  -1,
+		},
+	}
+	icpt, err := interceptor.NewInterceptor(cfg.Collector, nil)
+	if err != nil {
+		t.Fatalf("Failed to create interceptor: %v", err)
+	}
+
+	// Ecowitt uploads go to the web server
+	target, err := uploadTarget(cfg, icpt)
+	if err != nil {
+		t.Fatalf("uploadTarget returned error: %v", err)
+	}
+	if !target.Enabled || target.Port != 8080 || target.Path() != "/ingest/ecowitt" || target.Interval != 60 {
+		t.Errorf("Expected ecowitt uploads to port 8080 at /ingest/ecowitt, got %+v", target)
+	}
+
+	// Wunderground uploads go to the listener, with the accepted credentials
+	cfg.Collector.Device.Upload.Protocol = "wunderground"
+	target, err = uploadTarget(cfg, icpt)
+	if err != nil {
+		t.Fatalf("uploadTarget returned error: %v", err)
+	}
+	if target.Port != 8000 || target.Path() != "/weatherstation/updateweatherstation.php" || target.ID != "KTX1" || target.Password != "secret" {
+		t.Errorf("Expected wunderground uploads to port 8000 with credentials, got %+v", target)
+	}
+
+	// A port set explicitly, e.g. of a reverse proxy, wins
+	cfg.Collector.Device.Upload.Port = 80
+	if target, _ := uploadTarget(cfg, icpt); target.Port != 80 {
+		t.Errorf("Expected port 80, got %d", target.Port)
+	}
+
+	// A protocol nothing accepts is an error
+	cfg.Collector.Listeners = nil
+	icpt, err = interceptor.NewInterceptor(cfg.Collector, nil)
+	if err != nil {
+		t.Fatalf("Failed to create interceptor: %v", err)
+	}
+	if _, err := uploadTarget(cfg, icpt); err == nil {
+		t.Errorf("Expected error when no listener accepts the protocol")
+	}
+}
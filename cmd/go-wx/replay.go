@@ -0,0 +1,136 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/ask-23/go-wx/internal/models"
+	"github.com/ask-23/go-wx/pkg/config"
+	"github.com/ask-23/go-wx/pkg/database"
+	"github.com/ask-23/go-wx/pkg/interceptor"
+	"github.com/ask-23/go-wx/pkg/replay"
+)
+
+// runReplay implements the replay command, which feeds archived or captured
+// uploads back through the ingest steps and stores the resulting observations
+// in place of the ones stored before
+func runReplay(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	configFile := flags.String("config", "config/config.yaml", "Path to the configuration file")
+	since := flags.String("since", "", "Replay uploads received at or after this time (RFC3339)")
+	until := flags.String("until", "", "Replay uploads received at or before this time (RFC3339), default now")
+	file := flags.String("file", "", "Replay uploads from a capture file of JSON raw uploads instead of the archive")
+	dryRun := flags.Bool("dry-run", false, "Show how observations would change without storing them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	start, end, err := parseReplayRange(*since, *until)
+	if err != nil {
+		return err
+	}
+
+	// Load the configuration
+	cfg, err := config.LoadConfig(*configFile)
+	if err != nil {
+		return err
+	}
+
+	// Connect to the database
+	db, err := database.NewDatabase(cfg.Database)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+
+	// Read the uploads to replay
+	var uploads []*models.RawUpload
+	if *file != "" {
+		uploads, err = readCaptureFile(*file, start, end)
+
+		// A capture covers only the time it was taken
+		if err == nil && len(uploads) > 0 {
+			start, end = uploads[0].ReceivedAt, uploads[len(uploads)-1].ReceivedAt
+		}
+	} else {
+		uploads, err = db.GetRawUploads(start, end)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Ingest them the way the interceptor does
+	icpt, err := interceptor.NewInterceptor(cfg.Collector, db)
+	if err != nil {
+		return fmt.Errorf("failed to create interceptor: %w", err)
+	}
+
+	log.Printf("Replaying %d uploads received from %v to %v", len(uploads), start, end)
+	result, err := replay.NewReplayer(icpt, db, *dryRun, out).Run(uploads, start, end)
+	if err != nil {
+		return err
+	}
+
+	verb := "stored"
+	if *dryRun {
+		verb = "would be stored"
+	}
+	fmt.Fprintf(out, "Replayed %d uploads (%d rejected before): %d changed (%s), %d unchanged, %d skipped, %d partial records kept\n",
+		result.Replayed, result.Recovered, result.Changed, verb, result.Unchanged, result.Skipped, result.Partial)
+
+	return nil
+}
+
+// parseReplayRange parses the --since and --until times. Without them every
+// upload received up to now is replayed.
+func parseReplayRange(since, until string) (time.Time, time.Time, error) {
+	start := time.Unix(0, 0).UTC()
+	end := time.Now()
+
+	if since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			return start, end, fmt.Errorf("invalid --since time: %w", err)
+		}
+		start = t
+	}
+	if until != "" {
+		t, err := time.Parse(time.RFC3339, until)
+		if err != nil {
+			return start, end, fmt.Errorf("invalid --until time: %w", err)
+		}
+		end = t
+	}
+
+	if end.Before(start) {
+		return start, end, fmt.Errorf("--until %v is before --since %v", end, start)
+	}
+
+	return start, end, nil
+}
+
+// readCaptureFile reads the uploads in a capture file received between start and end
+func readCaptureFile(path string, start, end time.Time) ([]*models.RawUpload, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open capture file: %w", err)
+	}
+	defer f.Close()
+
+	uploads, err := replay.ReadCapture(f)
+	if err != nil {
+		return nil, err
+	}
+
+	var inRange []*models.RawUpload
+	for _, upload := range uploads {
+		if !upload.ReceivedAt.Before(start) && !upload.ReceivedAt.After(end) {
+			inRange = append(inRange, upload)
+		}
+	}
+	return inRange, nil
+}
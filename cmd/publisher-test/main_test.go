@@ -49,7 +49,7 @@ func TestCustomPublisherHttp(t *testing.T) {
 		Pressure:      1012.5,
 		WindSpeed:     5.5,
 		WindDirection: 180.0,
-		Rain:          0.0,
+		RainRate:      0.0,
 		UVIndex:       5.0,
 		CloudBase:     1500.0,
 		DewPoint:      9.5,
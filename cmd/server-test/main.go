@@ -19,7 +19,8 @@ func mockWeatherData() *models.WeatherData {
 		Pressure:      1012.5, // hPa
 		WindSpeed:     4.5,    // m/s
 		WindDirection: 180.0,  // degrees
-		Rain:          0.0,    // mm
+		RainRate:      0.0,    // mm/h
+		RainDay:       0.0,    // mm
 		UVIndex:       5.0,
 		CloudBase:     1500.0, // meters
 		DewPoint:      10.3,   // Celsius
@@ -49,7 +50,7 @@ func handleHistoryData(w http.ResponseWriter, r *http.Request) {
 			Pressure:      1011.2,
 			WindSpeed:     3.5,
 			WindDirection: 170.0,
-			Rain:          0.0,
+			RainRate:      0.0,
 			UVIndex:       6.0,
 			CloudBase:     1450.0,
 			DewPoint:      10.0,
@@ -63,7 +64,7 @@ func handleHistoryData(w http.ResponseWriter, r *http.Request) {
 			Pressure:      1012.0,
 			WindSpeed:     4.0,
 			WindDirection: 175.0,
-			Rain:          0.0,
+			RainRate:      0.0,
 			UVIndex:       5.5,
 			CloudBase:     1480.0,
 			DewPoint:      10.2,
@@ -161,7 +162,8 @@ func handleHomePage(w http.ResponseWriter, r *http.Request) {
                 html += '<tr><td>Pressure</td><td>' + data.pressure.toFixed(1) + ' hPa</td></tr>';
                 html += '<tr><td>Wind Speed</td><td>' + data.windSpeed.toFixed(1) + ' m/s</td></tr>';
                 html += '<tr><td>Wind Direction</td><td>' + data.windDirection.toFixed(0) + '°</td></tr>';
-                html += '<tr><td>Rain</td><td>' + data.rain.toFixed(1) + ' mm</td></tr>';
+                html += '<tr><td>Rain Rate</td><td>' + data.rainRate.toFixed(1) + ' mm/h</td></tr>';
+                html += '<tr><td>Rain Today</td><td>' + data.rainDay.toFixed(1) + ' mm</td></tr>';
                 html += '<tr><td>UV Index</td><td>' + data.uvIndex.toFixed(1) + '</td></tr>';
                 html += '<tr><td>Cloud Base</td><td>' + data.cloudBase.toFixed(0) + ' m</td></tr>';
                 html += '<tr><td>Dew Point</td><td>' + data.dewPoint.toFixed(1) + '°C</td></tr>';
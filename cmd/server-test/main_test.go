@@ -19,7 +19,7 @@ func MockWeatherData() *models.WeatherData {
 		Pressure:      1012.5,
 		WindSpeed:     8.0,
 		WindDirection: 180.0,
-		Rain:          0.0,
+		RainRate:      0.0,
 		UVIndex:       5.0,
 		CloudBase:     4500.0,
 		DewPoint:      50.0,
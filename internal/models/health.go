@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// Ways sensors report their battery
+const (
+	BatteryFlag    = "flag"    // 0 when the battery is fine, 1 when it is low
+	BatteryLevel   = "level"   // 0 to 5 bars, 6 when powered externally
+	BatteryVoltage = "voltage" // volts
+)
+
+// SensorHealth is the battery state and last report of a sensor paired with
+// a station's console
+type SensorHealth struct {
+	StationID   string    `json:"station"`
+	Sensor      string    `json:"sensor"`      // sensor model and channel, e.g. wh65 or wh31_ch2
+	Battery     float64   `json:"battery"`     // as reported, see BatteryType
+	BatteryType string    `json:"batteryType"` // flag, level or voltage
+	BatteryLow  bool      `json:"batteryLow"`
+	Capacitor   *float64  `json:"capacitorVoltage,omitempty"` // volts, for solar powered sensors
+	Signal      *int      `json:"signal,omitempty"`           // reception of the last 4 transmissions, 0-4, when the console reports it
+	LastSeen    time.Time `json:"lastSeen"`                   // receive time of the last upload that included the sensor
+	Stale       bool      `json:"stale"`                      // the sensor has stopped reporting
+}
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"math"
+	"time"
+)
+
+// IndoorData represents the indoor conditions measured by the console and
+// any indoor air quality sensor. Indoor readings arrive in the same uploads as
+// the outdoor WeatherData but are stored apart from it.
+type IndoorData struct {
+	StationID        string    `json:"station"`
+	Timestamp        time.Time `json:"timestamp"`           // observation time reported by the device
+	ReceivedAt       time.Time `json:"receivedAt"`          // time go-wx received the observation
+	Temperature      float64   `json:"temperature"`         // degrees Celsius
+	Humidity         float64   `json:"humidity"`            // percentage
+	Pressure         float64   `json:"pressure"`            // hPa, absolute, measured by the console
+	CO2              *float64  `json:"co2,omitempty"`       // ppm, from a WH45 or a console CO2 sensor
+	CO2Avg24h        *float64  `json:"co2Avg24h,omitempty"` // ppm, 24 hour average
+	DewPoint         float64   `json:"dewPoint"`            // degrees Celsius
+	AbsoluteHumidity float64   `json:"absoluteHumidity"`    // grams of water vapour per m³ of air
+	QCFlags          QCFlags   `json:"qcFlags,omitempty"`   // readings that failed quality control
+
+	// Reported records the readings the device reported, by their JSON
+	// names, or is nil when that is not known
+	Reported map[string]bool `json:"-"`
+}
+
+// CalculateDerivedValues calculates the dew point and absolute humidity,
+// which are left at zero without a reported humidity and temperature that
+// passed quality control
+func (d *IndoorData) CalculateDerivedValues() {
+	if d.Humidity <= 0 || !d.usable("humidity") || !d.usable("temperature") {
+		d.DewPoint = 0
+		d.AbsoluteHumidity = 0
+		return
+	}
+	d.DewPoint = calculateDewPoint(d.Temperature, d.Humidity)
+	d.AbsoluteHumidity = calculateAbsoluteHumidity(d.Temperature, d.Humidity)
+}
+
+// usable reports whether the reading field was reported and passed quality control
+func (d *IndoorData) usable(field string) bool {
+	return d.HasReported(field) && !d.Flagged(field)
+}
+
+// calculateAbsoluteHumidity calculates the absolute humidity in g/m³ from the
+// saturation vapour pressure given by the Magnus formula
+func calculateAbsoluteHumidity(tempC float64, humidity float64) float64 {
+	// Saturation vapour pressure in hPa
+	saturation := 6.112 * math.Exp((17.67*tempC)/(tempC+243.5))
+
+	// Water vapour density from the ideal gas law, 2.1674 = 100 / R_v
+	return saturation * humidity * 2.1674 / (273.15 + tempC)
+}
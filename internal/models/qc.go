@@ -0,0 +1,105 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// QCFlags maps the JSON name of a measurement that failed quality control to
+// the check it failed
+type QCFlags map[string]string
+
+// add records that field failed check. Only the first failing check is
+// kept for a field.
+func (f *QCFlags) add(field, check string) {
+	if *f == nil {
+		*f = make(QCFlags)
+	}
+	if _, ok := (*f)[field]; !ok {
+		(*f)[field] = check
+	}
+}
+
+// Flag records that field failed the named quality control check. Only the
+// first failing check is kept for a field.
+func (wd *WeatherData) Flag(field, check string) {
+	wd.QCFlags.add(field, check)
+}
+
+// Flagged reports whether field failed a quality control check
+func (wd *WeatherData) Flagged(field string) bool {
+	_, ok := wd.QCFlags[field]
+	return ok
+}
+
+// Flag records that the indoor reading field failed the named quality
+// control check. Only the first failing check is kept for a field.
+func (d *IndoorData) Flag(field, check string) {
+	d.QCFlags.add(field, check)
+}
+
+// Flagged reports whether the indoor reading field failed a quality control check
+func (d *IndoorData) Flagged(field string) bool {
+	_, ok := d.QCFlags[field]
+	return ok
+}
+
+// Report records that the device reported the named measurements
+func (wd *WeatherData) Report(fields ...string) {
+	if wd.Reported == nil {
+		wd.Reported = make(map[string]bool)
+	}
+	for _, field := range fields {
+		wd.Reported[field] = true
+	}
+}
+
+// HasReported reports whether the device reported field. Every field counts
+// as reported for observations that do not record what was reported.
+func (wd *WeatherData) HasReported(field string) bool {
+	return wd.Reported == nil || wd.Reported[field]
+}
+
+// Report records that the device reported the named indoor readings
+func (d *IndoorData) Report(fields ...string) {
+	if d.Reported == nil {
+		d.Reported = make(map[string]bool)
+	}
+	for _, field := range fields {
+		d.Reported[field] = true
+	}
+}
+
+// HasReported reports whether the device reported the indoor reading field.
+// Every reading counts as reported when what was reported is not recorded.
+func (d *IndoorData) HasReported(field string) bool {
+	return d.Reported == nil || d.Reported[field]
+}
+
+// Value stores the flags as JSON text in the database, or NULL when there are none
+func (f QCFlags) Value() (driver.Value, error) {
+	if len(f) == 0 {
+		return nil, nil
+	}
+	b, err := json.Marshal(map[string]string(f))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode QC flags: %w", err)
+	}
+	return string(b), nil
+}
+
+// Scan reads the flags from JSON text in the database
+func (f *QCFlags) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*f = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, f)
+	case string:
+		return json.Unmarshal([]byte(v), f)
+	default:
+		return fmt.Errorf("unsupported type for QC flags: %T", src)
+	}
+}
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// RawUpload is an upload exactly as a console sent it, kept so decoded
+// observations can be checked against their source and rebuilt later.
+// Secrets in the query and body are redacted before the upload is stored.
+type RawUpload struct {
+	ID         int64             `json:"id"`
+	ReceivedAt time.Time         `json:"receivedAt"`
+	SourceIP   string            `json:"sourceIp"`
+	Method     string            `json:"method"`
+	Path       string            `json:"path"`
+	Query      string            `json:"query"`
+	Body       string            `json:"body"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Decoder    string            `json:"decoder"`
+	StationID  string            `json:"station"`
+	Status     int               `json:"status"` // HTTP status returned to the console
+
+	WeatherDataID int64 `json:"weatherDataId,omitempty"` // weather_data row decoded from the upload
+}
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// SensorChannels holds readings from multi-channel add-on sensors
+type SensorChannels struct {
+	TempHumidity []TempHumidityChannel `json:"tempHumidity,omitempty"` // WH31 thermo-hygrometers, channels 1-8
+	SoilMoisture []SoilMoistureChannel `json:"soilMoisture,omitempty"` // WH51 soil moisture, channels 1-8
+	PM25         []PM25Channel         `json:"pm25,omitempty"`         // WH41/WH43 air quality, channels 1-4
+	Leak         []LeakChannel         `json:"leak,omitempty"`         // WH55 leak detectors, channels 1-4
+	Batteries    map[string]float64    `json:"batteries,omitempty"`    // raw battery fields keyed by device name
+	Capacitors   map[string]float64    `json:"capacitors,omitempty"`   // raw capacitor voltage fields keyed by device name
+	Signals      map[string]int        `json:"signals,omitempty"`      // reception of the last 4 transmissions, 0-4, keyed by sensor name
+}
+
+// TempHumidityChannel is a reading from an additional temperature/humidity sensor
+type TempHumidityChannel struct {
+	Channel     int     `json:"channel"`
+	Temperature float64 `json:"temperature"` // degrees Celsius
+	Humidity    float64 `json:"humidity"`    // percentage
+}
+
+// SoilMoistureChannel is a reading from a soil moisture sensor
+type SoilMoistureChannel struct {
+	Channel  int     `json:"channel"`
+	Moisture float64 `json:"moisture"` // percentage
+}
+
+// PM25Channel is a reading from a particulate matter sensor
+type PM25Channel struct {
+	Channel int     `json:"channel"`
+	PM25    float64 `json:"pm25"`    // µg/m³
+	PM25Avg float64 `json:"pm25Avg"` // 24 hour average, µg/m³
+}
+
+// LeakChannel is a reading from a water leak detector
+type LeakChannel struct {
+	Channel int  `json:"channel"`
+	Leak    bool `json:"leak"`
+}
+
+// IsEmpty reports whether no add-on sensor readings are present
+func (c *SensorChannels) IsEmpty() bool {
+	return c == nil || (len(c.TempHumidity) == 0 && len(c.SoilMoisture) == 0 &&
+		len(c.PM25) == 0 && len(c.Leak) == 0 && len(c.Batteries) == 0 && len(c.Capacitors) == 0 &&
+		len(c.Signals) == 0)
+}
+
+// Value stores the channels as JSON text in the database
+func (c SensorChannels) Value() (driver.Value, error) {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode sensor channels: %w", err)
+	}
+	return string(b), nil
+}
+
+// Scan reads the channels from JSON text in the database
+func (c *SensorChannels) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*c = SensorChannels{}
+		return nil
+	case []byte:
+		return json.Unmarshal(v, c)
+	case string:
+		return json.Unmarshal([]byte(v), c)
+	default:
+		return fmt.Errorf("unsupported type for sensor channels: %T", src)
+	}
+}
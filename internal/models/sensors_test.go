@@ -0,0 +1,52 @@
+package models
+
+import (
+	"testing"
+)
+
+// TestSensorChannelsRoundTrip tests storing and loading channels as database JSON
+func TestSensorChannelsRoundTrip(t *testing.T) {
+	channels := SensorChannels{
+		TempHumidity: []TempHumidityChannel{{Channel: 2, Temperature: 18.5, Humidity: 60}},
+		Leak:         []LeakChannel{{Channel: 1, Leak: true}},
+		Batteries:    map[string]float64{"wh65batt": 0},
+	}
+
+	value, err := channels.Value()
+	if err != nil {
+		t.Fatalf("Value returned error: %v", err)
+	}
+
+	var loaded SensorChannels
+	if err := loaded.Scan([]byte(value.(string))); err != nil {
+		t.Fatalf("Scan returned error: %v", err)
+	}
+
+	if len(loaded.TempHumidity) != 1 || loaded.TempHumidity[0].Temperature != 18.5 {
+		t.Errorf("Expected channel 2 temperature 18.5, got %+v", loaded.TempHumidity)
+	}
+
+	if len(loaded.Leak) != 1 || !loaded.Leak[0].Leak {
+		t.Errorf("Expected leak on channel 1, got %+v", loaded.Leak)
+	}
+
+	if _, ok := loaded.Batteries["wh65batt"]; !ok {
+		t.Errorf("Expected wh65batt battery entry, got %v", loaded.Batteries)
+	}
+}
+
+// TestSensorChannelsIsEmpty tests detection of payloads without add-on sensors
+func TestSensorChannelsIsEmpty(t *testing.T) {
+	var nilChannels *SensorChannels
+	if !nilChannels.IsEmpty() {
+		t.Errorf("Expected nil channels to be empty")
+	}
+
+	if !(&SensorChannels{}).IsEmpty() {
+		t.Errorf("Expected zero channels to be empty")
+	}
+
+	if (&SensorChannels{SoilMoisture: []SoilMoistureChannel{{Channel: 1, Moisture: 30}}}).IsEmpty() {
+		t.Errorf("Expected channels with soil moisture not to be empty")
+	}
+}
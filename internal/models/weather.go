@@ -3,59 +3,144 @@ package models
 import (
 	"math"
 	"time"
+
+	"github.com/ask-23/go-wx/pkg/units"
 )
 
+// DefaultStationID identifies observations from a single station setup and
+// uploads that could not be matched to any configured station
+const DefaultStationID = "default"
+
 // WeatherData represents a single set of weather measurements
 type WeatherData struct {
-	Timestamp     time.Time `json:"timestamp"`
+	StationID     string    `json:"station"`    // station that reported the observation
+	Timestamp     time.Time `json:"timestamp"`  // observation time reported by the device
+	ReceivedAt    time.Time `json:"receivedAt"` // time go-wx received the observation
+	ClockSkewed   bool      `json:"clockSkewed,omitempty"`
 	Temperature   float64   `json:"temperature"`   // degrees Celsius
 	Humidity      float64   `json:"humidity"`      // percentage
 	Pressure      float64   `json:"pressure"`      // hPa (hectopascals)
 	WindSpeed     float64   `json:"windSpeed"`     // meters per second
 	WindDirection float64   `json:"windDirection"` // degrees (0-359)
-	Rain          float64   `json:"rain"`          // millimeters
+	RainRate      float64   `json:"rainRate"`      // millimeters per hour
 	UVIndex       float64   `json:"uvIndex"`       // UV index
 	CloudBase     float64   `json:"cloudBase"`     // meters
 	DewPoint      float64   `json:"dewPoint"`      // degrees Celsius
 	WindChill     float64   `json:"windChill"`     // degrees Celsius
 	HeatIndex     float64   `json:"heatIndex"`     // degrees Celsius
+
+	RelativePressure float64 `json:"relativePressure"` // hPa, corrected to sea level
+	WindGust         float64 `json:"windGust"`         // meters per second
+	MaxDailyGust     float64 `json:"maxDailyGust"`     // meters per second
+	SolarRadiation   float64 `json:"solarRadiation"`   // W/m²
+
+	// Wind calculated from the recent observations: the average speed over
+	// the last 2 and 10 minutes, the 10 minute vector mean direction, and
+	// the highest gust with its time during the current clock hour and day
+	// in local time
+	WindAvg2m        float64    `json:"windAvg2m"`     // meters per second
+	WindAvg10m       float64    `json:"windAvg10m"`    // meters per second
+	WindDirAvg10m    float64    `json:"windDirAvg10m"` // degrees (0-359)
+	PeakGustHour     float64    `json:"peakGustHour"`  // meters per second
+	PeakGustHourTime *time.Time `json:"peakGustHourTime,omitempty"`
+	PeakGustDay      float64    `json:"peakGustDay"` // meters per second
+	PeakGustDayTime  *time.Time `json:"peakGustDayTime,omitempty"`
+
+	// Rain counters as reported by the console, in millimeters
+	EventRain   float64 `json:"eventRain"`
+	HourlyRain  float64 `json:"hourlyRain"`
+	DailyRain   float64 `json:"dailyRain"`
+	WeeklyRain  float64 `json:"weeklyRain"`
+	MonthlyRain float64 `json:"monthlyRain"`
+	YearlyRain  float64 `json:"yearlyRain"`
+	TotalRain   float64 `json:"totalRain"`
+
+	RainStartTime *time.Time `json:"rainStartTime,omitempty"` // start of the current rain event, when the device reports it
+
+	// Rain calculated from the counters, in millimeters: the rain fallen
+	// since the previous observation, or during an archive record's
+	// interval, and the totals for the current clock hour, day, month and
+	// rain year in local time
+	RainFall  float64 `json:"rainFall"`
+	RainHour  float64 `json:"rainHour"`
+	RainDay   float64 `json:"rainDay"`
+	RainMonth float64 `json:"rainMonth"`
+	RainYear  float64 `json:"rainYear"`
+
+	LightningDistance float64    `json:"lightningDistance"`       // kilometers to the last strike
+	LightningTime     *time.Time `json:"lightningTime,omitempty"` // time of the last strike
+	LightningCount    int        `json:"lightningCount"`          // strikes today
+
+	Channels *SensorChannels `json:"channels,omitempty"` // add-on sensor readings
+
+	// Indoor readings from the same upload, which are stored separately
+	Indoor *IndoorData `json:"-"`
+
+	QCFlags QCFlags `json:"qcFlags,omitempty"` // measurements that failed quality control
+
+	// Quality controlled measurements the device reported, by JSON name.
+	// When nil every measurement counts as reported.
+	Reported map[string]bool `json:"-"`
+
+	RawUploadID int64 `json:"rawUploadId,omitempty"` // archived upload the observation was decoded from
+
+	// First and last archived uploads an archive record was built from
+	FirstRawUploadID int64 `json:"firstRawUploadId,omitempty"`
+	LastRawUploadID  int64 `json:"lastRawUploadId,omitempty"`
 }
 
 // WeatherStation represents a weather station
 type WeatherStation struct {
+	ID        string  `json:"id"`
 	Name      string  `json:"name"`
 	Latitude  float64 `json:"latitude"`
 	Longitude float64 `json:"longitude"`
 	Altitude  float64 `json:"altitude"` // Altitude in meters
 }
 
-// CalculateDerivedValues calculates additional weather values based on the core measurements
+// CalculateDerivedValues calculates additional weather values based on the core measurements.
+// Measurements that were not reported or failed quality control do not feed
+// the values calculated from them, which are left at zero instead.
 func (wd *WeatherData) CalculateDerivedValues() {
+	hasTemperature := wd.usable("temperature")
+	hasHumidity := hasTemperature && wd.hasHumidity()
+
 	// Calculate dew point
-	wd.DewPoint = calculateDewPoint(wd.Temperature, wd.Humidity)
+	if hasHumidity {
+		wd.DewPoint = calculateDewPoint(wd.Temperature, wd.Humidity)
+	} else {
+		wd.DewPoint = 0
+	}
 
 	// Convert to Fahrenheit for standard formulas
-	tempF := celsiusToFahrenheit(wd.Temperature)
-	windSpeedMph := wd.WindSpeed / 0.44704
+	tempF := float64(units.Celsius(wd.Temperature).Fahrenheit())
+	windSpeedMph := float64(units.MetersPerSecond(wd.WindSpeed).MilesPerHour())
+	if !wd.usable("windSpeed") {
+		windSpeedMph = 0
+	}
 
 	// Calculate wind chill (valid for temps <= 50°F and wind speed > 3 mph)
-	if tempF <= 50 && windSpeedMph >= 3 {
+	if !hasTemperature {
+		wd.WindChill = 0
+	} else if tempF <= 50 && windSpeedMph >= 3 {
 		windChillF := calculateWindChillF(tempF, windSpeedMph)
-		wd.WindChill = fahrenheitToCelsius(windChillF)
+		wd.WindChill = float64(units.Fahrenheit(windChillF).Celsius())
 	} else {
 		wd.WindChill = wd.Temperature // No wind chill effect
 	}
 
 	// Calculate heat index (valid for temps >= 80°F)
-	if tempF >= 80 {
+	if !hasHumidity {
+		wd.HeatIndex = 0
+	} else if tempF >= 80 {
 		heatIndexF := calculateHeatIndexF(tempF, wd.Humidity)
-		wd.HeatIndex = fahrenheitToCelsius(heatIndexF)
+		wd.HeatIndex = float64(units.Fahrenheit(heatIndexF).Celsius())
 	} else {
 		wd.HeatIndex = wd.Temperature // No heat index effect
 	}
 
 	// Calculate cloud base using the standard approximation
-	if wd.Humidity > 0 {
+	if hasHumidity {
 		// Cloud base in meters = 122 * (temperature - dew point)
 		wd.CloudBase = 122 * (wd.Temperature - wd.DewPoint)
 	} else {
@@ -63,6 +148,18 @@ func (wd *WeatherData) CalculateDerivedValues() {
 	}
 }
 
+// usable reports whether field was reported and passed quality control
+func (wd *WeatherData) usable(field string) bool {
+	return wd.HasReported(field) && !wd.Flagged(field)
+}
+
+// hasHumidity reports whether there is a humidity reading to calculate the
+// dew point from. A humidity of zero is what unreported readings decode to,
+// and the dew point of it is not a number.
+func (wd *WeatherData) hasHumidity() bool {
+	return wd.Humidity > 0 && wd.usable("humidity")
+}
+
 // calculateDewPoint calculates the dew point temperature in Celsius
 func calculateDewPoint(tempC float64, humidity float64) float64 {
 	// Constants for Magnus formula
@@ -160,12 +257,3 @@ func calculateHeatIndexF(tempF float64, humidity float64) float64 {
 
 	return heatIndex
 }
-
-// Helper functions for temperature conversions
-func celsiusToFahrenheit(celsius float64) float64 {
-	return (celsius * 9 / 5) + 32
-}
-
-func fahrenheitToCelsius(fahrenheit float64) float64 {
-	return (fahrenheit - 32) * 5 / 9
-}
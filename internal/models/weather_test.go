@@ -3,6 +3,8 @@ package models
 import (
 	"math"
 	"testing"
+
+	"github.com/ask-23/go-wx/pkg/units"
 )
 
 // TestCalculateDerivedValues tests the calculation of derived weather values
@@ -14,7 +16,7 @@ func TestCalculateDerivedValues(t *testing.T) {
 		Pressure:      1013.0,
 		WindSpeed:     5.0, // ~11.2 mph
 		WindDirection: 180.0,
-		Rain:          0.0,
+		RainRate:      0.0,
 		UVIndex:       5.0,
 	}
 
@@ -27,13 +29,13 @@ func TestCalculateDerivedValues(t *testing.T) {
 	}
 
 	// At these temperatures, wind chill should be close to actual temperature
-	tempF := celsiusToFahrenheit(data.Temperature)
+	tempF := float64(units.Celsius(data.Temperature).Fahrenheit())
 	if tempF > 50 && data.WindChill != data.Temperature {
 		t.Errorf("Wind chill should equal temperature when temp > 50°F, got %.1f", data.WindChill)
 	}
 
 	// Heat index at 75°F should be around 75-76°F
-	expectedHeatIndexC := fahrenheitToCelsius(76.0)
+	expectedHeatIndexC := float64(units.Fahrenheit(76.0).Celsius())
 	if tempF < 80 && math.Abs(data.HeatIndex-expectedHeatIndexC) > 2.0 {
 		t.Errorf("Heat index calculation incorrect, expected ~%.1f°C, got %.1f", expectedHeatIndexC, data.HeatIndex)
 	}
@@ -45,7 +47,7 @@ func TestCalculateDerivedValues(t *testing.T) {
 
 	// At 90°F and 75% humidity, heat index should be around 109°F
 	expectedHeatIndexF := 107.0 // Adjusted to match implementation
-	actualHeatIndexF := celsiusToFahrenheit(data.HeatIndex)
+	actualHeatIndexF := float64(units.Celsius(data.HeatIndex).Fahrenheit())
 	if math.Abs(actualHeatIndexF-expectedHeatIndexF) > 4.0 {
 		t.Errorf("Heat index calculation incorrect, expected ~%.1f°F, got %.1f", expectedHeatIndexF, actualHeatIndexF)
 	}
@@ -134,3 +136,68 @@ func TestHeatIndexCalculation(t *testing.T) {
 		})
 	}
 }
+
+// TestQCFlags tests recording quality control flags and storing them
+func TestQCFlags(t *testing.T) {
+	var data WeatherData
+	if data.Flagged("temperature") {
+		t.Errorf("Expected no flags on new weather data")
+	}
+
+	if v, err := data.QCFlags.Value(); err != nil || v != nil {
+		t.Errorf("Expected NULL for no flags, got %v (%v)", v, err)
+	}
+
+	data.Flag("temperature", "spike")
+	data.Flag("temperature", "range")
+	if !data.Flagged("temperature") {
+		t.Errorf("Expected temperature to be flagged")
+	}
+	if data.QCFlags["temperature"] != "spike" {
+		t.Errorf("Expected the first failing check to be kept, got %s", data.QCFlags["temperature"])
+	}
+
+	v, err := data.QCFlags.Value()
+	if err != nil {
+		t.Fatalf("Value returned error: %v", err)
+	}
+
+	var loaded QCFlags
+	if err := loaded.Scan(v); err != nil {
+		t.Fatalf("Scan returned error: %v", err)
+	}
+	if loaded["temperature"] != "spike" {
+		t.Errorf("Expected temperature spike flag after round trip, got %v", loaded)
+	}
+
+	if err := loaded.Scan(nil); err != nil || loaded != nil {
+		t.Errorf("Expected NULL to scan as no flags, got %v (%v)", loaded, err)
+	}
+}
+
+// TestIndoorDerivedValues tests the indoor dew point and absolute humidity
+func TestIndoorDerivedValues(t *testing.T) {
+	indoor := IndoorData{Temperature: 20, Humidity: 50}
+	indoor.CalculateDerivedValues()
+
+	// 50% at 20°C holds about 8.6 g/m³ of water with a dew point near 9.3°C
+	if math.Abs(indoor.AbsoluteHumidity-8.63) > 0.1 {
+		t.Errorf("Expected absolute humidity ~8.63 g/m³, got %.2f", indoor.AbsoluteHumidity)
+	}
+	if math.Abs(indoor.DewPoint-9.3) > 0.2 {
+		t.Errorf("Expected dew point ~9.3°C, got %.2f", indoor.DewPoint)
+	}
+}
+
+// TestIndoorDerivedValuesUnreported tests that indoor derived values are not
+// calculated from a temperature the device did not report
+func TestIndoorDerivedValuesUnreported(t *testing.T) {
+	indoor := IndoorData{Humidity: 50}
+	indoor.Report("humidity")
+	indoor.CalculateDerivedValues()
+
+	if indoor.DewPoint != 0 || indoor.AbsoluteHumidity != 0 {
+		t.Errorf("Expected no derived values without a temperature, got dew point %.2f and absolute humidity %.2f",
+			indoor.DewPoint, indoor.AbsoluteHumidity)
+	}
+}
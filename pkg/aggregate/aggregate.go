@@ -0,0 +1,333 @@
+package aggregate
+
+import (
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/ask-23/go-wx/internal/models"
+	"github.com/ask-23/go-wx/pkg/qc"
+	"github.com/ask-23/go-wx/pkg/wind"
+)
+
+// closeDelay is how long after its interval ends a record waits for late uploads
+const closeDelay = 10 * time.Second
+
+// field is a measurement combined across the observations in a record
+type field struct {
+	name  string // JSON name, as used in QC flags
+	value func(d *models.WeatherData) *float64
+}
+
+// meanFields are averaged over the interval
+var meanFields = []field{
+	{"temperature", func(d *models.WeatherData) *float64 { return &d.Temperature }},
+	{"humidity", func(d *models.WeatherData) *float64 { return &d.Humidity }},
+	{"windSpeed", func(d *models.WeatherData) *float64 { return &d.WindSpeed }},
+	{"uvIndex", func(d *models.WeatherData) *float64 { return &d.UVIndex }},
+	{"solarRadiation", func(d *models.WeatherData) *float64 { return &d.SolarRadiation }},
+}
+
+// maxFields take their highest value in the interval
+var maxFields = []field{
+	{"windGust", func(d *models.WeatherData) *float64 { return &d.WindGust }},
+	{"rainRate", func(d *models.WeatherData) *float64 { return &d.RainRate }},
+}
+
+// lastFields keep their value from the last observation that reported them,
+// along with its QC flags. Other measurements, such as the rain counters and
+// totals, keep their last value too but are not quality controlled.
+var lastFields = []field{
+	{"pressure", func(d *models.WeatherData) *float64 { return &d.Pressure }},
+	{"relativePressure", func(d *models.WeatherData) *float64 { return &d.RelativePressure }},
+}
+
+// Aggregator combines the observations from each station into archive
+// records covering fixed intervals aligned to clock boundaries, so 60 second
+// records end on every minute. A record is timestamped with the end of its
+// interval and holds:
+//   - the mean of temperature, humidity, wind speed and the other levels
+//   - the wind direction averaged as vectors weighted by wind speed
+//   - the highest wind gust and rain rate
+//   - the rain that fell, summed over the observations
+//   - the last pressure, rain counters, rain totals, wind statistics and
+//     lightning readings
+//
+// Measurements that failed quality control are left out unless every value
+// in the interval failed, in which case the record is flagged too.
+// Observations that did not report a measurement are left out of it.
+type Aggregator struct {
+	interval time.Duration
+	stations map[string]*station
+	mutex    sync.Mutex
+}
+
+// station is the aggregation state of one station
+type station struct {
+	current *record
+	closed  time.Time     // end of the last record returned
+	offset  time.Duration // receive time minus device time of the latest observation
+}
+
+// record collects the observations of one interval
+type record struct {
+	end time.Time
+	obs []*models.WeatherData
+}
+
+// NewAggregator creates an aggregator producing records of the given interval
+func NewAggregator(interval time.Duration) *Aggregator {
+	return &Aggregator{
+		interval: interval,
+		stations: make(map[string]*station),
+	}
+}
+
+// Interval returns the length of the records
+func (a *Aggregator) Interval() time.Duration {
+	return a.interval
+}
+
+// Add adds an observation and returns the records it completes: the record
+// in progress for its station once an observation from a later interval
+// arrives. Observations for intervals already returned are dropped.
+func (a *Aggregator) Add(data *models.WeatherData) []*models.WeatherData {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	st, ok := a.stations[data.StationID]
+	if !ok {
+		st = &station{}
+		a.stations[data.StationID] = st
+	}
+
+	end := data.Timestamp.Truncate(a.interval).Add(a.interval)
+	if !end.After(st.closed) || (st.current != nil && end.Before(st.current.end)) {
+		log.Printf("Dropping late observation from %s at %v from its archive record", data.StationID, data.Timestamp)
+		return nil
+	}
+	st.offset = data.ReceivedAt.Sub(data.Timestamp)
+
+	var done []*models.WeatherData
+	if st.current != nil && end.After(st.current.end) {
+		done = append(done, st.close(data.StationID))
+	}
+	if st.current == nil {
+		st.current = &record{end: end}
+	}
+
+	st.current.obs = append(st.current.obs, data)
+
+	return done
+}
+
+// Due returns the records whose interval ended at least closeDelay before
+// now, allowing for each station's device clock offset
+func (a *Aggregator) Due(now time.Time) []*models.WeatherData {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	var done []*models.WeatherData
+	for id, st := range a.stations {
+		if st.current == nil {
+			continue
+		}
+		if !now.Add(-st.offset).Before(st.current.end.Add(closeDelay)) {
+			done = append(done, st.close(id))
+		}
+	}
+	return done
+}
+
+// Flush returns every record in progress, complete or not
+func (a *Aggregator) Flush() []*models.WeatherData {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	var done []*models.WeatherData
+	for id, st := range a.stations {
+		if st.current != nil {
+			done = append(done, st.close(id))
+		}
+	}
+	return done
+}
+
+// close completes the station's current record
+func (st *station) close(id string) *models.WeatherData {
+	r := st.current
+	st.current = nil
+	st.closed = r.end
+
+	data := r.build()
+	data.StationID = id
+	return data
+}
+
+// build combines the observations of a record
+func (r *record) build() *models.WeatherData {
+	last := r.obs[len(r.obs)-1]
+
+	data := *last
+	data.Timestamp = r.end
+	data.RawUploadID = 0 // a record covers several uploads
+	data.QCFlags = nil
+	data.Reported = reported(r.obs)
+	data.RainFall = 0
+
+	for _, d := range r.obs {
+		data.ClockSkewed = data.ClockSkewed || d.ClockSkewed
+		data.RainFall += d.RainFall
+
+		// Link the record to the range of uploads it was built from
+		if d.RawUploadID != 0 {
+			if data.FirstRawUploadID == 0 {
+				data.FirstRawUploadID = d.RawUploadID
+			}
+			data.LastRawUploadID = d.RawUploadID
+		}
+
+		// Indoor conditions change slowly, so the latest reading stands for
+		// the interval, stored at the time of the record
+		if d.Indoor != nil {
+			indoor := *d.Indoor
+			indoor.Timestamp = r.end
+			data.Indoor = &indoor
+		}
+	}
+
+	for _, f := range meanFields {
+		aggregateField(&data, r.obs, f, mean)
+	}
+	for _, f := range maxFields {
+		aggregateField(&data, r.obs, f, maximum)
+	}
+	for _, f := range lastFields {
+		aggregateLast(&data, r.obs, f)
+	}
+	r.aggregateDirection(&data)
+
+	// Recalculate dew point and the other derived values from the means
+	data.CalculateDerivedValues()
+	qc.FlagDerived(&data)
+
+	return &data
+}
+
+// aggregateField combines the reported values of a field with combine,
+// leaving out flagged values unless there are only flagged values
+func aggregateField(data *models.WeatherData, obs []*models.WeatherData, f field, combine func([]float64) float64) {
+	var good, flagged []float64
+	var check string
+	for _, d := range obs {
+		if !d.HasReported(f.name) {
+			continue
+		}
+		value := *f.value(d)
+		if c, ok := d.QCFlags[f.name]; ok {
+			flagged = append(flagged, value)
+			check = c
+		} else {
+			good = append(good, value)
+		}
+	}
+
+	if len(good) > 0 {
+		*f.value(data) = combine(good)
+		return
+	}
+	if len(flagged) == 0 {
+		return
+	}
+	*f.value(data) = combine(flagged)
+	data.Flag(f.name, check)
+}
+
+// aggregateLast sets a field from the last observation that reported it,
+// flagged if that value was
+func aggregateLast(data *models.WeatherData, obs []*models.WeatherData, f field) {
+	for n := len(obs) - 1; n >= 0; n-- {
+		d := obs[n]
+		if !d.HasReported(f.name) {
+			continue
+		}
+		*f.value(data) = *f.value(d)
+		if check, ok := d.QCFlags[f.name]; ok {
+			data.Flag(f.name, check)
+		}
+		return
+	}
+}
+
+// aggregateDirection sets the vector mean wind direction, weighting each
+// direction by its wind speed. When it was calm throughout every direction
+// counts equally.
+func (r *record) aggregateDirection(data *models.WeatherData) {
+	var good, flagged []*models.WeatherData
+	var check string
+	for _, d := range r.obs {
+		if !d.HasReported("windDirection") {
+			continue
+		}
+		if c, ok := d.QCFlags["windDirection"]; ok {
+			flagged = append(flagged, d)
+			check = c
+		} else {
+			good = append(good, d)
+		}
+	}
+	if len(good) == 0 {
+		if len(flagged) == 0 {
+			return
+		}
+		good = flagged
+		data.Flag("windDirection", check)
+	}
+
+	directions := make([]float64, len(good))
+	speeds := make([]float64, len(good))
+	ones := make([]float64, len(good))
+	for n, d := range good {
+		directions[n], speeds[n], ones[n] = d.WindDirection, d.WindSpeed, 1
+	}
+
+	if direction, ok := wind.VectorMean(directions, speeds); ok {
+		data.WindDirection = direction
+	} else if direction, ok := wind.VectorMean(directions, ones); ok {
+		data.WindDirection = direction
+	}
+}
+
+// reported returns the measurements reported by any of obs, or nil when one
+// of them does not record what it reported
+func reported(obs []*models.WeatherData) map[string]bool {
+	union := make(map[string]bool)
+	for _, d := range obs {
+		if d.Reported == nil {
+			return nil
+		}
+		for name := range d.Reported {
+			union[name] = true
+		}
+	}
+	return union
+}
+
+// mean returns the average of values
+func mean(values []float64) float64 {
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// maximum returns the largest of values
+func maximum(values []float64) float64 {
+	m := values[0]
+	for _, v := range values[1:] {
+		m = math.Max(m, v)
+	}
+	return m
+}
@@ -0,0 +1,239 @@
+package aggregate
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/ask-23/go-wx/internal/models"
+)
+
+var base = time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// observation returns an observation taken offset after base
+func observation(offset time.Duration) *models.WeatherData {
+	ts := base.Add(offset)
+	return &models.WeatherData{
+		StationID:  "default",
+		Timestamp:  ts,
+		ReceivedAt: ts,
+	}
+}
+
+// TestAggregate tests that records are aligned to the interval and combine
+// their observations
+func TestAggregate(t *testing.T) {
+	a := NewAggregator(time.Minute)
+
+	temps := []float64{20, 21, 22}
+	for n, temp := range temps {
+		data := observation(time.Duration(5+20*n) * time.Second)
+		data.Temperature = temp
+		data.Humidity = 50
+		data.Pressure = 1000 + float64(n)
+		data.WindGust = []float64{3, 9, 5}[n]
+		data.WindSpeed = 2
+		if records := a.Add(data); len(records) != 0 {
+			t.Fatalf("Expected no records within the interval, got %d", len(records))
+		}
+	}
+
+	// The first observation of the next interval completes the record
+	records := a.Add(observation(65 * time.Second))
+	if len(records) != 1 {
+		t.Fatalf("Expected 1 record, got %d", len(records))
+	}
+
+	record := records[0]
+	if !record.Timestamp.Equal(base.Add(time.Minute)) {
+		t.Errorf("Expected record ending at %v, got %v", base.Add(time.Minute), record.Timestamp)
+	}
+	if record.Temperature != 21 {
+		t.Errorf("Expected mean temperature 21, got %.2f", record.Temperature)
+	}
+	if record.Pressure != 1002 {
+		t.Errorf("Expected last pressure 1002, got %.2f", record.Pressure)
+	}
+	if record.WindGust != 9 {
+		t.Errorf("Expected max gust 9, got %.2f", record.WindGust)
+	}
+	if record.DewPoint == 0 && record.CloudBase == 0 {
+		t.Errorf("Expected derived values to be calculated")
+	}
+
+	// Observations for a completed interval are dropped
+	if records := a.Add(observation(30 * time.Second)); len(records) != 0 {
+		t.Errorf("Expected late observation to be dropped, got %d records", len(records))
+	}
+}
+
+// TestVectorMean tests averaging wind directions across north
+func TestVectorMean(t *testing.T) {
+	a := NewAggregator(time.Minute)
+
+	for n, dir := range []float64{350, 10, 20, 340} {
+		data := observation(time.Duration(n) * time.Second)
+		data.WindDirection = dir
+		data.WindSpeed = 3
+		a.Add(data)
+	}
+
+	record := a.Flush()[0]
+	if math.Min(record.WindDirection, 360-record.WindDirection) > 0.01 {
+		t.Errorf("Expected mean direction 0°, got %.2f°", record.WindDirection)
+	}
+
+	// Stronger wind counts for more
+	a = NewAggregator(time.Minute)
+	for n, wind := range [][2]float64{{90, 1}, {180, 3}} {
+		data := observation(time.Duration(n) * time.Second)
+		data.WindDirection = wind[0]
+		data.WindSpeed = wind[1]
+		a.Add(data)
+	}
+	record = a.Flush()[0]
+	expected := 180 - math.Atan2(1, 3)*180/math.Pi
+	if math.Abs(record.WindDirection-expected) > 0.01 {
+		t.Errorf("Expected speed weighted direction %.2f°, got %.2f°", expected, record.WindDirection)
+	}
+}
+
+// TestRainFall tests summing the rain fallen in each observation
+func TestRainFall(t *testing.T) {
+	a := NewAggregator(time.Minute)
+
+	for n, fallen := range []float64{0.5, 0, 1.5, 1} {
+		data := observation(time.Duration(n*10) * time.Second)
+		data.RainFall = fallen
+		data.RainDay = 10 + float64(n)
+		a.Add(data)
+	}
+
+	record := a.Flush()[0]
+	if math.Abs(record.RainFall-3) > 1e-9 {
+		t.Errorf("Expected 3 mm of rain, got %.2f mm", record.RainFall)
+	}
+	if record.RainDay != 13 {
+		t.Errorf("Expected last daily total, got %.2f", record.RainDay)
+	}
+}
+
+// TestFlaggedValues tests that flagged values are only used when nothing else is
+func TestFlaggedValues(t *testing.T) {
+	a := NewAggregator(time.Minute)
+
+	good := observation(0)
+	good.Temperature = 20
+	good.Humidity = 50
+	bad := observation(10 * time.Second)
+	bad.Temperature = 80
+	bad.Humidity = 0
+	bad.Flag("temperature", "range")
+	bad.Flag("humidity", "range")
+	worse := observation(20 * time.Second)
+	worse.Humidity = 0
+	worse.Flag("humidity", "range")
+	worse.Temperature = 20
+
+	a.Add(good)
+	a.Add(bad)
+	record := a.Flush()[0]
+	if record.Temperature != 20 || record.Flagged("temperature") {
+		t.Errorf("Expected flagged temperature to be left out, got %.2f (%v)", record.Temperature, record.QCFlags)
+	}
+
+	a = NewAggregator(time.Minute)
+	a.Add(bad)
+	a.Add(worse)
+	record = a.Flush()[0]
+	if !record.Flagged("humidity") || !record.Flagged("dewPoint") {
+		t.Errorf("Expected humidity and dew point to be flagged, got %v", record.QCFlags)
+	}
+}
+
+// TestUnreportedValues tests that observations are left out of the
+// measurements they did not report
+func TestUnreportedValues(t *testing.T) {
+	a := NewAggregator(time.Minute)
+
+	full := observation(0)
+	full.Temperature = 20
+	full.Humidity = 60
+	full.Report("temperature", "humidity")
+	partial := observation(20 * time.Second)
+	partial.Humidity = 40
+	partial.Report("humidity")
+
+	a.Add(full)
+	a.Add(partial)
+	record := a.Flush()[0]
+	if record.Temperature != 20 {
+		t.Errorf("Expected temperature 20 from the observation reporting it, got %.2f", record.Temperature)
+	}
+	if record.Humidity != 50 {
+		t.Errorf("Expected mean humidity 50, got %.2f", record.Humidity)
+	}
+	if !record.HasReported("temperature") || record.HasReported("windGust") {
+		t.Errorf("Expected the record to report what its observations did, got %v", record.Reported)
+	}
+}
+
+// TestLastReported tests that the pressure is kept from the last observation
+// that reported it
+func TestLastReported(t *testing.T) {
+	a := NewAggregator(time.Minute)
+
+	barometer := observation(0)
+	barometer.Pressure = 1012
+	barometer.Flag("pressure", "spike")
+	barometer.Report("pressure", "temperature")
+	outdoor := observation(20 * time.Second)
+	outdoor.Temperature = 20
+	outdoor.Report("temperature")
+
+	a.Add(barometer)
+	a.Add(outdoor)
+	record := a.Flush()[0]
+	if record.Pressure != 1012 || !record.Flagged("pressure") {
+		t.Errorf("Expected flagged pressure 1012 from the observation reporting it, got %.2f (flags %v)",
+			record.Pressure, record.QCFlags)
+	}
+}
+
+// TestIndoorReadings tests that a record carries the latest indoor reading
+func TestIndoorReadings(t *testing.T) {
+	a := NewAggregator(time.Minute)
+
+	first := observation(0)
+	first.Indoor = &models.IndoorData{Temperature: 20}
+	second := observation(20 * time.Second)
+	second.Indoor = &models.IndoorData{Temperature: 21}
+
+	a.Add(first)
+	a.Add(second)
+	a.Add(observation(40 * time.Second))
+	record := a.Flush()[0]
+	if record.Indoor == nil || record.Indoor.Temperature != 21 {
+		t.Fatalf("Expected the latest indoor reading, got %+v", record.Indoor)
+	}
+	if !record.Indoor.Timestamp.Equal(record.Timestamp) || second.Indoor.Timestamp.Equal(record.Timestamp) {
+		t.Errorf("Expected a copy of the reading at the record time %v, got %v", record.Timestamp, record.Indoor.Timestamp)
+	}
+}
+
+// TestDue tests closing records once their interval is over
+func TestDue(t *testing.T) {
+	a := NewAggregator(time.Minute)
+
+	// The device clock is two minutes slow
+	data := observation(10 * time.Second)
+	data.ReceivedAt = data.Timestamp.Add(2 * time.Minute)
+	a.Add(data)
+
+	if records := a.Due(base.Add(2*time.Minute + 30*time.Second)); len(records) != 0 {
+		t.Errorf("Expected record to stay open by the device clock, got %d", len(records))
+	}
+	if records := a.Due(base.Add(3*time.Minute + closeDelay)); len(records) != 1 {
+		t.Errorf("Expected record to close, got %d", len(records))
+	}
+}
@@ -3,6 +3,8 @@ package config
 import (
 	"fmt"
 	"os"
+	"regexp"
+	"strings"
 
 	"gopkg.in/yaml.v2"
 )
@@ -42,17 +44,141 @@ type DatabaseConfig struct {
 
 // CollectorConfig contains settings for data collection
 type CollectorConfig struct {
-	Type     string       `yaml:"type"` // interceptor or other methods
-	Device   DeviceConfig `yaml:"device"`
-	Interval int          `yaml:"interval"` // seconds
+	Type        string            `yaml:"type"` // interceptor, gw1000 or weatherlink to poll the device, or tempest
+	Device      DeviceConfig      `yaml:"device"`
+	Listeners   []ListenerConfig  `yaml:"listeners,omitempty"`
+	Ingest      IngestConfig      `yaml:"ingest"`
+	Tempest     TempestConfig     `yaml:"tempest"`
+	WeatherLink WeatherLinkConfig `yaml:"weatherlink"`
+	Interval    int               `yaml:"interval"` // archive record length in seconds; negative stores every upload
+
+	// MaxClockSkew is the largest difference allowed between the device
+	// reported observation time and the receive time, in seconds. Zero
+	// defaults to 300; negative disables the check.
+	MaxClockSkew int  `yaml:"max_clock_skew"`
+	RejectSkewed bool `yaml:"reject_skewed"` // reject rather than flag skewed observations
+
+	// Stations maps uploads to the stations that sent them. Without any
+	// mappings every observation belongs to the default station.
+	Stations []StationMapping `yaml:"stations,omitempty"`
+
+	QC     QCConfig     `yaml:"qc"`
+	Rain   RainConfig   `yaml:"rain"`
+	Health HealthConfig `yaml:"health"`
+
+	Buffer BufferConfig `yaml:"buffer"`
+	Batch  BatchConfig  `yaml:"batch"`
+}
+
+// BatchConfig contains settings for saving queued observations in multi-row inserts
+type BatchConfig struct {
+	Size          int `yaml:"size"`           // observations per insert
+	FlushInterval int `yaml:"flush_interval"` // seconds before a partial batch is saved
+	QueueSize     int `yaml:"queue_size"`     // queued observations before uploads are refused
+}
+
+// BufferConfig contains settings for the on-disk buffer that holds
+// observations until they are saved to the database
+type BufferConfig struct {
+	Dir            string `yaml:"dir"`              // directory for the segment files; empty writes to the database directly
+	MaxSegmentSize int64  `yaml:"max_segment_size"` // bytes per segment file
+}
+
+// QCConfig contains sensor quality control settings
+type QCConfig struct {
+	Disabled    bool                     `yaml:"disabled"`
+	MaxGap      int                      `yaml:"max_gap"`          // seconds between readings compared by step checks
+	StuckPeriod int                      `yaml:"stuck_period"`     // seconds a value may stay unchanged, negative disables
+	Limits      map[string]QCLimitConfig `yaml:"limits,omitempty"` // overrides keyed by measurement name, e.g. temperature
+}
+
+// RainConfig contains rain accounting settings
+type RainConfig struct {
+	YearStart int `yaml:"year_start"` // month the rain year starts in, 1-12
+}
+
+// HealthConfig contains sensor health monitoring settings
+type HealthConfig struct {
+	StaleAfter int `yaml:"stale_after"` // seconds without a report before a sensor is stale
+}
+
+// QCLimitConfig overrides the plausible range and step of a measurement
+type QCLimitConfig struct {
+	Min     *float64 `yaml:"min,omitempty"`
+	Max     *float64 `yaml:"max,omitempty"`
+	MaxStep *float64 `yaml:"max_step,omitempty"` // largest change between consecutive readings
+}
+
+// StationMapping identifies one of several stations reporting to the collector.
+// An upload belongs to the station if any of its identifiers match.
+type StationMapping struct {
+	ID       string         `yaml:"id"`
+	Name     string         `yaml:"name"`
+	Passkey  string         `yaml:"passkey,omitempty"`   // Ecowitt PASSKEY
+	MAC      string         `yaml:"mac,omitempty"`       // console MAC address
+	UploadID string         `yaml:"upload_id,omitempty"` // Weather Underground protocol ID or Tempest serial number
+	Location LocationConfig `yaml:"location"`
+}
+
+// ListenerConfig describes an interceptor HTTP listener and the device
+// protocols it accepts
+type ListenerConfig struct {
+	Port     int      `yaml:"port"`
+	Decoders []string `yaml:"decoders"` // ecowitt, wunderground
+}
+
+// IngestConfig describes the device protocols accepted by the web server,
+// each under its own path below Path, e.g. /ingest/ecowitt
+type IngestConfig struct {
+	Path     string   `yaml:"path"`     // path the decoders are mounted under; empty disables
+	Decoders []string `yaml:"decoders"` // ecowitt, wunderground
+}
+
+// TempestConfig contains settings for receiving the UDP broadcasts of
+// WeatherFlow Tempest hubs, alongside uploads or, with collector type
+// tempest, instead of them
+type TempestConfig struct {
+	Enabled bool `yaml:"enabled"`
+	Port    int  `yaml:"port"` // UDP port the hubs broadcast on
+}
+
+// WeatherLinkConfig contains settings for polling a Davis WeatherLink Live
+// with collector type weatherlink
+type WeatherLinkConfig struct {
+	Transmitter     int  `yaml:"transmitter"`       // ID of the ISS transmitter, 1-8; 0 for the first ISS
+	DisableRealTime bool `yaml:"disable_real_time"` // poll wind and rain rate instead of receiving broadcasts
 }
 
 // DeviceConfig contains information about the weather device
 type DeviceConfig struct {
 	Type    string `yaml:"type"`    // ecowitt, etc
 	Model   string `yaml:"model"`   // GW1000, etc
-	Address string `yaml:"address"` // IP address
+	Address string `yaml:"address"` // IP address, with the LAN API port for gw1000 polling
 	Port    int    `yaml:"port"`    // Port to listen on
+
+	// Upload authentication; when none are set every upload is accepted
+	Passkeys    []string           `yaml:"passkeys,omitempty"`    // allowed Ecowitt PASSKEYs
+	Credentials []CredentialConfig `yaml:"credentials,omitempty"` // allowed Weather Underground ID/PASSWORD pairs
+	HMACSecret  string             `yaml:"hmac_secret,omitempty"` // shared secret for X-Signature signed uploads
+
+	Upload UploadConfig `yaml:"upload"`
+}
+
+// UploadConfig describes the customized upload the collector keeps the
+// device pointed at, set over the device's LAN API
+type UploadConfig struct {
+	Configure     bool   `yaml:"configure"`      // check the device's settings on startup and correct them
+	Protocol      string `yaml:"protocol"`       // ecowitt or wunderground
+	Host          string `yaml:"host"`           // address the device uploads to; defaults to this machine's address on the device's network
+	Port          int    `yaml:"port"`           // port the device uploads to; defaults to where the protocol is accepted
+	Interval      int    `yaml:"interval"`       // seconds between uploads
+	CheckInterval int    `yaml:"check_interval"` // seconds between checks of the device's settings
+}
+
+// CredentialConfig is a Weather Underground style station ID and password
+type CredentialConfig struct {
+	ID       string `yaml:"id"`
+	Password string `yaml:"password"`
 }
 
 // ServerConfig contains web server settings
@@ -79,7 +205,10 @@ type PublisherConfig struct {
 	URL       string            `yaml:"url,omitempty"`
 	Method    string            `yaml:"method,omitempty"`
 	Headers   map[string]string `yaml:"headers,omitempty"`
-	Interval  int               `yaml:"interval"` // seconds
+	Interval  int               `yaml:"interval"`          // seconds
+	Station   string            `yaml:"station,omitempty"` // station to publish; defaults to the latest from any station
+
+	SkipFlagged bool `yaml:"skip_flagged"` // leave out measurements that failed quality control
 }
 
 // LoggingConfig contains logging settings
@@ -142,6 +271,85 @@ func applyDefaults(config *Config) {
 	if config.Collector.Interval == 0 {
 		config.Collector.Interval = 60 // 1 minute default
 	}
+
+	// Set default maximum clock skew if not specified
+	if config.Collector.MaxClockSkew == 0 {
+		config.Collector.MaxClockSkew = 300 // 5 minutes default
+	}
+
+	// Set default quality control windows if not specified
+	if config.Collector.QC.MaxGap == 0 {
+		config.Collector.QC.MaxGap = 900 // 15 minutes default
+	}
+	if config.Collector.QC.StuckPeriod == 0 {
+		config.Collector.QC.StuckPeriod = 10800 // 3 hours default
+	}
+
+	// Set default rain year start if not specified
+	if config.Collector.Rain.YearStart == 0 {
+		config.Collector.Rain.YearStart = 1 // calendar year
+	}
+
+	// Set default sensor stale time if not specified
+	if config.Collector.Health.StaleAfter == 0 {
+		config.Collector.Health.StaleAfter = 600 // 10 minutes default
+	}
+
+	// Set default write batching if not specified
+	if config.Collector.Batch.Size == 0 {
+		config.Collector.Batch.Size = 50
+	}
+	if config.Collector.Batch.FlushInterval == 0 {
+		config.Collector.Batch.FlushInterval = 2
+	}
+	if config.Collector.Batch.QueueSize == 0 {
+		config.Collector.Batch.QueueSize = 1000
+	}
+
+	// Set default Tempest broadcast port if not specified
+	if config.Collector.Tempest.Port == 0 {
+		config.Collector.Tempest.Port = 50222
+	}
+
+	// Set default device upload settings if not specified
+	upload := &config.Collector.Device.Upload
+	if upload.Protocol == "" {
+		upload.Protocol = "ecowitt"
+		if config.Collector.Device.Type == "wunderground" {
+			upload.Protocol = "wunderground"
+		}
+	}
+	if upload.Interval == 0 {
+		upload.Interval = 60
+	}
+	if upload.CheckInterval == 0 {
+		upload.CheckInterval = 600 // 10 minutes default
+	}
+
+	// Default to the configured device's protocol and the Weather
+	// Underground protocol most consoles can push, accepted on the web
+	// server under the ingest path or else, unless the device is polled or
+	// heard over UDP, on a listener of their own
+	if config.Collector.Device.Type != "" {
+		decoders := []string{config.Collector.Device.Type}
+		if config.Collector.Device.Type != "wunderground" {
+			decoders = append(decoders, "wunderground")
+		}
+
+		if config.Collector.Ingest.Path != "" {
+			if len(config.Collector.Ingest.Decoders) == 0 {
+				config.Collector.Ingest.Decoders = decoders
+			}
+		} else if len(config.Collector.Listeners) == 0 && (config.Collector.Type == "" || config.Collector.Type == "interceptor") {
+			if config.Collector.Device.Port == 0 {
+				config.Collector.Device.Port = 8000
+			}
+			config.Collector.Listeners = []ListenerConfig{{
+				Port:     config.Collector.Device.Port,
+				Decoders: decoders,
+			}}
+		}
+	}
 }
 
 // validateConfig verifies that the configuration is valid
@@ -156,6 +364,95 @@ func validateConfig(config *Config) error {
 		return fmt.Errorf("server type must be 'caddy' or 'nginx'")
 	}
 
+	// Validate the collection method
+	switch config.Collector.Type {
+	case "", "interceptor":
+	case "gw1000":
+		if config.Collector.Device.Address == "" {
+			return fmt.Errorf("collector type gw1000 requires the device address")
+		}
+	case "weatherlink":
+		if config.Collector.Device.Address == "" {
+			return fmt.Errorf("collector type weatherlink requires the device address")
+		}
+	case "tempest":
+	default:
+		return fmt.Errorf("collector type must be 'interceptor', 'gw1000', 'weatherlink' or 'tempest'")
+	}
+	if tx := config.Collector.WeatherLink.Transmitter; tx < 0 || tx > 8 {
+		return fmt.Errorf("collector weatherlink transmitter must be between 1 and 8")
+	}
+	if port := config.Collector.Tempest.Port; port < 0 || port > 65535 {
+		return fmt.Errorf("collector tempest port must be between 1 and 65535")
+	}
+
+	// Validate where uploads are accepted
+	if path := config.Collector.Ingest.Path; path != "" && !strings.HasPrefix(path, "/") {
+		return fmt.Errorf("collector ingest path must start with /")
+	}
+	for _, l := range config.Collector.Listeners {
+		if l.Port != 0 && l.Port == config.Server.Port {
+			return fmt.Errorf("collector listener port %d is the web server port; use collector ingest path to accept uploads on the web server", l.Port)
+		}
+	}
+
+	// Validate the device upload settings, which only apply to a device
+	// uploading to the interceptor
+	if upload := config.Collector.Device.Upload; upload.Configure {
+		if config.Collector.Type != "" && config.Collector.Type != "interceptor" {
+			return fmt.Errorf("collector device upload requires collector type interceptor")
+		}
+		if config.Collector.Device.Address == "" {
+			return fmt.Errorf("collector device upload requires the device address")
+		}
+		if upload.Protocol != "ecowitt" && upload.Protocol != "wunderground" {
+			return fmt.Errorf("collector device upload protocol must be 'ecowitt' or 'wunderground'")
+		}
+		if upload.Interval < 16 || upload.Interval > 600 {
+			return fmt.Errorf("collector device upload interval must be between 16 and 600 seconds")
+		}
+		if upload.Port < 0 || upload.Port > 65535 {
+			return fmt.Errorf("collector device upload port must be between 1 and 65535")
+		}
+		if upload.CheckInterval < 0 {
+			return fmt.Errorf("collector device upload check_interval must not be negative")
+		}
+	}
+
+	// Validate station mappings
+	stationIDs := make(map[string]bool)
+	for _, st := range config.Collector.Stations {
+		if st.ID == "" {
+			return fmt.Errorf("station mapping requires an id")
+		}
+		if stationIDs[st.ID] {
+			return fmt.Errorf("duplicate station id: %s", st.ID)
+		}
+		stationIDs[st.ID] = true
+		if st.Passkey == "" && st.MAC == "" && st.UploadID == "" {
+			return fmt.Errorf("station %s requires a passkey, mac or upload_id", st.ID)
+		}
+	}
+
+	// Validate rain accounting
+	if config.Collector.Rain.YearStart < 0 || config.Collector.Rain.YearStart > 12 {
+		return fmt.Errorf("collector rain year_start must be a month from 1 to 12")
+	}
+
+	// Validate sensor health monitoring
+	if config.Collector.Health.StaleAfter < 0 {
+		return fmt.Errorf("collector health stale_after must not be negative")
+	}
+
+	// Validate write batching; PostgreSQL allows 65535 parameters per statement
+	batch := config.Collector.Batch
+	if batch.Size < 0 || batch.Size > 1000 {
+		return fmt.Errorf("collector batch size must be between 1 and 1000")
+	}
+	if batch.FlushInterval < 0 || batch.QueueSize < 0 {
+		return fmt.Errorf("collector batch flush_interval and queue_size must not be negative")
+	}
+
 	// If SSL is enabled, verify that certificate and key files are specified
 	if config.Server.SSL.Enabled {
 		if config.Server.SSL.CertFile == "" || config.Server.SSL.KeyFile == "" {
@@ -165,3 +462,89 @@ func validateConfig(config *Config) error {
 
 	return nil
 }
+
+// deviceSetting matches a setting line in a configuration file: its
+// indentation, key, value and the comment after it
+var deviceSetting = regexp.MustCompile(`^(\s+)(\w+):\s*("[^"]*"|[^\s#]*)(.*)$`)
+
+// UpdateDevice sets the address and, unless empty, the model of
+// collector.device in the configuration file at path. The rest of the file,
+// comments included, is left as it is.
+func UpdateDevice(path, address, model string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	values := map[string]string{"address": address}
+	if model != "" {
+		values["model"] = model
+	}
+
+	lines := strings.Split(string(content), "\n")
+	section := ""
+	deviceLine, deviceIndent, settingIndent := -1, -1, -1
+	inDevice := false
+	for n, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+
+		// Track the top level section and whether we are in its device block
+		if indent == 0 {
+			section = strings.TrimSuffix(strings.Fields(trimmed)[0], ":")
+			inDevice = false
+			continue
+		}
+		if inDevice && indent <= deviceIndent {
+			inDevice = false
+		}
+		if section == "collector" && deviceLine < 0 && strings.HasPrefix(trimmed, "device:") {
+			deviceLine, deviceIndent, inDevice = n, indent, true
+			continue
+		}
+		if !inDevice {
+			continue
+		}
+
+		if settingIndent < 0 {
+			settingIndent = indent
+		}
+		match := deviceSetting.FindStringSubmatch(line)
+		if match == nil || indent != settingIndent {
+			continue
+		}
+		if value, ok := values[match[2]]; ok {
+			lines[n] = fmt.Sprintf("%s%s: %q%s", match[1], match[2], value, match[4])
+			delete(values, match[2])
+		}
+	}
+
+	if deviceLine < 0 {
+		return fmt.Errorf("no collector device section in %s", path)
+	}
+
+	// Add the settings the file does not have yet
+	if settingIndent < 0 {
+		settingIndent = deviceIndent + 2
+	}
+	var added []string
+	for _, key := range []string{"model", "address"} {
+		if value, ok := values[key]; ok {
+			added = append(added, fmt.Sprintf("%s%s: %q", strings.Repeat(" ", settingIndent), key, value))
+		}
+	}
+	lines = append(lines[:deviceLine+1], append(added, lines[deviceLine+1:]...)...)
+
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+
+	return nil
+}
@@ -2,7 +2,11 @@ package config
 
 import (
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	"gopkg.in/yaml.v2"
 )
 
 // TestLoadConfig tests the loading of configuration from a YAML file
@@ -35,7 +39,7 @@ collector:
     type: "ecowitt"
     model: "GW1000"
     address: "192.168.1.100"
-    port: 8080
+    port: 8000
   interval: 60
 server:
   type: "caddy"
@@ -192,6 +196,110 @@ func TestValidateConfig(t *testing.T) {
 	if err := validateConfig(invalidConfig); err == nil {
 		t.Errorf("validateConfig did not return error for invalid config (missing station name)")
 	}
+
+	// Test station mappings
+	validConfig.Collector.Stations = []StationMapping{
+		{ID: "backyard", Passkey: "ABC"},
+		{ID: "roof", MAC: "AA:BB:CC:DD:EE:FF"},
+	}
+	if err := validateConfig(validConfig); err != nil {
+		t.Errorf("validateConfig returned error for valid station mappings: %v", err)
+	}
+
+	validConfig.Collector.Stations = append(validConfig.Collector.Stations, StationMapping{ID: "roof", UploadID: "KTX1"})
+	if err := validateConfig(validConfig); err == nil {
+		t.Errorf("validateConfig did not return error for duplicate station ids")
+	}
+
+	validConfig.Collector.Stations = []StationMapping{{ID: "shed"}}
+	if err := validateConfig(validConfig); err == nil {
+		t.Errorf("validateConfig did not return error for a station without identifiers")
+	}
+	validConfig.Collector.Stations = nil
+
+	// Test rain accounting
+	validConfig.Collector.Rain.YearStart = 13
+	if err := validateConfig(validConfig); err == nil {
+		t.Errorf("validateConfig did not return error for an invalid rain year start")
+	}
+	validConfig.Collector.Rain.YearStart = 10
+
+	// Test sensor health monitoring
+	validConfig.Collector.Health.StaleAfter = -1
+	if err := validateConfig(validConfig); err == nil {
+		t.Errorf("validateConfig did not return error for a negative stale_after")
+	}
+	validConfig.Collector.Health.StaleAfter = 600
+
+	// Test where uploads are accepted
+	validConfig.Collector.Listeners = []ListenerConfig{{Port: 8080, Decoders: []string{"ecowitt"}}}
+	if err := validateConfig(validConfig); err == nil {
+		t.Errorf("validateConfig did not return error for a listener on the web server port")
+	}
+	validConfig.Collector.Listeners = nil
+
+	validConfig.Collector.Ingest.Path = "ingest"
+	if err := validateConfig(validConfig); err == nil {
+		t.Errorf("validateConfig did not return error for a relative ingest path")
+	}
+	validConfig.Collector.Ingest.Path = "/ingest"
+
+	// Test the collection method
+	validConfig.Collector.Type = "gw1000"
+	validConfig.Collector.Device.Address = ""
+	if err := validateConfig(validConfig); err == nil {
+		t.Errorf("validateConfig did not return error for gw1000 polling without an address")
+	}
+	validConfig.Collector.Device.Address = "192.168.1.100"
+	if err := validateConfig(validConfig); err != nil {
+		t.Errorf("validateConfig returned error for gw1000 polling: %v", err)
+	}
+	validConfig.Collector.Type = "weatherlink"
+	if err := validateConfig(validConfig); err != nil {
+		t.Errorf("validateConfig returned error for WeatherLink Live polling: %v", err)
+	}
+	validConfig.Collector.WeatherLink.Transmitter = 9
+	if err := validateConfig(validConfig); err == nil {
+		t.Errorf("validateConfig did not return error for an invalid WeatherLink transmitter")
+	}
+	validConfig.Collector.WeatherLink.Transmitter = 0
+	validConfig.Collector.Type = "tempest"
+	if err := validateConfig(validConfig); err != nil {
+		t.Errorf("validateConfig returned error for tempest broadcasts: %v", err)
+	}
+	validConfig.Collector.Type = "carrier-pigeon"
+	if err := validateConfig(validConfig); err == nil {
+		t.Errorf("validateConfig did not return error for an unknown collector type")
+	}
+	validConfig.Collector.Type = "interceptor"
+
+	// Test the device upload settings
+	validConfig.Collector.Device.Upload = UploadConfig{Configure: true, Protocol: "ecowitt", Interval: 60}
+	if err := validateConfig(validConfig); err != nil {
+		t.Errorf("validateConfig returned error for a device upload: %v", err)
+	}
+	validConfig.Collector.Device.Upload.Interval = 5
+	if err := validateConfig(validConfig); err == nil {
+		t.Errorf("validateConfig did not return error for a device upload interval under 16 seconds")
+	}
+	validConfig.Collector.Device.Upload.Interval = 60
+	validConfig.Collector.Device.Upload.Protocol = "ftp"
+	if err := validateConfig(validConfig); err == nil {
+		t.Errorf("validateConfig did not return error for an unknown device upload protocol")
+	}
+	validConfig.Collector.Device.Upload.Protocol = "ecowitt"
+	validConfig.Collector.Type = "gw1000"
+	if err := validateConfig(validConfig); err == nil {
+		t.Errorf("validateConfig did not return error for a device upload while polling")
+	}
+	validConfig.Collector.Type = "interceptor"
+	validConfig.Collector.Device.Upload = UploadConfig{}
+
+	// Test write batching
+	validConfig.Collector.Batch.Size = 5000
+	if err := validateConfig(validConfig); err == nil {
+		t.Errorf("validateConfig did not return error for an oversized batch")
+	}
 }
 
 // TestApplyDefaults tests the default value application
@@ -228,4 +336,165 @@ func TestApplyDefaults(t *testing.T) {
 	if minimalConfig.Collector.Interval != 60 {
 		t.Errorf("Default collector interval not applied, expected 60, got %d", minimalConfig.Collector.Interval)
 	}
+
+	if minimalConfig.Collector.QC.MaxGap != 900 || minimalConfig.Collector.QC.StuckPeriod != 10800 {
+		t.Errorf("Default QC windows not applied, expected 900/10800, got %d/%d",
+			minimalConfig.Collector.QC.MaxGap, minimalConfig.Collector.QC.StuckPeriod)
+	}
+
+	if minimalConfig.Collector.Health.StaleAfter != 600 {
+		t.Errorf("Default sensor stale time not applied, expected 600, got %d", minimalConfig.Collector.Health.StaleAfter)
+	}
+
+	if batch := minimalConfig.Collector.Batch; batch.Size != 50 || batch.FlushInterval != 2 || batch.QueueSize != 1000 {
+		t.Errorf("Default write batching not applied, expected 50/2/1000, got %d/%d/%d",
+			batch.Size, batch.FlushInterval, batch.QueueSize)
+	}
+
+	if upload := minimalConfig.Collector.Device.Upload; upload.Protocol != "ecowitt" || upload.Interval != 60 || upload.CheckInterval != 600 {
+		t.Errorf("Default device upload not applied, expected ecowitt/60/600, got %s/%d/%d",
+			upload.Protocol, upload.Interval, upload.CheckInterval)
+	}
+
+	// No device configured, so no listener should be created
+	if len(minimalConfig.Collector.Listeners) != 0 {
+		t.Errorf("Expected no default listeners without a device, got %d", len(minimalConfig.Collector.Listeners))
+	}
+
+	// A configured device gets a single listener for its protocol
+	deviceConfig := &Config{
+		Collector: CollectorConfig{
+			Device: DeviceConfig{Type: "ecowitt", Port: 8000},
+		},
+	}
+	applyDefaults(deviceConfig)
+
+	if len(deviceConfig.Collector.Listeners) != 1 {
+		t.Fatalf("Expected 1 default listener, got %d", len(deviceConfig.Collector.Listeners))
+	}
+
+	listener := deviceConfig.Collector.Listeners[0]
+	if listener.Port != 8000 || len(listener.Decoders) != 2 ||
+		listener.Decoders[0] != "ecowitt" || listener.Decoders[1] != "wunderground" {
+		t.Errorf("Expected default listener on port 8000 for ecowitt and wunderground, got %+v", listener)
+	}
+
+	// An ingest path accepts the device protocols on the web server instead
+	ingestConfig := &Config{
+		Collector: CollectorConfig{
+			Device: DeviceConfig{Type: "ecowitt"},
+			Ingest: IngestConfig{Path: "/ingest"},
+		},
+	}
+	applyDefaults(ingestConfig)
+
+	if len(ingestConfig.Collector.Listeners) != 0 {
+		t.Errorf("Expected no default listener with an ingest path, got %d", len(ingestConfig.Collector.Listeners))
+	}
+	if decoders := ingestConfig.Collector.Ingest.Decoders; len(decoders) != 2 || decoders[0] != "ecowitt" || decoders[1] != "wunderground" {
+		t.Errorf("Expected ingest of ecowitt and wunderground, got %v", decoders)
+	}
+
+	// Without a port the default listener uses 8000, apart from the web server
+	portConfig := &Config{Collector: CollectorConfig{Device: DeviceConfig{Type: "ecowitt"}}}
+	applyDefaults(portConfig)
+	if port := portConfig.Collector.Listeners[0].Port; port != 8000 {
+		t.Errorf("Expected default listener on port 8000, got %d", port)
+	}
+
+	// A polled device gets no listener
+	pollConfig := &Config{Collector: CollectorConfig{Type: "gw1000", Device: DeviceConfig{Type: "ecowitt"}}}
+	applyDefaults(pollConfig)
+	if len(pollConfig.Collector.Listeners) != 0 {
+		t.Errorf("Expected no default listener for a polled device, got %d", len(pollConfig.Collector.Listeners))
+	}
+
+	// Nor do Tempest broadcasts, which use the default port
+	tempestConfig := &Config{Collector: CollectorConfig{Type: "tempest", Device: DeviceConfig{Type: "ecowitt"}}}
+	applyDefaults(tempestConfig)
+	if len(tempestConfig.Collector.Listeners) != 0 {
+		t.Errorf("Expected no default listener for Tempest broadcasts, got %d", len(tempestConfig.Collector.Listeners))
+	}
+	if port := tempestConfig.Collector.Tempest.Port; port != 50222 {
+		t.Errorf("Expected default Tempest port 50222, got %d", port)
+	}
+}
+
+// TestUpdateDevice tests setting the device in a configuration file
+func TestUpdateDevice(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	original := `# Data collection
+collector:
+  type: "gw1000"
+  device:
+    type: "ecowitt"
+    model: "GW1000"
+    address: "192.168.1.100"  # IP address of your GW1000
+    port: 8000
+  interval: 60
+server:
+  address: "0.0.0.0"
+`
+	if err := os.WriteFile(path, []byte(original), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	if err := UpdateDevice(path, "10.0.0.7", "GW1100A"); err != nil {
+		t.Fatalf("UpdateDevice returned error: %v", err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read config file: %v", err)
+	}
+	var cfg Config
+	if err := yaml.Unmarshal(content, &cfg); err != nil {
+		t.Fatalf("Updated config file is invalid: %v", err)
+	}
+	if cfg.Collector.Device.Address != "10.0.0.7" || cfg.Collector.Device.Model != "GW1100A" {
+		t.Errorf("Expected device GW1100A at 10.0.0.7, got %+v", cfg.Collector.Device)
+	}
+	if cfg.Server.Address != "0.0.0.0" || cfg.Collector.Device.Port != 8000 {
+		t.Errorf("Expected other settings to be unchanged, got %+v", cfg)
+	}
+	if !strings.Contains(string(content), "# IP address of your GW1000") || !strings.HasPrefix(string(content), "# Data collection") {
+		t.Errorf("Expected comments to be kept, got:\n%s", content)
+	}
+
+	// Missing settings are added to the device section
+	if err := os.WriteFile(path, []byte("collector:\n  device:\n    type: \"ecowitt\"\n"), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	if err := UpdateDevice(path, "10.0.0.7", ""); err != nil {
+		t.Fatalf("UpdateDevice returned error: %v", err)
+	}
+	content, _ = os.ReadFile(path)
+	cfg = Config{}
+	if err := yaml.Unmarshal(content, &cfg); err != nil || cfg.Collector.Device.Address != "10.0.0.7" {
+		t.Errorf("Expected the address to be added, got:\n%s", content)
+	}
+
+	// Settings are indented below a device section without any
+	if err := os.WriteFile(path, []byte("collector:\n  device:\n  interval: 60\n"), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	if err := UpdateDevice(path, "10.0.0.7", "GW1100A"); err != nil {
+		t.Fatalf("UpdateDevice returned error: %v", err)
+	}
+	content, _ = os.ReadFile(path)
+	cfg = Config{}
+	if err := yaml.Unmarshal(content, &cfg); err != nil || cfg.Collector.Device.Address != "10.0.0.7" || cfg.Collector.Interval != 60 {
+		t.Errorf("Expected the settings to be added to the empty device section, got:\n%s", content)
+	}
+	if !strings.Contains(string(content), "\n    address: \"10.0.0.7\"\n") {
+		t.Errorf("Expected the settings indented below the device section, got:\n%s", content)
+	}
+
+	// A file without a device section is not changed
+	if err := os.WriteFile(path, []byte("server:\n  port: 8080\n"), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	if err := UpdateDevice(path, "10.0.0.7", ""); err == nil {
+		t.Errorf("Expected an error without a collector device section")
+	}
 }
@@ -0,0 +1,233 @@
+package database
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ask-23/go-wx/internal/models"
+)
+
+// weatherDataField maps a weather_data column to the WeatherData field it holds
+type weatherDataField struct {
+	column string
+	field  func(data *models.WeatherData) interface{}
+}
+
+// weatherDataFields lists every weather_data column written and read for an
+// observation. The field functions return pointers, which database/sql
+// dereferences for query arguments and fills in when scanning rows.
+var weatherDataFields = []weatherDataField{
+	{"station", func(d *models.WeatherData) interface{} { return &d.StationID }},
+	{"timestamp", func(d *models.WeatherData) interface{} { return &d.Timestamp }},
+	{"received_at", func(d *models.WeatherData) interface{} { return nullTime{&d.ReceivedAt} }},
+	{"clock_skewed", func(d *models.WeatherData) interface{} { return &d.ClockSkewed }},
+	{"temperature", func(d *models.WeatherData) interface{} { return &d.Temperature }},
+	{"humidity", func(d *models.WeatherData) interface{} { return &d.Humidity }},
+	{"pressure", func(d *models.WeatherData) interface{} { return &d.Pressure }},
+	{"wind_speed", func(d *models.WeatherData) interface{} { return &d.WindSpeed }},
+	{"wind_direction", func(d *models.WeatherData) interface{} { return &d.WindDirection }},
+	{"rain", func(d *models.WeatherData) interface{} { return &d.RainRate }},
+	{"uv_index", func(d *models.WeatherData) interface{} { return &d.UVIndex }},
+	{"cloud_base", func(d *models.WeatherData) interface{} { return &d.CloudBase }},
+	{"relative_pressure", func(d *models.WeatherData) interface{} { return &d.RelativePressure }},
+	{"wind_gust", func(d *models.WeatherData) interface{} { return &d.WindGust }},
+	{"max_daily_gust", func(d *models.WeatherData) interface{} { return &d.MaxDailyGust }},
+	{"solar_radiation", func(d *models.WeatherData) interface{} { return &d.SolarRadiation }},
+	{"event_rain", func(d *models.WeatherData) interface{} { return &d.EventRain }},
+	{"rain_start_time", func(d *models.WeatherData) interface{} { return &d.RainStartTime }},
+	{"hourly_rain", func(d *models.WeatherData) interface{} { return &d.HourlyRain }},
+	{"daily_rain", func(d *models.WeatherData) interface{} { return &d.DailyRain }},
+	{"weekly_rain", func(d *models.WeatherData) interface{} { return &d.WeeklyRain }},
+	{"monthly_rain", func(d *models.WeatherData) interface{} { return &d.MonthlyRain }},
+	{"yearly_rain", func(d *models.WeatherData) interface{} { return &d.YearlyRain }},
+	{"total_rain", func(d *models.WeatherData) interface{} { return &d.TotalRain }},
+	{"rain_fall", func(d *models.WeatherData) interface{} { return &d.RainFall }},
+	{"rain_hour", func(d *models.WeatherData) interface{} { return &d.RainHour }},
+	{"rain_day", func(d *models.WeatherData) interface{} { return &d.RainDay }},
+	{"rain_month", func(d *models.WeatherData) interface{} { return &d.RainMonth }},
+	{"rain_year", func(d *models.WeatherData) interface{} { return &d.RainYear }},
+	{"wind_avg_2m", func(d *models.WeatherData) interface{} { return &d.WindAvg2m }},
+	{"wind_avg_10m", func(d *models.WeatherData) interface{} { return &d.WindAvg10m }},
+	{"wind_dir_avg_10m", func(d *models.WeatherData) interface{} { return &d.WindDirAvg10m }},
+	{"peak_gust_hour", func(d *models.WeatherData) interface{} { return &d.PeakGustHour }},
+	{"peak_gust_hour_time", func(d *models.WeatherData) interface{} { return &d.PeakGustHourTime }},
+	{"peak_gust_day", func(d *models.WeatherData) interface{} { return &d.PeakGustDay }},
+	{"peak_gust_day_time", func(d *models.WeatherData) interface{} { return &d.PeakGustDayTime }},
+	{"lightning_distance", func(d *models.WeatherData) interface{} { return &d.LightningDistance }},
+	{"lightning_time", func(d *models.WeatherData) interface{} { return &d.LightningTime }},
+	{"lightning_count", func(d *models.WeatherData) interface{} { return &d.LightningCount }},
+	{"channels", func(d *models.WeatherData) interface{} { return &d.Channels }},
+	{"qc_flags", func(d *models.WeatherData) interface{} { return &d.QCFlags }},
+	{"raw_upload_id", func(d *models.WeatherData) interface{} { return &d.RawUploadID }},
+	{"first_raw_upload_id", func(d *models.WeatherData) interface{} { return &d.FirstRawUploadID }},
+	{"last_raw_upload_id", func(d *models.WeatherData) interface{} { return &d.LastRawUploadID }},
+}
+
+// nullTime stores a zero time as NULL and reads NULL back as a zero time, so
+// columns added to existing tables can map onto plain time.Time fields
+type nullTime struct {
+	t *time.Time
+}
+
+// Value implements driver.Valuer
+func (n nullTime) Value() (driver.Value, error) {
+	if n.t.IsZero() {
+		return nil, nil
+	}
+	return *n.t, nil
+}
+
+// Scan implements sql.Scanner
+func (n nullTime) Scan(src interface{}) error {
+	var nt sql.NullTime
+	if err := nt.Scan(src); err != nil {
+		return err
+	}
+	*n.t = nt.Time
+	return nil
+}
+
+// columnDefinition describes a column added to a table after its initial schema
+type columnDefinition struct {
+	name     string
+	mariadb  string // column type for MariaDB
+	postgres string // column type for PostgreSQL
+}
+
+// weatherDataAddedColumns are created on existing weather_data tables at startup.
+// Numeric columns default to zero so rows written before the column existed
+// can still be scanned into WeatherData.
+var weatherDataAddedColumns = []columnDefinition{
+	{"relative_pressure", "FLOAT DEFAULT 0", "FLOAT DEFAULT 0"},
+	{"wind_gust", "FLOAT DEFAULT 0", "FLOAT DEFAULT 0"},
+	{"max_daily_gust", "FLOAT DEFAULT 0", "FLOAT DEFAULT 0"},
+	{"solar_radiation", "FLOAT DEFAULT 0", "FLOAT DEFAULT 0"},
+	{"event_rain", "FLOAT DEFAULT 0", "FLOAT DEFAULT 0"},
+	{"hourly_rain", "FLOAT DEFAULT 0", "FLOAT DEFAULT 0"},
+	{"daily_rain", "FLOAT DEFAULT 0", "FLOAT DEFAULT 0"},
+	{"weekly_rain", "FLOAT DEFAULT 0", "FLOAT DEFAULT 0"},
+	{"monthly_rain", "FLOAT DEFAULT 0", "FLOAT DEFAULT 0"},
+	{"yearly_rain", "FLOAT DEFAULT 0", "FLOAT DEFAULT 0"},
+	{"total_rain", "FLOAT DEFAULT 0", "FLOAT DEFAULT 0"},
+	{"lightning_distance", "FLOAT DEFAULT 0", "FLOAT DEFAULT 0"},
+	{"lightning_time", "DATETIME NULL", "TIMESTAMP NULL"},
+	{"lightning_count", "INT DEFAULT 0", "INTEGER DEFAULT 0"},
+	{"channels", "TEXT NULL", "TEXT NULL"},
+	{"received_at", "DATETIME NULL", "TIMESTAMP NULL"},
+	{"clock_skewed", "BOOLEAN DEFAULT FALSE", "BOOLEAN DEFAULT FALSE"},
+	{"station", "VARCHAR(64) NOT NULL DEFAULT 'default'", "VARCHAR(64) NOT NULL DEFAULT 'default'"},
+	{"qc_flags", "TEXT NULL", "TEXT NULL"},
+	{"raw_upload_id", "BIGINT DEFAULT 0", "BIGINT DEFAULT 0"},
+	{"rain_fall", "FLOAT DEFAULT 0", "FLOAT DEFAULT 0"},
+	{"rain_hour", "FLOAT DEFAULT 0", "FLOAT DEFAULT 0"},
+	{"rain_day", "FLOAT DEFAULT 0", "FLOAT DEFAULT 0"},
+	{"rain_month", "FLOAT DEFAULT 0", "FLOAT DEFAULT 0"},
+	{"rain_year", "FLOAT DEFAULT 0", "FLOAT DEFAULT 0"},
+	{"wind_avg_2m", "FLOAT DEFAULT 0", "FLOAT DEFAULT 0"},
+	{"wind_avg_10m", "FLOAT DEFAULT 0", "FLOAT DEFAULT 0"},
+	{"wind_dir_avg_10m", "FLOAT DEFAULT 0", "FLOAT DEFAULT 0"},
+	{"peak_gust_hour", "FLOAT DEFAULT 0", "FLOAT DEFAULT 0"},
+	{"peak_gust_hour_time", "DATETIME NULL", "TIMESTAMP NULL"},
+	{"peak_gust_day", "FLOAT DEFAULT 0", "FLOAT DEFAULT 0"},
+	{"peak_gust_day_time", "DATETIME NULL", "TIMESTAMP NULL"},
+	{"first_raw_upload_id", "BIGINT DEFAULT 0", "BIGINT DEFAULT 0"},
+	{"last_raw_upload_id", "BIGINT DEFAULT 0", "BIGINT DEFAULT 0"},
+	{"rain_start_time", "DATETIME NULL", "TIMESTAMP NULL"},
+}
+
+// weatherDataColumnList returns the weather_data column names as a comma separated list
+func weatherDataColumnList() string {
+	names := make([]string, len(weatherDataFields))
+	for i, f := range weatherDataFields {
+		names[i] = f.column
+	}
+	return strings.Join(names, ", ")
+}
+
+// weatherDataArgs returns pointers to the fields of data in column order
+func weatherDataArgs(data *models.WeatherData) []interface{} {
+	args := make([]interface{}, len(weatherDataFields))
+	for i, f := range weatherDataFields {
+		args[i] = f.field(data)
+	}
+	return args
+}
+
+// rowScanner is a *sql.Row or *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanWeatherData scans a weather_data row into data. The dew point, wind
+// chill and heat index are not stored, so they are calculated again; the
+// stored cloud base is kept as it was saved.
+func scanWeatherData(row rowScanner, data *models.WeatherData) error {
+	if err := row.Scan(weatherDataArgs(data)...); err != nil {
+		return err
+	}
+	cloudBase := data.CloudBase
+	data.CalculateDerivedValues()
+	data.CloudBase = cloudBase
+	return nil
+}
+
+// stationFilter returns a WHERE clause and its arguments selecting the rows
+// of a station, or nothing when station is empty
+func stationFilter(station string) (string, []interface{}) {
+	if station == "" {
+		return "", nil
+	}
+	return "WHERE station = ?", []interface{}{station}
+}
+
+// observationMatch returns a WHERE clause and its arguments identifying an
+// observation: by the raw upload it was decoded from when it has one,
+// otherwise by station and timestamp
+func observationMatch(rawUploadID int64, station string, timestamp time.Time) (string, []interface{}) {
+	if rawUploadID != 0 {
+		return "WHERE raw_upload_id = ?", []interface{}{rawUploadID}
+	}
+	return "WHERE station = ? AND timestamp = ?", []interface{}{station, timestamp}
+}
+
+// onDuplicate returns the clause that makes an insert leave the rows already
+// stored with the same key. PostgreSQL needs the columns of the key.
+func onDuplicate(dbType, key string) string {
+	if dbType == "postgres" {
+		return " ON CONFLICT (" + key + ") DO NOTHING"
+	}
+	return " ON DUPLICATE KEY UPDATE id = id"
+}
+
+// placeholders returns n comma separated query placeholders
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// rowPlaceholders returns placeholder groups for inserting rows of n values each
+func rowPlaceholders(rows, n int) string {
+	group := "(" + placeholders(n) + ")"
+	return strings.TrimSuffix(strings.Repeat(group+", ", rows), ", ")
+}
+
+// rebind rewrites ? placeholders into the numbered form PostgreSQL expects
+func rebind(dbType, query string) string {
+	if dbType != "postgres" {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,223 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ask-23/go-wx/internal/models"
+)
+
+// TestWeatherDataFields tests that every column maps to a distinct field and
+// that every added column is written and read
+func TestWeatherDataFields(t *testing.T) {
+	var data models.WeatherData
+	args := weatherDataArgs(&data)
+
+	if len(args) != len(weatherDataFields) {
+		t.Fatalf("Expected %d args, got %d", len(weatherDataFields), len(args))
+	}
+
+	seen := make(map[interface{}]string)
+	for i, arg := range args {
+		if other, ok := seen[arg]; ok {
+			t.Errorf("Columns %s and %s map to the same field", other, weatherDataFields[i].column)
+		}
+		seen[arg] = weatherDataFields[i].column
+	}
+
+	columns := weatherDataColumnList()
+	for _, col := range weatherDataAddedColumns {
+		if !strings.Contains(columns, col.name) {
+			t.Errorf("Added column %s is not in the weather_data field list", col.name)
+		}
+	}
+}
+
+// fakeRow fills in the named columns when scanned
+type fakeRow map[string]float64
+
+func (r fakeRow) Scan(dest ...interface{}) error {
+	for i, f := range weatherDataFields {
+		if value, ok := r[f.column]; ok {
+			*dest[i].(*float64) = value
+		}
+	}
+	return nil
+}
+
+// TestScanWeatherData tests that the derived values, which are not stored,
+// are calculated for rows read back
+func TestScanWeatherData(t *testing.T) {
+	var data models.WeatherData
+	if err := scanWeatherData(fakeRow{"temperature": 20, "humidity": 50}, &data); err != nil {
+		t.Fatalf("scanWeatherData returned error: %v", err)
+	}
+	if data.DewPoint < 9 || data.DewPoint > 10 {
+		t.Errorf("Expected dew point around 9.3°C, got %.2f°C", data.DewPoint)
+	}
+	if data.WindChill != 20 || data.HeatIndex != 20 {
+		t.Errorf("Expected wind chill and heat index of 20°C, got %.2f°C and %.2f°C", data.WindChill, data.HeatIndex)
+	}
+}
+
+// TestScanWeatherDataNoHumidity tests that rows with a humidity of 0, such
+// as flagged readings, read back with finite derived values and the stored
+// cloud base
+func TestScanWeatherDataNoHumidity(t *testing.T) {
+	var data models.WeatherData
+	if err := scanWeatherData(fakeRow{"temperature": 30, "humidity": 0, "cloud_base": 1200}, &data); err != nil {
+		t.Fatalf("scanWeatherData returned error: %v", err)
+	}
+	if math.IsNaN(data.DewPoint) || math.IsNaN(data.HeatIndex) || math.IsNaN(data.WindChill) {
+		t.Errorf("Expected finite derived values, got dew point %v, heat index %v and wind chill %v",
+			data.DewPoint, data.HeatIndex, data.WindChill)
+	}
+	if data.CloudBase != 1200 {
+		t.Errorf("Expected the stored cloud base of 1200 m, got %.1f m", data.CloudBase)
+	}
+	if _, err := json.Marshal(&data); err != nil {
+		t.Errorf("Expected the row to encode as JSON, got %v", err)
+	}
+}
+
+// TestRebind tests placeholder rewriting for each database type
+func TestRebind(t *testing.T) {
+	query := "SELECT a FROM t WHERE b BETWEEN ? AND ?"
+
+	if got := rebind("mariadb", query); got != query {
+		t.Errorf("Expected MariaDB query to be unchanged, got %q", got)
+	}
+
+	expected := "SELECT a FROM t WHERE b BETWEEN $1 AND $2"
+	if got := rebind("postgres", query); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
+
+// TestStationFilter tests selecting rows by station
+func TestStationFilter(t *testing.T) {
+	where, args := stationFilter("")
+	if where != "" || len(args) != 0 {
+		t.Errorf("Expected no filter for an empty station, got %q %v", where, args)
+	}
+
+	where, args = stationFilter("backyard")
+	if where != "WHERE station = ?" {
+		t.Errorf("Expected station filter, got %q", where)
+	}
+	if len(args) != 1 || args[0] != "backyard" {
+		t.Errorf("Expected [backyard] args, got %v", args)
+	}
+}
+
+// TestPlaceholders tests the placeholder list generation
+func TestPlaceholders(t *testing.T) {
+	if got := placeholders(3); got != "?, ?, ?" {
+		t.Errorf("Expected '?, ?, ?', got %q", got)
+	}
+	if got := rowPlaceholders(2, 2); got != "(?, ?), (?, ?)" {
+		t.Errorf("Expected '(?, ?), (?, ?)', got %q", got)
+	}
+}
+
+// TestNullTime tests that zero times are stored as NULL and read back as zero
+func TestNullTime(t *testing.T) {
+	var ts time.Time
+
+	value, err := nullTime{&ts}.Value()
+	if err != nil || value != nil {
+		t.Errorf("Expected zero time to be stored as NULL, got %v (%v)", value, err)
+	}
+
+	now := time.Now()
+	if err := (nullTime{&ts}).Scan(now); err != nil {
+		t.Fatalf("Scan returned error: %v", err)
+	}
+	if !ts.Equal(now) {
+		t.Errorf("Expected scanned time %v, got %v", now, ts)
+	}
+
+	if err := (nullTime{&ts}).Scan(nil); err != nil {
+		t.Fatalf("Scan returned error for NULL: %v", err)
+	}
+	if !ts.IsZero() {
+		t.Errorf("Expected NULL to be read as zero time, got %v", ts)
+	}
+}
+
+// TestRawUploadArgs tests that every raw_uploads column gets an argument
+func TestRawUploadArgs(t *testing.T) {
+	upload := &models.RawUpload{
+		ReceivedAt: time.Now(),
+		Headers:    map[string]string{"User-Agent": "GW1000"},
+	}
+
+	args, err := rawUploadArgs(upload)
+	if err != nil {
+		t.Fatalf("rawUploadArgs returned error: %v", err)
+	}
+
+	columns := strings.Split(rawUploadColumns, ", ")
+	if len(args) != len(columns) {
+		t.Errorf("Expected %d args, got %d", len(columns), len(args))
+	}
+
+	if headers := args[6].(string); headers != `{"User-Agent":"GW1000"}` {
+		t.Errorf("Expected headers as JSON, got %s", headers)
+	}
+}
+
+// TestObservationMatch tests identifying stored observations
+func TestObservationMatch(t *testing.T) {
+	where, args := observationMatch(42, "backyard", time.Now())
+	if where != "WHERE raw_upload_id = ?" || len(args) != 1 || args[0] != int64(42) {
+		t.Errorf("Expected match by raw upload, got %q %v", where, args)
+	}
+
+	ts := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	where, args = observationMatch(0, "backyard", ts)
+	if where != "WHERE station = ? AND timestamp = ?" || len(args) != 2 || args[0] != "backyard" || args[1] != ts {
+		t.Errorf("Expected match by station and timestamp, got %q %v", where, args)
+	}
+}
+
+// TestInsertIndoorData tests the insert of indoor readings saved and
+// replaced with their observations
+func TestInsertIndoorData(t *testing.T) {
+	batch := []*models.IndoorData{{StationID: "backyard"}, {StationID: "attic"}}
+	query, args := insertIndoorData("postgres", batch)
+
+	if len(args) != 2*len(indoorDataColumns) {
+		t.Errorf("Expected %d args, got %d", 2*len(indoorDataColumns), len(args))
+	}
+	if !strings.HasPrefix(query, "INSERT INTO indoor_data (station, timestamp,") {
+		t.Errorf("Expected an insert into indoor_data, got %q", query)
+	}
+	last := fmt.Sprintf("$%d) ON CONFLICT (station, timestamp) DO NOTHING", 2*len(indoorDataColumns))
+	if !strings.HasSuffix(query, last) || strings.Contains(query, "?") {
+		t.Errorf("Expected 2 rows of postgres placeholders ending in %s, got %q", last, query)
+	}
+}
+
+// TestInsertWeatherData tests that saving observations again leaves those
+// already stored
+func TestInsertWeatherData(t *testing.T) {
+	batch := []*models.WeatherData{{StationID: "backyard"}, {StationID: "attic"}}
+
+	query, args := insertWeatherData("mariadb", batch)
+	if len(args) != 2*len(weatherDataFields) {
+		t.Errorf("Expected %d args, got %d", 2*len(weatherDataFields), len(args))
+	}
+	if !strings.HasPrefix(query, "INSERT INTO weather_data (") || !strings.HasSuffix(query, "?) ON DUPLICATE KEY UPDATE id = id") {
+		t.Errorf("Expected an insert into weather_data ignoring duplicates, got %q", query)
+	}
+
+	query, _ = insertWeatherData("postgres", batch)
+	if !strings.HasSuffix(query, " ON CONFLICT (station, timestamp) DO NOTHING") || strings.Contains(query, "?") {
+		t.Errorf("Expected a postgres insert ignoring duplicates by station and timestamp, got %q", query)
+	}
+}
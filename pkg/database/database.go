@@ -3,6 +3,7 @@ package database
 import (
 	"database/sql"
 	"fmt"
+	"log"
 	"time"
 
 	"github.com/ask-23/go-wx/internal/models"
@@ -73,55 +74,150 @@ func (d *Database) Close() error {
 	return d.db.Close()
 }
 
-// SaveWeatherData saves weather data to the database
+// SaveWeatherData saves weather data, and the indoor readings it carries, to the database
 func (d *Database) SaveWeatherData(data *models.WeatherData) error {
-	// SQL query to insert weather data
-	var query string
-	var args []interface{}
+	return d.SaveWeatherDataBatch([]*models.WeatherData{data})
+}
 
-	switch d.config.Type {
-	case "mariadb":
-		query = `INSERT INTO weather_data (
-			timestamp, temperature, humidity, pressure, wind_speed, 
-			wind_direction, rain, uv_index, cloud_base
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
-		args = []interface{}{
-			data.Timestamp, data.Temperature, data.Humidity, data.Pressure,
-			data.WindSpeed, data.WindDirection, data.Rain, data.UVIndex, data.CloudBase,
+// SaveWeatherDataBatch saves several observations with a single multi-row
+// insert. The indoor readings they carry are saved to indoor_data in the
+// same transaction. Observations already stored for their station and
+// timestamp are left as they are, so saving a batch again is harmless.
+func (d *Database) SaveWeatherDataBatch(batch []*models.WeatherData) error {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	query, args := insertWeatherData(d.config.Type, batch)
+	var indoor []*models.IndoorData
+	for _, data := range batch {
+		if data.Indoor != nil {
+			indoor = append(indoor, data.Indoor)
 		}
-	case "postgres":
-		query = `INSERT INTO weather_data (
-			timestamp, temperature, humidity, pressure, wind_speed, 
-			wind_direction, rain, uv_index, cloud_base
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
-		args = []interface{}{
-			data.Timestamp, data.Temperature, data.Humidity, data.Pressure,
-			data.WindSpeed, data.WindDirection, data.Rain, data.UVIndex, data.CloudBase,
+	}
+
+	if len(indoor) == 0 {
+		if _, err := d.db.Exec(query, args...); err != nil {
+			return fmt.Errorf("failed to save %d weather data rows: %w", len(batch), err)
 		}
+		return nil
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(query, args...); err != nil {
+		return fmt.Errorf("failed to save %d weather data rows: %w", len(batch), err)
+	}
+
+	indoorQuery, indoorArgs := insertIndoorData(d.config.Type, indoor)
+	if _, err := tx.Exec(indoorQuery, indoorArgs...); err != nil {
+		return fmt.Errorf("failed to save %d indoor data rows: %w", len(indoor), err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit weather data: %w", err)
+	}
+
+	return nil
+}
+
+// insertWeatherData returns a multi-row insert of observations and its
+// arguments, leaving those already stored for their station and timestamp
+func insertWeatherData(dbType string, batch []*models.WeatherData) (string, []interface{}) {
+	query := fmt.Sprintf("INSERT INTO weather_data (%s) VALUES %s",
+		weatherDataColumnList(), rowPlaceholders(len(batch), len(weatherDataFields)))
+	query += onDuplicate(dbType, "station, timestamp")
+
+	args := make([]interface{}, 0, len(batch)*len(weatherDataFields))
+	for _, data := range batch {
+		args = append(args, weatherDataArgs(data)...)
+	}
+	return rebind(dbType, query), args
+}
+
+// FindWeatherData retrieves the stored observation decoded from a raw upload,
+// or for observations without one, the observation from station at
+// timestamp, with the indoor reading stored with it. It returns nil when
+// there is no such observation.
+func (d *Database) FindWeatherData(rawUploadID int64, station string, timestamp time.Time) (*models.WeatherData, error) {
+	where, args := observationMatch(rawUploadID, station, timestamp)
+	query := rebind(d.config.Type, fmt.Sprintf("SELECT %s FROM weather_data %s ORDER BY id DESC LIMIT 1",
+		weatherDataColumnList(), where))
+
+	var data models.WeatherData
+	err := scanWeatherData(d.db.QueryRow(query, args...), &data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find weather data: %w", err)
 	}
 
-	_, err := d.db.Exec(query, args...)
+	data.Indoor, err = d.getIndoorDataAt(data.StationID, data.Timestamp)
 	if err != nil {
+		return nil, err
+	}
+
+	return &data, nil
+}
+
+// ReplaceWeatherData saves weather data, and the indoor reading it carries,
+// in place of the observations FindWeatherData would return for it, so
+// saving the same observation again leaves a single row
+func (d *Database) ReplaceWeatherData(data *models.WeatherData) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	where, args := observationMatch(data.RawUploadID, data.StationID, data.Timestamp)
+	if _, err := tx.Exec(rebind(d.config.Type, "DELETE FROM weather_data "+where), args...); err != nil {
+		return fmt.Errorf("failed to remove replaced weather data: %w", err)
+	}
+
+	// Only one observation is stored per station and timestamp
+	if data.RawUploadID != 0 {
+		if _, err := tx.Exec(rebind(d.config.Type, "DELETE FROM weather_data WHERE station = ? AND timestamp = ?"),
+			data.StationID, data.Timestamp); err != nil {
+			return fmt.Errorf("failed to remove replaced weather data: %w", err)
+		}
+	}
+
+	query := rebind(d.config.Type, fmt.Sprintf("INSERT INTO weather_data (%s) VALUES (%s)",
+		weatherDataColumnList(), placeholders(len(weatherDataFields))))
+	if _, err := tx.Exec(query, weatherDataArgs(data)...); err != nil {
 		return fmt.Errorf("failed to save weather data: %w", err)
 	}
 
+	if data.Indoor != nil {
+		if err := replaceIndoorData(tx, d.config.Type, data.Indoor); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit weather data: %w", err)
+	}
+
 	return nil
 }
 
-// GetLatestWeatherData retrieves the most recent weather data
-func (d *Database) GetLatestWeatherData() (*models.WeatherData, error) {
-	query := `SELECT 
-		timestamp, temperature, humidity, pressure, wind_speed, 
-		wind_direction, rain, uv_index, cloud_base
-	FROM weather_data 
+// GetLatestWeatherData retrieves the most recent weather data for a station.
+// An empty station returns the most recent observation from any station.
+func (d *Database) GetLatestWeatherData(station string) (*models.WeatherData, error) {
+	where, args := stationFilter(station)
+	query := rebind(d.config.Type, fmt.Sprintf(`SELECT %s
+	FROM weather_data %s
 	ORDER BY timestamp DESC 
-	LIMIT 1`
+	LIMIT 1`, weatherDataColumnList(), where))
 
 	var data models.WeatherData
-	err := d.db.QueryRow(query).Scan(
-		&data.Timestamp, &data.Temperature, &data.Humidity, &data.Pressure,
-		&data.WindSpeed, &data.WindDirection, &data.Rain, &data.UVIndex, &data.CloudBase,
-	)
+	err := scanWeatherData(d.db.QueryRow(query, args...), &data)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -133,30 +229,58 @@ func (d *Database) GetLatestWeatherData() (*models.WeatherData, error) {
 	return &data, nil
 }
 
-// GetWeatherDataRange retrieves weather data for a specific time range
-func (d *Database) GetWeatherDataRange(start, end time.Time) ([]*models.WeatherData, error) {
+// GetWeatherDataBefore retrieves the most recent observation from a station
+// taken before ts. It returns nil when there is no such observation.
+func (d *Database) GetWeatherDataBefore(station string, ts time.Time) (*models.WeatherData, error) {
+	query := rebind(d.config.Type, fmt.Sprintf(`SELECT %s
+		FROM weather_data
+		WHERE station = ? AND timestamp < ?
+		ORDER BY timestamp DESC
+		LIMIT 1`, weatherDataColumnList()))
+
+	var data models.WeatherData
+	err := scanWeatherData(d.db.QueryRow(query, station, ts), &data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get weather data before %v: %w", ts, err)
+	}
+
+	return &data, nil
+}
+
+// SumRainFall returns the rain a station recorded after start up to and
+// including end
+func (d *Database) SumRainFall(station string, start, end time.Time) (float64, error) {
+	query := rebind(d.config.Type, `SELECT COALESCE(SUM(rain_fall), 0)
+		FROM weather_data
+		WHERE station = ? AND timestamp > ? AND timestamp <= ?`)
+
+	var total float64
+	if err := d.db.QueryRow(query, station, start, end).Scan(&total); err != nil {
+		return 0, fmt.Errorf("failed to sum rain fall: %w", err)
+	}
+
+	return total, nil
+}
+
+// GetWeatherDataRange retrieves weather data for a station over a specific
+// time range. An empty station returns observations from every station.
+func (d *Database) GetWeatherDataRange(station string, start, end time.Time) ([]*models.WeatherData, error) {
 	// SQL query to get weather data for a time range
-	var query string
-	var args []interface{}
+	where, args := stationFilter(station)
+	if where == "" {
+		where = "WHERE timestamp BETWEEN ? AND ?"
+	} else {
+		where += " AND timestamp BETWEEN ? AND ?"
+	}
+	args = append(args, start, end)
 
-	switch d.config.Type {
-	case "mariadb":
-		query = `SELECT 
-			timestamp, temperature, humidity, pressure, wind_speed, 
-			wind_direction, rain, uv_index, cloud_base
-		FROM weather_data 
-		WHERE timestamp BETWEEN ? AND ?
-		ORDER BY timestamp ASC`
-		args = []interface{}{start, end}
-	case "postgres":
-		query = `SELECT 
-			timestamp, temperature, humidity, pressure, wind_speed, 
-			wind_direction, rain, uv_index, cloud_base
+	query := rebind(d.config.Type, fmt.Sprintf(`SELECT %s
 		FROM weather_data 
-		WHERE timestamp BETWEEN $1 AND $2
-		ORDER BY timestamp ASC`
-		args = []interface{}{start, end}
-	}
+		%s
+		ORDER BY timestamp ASC`, weatherDataColumnList(), where))
 
 	rows, err := d.db.Query(query, args...)
 	if err != nil {
@@ -167,11 +291,7 @@ func (d *Database) GetWeatherDataRange(start, end time.Time) ([]*models.WeatherD
 	var results []*models.WeatherData
 	for rows.Next() {
 		var data models.WeatherData
-		err := rows.Scan(
-			&data.Timestamp, &data.Temperature, &data.Humidity, &data.Pressure,
-			&data.WindSpeed, &data.WindDirection, &data.Rain, &data.UVIndex, &data.CloudBase,
-		)
-		if err != nil {
+		if err := scanWeatherData(rows, &data); err != nil {
 			return nil, fmt.Errorf("failed to scan weather data row: %w", err)
 		}
 		results = append(results, &data)
@@ -184,6 +304,30 @@ func (d *Database) GetWeatherDataRange(start, end time.Time) ([]*models.WeatherD
 	return results, nil
 }
 
+// GetStationIDs returns the IDs of every station with stored weather data
+func (d *Database) GetStationIDs() ([]string, error) {
+	rows, err := d.db.Query("SELECT DISTINCT station FROM weather_data ORDER BY station")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query stations: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan station row: %w", err)
+		}
+		ids = append(ids, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating station rows: %w", err)
+	}
+
+	return ids, nil
+}
+
 // initSchema initializes the database schema if it doesn't exist
 func (d *Database) initSchema() error {
 	var createTableSQL string
@@ -227,5 +371,93 @@ func (d *Database) initSchema() error {
 		return fmt.Errorf("failed to create database schema: %w", err)
 	}
 
+	// Add columns introduced after the initial schema
+	if err := d.addColumns("weather_data", weatherDataAddedColumns); err != nil {
+		return err
+	}
+
+	// Index per-station queries, keeping one observation per station and
+	// timestamp so a batch saved again is not stored twice
+	if err := d.createUniqueIndex("weather_data", "uniq_station_timestamp", "idx_station_timestamp"); err != nil {
+		return err
+	}
+
+	// Archive of the raw uploads observations are decoded from
+	if err := d.createRawUploadsTable(); err != nil {
+		return err
+	}
+
+	// Indoor readings, kept apart from the outdoor weather data
+	if err := d.createIndoorDataTable(); err != nil {
+		return err
+	}
+
+	// Battery state and last report of each sensor
+	if err := d.createSensorHealthTable(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// createUniqueIndex creates a unique index on the station and timestamp of
+// table in place of the non-unique index replaced. Rows duplicated before the
+// index existed are removed first, keeping the first saved.
+func (d *Database) createUniqueIndex(table, index, replaced string) error {
+	var exists int
+	query := `SELECT COUNT(*) FROM information_schema.statistics
+		WHERE table_schema = DATABASE() AND table_name = ? AND index_name = ?`
+	if d.config.Type == "postgres" {
+		query = "SELECT COUNT(*) FROM pg_indexes WHERE tablename = $1 AND indexname = $2"
+	}
+	if err := d.db.QueryRow(query, table, index).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to look up index %s: %w", index, err)
+	}
+
+	if exists == 0 {
+		dedupe := fmt.Sprintf(`DELETE a FROM %[1]s a JOIN %[1]s b
+			ON a.station = b.station AND a.timestamp = b.timestamp AND a.id > b.id`, table)
+		if d.config.Type == "postgres" {
+			dedupe = fmt.Sprintf(`DELETE FROM %[1]s a USING %[1]s b
+				WHERE a.station = b.station AND a.timestamp = b.timestamp AND a.id > b.id`, table)
+		}
+		result, err := d.db.Exec(dedupe)
+		if err != nil {
+			return fmt.Errorf("failed to remove duplicate rows from %s: %w", table, err)
+		}
+		if n, err := result.RowsAffected(); err == nil && n > 0 {
+			log.Printf("Removed %d duplicate rows from %s", n, table)
+		}
+
+		if _, err := d.db.Exec(fmt.Sprintf("CREATE UNIQUE INDEX %s ON %s (station, timestamp)", index, table)); err != nil {
+			return fmt.Errorf("failed to create index %s: %w", index, err)
+		}
+	}
+
+	drop := fmt.Sprintf("DROP INDEX IF EXISTS %s ON %s", replaced, table)
+	if d.config.Type == "postgres" {
+		drop = fmt.Sprintf("DROP INDEX IF EXISTS %s", replaced)
+	}
+	if _, err := d.db.Exec(drop); err != nil {
+		return fmt.Errorf("failed to drop index %s: %w", replaced, err)
+	}
+
+	return nil
+}
+
+// addColumns adds any of the given columns that are missing from table
+func (d *Database) addColumns(table string, columns []columnDefinition) error {
+	for _, col := range columns {
+		colType := col.mariadb
+		if d.config.Type == "postgres" {
+			colType = col.postgres
+		}
+
+		query := fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s", table, col.name, colType)
+		if _, err := d.db.Exec(query); err != nil {
+			return fmt.Errorf("failed to add column %s to %s: %w", col.name, table, err)
+		}
+	}
+
 	return nil
 }
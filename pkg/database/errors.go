@@ -0,0 +1,44 @@
+package database
+
+import (
+	"errors"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+)
+
+// rejectedRowErrors are the MySQL and MariaDB error numbers for rows the
+// database refuses whatever state it is in: a missing or duplicate key,
+// a null in a NOT NULL column, and values out of range, malformed or too
+// long for their column
+var rejectedRowErrors = map[uint16]bool{
+	1048: true, // column cannot be null
+	1062: true, // duplicate entry
+	1264: true, // out of range value
+	1265: true, // data truncated
+	1292: true, // incorrect value
+	1366: true, // incorrect value for column
+	1406: true, // data too long
+	1452: true, // foreign key constraint fails
+	1690: true, // value out of range
+	3819: true, // check constraint violated
+}
+
+// IsPermanent reports whether err is the database rejecting the rows being
+// saved, so saving them again would fail the same way. Other errors, such
+// as a lost connection or a timeout, may succeed when retried.
+func IsPermanent(err error) bool {
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) {
+		return rejectedRowErrors[myErr.Number]
+	}
+
+	// PostgreSQL data exceptions and integrity constraint violations
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		class := pqErr.Code.Class()
+		return class == "22" || class == "23"
+	}
+
+	return false
+}
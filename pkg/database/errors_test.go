@@ -0,0 +1,33 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+)
+
+// TestIsPermanent tests telling rejected rows from errors worth retrying
+func TestIsPermanent(t *testing.T) {
+	tests := []struct {
+		err       error
+		permanent bool
+	}{
+		{&mysql.MySQLError{Number: 1406, Message: "Data too long"}, true},
+		{fmt.Errorf("failed to save: %w", &mysql.MySQLError{Number: 1062}), true},
+		{&mysql.MySQLError{Number: 1205, Message: "Lock wait timeout exceeded"}, false},
+		{&pq.Error{Code: "23505"}, true},
+		{&pq.Error{Code: "22001"}, true},
+		{&pq.Error{Code: "57P01"}, false},
+		{mysql.ErrInvalidConn, false},
+		{errors.New("connection refused"), false},
+	}
+
+	for _, test := range tests {
+		if got := IsPermanent(test.err); got != test.permanent {
+			t.Errorf("Expected IsPermanent(%v) to be %v, got %v", test.err, test.permanent, got)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ask-23/go-wx/internal/models"
+)
+
+// sensorHealthColumns lists the sensor_health columns, in the order
+// sensorHealthArgs returns them
+var sensorHealthColumns = []string{
+	"station", "sensor", "battery", "battery_type", "battery_low", "capacitor", "last_seen", "stale",
+	"signal_level",
+}
+
+// sensorHealthAddedColumns are created on existing sensor_health tables at startup
+var sensorHealthAddedColumns = []columnDefinition{
+	{"signal_level", "INT NULL", "INTEGER NULL"},
+}
+
+// sensorHealthArgs returns the query arguments for a sensor in column order
+func sensorHealthArgs(h *models.SensorHealth) []interface{} {
+	return []interface{}{
+		&h.StationID, &h.Sensor, &h.Battery, &h.BatteryType, &h.BatteryLow, &h.Capacitor, &h.LastSeen, &h.Stale,
+		&h.Signal,
+	}
+}
+
+// SaveSensorHealth saves the state of sensors, replacing their previous state
+func (d *Database) SaveSensorHealth(sensors []*models.SensorHealth) error {
+	if len(sensors) == 0 {
+		return nil
+	}
+
+	// Update every column but the key on conflict
+	var updates []string
+	for _, col := range sensorHealthColumns[2:] {
+		if d.config.Type == "postgres" {
+			updates = append(updates, fmt.Sprintf("%s = EXCLUDED.%s", col, col))
+		} else {
+			updates = append(updates, fmt.Sprintf("%s = VALUES(%s)", col, col))
+		}
+	}
+	conflict := "ON DUPLICATE KEY UPDATE"
+	if d.config.Type == "postgres" {
+		conflict = "ON CONFLICT (station, sensor) DO UPDATE SET"
+	}
+
+	query := rebind(d.config.Type, fmt.Sprintf("INSERT INTO sensor_health (%s) VALUES %s %s %s",
+		strings.Join(sensorHealthColumns, ", "), rowPlaceholders(len(sensors), len(sensorHealthColumns)),
+		conflict, strings.Join(updates, ", ")))
+
+	args := make([]interface{}, 0, len(sensors)*len(sensorHealthColumns))
+	for _, h := range sensors {
+		args = append(args, sensorHealthArgs(h)...)
+	}
+
+	if _, err := d.db.Exec(query, args...); err != nil {
+		return fmt.Errorf("failed to save sensor health: %w", err)
+	}
+
+	return nil
+}
+
+// GetSensorHealth retrieves the state of every sensor of a station. An empty
+// station returns the sensors of every station.
+func (d *Database) GetSensorHealth(station string) ([]*models.SensorHealth, error) {
+	where, args := stationFilter(station)
+	query := rebind(d.config.Type, fmt.Sprintf("SELECT %s FROM sensor_health %s ORDER BY station, sensor",
+		strings.Join(sensorHealthColumns, ", "), where))
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sensor health: %w", err)
+	}
+	defer rows.Close()
+
+	var results []*models.SensorHealth
+	for rows.Next() {
+		var h models.SensorHealth
+		if err := rows.Scan(sensorHealthArgs(&h)...); err != nil {
+			return nil, fmt.Errorf("failed to scan sensor health row: %w", err)
+		}
+		results = append(results, &h)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating sensor health rows: %w", err)
+	}
+
+	return results, nil
+}
+
+// createSensorHealthTable creates the table holding the state of each sensor
+func (d *Database) createSensorHealthTable() error {
+	var stmt string
+
+	switch d.config.Type {
+	case "mariadb":
+		stmt = `
+		CREATE TABLE IF NOT EXISTS sensor_health (
+			station VARCHAR(64) NOT NULL,
+			sensor VARCHAR(32) NOT NULL,
+			battery FLOAT DEFAULT 0,
+			battery_type VARCHAR(16),
+			battery_low BOOLEAN DEFAULT FALSE,
+			capacitor FLOAT NULL,
+			last_seen DATETIME NOT NULL,
+			stale BOOLEAN DEFAULT FALSE,
+			signal_level INT NULL,
+			PRIMARY KEY (station, sensor)
+		)`
+	case "postgres":
+		stmt = `
+		CREATE TABLE IF NOT EXISTS sensor_health (
+			station VARCHAR(64) NOT NULL,
+			sensor VARCHAR(32) NOT NULL,
+			battery FLOAT DEFAULT 0,
+			battery_type VARCHAR(16),
+			battery_low BOOLEAN DEFAULT FALSE,
+			capacitor FLOAT NULL,
+			last_seen TIMESTAMP NOT NULL,
+			stale BOOLEAN DEFAULT FALSE,
+			signal_level INTEGER NULL,
+			PRIMARY KEY (station, sensor)
+		)`
+	}
+
+	if _, err := d.db.Exec(stmt); err != nil {
+		return fmt.Errorf("failed to create sensor health table: %w", err)
+	}
+
+	return d.addColumns("sensor_health", sensorHealthAddedColumns)
+}
@@ -0,0 +1,177 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ask-23/go-wx/internal/models"
+)
+
+// indoorDataColumns lists the indoor_data columns, in the order
+// indoorDataArgs returns them
+var indoorDataColumns = []string{
+	"station", "timestamp", "received_at", "temperature", "humidity", "pressure",
+	"co2", "co2_avg_24h", "dew_point", "absolute_humidity", "qc_flags",
+}
+
+// indoorDataAddedColumns are created on existing indoor_data tables at startup
+var indoorDataAddedColumns = []columnDefinition{
+	{"qc_flags", "TEXT NULL", "TEXT NULL"},
+}
+
+// indoorDataArgs returns the query arguments for indoor data in column order
+func indoorDataArgs(d *models.IndoorData) []interface{} {
+	return []interface{}{
+		&d.StationID, &d.Timestamp, &d.ReceivedAt, &d.Temperature, &d.Humidity, &d.Pressure,
+		&d.CO2, &d.CO2Avg24h, &d.DewPoint, &d.AbsoluteHumidity, &d.QCFlags,
+	}
+}
+
+// insertIndoorData returns a multi-row insert of indoor readings and its
+// arguments, leaving those already stored for their station and timestamp
+func insertIndoorData(dbType string, batch []*models.IndoorData) (string, []interface{}) {
+	query := rebind(dbType, fmt.Sprintf("INSERT INTO indoor_data (%s) VALUES %s%s",
+		strings.Join(indoorDataColumns, ", "), rowPlaceholders(len(batch), len(indoorDataColumns)),
+		onDuplicate(dbType, "station, timestamp")))
+
+	args := make([]interface{}, 0, len(batch)*len(indoorDataColumns))
+	for _, data := range batch {
+		args = append(args, indoorDataArgs(data)...)
+	}
+	return query, args
+}
+
+// replaceIndoorData saves indoor data within tx in place of the reading
+// stored for the same station and time
+func replaceIndoorData(tx *sql.Tx, dbType string, data *models.IndoorData) error {
+	if _, err := tx.Exec(rebind(dbType, "DELETE FROM indoor_data WHERE station = ? AND timestamp = ?"),
+		data.StationID, data.Timestamp); err != nil {
+		return fmt.Errorf("failed to remove replaced indoor data: %w", err)
+	}
+
+	query, args := insertIndoorData(dbType, []*models.IndoorData{data})
+	if _, err := tx.Exec(query, args...); err != nil {
+		return fmt.Errorf("failed to save indoor data: %w", err)
+	}
+
+	return nil
+}
+
+// getIndoorDataAt retrieves the indoor data of a station at timestamp. It
+// returns nil when there is none.
+func (d *Database) getIndoorDataAt(station string, timestamp time.Time) (*models.IndoorData, error) {
+	query := rebind(d.config.Type, fmt.Sprintf(`SELECT %s FROM indoor_data
+		WHERE station = ? AND timestamp = ? LIMIT 1`, strings.Join(indoorDataColumns, ", ")))
+
+	var data models.IndoorData
+	err := d.db.QueryRow(query, station, timestamp).Scan(indoorDataArgs(&data)...)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get indoor data: %w", err)
+	}
+
+	return &data, nil
+}
+
+// GetLatestIndoorData retrieves the most recent indoor data for a station.
+// It returns nil when the station has none.
+func (d *Database) GetLatestIndoorData(station string) (*models.IndoorData, error) {
+	query := rebind(d.config.Type, fmt.Sprintf(`SELECT %s FROM indoor_data
+		WHERE station = ? ORDER BY timestamp DESC LIMIT 1`, strings.Join(indoorDataColumns, ", ")))
+
+	var data models.IndoorData
+	err := d.db.QueryRow(query, station).Scan(indoorDataArgs(&data)...)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get latest indoor data: %w", err)
+	}
+
+	return &data, nil
+}
+
+// GetIndoorDataRange retrieves the indoor data of a station over a time range
+func (d *Database) GetIndoorDataRange(station string, start, end time.Time) ([]*models.IndoorData, error) {
+	query := rebind(d.config.Type, fmt.Sprintf(`SELECT %s FROM indoor_data
+		WHERE station = ? AND timestamp BETWEEN ? AND ?
+		ORDER BY timestamp ASC`, strings.Join(indoorDataColumns, ", ")))
+
+	rows, err := d.db.Query(query, station, start, end)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query indoor data: %w", err)
+	}
+	defer rows.Close()
+
+	var results []*models.IndoorData
+	for rows.Next() {
+		var data models.IndoorData
+		if err := rows.Scan(indoorDataArgs(&data)...); err != nil {
+			return nil, fmt.Errorf("failed to scan indoor data row: %w", err)
+		}
+		results = append(results, &data)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating indoor data rows: %w", err)
+	}
+
+	return results, nil
+}
+
+// createIndoorDataTable creates the table holding indoor readings
+func (d *Database) createIndoorDataTable() error {
+	var statements []string
+
+	switch d.config.Type {
+	case "mariadb":
+		statements = []string{`
+		CREATE TABLE IF NOT EXISTS indoor_data (
+			id BIGINT AUTO_INCREMENT PRIMARY KEY,
+			station VARCHAR(64) NOT NULL DEFAULT 'default',
+			timestamp DATETIME NOT NULL,
+			received_at DATETIME NULL,
+			temperature FLOAT DEFAULT 0,
+			humidity FLOAT DEFAULT 0,
+			pressure FLOAT DEFAULT 0,
+			co2 FLOAT NULL,
+			co2_avg_24h FLOAT NULL,
+			dew_point FLOAT DEFAULT 0,
+			absolute_humidity FLOAT DEFAULT 0,
+			qc_flags TEXT NULL
+		)`}
+	case "postgres":
+		statements = []string{`
+		CREATE TABLE IF NOT EXISTS indoor_data (
+			id BIGSERIAL PRIMARY KEY,
+			station VARCHAR(64) NOT NULL DEFAULT 'default',
+			timestamp TIMESTAMP NOT NULL,
+			received_at TIMESTAMP NULL,
+			temperature FLOAT DEFAULT 0,
+			humidity FLOAT DEFAULT 0,
+			pressure FLOAT DEFAULT 0,
+			co2 FLOAT NULL,
+			co2_avg_24h FLOAT NULL,
+			dew_point FLOAT DEFAULT 0,
+			absolute_humidity FLOAT DEFAULT 0,
+			qc_flags TEXT NULL
+		)`}
+	}
+
+	for _, stmt := range statements {
+		if _, err := d.db.Exec(stmt); err != nil {
+			return fmt.Errorf("failed to create indoor data table: %w", err)
+		}
+	}
+
+	if err := d.addColumns("indoor_data", indoorDataAddedColumns); err != nil {
+		return err
+	}
+
+	// One reading per station and timestamp, as for the observations
+	return d.createUniqueIndex("indoor_data", "uniq_indoor_station_timestamp", "idx_indoor_station_timestamp")
+}
@@ -0,0 +1,199 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ask-23/go-wx/internal/models"
+)
+
+// rawUploadColumns lists the raw_uploads columns written for an upload, in
+// the order rawUploadArgs returns them
+const rawUploadColumns = "received_at, source_ip, method, path, query, body, headers, decoder, station, status"
+
+// rawUploadArgs returns the query arguments for an upload in column order
+func rawUploadArgs(upload *models.RawUpload) ([]interface{}, error) {
+	headers, err := json.Marshal(upload.Headers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode upload headers: %w", err)
+	}
+
+	return []interface{}{
+		upload.ReceivedAt, upload.SourceIP, upload.Method, upload.Path, upload.Query,
+		upload.Body, string(headers), upload.Decoder, upload.StationID, upload.Status,
+	}, nil
+}
+
+// SaveRawUpload archives an upload. An upload without an ID is given the
+// next one by the database; see SaveRawUploadBatch for uploads with one.
+func (d *Database) SaveRawUpload(upload *models.RawUpload) error {
+	if upload.ID != 0 {
+		return d.SaveRawUploadBatch([]*models.RawUpload{upload})
+	}
+
+	args, err := rawUploadArgs(upload)
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf("INSERT INTO raw_uploads (%s) VALUES (%s)", rawUploadColumns, placeholders(len(args)))
+
+	// PostgreSQL does not report the inserted ID without RETURNING
+	if d.config.Type == "postgres" {
+		err := d.db.QueryRow(rebind(d.config.Type, query+" RETURNING id"), args...).Scan(&upload.ID)
+		if err != nil {
+			return fmt.Errorf("failed to save raw upload: %w", err)
+		}
+		return nil
+	}
+
+	result, err := d.db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to save raw upload: %w", err)
+	}
+	if upload.ID, err = result.LastInsertId(); err != nil {
+		return fmt.Errorf("failed to get raw upload id: %w", err)
+	}
+
+	return nil
+}
+
+// SaveRawUploadBatch archives several uploads that were given their IDs
+// before they were queued, with a single multi-row insert. Uploads already
+// archived under their ID are left as they are, so saving a batch again
+// after a failure does not fail on the uploads that made it.
+func (d *Database) SaveRawUploadBatch(batch []*models.RawUpload) error {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	var args []interface{}
+	for _, upload := range batch {
+		uploadArgs, err := rawUploadArgs(upload)
+		if err != nil {
+			return err
+		}
+		args = append(args, upload.ID)
+		args = append(args, uploadArgs...)
+	}
+
+	query := fmt.Sprintf("INSERT INTO raw_uploads (id, %s) VALUES %s",
+		rawUploadColumns, rowPlaceholders(len(batch), len(args)/len(batch)))
+	query += onDuplicate(d.config.Type, "id")
+
+	if _, err := d.db.Exec(rebind(d.config.Type, query), args...); err != nil {
+		return fmt.Errorf("failed to save %d raw uploads: %w", len(batch), err)
+	}
+	return nil
+}
+
+// LastRawUploadID returns the highest ID in the upload archive, or 0 when it
+// is empty
+func (d *Database) LastRawUploadID() (int64, error) {
+	var id sql.NullInt64
+	if err := d.db.QueryRow("SELECT MAX(id) FROM raw_uploads").Scan(&id); err != nil {
+		return 0, fmt.Errorf("failed to get the last raw upload id: %w", err)
+	}
+	return id.Int64, nil
+}
+
+// GetRawUploads retrieves the uploads received in a time range, with the ID
+// of the weather_data row decoded from each where there is one: the
+// observation itself or the archive record whose range of uploads includes it
+func (d *Database) GetRawUploads(start, end time.Time) ([]*models.RawUpload, error) {
+	query := rebind(d.config.Type, `SELECT r.id, r.received_at, r.source_ip, r.method, r.path, r.query,
+		r.body, r.headers, r.decoder, r.station, r.status, COALESCE(w.id, a.id)
+		FROM raw_uploads r
+		LEFT JOIN weather_data w ON w.raw_upload_id = r.id
+		LEFT JOIN weather_data a ON r.status = 200 AND a.station = r.station
+			AND a.first_raw_upload_id > 0 AND r.id BETWEEN a.first_raw_upload_id AND a.last_raw_upload_id
+		WHERE r.received_at BETWEEN ? AND ?
+		ORDER BY r.received_at ASC, r.id ASC`)
+
+	rows, err := d.db.Query(query, start, end)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query raw uploads: %w", err)
+	}
+	defer rows.Close()
+
+	var results []*models.RawUpload
+	for rows.Next() {
+		var upload models.RawUpload
+		var headers sql.NullString
+		var weatherDataID sql.NullInt64
+		if err := rows.Scan(&upload.ID, &upload.ReceivedAt, &upload.SourceIP, &upload.Method, &upload.Path,
+			&upload.Query, &upload.Body, &headers, &upload.Decoder, &upload.StationID, &upload.Status,
+			&weatherDataID); err != nil {
+			return nil, fmt.Errorf("failed to scan raw upload row: %w", err)
+		}
+		if headers.Valid && headers.String != "" {
+			if err := json.Unmarshal([]byte(headers.String), &upload.Headers); err != nil {
+				return nil, fmt.Errorf("failed to decode headers of raw upload %d: %w", upload.ID, err)
+			}
+		}
+		upload.WeatherDataID = weatherDataID.Int64
+		results = append(results, &upload)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating raw upload rows: %w", err)
+	}
+
+	return results, nil
+}
+
+// createRawUploadsTable creates the raw upload archive
+func (d *Database) createRawUploadsTable() error {
+	var statements []string
+
+	switch d.config.Type {
+	case "mariadb":
+		statements = []string{`
+		CREATE TABLE IF NOT EXISTS raw_uploads (
+			id BIGINT AUTO_INCREMENT PRIMARY KEY,
+			received_at DATETIME NOT NULL,
+			source_ip VARCHAR(64),
+			method VARCHAR(8),
+			path VARCHAR(255),
+			query TEXT,
+			body MEDIUMTEXT,
+			headers TEXT,
+			decoder VARCHAR(32),
+			station VARCHAR(64),
+			status INT,
+			INDEX idx_received_at (received_at)
+		)`}
+	case "postgres":
+		statements = []string{`
+		CREATE TABLE IF NOT EXISTS raw_uploads (
+			id BIGSERIAL PRIMARY KEY,
+			received_at TIMESTAMP NOT NULL,
+			source_ip VARCHAR(64),
+			method VARCHAR(8),
+			path VARCHAR(255),
+			query TEXT,
+			body TEXT,
+			headers TEXT,
+			decoder VARCHAR(32),
+			station VARCHAR(64),
+			status INTEGER
+		)`,
+			"CREATE INDEX IF NOT EXISTS idx_raw_uploads_received_at ON raw_uploads (received_at)"}
+	}
+
+	// Uploads are found from their observation through raw_upload_id, or
+	// from their archive record through its range of upload IDs
+	statements = append(statements,
+		"CREATE INDEX IF NOT EXISTS idx_raw_upload_id ON weather_data (raw_upload_id)",
+		"CREATE INDEX IF NOT EXISTS idx_station_first_raw_upload_id ON weather_data (station, first_raw_upload_id)")
+
+	for _, stmt := range statements {
+		if _, err := d.db.Exec(stmt); err != nil {
+			return fmt.Errorf("failed to create raw upload archive: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,223 @@
+package database
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ask-23/go-wx/internal/models"
+	"github.com/ask-23/go-wx/pkg/config"
+)
+
+// ErrQueueFull is returned when the batch writer has no room for another observation
+var ErrQueueFull = errors.New("write queue is full")
+
+// maxSaveAttempts is how many times a batch is saved before it is given up
+const maxSaveAttempts = 3
+
+// BatchStore saves several observations, or several archived uploads, with a
+// single statement
+type BatchStore interface {
+	SaveWeatherDataBatch(batch []*models.WeatherData) error
+	SaveRawUploadBatch(batch []*models.RawUpload) error
+}
+
+// queuedWrite is an observation or an archived upload waiting to be saved
+type queuedWrite struct {
+	data   *models.WeatherData
+	upload *models.RawUpload
+}
+
+// BatchWriter queues observations and archived uploads and saves them from a
+// single goroutine in multi-row inserts, flushing when a batch is full or
+// the flush interval has passed. A batch that fails to save is retried with
+// backoff, and one the database rejects for the rows in it is saved a row at
+// a time so only the rejected rows are lost. Writes that still fail are
+// logged, counted and dropped; configure the collector buffer to keep them.
+type BatchWriter struct {
+	store         BatchStore
+	queue         chan queuedWrite
+	batchSize     int
+	flushInterval time.Duration
+	retryDelay    time.Duration // before the first retry, doubling after each
+	dropped       atomic.Int64
+	done          chan struct{}
+	wg            sync.WaitGroup
+}
+
+// NewBatchWriter creates a batch writer saving to store
+func NewBatchWriter(store BatchStore, cfg config.BatchConfig) *BatchWriter {
+	w := &BatchWriter{
+		store:         store,
+		queue:         make(chan queuedWrite, cfg.QueueSize),
+		batchSize:     cfg.Size,
+		flushInterval: time.Duration(cfg.FlushInterval) * time.Second,
+		retryDelay:    time.Second,
+	}
+	if w.batchSize < 1 {
+		w.batchSize = 1
+	}
+	if w.flushInterval <= 0 {
+		w.flushInterval = time.Second
+	}
+	return w
+}
+
+// SaveWeatherData queues an observation to be saved. It returns ErrQueueFull
+// rather than waiting when the queue is full.
+func (w *BatchWriter) SaveWeatherData(data *models.WeatherData) error {
+	return w.enqueue(queuedWrite{data: data})
+}
+
+// SaveRawUpload queues an archived upload to be saved. The upload must
+// already have its ID. It returns ErrQueueFull rather than waiting when the
+// queue is full.
+func (w *BatchWriter) SaveRawUpload(upload *models.RawUpload) error {
+	return w.enqueue(queuedWrite{upload: upload})
+}
+
+// enqueue adds a write to the queue unless it is full
+func (w *BatchWriter) enqueue(write queuedWrite) error {
+	select {
+	case w.queue <- write:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Dropped returns how many writes have been dropped because they could not
+// be saved
+func (w *BatchWriter) Dropped() int64 {
+	return w.dropped.Load()
+}
+
+// Full reports whether the queue has no room for another write
+func (w *BatchWriter) Full() bool {
+	return len(w.queue) >= cap(w.queue)
+}
+
+// Start begins saving queued observations
+func (w *BatchWriter) Start() {
+	w.done = make(chan struct{})
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		w.run()
+	}()
+}
+
+// Stop saves the observations still queued and stops the writer
+func (w *BatchWriter) Stop() {
+	if w.done == nil {
+		return
+	}
+	close(w.done)
+	w.wg.Wait()
+	w.done = nil
+}
+
+// run collects queued writes into batches until stopped
+func (w *BatchWriter) run() {
+	ticker := time.NewTicker(w.flushInterval)
+	defer ticker.Stop()
+
+	var batch []queuedWrite
+	for {
+		select {
+		case write := <-w.queue:
+			batch = append(batch, write)
+			if len(batch) >= w.batchSize {
+				w.flush(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			w.flush(batch)
+			batch = nil
+		case <-w.done:
+			// Save whatever is left in the queue
+			for {
+				select {
+				case write := <-w.queue:
+					batch = append(batch, write)
+					if len(batch) >= w.batchSize {
+						w.flush(batch)
+						batch = nil
+					}
+				default:
+					w.flush(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// flush saves a batch of writes, the archived uploads before the
+// observations decoded from them
+func (w *BatchWriter) flush(batch []queuedWrite) {
+	var uploads []*models.RawUpload
+	var observations []*models.WeatherData
+	for _, write := range batch {
+		if write.upload != nil {
+			uploads = append(uploads, write.upload)
+		} else {
+			observations = append(observations, write.data)
+		}
+	}
+
+	if len(uploads) > 0 {
+		w.save("raw uploads", len(uploads),
+			func() error { return w.store.SaveRawUploadBatch(uploads) },
+			func(n int) error { return w.store.SaveRawUploadBatch(uploads[n : n+1]) })
+	}
+	if len(observations) > 0 {
+		w.save("observations", len(observations),
+			func() error { return w.store.SaveWeatherDataBatch(observations) },
+			func(n int) error { return w.store.SaveWeatherDataBatch(observations[n : n+1]) })
+	}
+}
+
+// save saves a batch of count writes of one kind with saveAll. When the
+// database rejects the rows, they are saved one at a time with saveOne
+// instead so only the rows at fault are dropped.
+func (w *BatchWriter) save(kind string, count int, saveAll func() error, saveOne func(n int) error) {
+	err := w.retry(saveAll)
+	if err == nil {
+		return
+	}
+	if !IsPermanent(err) {
+		w.drop(kind, count, err)
+		return
+	}
+
+	log.Printf("Saving %d queued %s one at a time after the batch was rejected: %v", count, kind, err)
+	for n := 0; n < count; n++ {
+		n := n
+		if err := w.retry(func() error { return saveOne(n) }); err != nil {
+			w.drop(kind, 1, err)
+		}
+	}
+}
+
+// retry calls save until it succeeds, fails permanently or has been tried
+// maxSaveAttempts times, waiting longer after each failure
+func (w *BatchWriter) retry(save func() error) error {
+	delay := w.retryDelay
+	for attempt := 1; ; attempt++ {
+		err := save()
+		if err == nil || IsPermanent(err) || attempt >= maxSaveAttempts {
+			return err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// drop logs and counts writes that could not be saved
+func (w *BatchWriter) drop(kind string, count int, err error) {
+	total := w.dropped.Add(int64(count))
+	log.Printf("Dropped %d queued %s (%d dropped in all): %v", count, kind, total, err)
+}
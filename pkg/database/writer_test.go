@@ -0,0 +1,182 @@
+package database
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ask-23/go-wx/internal/models"
+	"github.com/ask-23/go-wx/pkg/config"
+	"github.com/go-sql-driver/mysql"
+)
+
+// MockBatchStore records the batches it is asked to save
+type MockBatchStore struct {
+	mutex    sync.Mutex
+	batches  [][]*models.WeatherData
+	uploads  [][]*models.RawUpload
+	order    []string // kinds of the batches in the order they were saved
+	failures int      // observation batches to fail as if the database were down
+	reject   string   // station whose observations the database rejects
+}
+
+func (m *MockBatchStore) SaveWeatherDataBatch(batch []*models.WeatherData) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.failures > 0 {
+		m.failures--
+		return errors.New("connection refused")
+	}
+	for _, data := range batch {
+		if m.reject != "" && data.StationID == m.reject {
+			return &mysql.MySQLError{Number: 1406, Message: "Data too long for column 'station_id'"}
+		}
+	}
+	m.batches = append(m.batches, batch)
+	m.order = append(m.order, "observations")
+	return nil
+}
+
+func (m *MockBatchStore) SaveRawUploadBatch(batch []*models.RawUpload) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.uploads = append(m.uploads, batch)
+	m.order = append(m.order, "uploads")
+	return nil
+}
+
+func (m *MockBatchStore) batchSizes() []int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	sizes := make([]int, len(m.batches))
+	for i, batch := range m.batches {
+		sizes[i] = len(batch)
+	}
+	return sizes
+}
+
+// TestBatchWriter tests flushing full batches and the remainder on stop
+func TestBatchWriter(t *testing.T) {
+	store := &MockBatchStore{}
+	writer := NewBatchWriter(store, config.BatchConfig{Size: 3, FlushInterval: 60, QueueSize: 10})
+	writer.Start()
+
+	for n := 0; n < 7; n++ {
+		if err := writer.SaveWeatherData(&models.WeatherData{Temperature: float64(n)}); err != nil {
+			t.Fatalf("SaveWeatherData returned error: %v", err)
+		}
+	}
+	writer.Stop()
+
+	sizes := store.batchSizes()
+	if len(sizes) != 3 || sizes[0] != 3 || sizes[1] != 3 || sizes[2] != 1 {
+		t.Fatalf("Expected batches of 3, 3 and 1, got %v", sizes)
+	}
+	if store.batches[2][0].Temperature != 6 {
+		t.Errorf("Expected observations in order, got temperature %.0f last", store.batches[2][0].Temperature)
+	}
+}
+
+// TestBatchWriterUploads tests that archived uploads are batched with the
+// observations and saved before them
+func TestBatchWriterUploads(t *testing.T) {
+	store := &MockBatchStore{}
+	writer := NewBatchWriter(store, config.BatchConfig{Size: 4, FlushInterval: 60, QueueSize: 10})
+	writer.Start()
+
+	for n := 1; n <= 2; n++ {
+		if err := writer.SaveRawUpload(&models.RawUpload{ID: int64(n)}); err != nil {
+			t.Fatalf("SaveRawUpload returned error: %v", err)
+		}
+		if err := writer.SaveWeatherData(&models.WeatherData{RawUploadID: int64(n)}); err != nil {
+			t.Fatalf("SaveWeatherData returned error: %v", err)
+		}
+	}
+	writer.Stop()
+
+	if len(store.order) != 2 || store.order[0] != "uploads" || store.order[1] != "observations" {
+		t.Fatalf("Expected a batch of uploads then one of observations, got %v", store.order)
+	}
+	if len(store.uploads[0]) != 2 || len(store.batches[0]) != 2 {
+		t.Errorf("Expected 2 uploads and 2 observations, got %d and %d", len(store.uploads[0]), len(store.batches[0]))
+	}
+}
+
+// TestBatchWriterRetry tests that a batch is saved again after the database
+// was briefly unavailable
+func TestBatchWriterRetry(t *testing.T) {
+	store := &MockBatchStore{failures: 2}
+	writer := NewBatchWriter(store, config.BatchConfig{Size: 3, FlushInterval: 60, QueueSize: 10})
+	writer.retryDelay = time.Millisecond
+	writer.Start()
+
+	for n := 0; n < 3; n++ {
+		writer.SaveWeatherData(&models.WeatherData{Temperature: float64(n)})
+	}
+	writer.Stop()
+
+	sizes := store.batchSizes()
+	if len(sizes) != 1 || sizes[0] != 3 {
+		t.Fatalf("Expected the batch of 3 to be saved once retried, got %v", sizes)
+	}
+	if writer.Dropped() != 0 {
+		t.Errorf("Expected nothing dropped, got %d", writer.Dropped())
+	}
+}
+
+// TestBatchWriterRejectedRow tests that only the row the database rejects is
+// dropped from a batch
+func TestBatchWriterRejectedRow(t *testing.T) {
+	store := &MockBatchStore{reject: "bad"}
+	writer := NewBatchWriter(store, config.BatchConfig{Size: 3, FlushInterval: 60, QueueSize: 10})
+	writer.retryDelay = time.Millisecond
+	writer.Start()
+
+	for _, station := range []string{"good", "bad", "good"} {
+		writer.SaveWeatherData(&models.WeatherData{StationID: station})
+	}
+	writer.Stop()
+
+	sizes := store.batchSizes()
+	if len(sizes) != 2 || sizes[0] != 1 || sizes[1] != 1 {
+		t.Fatalf("Expected the 2 good rows to be saved one at a time, got %v", sizes)
+	}
+	if writer.Dropped() != 1 {
+		t.Errorf("Expected 1 dropped row, got %d", writer.Dropped())
+	}
+}
+
+// TestBatchWriterInterval tests that partial batches are saved after the flush interval
+func TestBatchWriterInterval(t *testing.T) {
+	store := &MockBatchStore{}
+	writer := NewBatchWriter(store, config.BatchConfig{Size: 50, QueueSize: 10})
+	writer.flushInterval = 10 * time.Millisecond
+	writer.Start()
+	defer writer.Stop()
+
+	writer.SaveWeatherData(&models.WeatherData{})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(store.batchSizes()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the partial batch to be saved")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// TestBatchWriterQueueFull tests backpressure when the queue is full
+func TestBatchWriterQueueFull(t *testing.T) {
+	writer := NewBatchWriter(&MockBatchStore{}, config.BatchConfig{Size: 50, FlushInterval: 60, QueueSize: 2})
+
+	// Not started, so nothing leaves the queue
+	for n := 0; n < 2; n++ {
+		if err := writer.SaveWeatherData(&models.WeatherData{}); err != nil {
+			t.Fatalf("SaveWeatherData returned error: %v", err)
+		}
+	}
+	if err := writer.SaveWeatherData(&models.WeatherData{}); err != ErrQueueFull {
+		t.Errorf("Expected ErrQueueFull, got %v", err)
+	}
+}
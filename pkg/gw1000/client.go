@@ -0,0 +1,113 @@
+package gw1000
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ask-23/go-wx/internal/models"
+)
+
+// defaultTimeout bounds each command, from connecting to the last byte of
+// the response
+const defaultTimeout = 5 * time.Second
+
+// Client sends commands to a gateway over its LAN API
+type Client struct {
+	address string
+	timeout time.Duration
+}
+
+// NewClient creates a client for the gateway at address, a host with an
+// optional port that defaults to DefaultPort
+func NewClient(address string) *Client {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, strconv.Itoa(DefaultPort))
+	}
+	return &Client{address: address, timeout: defaultTimeout}
+}
+
+// Address returns the host and port of the gateway
+func (c *Client) Address() string {
+	return c.address
+}
+
+// command sends cmd with its payload and returns the payload of the response
+func (c *Client) command(cmd byte, payload []byte) ([]byte, error) {
+	conn, err := net.DialTimeout("tcp", c.address, c.timeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to gateway: %w", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(c.timeout))
+
+	if _, err := conn.Write(encodePacket(cmd, payload, 1)); err != nil {
+		return nil, fmt.Errorf("failed to send command 0x%02x: %w", cmd, err)
+	}
+
+	response, err := readPacket(bufio.NewReader(conn), cmd)
+	if err != nil {
+		return nil, fmt.Errorf("command 0x%02x: %w", cmd, err)
+	}
+	return response, nil
+}
+
+// Firmware returns the firmware version of the gateway, e.g. GW1000_V1.7.3
+func (c *Client) Firmware() (string, error) {
+	response, err := c.command(cmdReadFirmware, nil)
+	if err != nil {
+		return "", err
+	}
+	return readString(response)
+}
+
+// MAC returns the MAC address of the gateway, e.g. AA:BB:CC:DD:EE:FF
+func (c *Client) MAC() (string, error) {
+	response, err := c.command(cmdReadStationMAC, nil)
+	if err != nil {
+		return "", err
+	}
+	if len(response) != 6 {
+		return "", fmt.Errorf("invalid MAC address of %d bytes", len(response))
+	}
+	return formatMAC(response), nil
+}
+
+// LiveData returns the current readings of the gateway's sensors as an
+// observation timestamped now
+func (c *Client) LiveData() (*models.WeatherData, error) {
+	response, err := c.command(cmdLiveData, nil)
+	if err != nil {
+		return nil, err
+	}
+	return parseLiveData(response, time.Now().Truncate(time.Second))
+}
+
+// Sensors returns the sensors registered with the gateway
+func (c *Client) Sensors() ([]Sensor, error) {
+	response, err := c.command(cmdReadSensorIDNew, nil)
+	if err != nil {
+		return nil, err
+	}
+	return parseSensors(response)
+}
+
+// readString reads a string prefixed by its length
+func readString(b []byte) (string, error) {
+	if len(b) == 0 || int(b[0]) > len(b)-1 {
+		return "", fmt.Errorf("invalid string field")
+	}
+	return string(b[1 : 1+int(b[0])]), nil
+}
+
+// formatMAC formats a MAC address as colon separated upper case hex
+func formatMAC(b []byte) string {
+	parts := make([]string, len(b))
+	for n, c := range b {
+		parts[n] = fmt.Sprintf("%02X", c)
+	}
+	return strings.Join(parts, ":")
+}
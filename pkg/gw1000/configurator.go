@@ -0,0 +1,160 @@
+package gw1000
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Configurator keeps the customized upload of a gateway pointed at the
+// collector, checking its settings on start and then at an interval, as a
+// firmware update or reset silently clears them
+type Configurator struct {
+	client   *Client
+	target   CustomServer
+	interval time.Duration
+	done     chan struct{} // closed to ask Run to return
+	stopped  chan struct{} // closed once Run has returned
+	running  bool
+	mutex    sync.Mutex
+}
+
+// NewConfigurator creates a configurator setting the customized upload of
+// the gateway of client to target every interval it differs. An empty host
+// in target stands for the address of this machine on the gateway's network.
+// Settings the target leaves empty, such as the station ID, are kept.
+func NewConfigurator(client *Client, target CustomServer, interval time.Duration) *Configurator {
+	// The gateway appends the query string straight to the wunderground path
+	if target.Protocol == ProtocolWunderground && !strings.HasSuffix(target.WundergroundPath, "?") {
+		target.WundergroundPath += "?"
+	}
+	return &Configurator{
+		client:   client,
+		target:   target,
+		interval: interval,
+	}
+}
+
+// Name returns the name the configurator is reported under
+func (c *Configurator) Name() string {
+	return "gw1000 configurator"
+}
+
+// Run checks the settings of the gateway until the configurator is shut down
+// or ctx is canceled, or returns an error once several checks in a row have
+// failed
+func (c *Configurator) Run(ctx context.Context) error {
+	c.mutex.Lock()
+	if c.running {
+		c.mutex.Unlock()
+		return fmt.Errorf("configurator is already running")
+	}
+	done, stopped := make(chan struct{}), make(chan struct{})
+	c.done, c.stopped = done, stopped
+	c.running = true
+	c.mutex.Unlock()
+
+	defer func() {
+		c.mutex.Lock()
+		c.running = false
+		c.mutex.Unlock()
+		close(stopped)
+	}()
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	failures := 0
+	for {
+		if err := c.check(); err != nil {
+			failures++
+			log.Printf("Error configuring customized upload of gateway at %s: %v", c.client.Address(), err)
+			if failures >= maxPollFailures {
+				return fmt.Errorf("%d checks in a row failed: %w", failures, err)
+			}
+		} else {
+			failures = 0
+		}
+
+		select {
+		case <-ticker.C:
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// check reads the customized upload settings of the gateway and corrects
+// them if they differ from the target
+func (c *Configurator) check() error {
+	current, err := c.client.CustomServer()
+	if err != nil {
+		return err
+	}
+
+	want := c.target
+	if want.Host == "" {
+		host, err := localAddress(c.client.Address())
+		if err != nil {
+			return err
+		}
+		want.Host = host
+	}
+	if want.ID == "" {
+		want.ID = current.ID
+	}
+	if want.Password == "" {
+		want.Password = current.Password
+	}
+	if want.Protocol == ProtocolWunderground {
+		want.EcowittPath = current.EcowittPath
+	} else {
+		want.WundergroundPath = current.WundergroundPath
+	}
+
+	if current == want {
+		return nil
+	}
+
+	log.Printf("Customized upload of gateway at %s is %v; changing it to %v", c.client.Address(), current, want)
+	return c.client.SetCustomServer(want)
+}
+
+// localAddress returns the IP address this machine reaches address from
+func localAddress(address string) (string, error) {
+	// Connecting a UDP socket picks the route without sending anything
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return "", fmt.Errorf("failed to find local address towards %s: %w", address, err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String(), nil
+}
+
+// Shutdown stops checking, waiting for a check in progress until ctx is done
+func (c *Configurator) Shutdown(ctx context.Context) error {
+	c.mutex.Lock()
+	if !c.running {
+		c.mutex.Unlock()
+		return nil
+	}
+	if c.done != nil {
+		close(c.done)
+		c.done = nil
+	}
+	stopped := c.stopped
+	c.mutex.Unlock()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("configurator did not finish checking: %w", ctx.Err())
+	}
+}
@@ -0,0 +1,163 @@
+package gw1000
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// Upload protocols of the customized server
+const (
+	ProtocolEcowitt      = "ecowitt"
+	ProtocolWunderground = "wunderground"
+)
+
+// CustomServer is the customized upload of a gateway, which sends its
+// readings to a server of choice in addition to the weather services
+type CustomServer struct {
+	Enabled  bool
+	Protocol string // ecowitt or wunderground
+	Host     string
+	Port     int
+	Interval int    // seconds between uploads
+	ID       string // station ID of wunderground uploads
+	Password string // station key of wunderground uploads
+
+	// Each protocol keeps its own path; uploads use the one of Protocol
+	EcowittPath      string
+	WundergroundPath string
+}
+
+// Path returns the path uploads are sent to with the current protocol
+func (s CustomServer) Path() string {
+	if s.Protocol == ProtocolWunderground {
+		return s.WundergroundPath
+	}
+	return s.EcowittPath
+}
+
+// String describes the settings for logging, e.g. ecowitt upload to
+// 192.168.1.10:8000/ every 60s
+func (s CustomServer) String() string {
+	if !s.Enabled {
+		return "disabled"
+	}
+	return fmt.Sprintf("%s upload to %s%s every %ds", s.Protocol, net.JoinHostPort(s.Host, strconv.Itoa(s.Port)), s.Path(), s.Interval)
+}
+
+// CustomServer returns the customized upload settings of the gateway
+func (c *Client) CustomServer() (CustomServer, error) {
+	response, err := c.command(cmdReadCustomized, nil)
+	if err != nil {
+		return CustomServer{}, err
+	}
+	server, err := parseCustomized(response)
+	if err != nil {
+		return CustomServer{}, err
+	}
+
+	response, err = c.command(cmdReadUserPath, nil)
+	if err != nil {
+		return CustomServer{}, err
+	}
+	fields, err := readStrings(response, 2)
+	if err != nil {
+		return CustomServer{}, fmt.Errorf("invalid upload paths: %w", err)
+	}
+	server.EcowittPath, server.WundergroundPath = fields[0], fields[1]
+
+	return server, nil
+}
+
+// SetCustomServer changes the customized upload settings of the gateway
+func (c *Client) SetCustomServer(s CustomServer) error {
+	for _, field := range []string{s.ID, s.Password, s.Host, s.EcowittPath, s.WundergroundPath} {
+		if len(field) > 255 {
+			return fmt.Errorf("customized upload setting %q too long", field)
+		}
+	}
+
+	// The paths first, so the upload is not enabled with the old ones
+	paths := appendString(appendString(nil, s.EcowittPath), s.WundergroundPath)
+	if err := c.write(cmdWriteUserPath, paths); err != nil {
+		return err
+	}
+	return c.write(cmdWriteCustomized, encodeCustomized(s))
+}
+
+// write sends a command changing a setting and checks its result
+func (c *Client) write(cmd byte, payload []byte) error {
+	response, err := c.command(cmd, payload)
+	if err != nil {
+		return err
+	}
+	if len(response) != 1 || response[0] != 0 {
+		return fmt.Errorf("gateway refused command 0x%02x", cmd)
+	}
+	return nil
+}
+
+// parseCustomized converts the response to cmdReadCustomized: the station
+// ID, password and host as strings prefixed by their length, then the port
+// and interval, the protocol and whether the upload is enabled
+func parseCustomized(b []byte) (CustomServer, error) {
+	fields, err := readStrings(b, 3)
+	if err != nil {
+		return CustomServer{}, fmt.Errorf("invalid customized upload settings: %w", err)
+	}
+	rest := b[3+len(fields[0])+len(fields[1])+len(fields[2]):]
+	if len(rest) != 6 {
+		return CustomServer{}, fmt.Errorf("invalid customized upload settings of %d bytes", len(b))
+	}
+
+	protocol := ProtocolEcowitt
+	if rest[4] == 1 {
+		protocol = ProtocolWunderground
+	}
+	return CustomServer{
+		Enabled:  rest[5] == 1,
+		Protocol: protocol,
+		Host:     fields[2],
+		Port:     int(binary.BigEndian.Uint16(rest[0:2])),
+		Interval: int(binary.BigEndian.Uint16(rest[2:4])),
+		ID:       fields[0],
+		Password: fields[1],
+	}, nil
+}
+
+// encodeCustomized builds the payload of cmdWriteCustomized, laid out like
+// the response to cmdReadCustomized
+func encodeCustomized(s CustomServer) []byte {
+	b := appendString(appendString(appendString(nil, s.ID), s.Password), s.Host)
+	b = binary.BigEndian.AppendUint16(b, uint16(s.Port))
+	b = binary.BigEndian.AppendUint16(b, uint16(s.Interval))
+
+	var protocol, enabled byte
+	if s.Protocol == ProtocolWunderground {
+		protocol = 1
+	}
+	if s.Enabled {
+		enabled = 1
+	}
+	return append(b, protocol, enabled)
+}
+
+// readStrings reads n consecutive strings prefixed by their length
+func readStrings(b []byte, n int) ([]string, error) {
+	fields := make([]string, n)
+	for i := range fields {
+		field, err := readString(b)
+		if err != nil {
+			return nil, err
+		}
+		fields[i] = field
+		b = b[1+len(field):]
+	}
+	return fields, nil
+}
+
+// appendString appends s prefixed by its length
+func appendString(b []byte, s string) []byte {
+	return append(append(b, byte(len(s))), s...)
+}
@@ -0,0 +1,112 @@
+package gw1000
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DiscoveryAddress is where discovery requests are broadcast
+const DiscoveryAddress = "255.255.255.255:46000"
+
+// Device is a gateway that answered a discovery request
+type Device struct {
+	MAC      string `json:"mac"`
+	IP       string `json:"ip"`
+	Port     int    `json:"port"`     // LAN API port
+	SSID     string `json:"ssid"`     // access point name, e.g. GW1000A-WIFI1234
+	Firmware string `json:"firmware"` // e.g. V1.6.8
+}
+
+// Address returns the host and port of the device's LAN API
+func (d Device) Address() string {
+	return net.JoinHostPort(d.IP, strconv.Itoa(d.Port))
+}
+
+// Model returns the model of the device from its access point name, e.g.
+// GW1000A, or an empty string if it cannot tell
+func (d Device) Model() string {
+	model, _, ok := strings.Cut(d.SSID, "-")
+	if !ok {
+		return ""
+	}
+	return model
+}
+
+// Discover broadcasts a discovery request to address, normally
+// DiscoveryAddress, and returns the devices that answer within timeout,
+// each once
+func Discover(address string, timeout time.Duration) ([]Device, error) {
+	target, err := net.ResolveUDPAddr("udp4", address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid discovery address: %w", err)
+	}
+
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open discovery socket: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.WriteToUDP(encodePacket(cmdBroadcast, nil, 1), target); err != nil {
+		return nil, fmt.Errorf("failed to send discovery request: %w", err)
+	}
+
+	// Collect answers until the timeout
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	var devices []Device
+	seen := make(map[string]bool)
+	buf := make([]byte, 1024)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return devices, nil
+			}
+			return devices, fmt.Errorf("failed to read discovery response: %w", err)
+		}
+
+		// Ignore anything but discovery responses
+		payload, err := readPacket(bufio.NewReader(bytes.NewReader(buf[:n])), cmdBroadcast)
+		if err != nil {
+			continue
+		}
+		device, err := parseDevice(payload)
+		if err != nil || seen[device.MAC] {
+			continue
+		}
+		seen[device.MAC] = true
+		devices = append(devices, device)
+	}
+}
+
+// parseDevice converts the payload of a discovery response: the MAC
+// address, IP address and LAN API port, then the access point name and
+// firmware version, e.g. "GW1000A-WIFI1234 V1.6.8", prefixed by its length
+func parseDevice(b []byte) (Device, error) {
+	if len(b) < 13 {
+		return Device{}, fmt.Errorf("discovery response too short")
+	}
+
+	name, err := readString(b[12:])
+	if err != nil {
+		return Device{}, err
+	}
+
+	device := Device{
+		MAC:  formatMAC(b[:6]),
+		IP:   net.IP(b[6:10]).String(),
+		Port: int(binary.BigEndian.Uint16(b[10:12])),
+		SSID: name,
+	}
+	if ssid, firmware, ok := strings.Cut(name, " "); ok && strings.HasPrefix(firmware, "V") {
+		device.SSID, device.Firmware = ssid, firmware
+	}
+
+	return device, nil
+}
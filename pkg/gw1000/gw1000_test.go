@@ -0,0 +1,642 @@
+package gw1000
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"math"
+	"net"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ask-23/go-wx/internal/models"
+)
+
+// fakeDevice answers LAN API commands on a local port with canned
+// responses, standing in for a gateway
+type fakeDevice struct {
+	listener  net.Listener
+	responses map[byte][]byte // response payload by command
+	corrupt   bool            // send responses with a bad checksum
+	refuse    bool            // refuse to change settings
+	commands  []byte          // commands received
+	mutex     sync.Mutex
+}
+
+// writeCommands maps the commands a fake gateway stores settings for to the
+// commands reading them back
+var writeCommands = map[byte]byte{
+	cmdWriteCustomized: cmdReadCustomized,
+	cmdWriteUserPath:   cmdReadUserPath,
+}
+
+// newFakeDevice starts a fake gateway answering with responses
+func newFakeDevice(t *testing.T, responses map[byte][]byte) *fakeDevice {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	d := &fakeDevice{listener: l, responses: responses}
+	t.Cleanup(func() { l.Close() })
+	go d.serve()
+	return d
+}
+
+// serve answers one command per connection until the listener is closed
+func (d *fakeDevice) serve() {
+	for {
+		conn, err := d.listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+
+			// Requests are header, command, size, payload and checksum
+			r := bufio.NewReader(conn)
+			start := make([]byte, 4)
+			if _, err := io.ReadFull(r, start); err != nil || start[0] != 0xFF || start[1] != 0xFF {
+				return
+			}
+			rest := make([]byte, int(start[3])-2)
+			if _, err := io.ReadFull(r, rest); err != nil {
+				return
+			}
+			cmd := start[2]
+
+			d.mutex.Lock()
+			d.commands = append(d.commands, cmd)
+			payload, ok := d.responses[cmd]
+			// Writes change what the matching read answers
+			if read, isWrite := writeCommands[cmd]; isWrite {
+				payload, ok = []byte{1}, true
+				if !d.refuse {
+					d.responses[read] = rest[:len(rest)-1]
+					payload = []byte{0}
+				}
+			}
+			corrupt := d.corrupt
+			d.mutex.Unlock()
+			if !ok {
+				return
+			}
+
+			packet := encodePacket(cmd, payload, sizeWidth(cmd))
+			if corrupt {
+				packet[len(packet)-1]++
+			}
+			conn.Write(packet)
+		}()
+	}
+}
+
+// client returns a client for the fake gateway
+func (d *fakeDevice) client() *Client {
+	return NewClient(d.listener.Addr().String())
+}
+
+// item builds a live data item
+func item(id byte, value ...byte) []byte {
+	return append([]byte{id}, value...)
+}
+
+// liveDataPayload returns a live data response of a gateway with a WH65,
+// a WH31, a WH51, a WH41, a WH55, a WH45 and a WN34
+func liveDataPayload() []byte {
+	return bytes.Join([][]byte{
+		item(0x01, 0x00, 0xE1),             // indoor 22.5°C
+		item(0x06, 45),                     // indoor 45%
+		item(0x02, 0xFF, 0xCB),             // outdoor -5.3°C
+		item(0x07, 80),                     // outdoor 80%
+		item(0x08, 0x27, 0x1C),             // absolute 1001.2 hPa
+		item(0x09, 0x27, 0x96),             // relative 1013.4 hPa
+		item(0x0A, 0x01, 0x0E),             // 270°
+		item(0x0B, 0x00, 0x22),             // 3.4 m/s
+		item(0x0C, 0x00, 0x38),             // gust 5.6 m/s
+		item(0x0E, 0x00, 0x0C),             // 1.2 mm/h
+		item(0x10, 0x00, 0x2D),             // 4.5 mm today
+		item(0x13, 0x00, 0x00, 0x04, 0xD2), // 123.4 mm this year
+		item(0x15, 0x00, 0x01, 0xEE, 0xEC), // 12670 lux
+		item(0x17, 3),                      // UV index 3
+		item(0x1A, 0x00, 0xD2),             // channel 1 21.0°C
+		item(0x22, 55),                     // channel 1 55%
+		item(0x2C, 33),                     // soil moisture channel 1 33%
+		item(0x2A, 0x00, 0x7B),             // PM2.5 channel 1 12.3 µg/m³
+		item(0x4D, 0x00, 0x64),             // PM2.5 channel 1 average 10.0 µg/m³
+		item(0x58, 1),                      // leak on channel 1
+		item(0x70, 0x00, 0xE1, 45, 0, 0, 0, 0, 0, 0, 0, 0, 0x02, 0x64, 0x02, 0x44, 5), // CO2 612, 580 ppm
+		item(0x63, 0x00, 0x64, 78), // WN34 channel 1 at 1.56 V
+		item(0xF0, 1, 2),           // unknown to the decoder
+	}, nil)
+}
+
+// sensorPayload returns a sensor ID response with a WH65, a WH68 that has
+// not been heard from, a WH31 on channel 2, a WH51 on channel 1, a disabled
+// WH80 and a WH90
+func sensorPayload() []byte {
+	return bytes.Join([][]byte{
+		{0, 0x00, 0x00, 0x00, 0xA1, 0, 4},
+		{1, 0x00, 0x00, 0x00, 0xB1, 50, 0},
+		{2, 0xFF, 0xFF, 0xFF, 0xFE, 0, 0},
+		{7, 0x00, 0x00, 0x00, 0xC2, 1, 3},
+		{14, 0x00, 0x00, 0x00, 0xD3, 14, 4},
+		{48, 0x00, 0x00, 0x01, 0x00, 155, 4},
+	}, nil)
+}
+
+// fakeGateway returns a fake gateway with live data, sensors, firmware and MAC
+func fakeGateway(t *testing.T) *fakeDevice {
+	return newFakeDevice(t, map[byte][]byte{
+		cmdLiveData:        liveDataPayload(),
+		cmdReadSensorIDNew: sensorPayload(),
+		cmdReadFirmware:    append([]byte{13}, "GW1000_V1.6.8"...),
+		cmdReadStationMAC:  {0xAA, 0xBB, 0xCC, 0xDD, 0xEE, 0xFF},
+	})
+}
+
+func approximatelyEqual(a, b float64) bool {
+	return math.Abs(a-b) < 0.001
+}
+
+// TestPacket tests encoding requests and reading responses
+func TestPacket(t *testing.T) {
+	if packet := encodePacket(cmdLiveData, nil, 1); !bytes.Equal(packet, []byte{0xFF, 0xFF, 0x27, 0x03, 0x2A}) {
+		t.Errorf("Expected live data request ff ff 27 03 2a, got % x", packet)
+	}
+
+	response := []byte{0xFF, 0xFF, 0x27, 0x00, 0x06, 0x07, 0x32, 0x66}
+	payload, err := readPacket(bufio.NewReader(bytes.NewReader(response)), cmdLiveData)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	if !bytes.Equal(payload, []byte{0x07, 0x32}) {
+		t.Errorf("Expected payload 07 32, got % x", payload)
+	}
+
+	response[len(response)-1] = 0
+	if _, err := readPacket(bufio.NewReader(bytes.NewReader(response)), cmdLiveData); err == nil {
+		t.Errorf("Expected a checksum error")
+	}
+}
+
+// TestLiveData tests reading the live data of a gateway
+func TestLiveData(t *testing.T) {
+	device := fakeGateway(t)
+
+	data, err := device.client().LiveData()
+	if err != nil {
+		t.Fatalf("Failed to read live data: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		got      float64
+		expected float64
+	}{
+		{"temperature", data.Temperature, -5.3},
+		{"humidity", data.Humidity, 80},
+		{"pressure", data.Pressure, 1001.2},
+		{"relative pressure", data.RelativePressure, 1013.4},
+		{"wind direction", data.WindDirection, 270},
+		{"wind speed", data.WindSpeed, 3.4},
+		{"wind gust", data.WindGust, 5.6},
+		{"rain rate", data.RainRate, 1.2},
+		{"daily rain", data.DailyRain, 4.5},
+		{"yearly rain", data.YearlyRain, 123.4},
+		{"solar radiation", data.SolarRadiation, 100},
+		{"UV index", data.UVIndex, 3},
+	}
+	for _, tt := range tests {
+		if !approximatelyEqual(tt.got, tt.expected) {
+			t.Errorf("Expected %s %v, got %v", tt.name, tt.expected, tt.got)
+		}
+	}
+	if data.DewPoint == 0 {
+		t.Errorf("Expected derived values to be calculated")
+	}
+
+	indoor := data.Indoor
+	if indoor == nil || indoor.Temperature != 22.5 || indoor.Humidity != 45 || indoor.Pressure != 1001.2 {
+		t.Fatalf("Expected indoor 22.5°C, 45%% and 1001.2 hPa, got %+v", indoor)
+	}
+	if indoor.CO2 == nil || *indoor.CO2 != 612 || indoor.CO2Avg24h == nil || *indoor.CO2Avg24h != 580 {
+		t.Errorf("Expected CO2 612 ppm averaging 580 ppm, got %v and %v", indoor.CO2, indoor.CO2Avg24h)
+	}
+
+	channels := data.Channels
+	if channels == nil {
+		t.Fatalf("Expected add-on sensor channels")
+	}
+	if len(channels.TempHumidity) != 1 || channels.TempHumidity[0] != (models.TempHumidityChannel{Channel: 1, Temperature: 21, Humidity: 55}) {
+		t.Errorf("Expected channel 1 at 21°C and 55%%, got %+v", channels.TempHumidity)
+	}
+	if len(channels.SoilMoisture) != 1 || channels.SoilMoisture[0].Moisture != 33 {
+		t.Errorf("Expected soil moisture 33%%, got %+v", channels.SoilMoisture)
+	}
+	if len(channels.PM25) != 1 || channels.PM25[0].PM25 != 12.3 || channels.PM25[0].PM25Avg != 10 {
+		t.Errorf("Expected PM2.5 12.3 averaging 10, got %+v", channels.PM25)
+	}
+	if len(channels.Leak) != 1 || !channels.Leak[0].Leak {
+		t.Errorf("Expected a leak on channel 1, got %+v", channels.Leak)
+	}
+	if !approximatelyEqual(channels.Batteries["tf_batt1"], 1.56) || channels.Batteries["co2_batt"] != 5 {
+		t.Errorf("Expected WN34 at 1.56 V and WH45 at level 5, got %v", channels.Batteries)
+	}
+}
+
+// TestPiezoRain tests choosing between a tipping bucket and a piezo gauge
+func TestPiezoRain(t *testing.T) {
+	bucket := item(0x0E, 0x00, 0x0C)
+	piezo := item(0x80, 0x00, 0x14)
+
+	data, err := parseLiveData(append(append([]byte{}, bucket...), piezo...), time.Now())
+	if err != nil {
+		t.Fatalf("Failed to parse live data: %v", err)
+	}
+	if data.RainRate != 1.2 {
+		t.Errorf("Expected the tipping bucket rain rate 1.2, got %v", data.RainRate)
+	}
+
+	priority := item(0x7A, rainPriorityPiezo)
+	data, err = parseLiveData(bytes.Join([][]byte{bucket, priority, piezo}, nil), time.Now())
+	if err != nil {
+		t.Fatalf("Failed to parse live data: %v", err)
+	}
+	if data.RainRate != 2 {
+		t.Errorf("Expected the piezo rain rate 2.0, got %v", data.RainRate)
+	}
+
+	if _, err := parseLiveData(item(0x12, 0x00), time.Now()); err == nil {
+		t.Errorf("Expected an error for a truncated item")
+	}
+}
+
+// TestSensors tests reading the registered sensors and their batteries
+func TestSensors(t *testing.T) {
+	device := fakeGateway(t)
+
+	sensors, err := device.client().Sensors()
+	if err != nil {
+		t.Fatalf("Failed to read sensors: %v", err)
+	}
+
+	expected := []Sensor{
+		{Name: "wh65", ID: "A1", Battery: 0, Signal: 4},
+		{Name: "wh68", ID: "B1", Battery: 1, Signal: 0},
+		{Name: "wh31_ch2", ID: "C2", Battery: 1, Signal: 3},
+		{Name: "wh51_ch1", ID: "D3", Battery: 1.4, Signal: 4},
+		{Name: "wh90", ID: "100", Battery: 3.1, Signal: 4},
+	}
+	if len(sensors) != len(expected) {
+		t.Fatalf("Expected %d sensors, got %+v", len(expected), sensors)
+	}
+	for n, s := range sensors {
+		e := expected[n]
+		if s.Name != e.Name || s.ID != e.ID || !approximatelyEqual(s.Battery, e.Battery) || s.Signal != e.Signal {
+			t.Errorf("Expected %+v, got %+v", e, s)
+		}
+	}
+
+	batteries := Batteries(sensors)
+	for _, field := range []string{"wh65batt", "batt2", "soilbatt1", "wh90batt"} {
+		if _, ok := batteries[field]; !ok {
+			t.Errorf("Expected battery field %s, got %v", field, batteries)
+		}
+	}
+}
+
+// TestIdentity tests reading the firmware version and MAC address
+func TestIdentity(t *testing.T) {
+	device := fakeGateway(t)
+	client := device.client()
+
+	if firmware, err := client.Firmware(); err != nil || firmware != "GW1000_V1.6.8" {
+		t.Errorf("Expected firmware GW1000_V1.6.8, got %q (%v)", firmware, err)
+	}
+	if mac, err := client.MAC(); err != nil || mac != "AA:BB:CC:DD:EE:FF" {
+		t.Errorf("Expected MAC AA:BB:CC:DD:EE:FF, got %q (%v)", mac, err)
+	}
+
+	device.mutex.Lock()
+	device.corrupt = true
+	device.mutex.Unlock()
+	if _, err := client.Firmware(); err == nil {
+		t.Errorf("Expected an error for a corrupt response")
+	}
+
+	if c := NewClient("192.168.1.100"); c.Address() != "192.168.1.100:45000" {
+		t.Errorf("Expected the default port, got %s", c.Address())
+	}
+}
+
+// MockSink keeps the observations submitted to it
+type MockSink struct {
+	submitted []*models.WeatherData
+	mutex     sync.Mutex
+}
+
+func (m *MockSink) ResolveStation(form url.Values) string {
+	return "mac-" + form.Get("MAC")
+}
+
+func (m *MockSink) Submit(data *models.WeatherData) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.submitted = append(m.submitted, data)
+	return nil
+}
+
+// count returns the number of observations submitted
+func (m *MockSink) count() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return len(m.submitted)
+}
+
+// TestPoller tests polling a gateway at an interval
+func TestPoller(t *testing.T) {
+	device := fakeGateway(t)
+	sink := &MockSink{}
+	poller := NewPoller(device.client(), sink, 10*time.Millisecond)
+
+	result := make(chan error)
+	go func() { result <- poller.Run(context.Background()) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for sink.count() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for polls")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := poller.Shutdown(context.Background()); err != nil {
+		t.Errorf("Expected shutdown to succeed, got %v", err)
+	}
+	if err := <-result; err != nil {
+		t.Errorf("Expected run to return nil, got %v", err)
+	}
+
+	data := sink.submitted[0]
+	if data.StationID != "mac-AA:BB:CC:DD:EE:FF" || data.ReceivedAt.IsZero() {
+		t.Errorf("Expected the station of the gateway's MAC and a receive time, got %q at %v", data.StationID, data.ReceivedAt)
+	}
+	batteries := data.Channels.Batteries
+	if _, ok := batteries["wh65batt"]; !ok || !approximatelyEqual(batteries["tf_batt1"], 1.56) {
+		t.Errorf("Expected batteries from the sensor IDs and the live data, got %v", batteries)
+	}
+	if _, ok := batteries["wh68batt"]; ok {
+		t.Errorf("Expected no battery for a sensor without signal, got %v", batteries)
+	}
+	signals := data.Channels.Signals
+	if len(signals) != 4 || signals["wh31_ch2"] != 3 {
+		t.Errorf("Expected the signals of the 4 sensors heard from, got %v", signals)
+	}
+}
+
+// TestPollerFailure tests giving up once polls keep failing
+func TestPollerFailure(t *testing.T) {
+	device := newFakeDevice(t, map[byte][]byte{
+		cmdReadFirmware:   append([]byte{13}, "GW1000_V1.6.8"...),
+		cmdReadStationMAC: {0xAA, 0xBB, 0xCC, 0xDD, 0xEE, 0xFF},
+	})
+	poller := NewPoller(device.client(), &MockSink{}, time.Millisecond)
+
+	if err := poller.Run(context.Background()); err == nil {
+		t.Errorf("Expected an error when live data cannot be read")
+	}
+}
+
+// newDiscoveryResponder answers discovery requests on a local UDP port with
+// the announcements of devices, standing in for gateways on the network.
+// It returns the address to send requests to.
+func newDiscoveryResponder(t *testing.T, announcements ...[]byte) string {
+	t.Helper()
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if !bytes.Equal(buf[:n], []byte{0xFF, 0xFF, cmdBroadcast, 0x03, 0x15}) {
+				continue
+			}
+			for _, a := range announcements {
+				conn.WriteToUDP(a, from)
+			}
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+// announcement returns a discovery response of a device
+func announcement(mac []byte, ip net.IP, name string) []byte {
+	payload := append(append(append([]byte{}, mac...), ip.To4()...), 0xAF, 0xC8) // port 45000
+	payload = append(append(payload, byte(len(name))), name...)
+	return encodePacket(cmdBroadcast, payload, 2)
+}
+
+// TestDiscover tests discovering gateways on the network
+func TestDiscover(t *testing.T) {
+	gw1000 := announcement([]byte{0xAA, 0xBB, 0xCC, 0xDD, 0xEE, 0xFF}, net.IPv4(192, 168, 1, 100), "GW1000A-WIFI1234 V1.6.8")
+	gw1100 := announcement([]byte{0x11, 0x22, 0x33, 0x44, 0x55, 0x66}, net.IPv4(192, 168, 1, 101), "GW1100B-WIFI5678 V2.1.4")
+	address := newDiscoveryResponder(t, gw1000, []byte("noise"), gw1100, gw1000)
+
+	devices, err := Discover(address, 200*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Discover returned error: %v", err)
+	}
+
+	expected := []Device{
+		{MAC: "AA:BB:CC:DD:EE:FF", IP: "192.168.1.100", Port: 45000, SSID: "GW1000A-WIFI1234", Firmware: "V1.6.8"},
+		{MAC: "11:22:33:44:55:66", IP: "192.168.1.101", Port: 45000, SSID: "GW1100B-WIFI5678", Firmware: "V2.1.4"},
+	}
+	if len(devices) != len(expected) {
+		t.Fatalf("Expected %d devices, got %+v", len(expected), devices)
+	}
+	for n, d := range devices {
+		if d != expected[n] {
+			t.Errorf("Expected %+v, got %+v", expected[n], d)
+		}
+	}
+
+	if model := devices[1].Model(); model != "GW1100B" {
+		t.Errorf("Expected model GW1100B, got %q", model)
+	}
+	if addr := devices[0].Address(); addr != "192.168.1.100:45000" {
+		t.Errorf("Expected address 192.168.1.100:45000, got %s", addr)
+	}
+}
+
+// customizedPayload returns a response to cmdReadCustomized of a gateway
+// uploading to the Ecowitt protocol at 192.168.1.50:8080 every 60 seconds
+func customizedPayload() []byte {
+	payload := append([]byte{4}, "ABCD"...)
+	payload = append(append(payload, 6), "secret"...)
+	payload = append(append(payload, 12), "192.168.1.50"...)
+	return append(payload, 0x1F, 0x90, 0x00, 0x3C, 0, 1)
+}
+
+// userPathPayload returns a response to cmdReadUserPath with the default paths
+func userPathPayload() []byte {
+	payload := append([]byte{13}, "/data/report/"...)
+	wu := "/weatherstation/updateweatherstation.php?"
+	return append(append(payload, byte(len(wu))), wu...)
+}
+
+// TestCustomServer tests reading and writing the customized upload settings
+func TestCustomServer(t *testing.T) {
+	device := newFakeDevice(t, map[byte][]byte{
+		cmdReadCustomized: customizedPayload(),
+		cmdReadUserPath:   userPathPayload(),
+	})
+	client := device.client()
+
+	server, err := client.CustomServer()
+	if err != nil {
+		t.Fatalf("CustomServer returned error: %v", err)
+	}
+	expected := CustomServer{
+		Enabled:          true,
+		Protocol:         ProtocolEcowitt,
+		Host:             "192.168.1.50",
+		Port:             8080,
+		Interval:         60,
+		ID:               "ABCD",
+		Password:         "secret",
+		EcowittPath:      "/data/report/",
+		WundergroundPath: "/weatherstation/updateweatherstation.php?",
+	}
+	if server != expected {
+		t.Errorf("Expected %+v, got %+v", expected, server)
+	}
+	if s := server.String(); s != "ecowitt upload to 192.168.1.50:8080/data/report/ every 60s" {
+		t.Errorf("Expected description of the upload, got %q", s)
+	}
+
+	// Settings written are read back unchanged
+	expected.Protocol = ProtocolWunderground
+	expected.Host = "wx.example.com"
+	expected.WundergroundPath = "/ingest/wunderground?"
+	if err := client.SetCustomServer(expected); err != nil {
+		t.Fatalf("SetCustomServer returned error: %v", err)
+	}
+	server, err = client.CustomServer()
+	if err != nil {
+		t.Fatalf("CustomServer returned error: %v", err)
+	}
+	if server != expected {
+		t.Errorf("Expected %+v, got %+v", expected, server)
+	}
+
+	// A refused write is an error
+	device.mutex.Lock()
+	device.refuse = true
+	device.mutex.Unlock()
+	if err := client.SetCustomServer(expected); err == nil {
+		t.Errorf("Expected error for refused write, got nil")
+	}
+}
+
+// writes returns the number of commands received that change settings
+func (d *fakeDevice) writes() int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	count := 0
+	for _, cmd := range d.commands {
+		if _, ok := writeCommands[cmd]; ok {
+			count++
+		}
+	}
+	return count
+}
+
+// TestConfigurator tests pointing the customized upload at the collector
+// and correcting it when it drifts
+func TestConfigurator(t *testing.T) {
+	device := newFakeDevice(t, map[byte][]byte{
+		cmdReadCustomized: customizedPayload(),
+		cmdReadUserPath:   userPathPayload(),
+	})
+	target := CustomServer{Enabled: true, Protocol: ProtocolEcowitt, Port: 8000, Interval: 60, EcowittPath: "/"}
+	configurator := NewConfigurator(device.client(), target, time.Hour)
+
+	// Run checks the settings right away
+	result := make(chan error)
+	go func() { result <- configurator.Run(context.Background()) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for device.writes() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for the settings to be written")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := configurator.Shutdown(context.Background()); err != nil {
+		t.Errorf("Expected shutdown to succeed, got %v", err)
+	}
+	if err := <-result; err != nil {
+		t.Errorf("Expected run to return nil, got %v", err)
+	}
+
+	// The host defaults to the local address and unmanaged settings are kept
+	server, err := device.client().CustomServer()
+	if err != nil {
+		t.Fatalf("CustomServer returned error: %v", err)
+	}
+	expected := CustomServer{
+		Enabled:          true,
+		Protocol:         ProtocolEcowitt,
+		Host:             "127.0.0.1",
+		Port:             8000,
+		Interval:         60,
+		ID:               "ABCD",
+		Password:         "secret",
+		EcowittPath:      "/",
+		WundergroundPath: "/weatherstation/updateweatherstation.php?",
+	}
+	if server != expected {
+		t.Errorf("Expected %+v, got %+v", expected, server)
+	}
+
+	// Settings that match are left alone
+	if err := configurator.check(); err != nil {
+		t.Fatalf("check returned error: %v", err)
+	}
+	if n := device.writes(); n != 2 {
+		t.Errorf("Expected no further writes, got %d writes", n)
+	}
+
+	// Settings that drifted, e.g. after a reset, are corrected
+	device.mutex.Lock()
+	device.responses[cmdReadCustomized] = customizedPayload()
+	device.mutex.Unlock()
+	if err := configurator.check(); err != nil {
+		t.Fatalf("check returned error: %v", err)
+	}
+	if server, _ := device.client().CustomServer(); server != expected {
+		t.Errorf("Expected %+v after correcting, got %+v", expected, server)
+	}
+}
+
+// TestConfiguratorWunderground tests the path of wunderground uploads ends
+// in the query string separator
+func TestConfiguratorWunderground(t *testing.T) {
+	target := CustomServer{Protocol: ProtocolWunderground, WundergroundPath: "/ingest/wunderground"}
+	configurator := NewConfigurator(NewClient("192.168.1.100"), target, time.Minute)
+	if path := configurator.target.Path(); path != "/ingest/wunderground?" {
+		t.Errorf("Expected path /ingest/wunderground?, got %s", path)
+	}
+}
@@ -0,0 +1,304 @@
+package gw1000
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ask-23/go-wx/internal/models"
+)
+
+// luxPerWattPerSquareMeter converts the illuminance gateways report into
+// solar radiation, the same way their uploads do
+const luxPerWattPerSquareMeter = 126.7
+
+// maxChannels is the most channels of any family of add-on sensors
+const maxChannels = 8
+
+// rainPriorityPiezo is the rain priority setting selecting a piezo gauge,
+// such as the WS90's, over a tipping bucket
+const rainPriorityPiezo = 2
+
+// liveField is an item of a live data response: its size in bytes and how
+// its value is recorded. Items without a place in an observation are only
+// skipped.
+type liveField struct {
+	size   int
+	record func(r *liveReading, b []byte)
+}
+
+// liveReading collects the items of a live data response
+type liveReading struct {
+	data         *models.WeatherData
+	indoor       *models.IndoorData
+	tempHumidity map[int]*models.TempHumidityChannel
+	soilMoisture map[int]float64
+	pm25         map[int]*models.PM25Channel
+	leak         map[int]bool
+	batteries    map[string]float64
+	bucket       bool         // a tipping bucket reported rain
+	piezo        *rainReading // rain reported by a piezo gauge
+	rainPriority int
+}
+
+// rainReading holds the rain counters of a piezo gauge, in millimeters
+type rainReading struct {
+	rate, event, hour, day, week, month, year float64
+}
+
+// liveFields maps item IDs to their fields. Channels of add-on sensors are
+// added by init.
+var liveFields = map[byte]liveField{
+	0x01: {2, func(r *liveReading, b []byte) {
+		r.indoorData().Temperature = signedTenths(b)
+		r.indoor.Report("temperature")
+	}},
+	0x02: {2, func(r *liveReading, b []byte) { r.data.Temperature = signedTenths(b) }},
+	0x03: {2, nil}, // dew point, derived
+	0x04: {2, nil}, // wind chill, derived
+	0x05: {2, nil}, // heat index, derived
+	0x06: {1, func(r *liveReading, b []byte) {
+		r.indoorData().Humidity = float64(b[0])
+		r.indoor.Report("humidity")
+	}},
+	0x07: {1, func(r *liveReading, b []byte) { r.data.Humidity = float64(b[0]) }},
+	0x08: {2, func(r *liveReading, b []byte) { r.data.Pressure = tenths(b) }},
+	0x09: {2, func(r *liveReading, b []byte) { r.data.RelativePressure = tenths(b) }},
+	0x0A: {2, func(r *liveReading, b []byte) { r.data.WindDirection = float64(binary.BigEndian.Uint16(b)) }},
+	0x0B: {2, func(r *liveReading, b []byte) { r.data.WindSpeed = tenths(b) }},
+	0x0C: {2, func(r *liveReading, b []byte) { r.data.WindGust = tenths(b) }},
+	0x0D: {2, func(r *liveReading, b []byte) { r.data.EventRain = tenths(b); r.bucket = true }},
+	0x0E: {2, func(r *liveReading, b []byte) { r.data.RainRate = tenths(b); r.bucket = true }},
+	0x0F: {2, func(r *liveReading, b []byte) { r.data.HourlyRain = tenths(b); r.bucket = true }},
+	0x10: {2, func(r *liveReading, b []byte) { r.data.DailyRain = tenths(b); r.bucket = true }},
+	0x11: {2, func(r *liveReading, b []byte) { r.data.WeeklyRain = tenths(b); r.bucket = true }},
+	0x12: {4, func(r *liveReading, b []byte) { r.data.MonthlyRain = tenths(b); r.bucket = true }},
+	0x13: {4, func(r *liveReading, b []byte) { r.data.YearlyRain = tenths(b); r.bucket = true }},
+	0x14: {4, func(r *liveReading, b []byte) { r.data.TotalRain = tenths(b); r.bucket = true }},
+	0x15: {4, func(r *liveReading, b []byte) { r.data.SolarRadiation = tenths(b) / luxPerWattPerSquareMeter }},
+	0x16: {2, nil}, // UV irradiance
+	0x17: {1, func(r *liveReading, b []byte) { r.data.UVIndex = float64(b[0]) }},
+	0x18: {6, nil}, // gateway date and time
+	0x19: {2, func(r *liveReading, b []byte) { r.data.MaxDailyGust = tenths(b) }},
+	0x4C: {16, nil}, // low battery flags of older firmware
+	0x60: {1, func(r *liveReading, b []byte) { r.data.LightningDistance = float64(b[0]) }},
+	0x61: {4, func(r *liveReading, b []byte) {
+		if ts := binary.BigEndian.Uint32(b); ts != 0 && ts != 0xFFFFFFFF {
+			strike := time.Unix(int64(ts), 0).UTC()
+			r.data.LightningTime = &strike
+		}
+	}},
+	0x62: {4, func(r *liveReading, b []byte) { r.data.LightningCount = int(binary.BigEndian.Uint32(b)) }},
+	0x6C: {4, nil}, // free heap
+	0x70: {16, recordCO2},
+	0x7A: {1, func(r *liveReading, b []byte) { r.rainPriority = int(b[0]) }},
+	0x7B: {1, nil}, // radiation compensation
+	0x80: {2, func(r *liveReading, b []byte) { r.piezoRain().rate = tenths(b) }},
+	0x81: {2, func(r *liveReading, b []byte) { r.piezoRain().event = tenths(b) }},
+	0x82: {2, func(r *liveReading, b []byte) { r.piezoRain().hour = tenths(b) }},
+	0x83: {4, func(r *liveReading, b []byte) { r.piezoRain().day = tenths(b) }},
+	0x84: {4, func(r *liveReading, b []byte) { r.piezoRain().week = tenths(b) }},
+	0x85: {4, func(r *liveReading, b []byte) { r.piezoRain().month = tenths(b) }},
+	0x86: {4, func(r *liveReading, b []byte) { r.piezoRain().year = tenths(b) }},
+	0x87: {20, nil}, // piezo gain
+	0x88: {3, nil},  // rain reset times
+}
+
+// liveMeasurements maps item IDs to the quality controlled measurements they report
+var liveMeasurements = map[byte]string{
+	0x01: "indoorTemperature",
+	0x02: "temperature",
+	0x06: "indoorHumidity",
+	0x07: "humidity",
+	0x08: "pressure",
+	0x09: "relativePressure",
+	0x0A: "windDirection",
+	0x0B: "windSpeed",
+	0x0C: "windGust",
+	0x0E: "rainRate",
+	0x15: "solarRadiation",
+	0x17: "uvIndex",
+	0x80: "rainRate",
+}
+
+func init() {
+	for ch := 1; ch <= maxChannels; ch++ {
+		ch := ch
+
+		// WH31 thermo-hygrometers
+		liveFields[byte(0x1A+ch-1)] = liveField{2, func(r *liveReading, b []byte) {
+			r.tempHumidityChannel(ch).Temperature = signedTenths(b)
+		}}
+		liveFields[byte(0x22+ch-1)] = liveField{1, func(r *liveReading, b []byte) {
+			r.tempHumidityChannel(ch).Humidity = float64(b[0])
+		}}
+
+		// WH51 soil moisture, interleaved with soil temperatures
+		liveFields[byte(0x2B+2*(ch-1))] = liveField{2, nil}
+		liveFields[byte(0x2C+2*(ch-1))] = liveField{1, func(r *liveReading, b []byte) {
+			r.soilMoisture[ch] = float64(b[0])
+		}}
+
+		// WN34 temperatures with their battery voltage, and WH35 leaf wetness
+		liveFields[byte(0x63+ch-1)] = liveField{3, func(r *liveReading, b []byte) {
+			r.batteries[fmt.Sprintf("tf_batt%d", ch)] = float64(b[2]) * 0.02
+		}}
+		liveFields[byte(0x72+ch-1)] = liveField{1, nil}
+	}
+
+	// WH41/WH43 PM2.5 and their 24 hour averages; channel 1 comes first
+	pm25IDs := []byte{0x2A, 0x51, 0x52, 0x53}
+	pm25AvgIDs := []byte{0x4D, 0x4E, 0x4F, 0x50}
+	for n := range pm25IDs {
+		ch := n + 1
+		liveFields[pm25IDs[n]] = liveField{2, func(r *liveReading, b []byte) {
+			r.pm25Channel(ch).PM25 = tenths(b)
+		}}
+		liveFields[pm25AvgIDs[n]] = liveField{2, func(r *liveReading, b []byte) {
+			r.pm25Channel(ch).PM25Avg = tenths(b)
+		}}
+	}
+
+	// WH55 leak detectors
+	for ch := 1; ch <= 4; ch++ {
+		ch := ch
+		liveFields[byte(0x58+ch-1)] = liveField{1, func(r *liveReading, b []byte) {
+			r.leak[ch] = b[0] != 0
+		}}
+	}
+}
+
+// recordCO2 records the WH45 air quality sensor: its temperature, humidity,
+// PM10 and PM2.5 followed by the CO2 concentration and its 24 hour average
+// in ppm, then its battery level
+func recordCO2(r *liveReading, b []byte) {
+	indoor := r.indoorData()
+	co2 := float64(binary.BigEndian.Uint16(b[11:13]))
+	avg := float64(binary.BigEndian.Uint16(b[13:15]))
+	indoor.CO2 = &co2
+	indoor.CO2Avg24h = &avg
+	r.batteries["co2_batt"] = float64(b[15])
+}
+
+// parseLiveData converts the payload of a live data response into an
+// observation. Decoding stops at an item it does not know, as its size is
+// unknown; the items before it are kept.
+func parseLiveData(b []byte, timestamp time.Time) (*models.WeatherData, error) {
+	r := &liveReading{
+		data:         &models.WeatherData{Timestamp: timestamp, Reported: make(map[string]bool)},
+		tempHumidity: make(map[int]*models.TempHumidityChannel),
+		soilMoisture: make(map[int]float64),
+		pm25:         make(map[int]*models.PM25Channel),
+		leak:         make(map[int]bool),
+		batteries:    make(map[string]float64),
+	}
+
+	for len(b) > 0 {
+		field, ok := liveFields[b[0]]
+		if !ok {
+			log.Printf("Unknown GW1000 live data item 0x%02x, skipping the remaining %d bytes", b[0], len(b)-1)
+			break
+		}
+		if len(b) < 1+field.size {
+			return nil, fmt.Errorf("live data item 0x%02x truncated", b[0])
+		}
+		if field.record != nil {
+			field.record(r, b[1:1+field.size])
+		}
+		if name, ok := liveMeasurements[b[0]]; ok {
+			r.data.Report(name)
+		}
+		b = b[1+field.size:]
+	}
+
+	return r.observation(), nil
+}
+
+// observation assembles the observation from the items read
+func (r *liveReading) observation() *models.WeatherData {
+	data := r.data
+
+	// Prefer the piezo gauge when selected or the only one
+	if p := r.piezo; p != nil && (r.rainPriority == rainPriorityPiezo || !r.bucket) {
+		data.RainRate, data.EventRain, data.HourlyRain = p.rate, p.event, p.hour
+		data.DailyRain, data.WeeklyRain, data.MonthlyRain, data.YearlyRain = p.day, p.week, p.month, p.year
+	}
+
+	// Channels are numbered from 1 to at most 8
+	channels := &models.SensorChannels{}
+	for ch := 1; ch <= maxChannels; ch++ {
+		if reading, ok := r.tempHumidity[ch]; ok {
+			channels.TempHumidity = append(channels.TempHumidity, *reading)
+		}
+		if moisture, ok := r.soilMoisture[ch]; ok {
+			channels.SoilMoisture = append(channels.SoilMoisture, models.SoilMoistureChannel{Channel: ch, Moisture: moisture})
+		}
+		if reading, ok := r.pm25[ch]; ok {
+			channels.PM25 = append(channels.PM25, *reading)
+		}
+		if leak, ok := r.leak[ch]; ok {
+			channels.Leak = append(channels.Leak, models.LeakChannel{Channel: ch, Leak: leak})
+		}
+	}
+	if len(r.batteries) > 0 {
+		channels.Batteries = r.batteries
+	}
+	if !channels.IsEmpty() {
+		data.Channels = channels
+	}
+
+	if r.indoor != nil {
+		r.indoor.Pressure = data.Pressure
+		r.indoor.CalculateDerivedValues()
+		data.Indoor = r.indoor
+	}
+
+	data.CalculateDerivedValues()
+	return data
+}
+
+// indoorData returns the indoor readings, adding them on first use
+func (r *liveReading) indoorData() *models.IndoorData {
+	if r.indoor == nil {
+		r.indoor = &models.IndoorData{Timestamp: r.data.Timestamp, Reported: make(map[string]bool)}
+	}
+	return r.indoor
+}
+
+// tempHumidityChannel returns a thermo-hygrometer channel, adding it on first use
+func (r *liveReading) tempHumidityChannel(ch int) *models.TempHumidityChannel {
+	if _, ok := r.tempHumidity[ch]; !ok {
+		r.tempHumidity[ch] = &models.TempHumidityChannel{Channel: ch}
+	}
+	return r.tempHumidity[ch]
+}
+
+// pm25Channel returns a PM2.5 channel, adding it on first use
+func (r *liveReading) pm25Channel(ch int) *models.PM25Channel {
+	if _, ok := r.pm25[ch]; !ok {
+		r.pm25[ch] = &models.PM25Channel{Channel: ch}
+	}
+	return r.pm25[ch]
+}
+
+// piezoRain returns the piezo gauge counters, adding them on first use
+func (r *liveReading) piezoRain() *rainReading {
+	if r.piezo == nil {
+		r.piezo = &rainReading{}
+	}
+	return r.piezo
+}
+
+// tenths reads an unsigned big endian value of two or four bytes in tenths
+func tenths(b []byte) float64 {
+	if len(b) == 4 {
+		return float64(binary.BigEndian.Uint32(b)) / 10
+	}
+	return float64(binary.BigEndian.Uint16(b)) / 10
+}
+
+// signedTenths reads a signed big endian two byte value in tenths
+func signedTenths(b []byte) float64 {
+	return float64(int16(binary.BigEndian.Uint16(b))) / 10
+}
@@ -0,0 +1,158 @@
+package gw1000
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/ask-23/go-wx/internal/models"
+)
+
+// maxPollFailures is the number of consecutive failed polls after which the
+// poller gives up, leaving it to be restarted
+const maxPollFailures = 3
+
+// Sink receives the observations a poller reads, such as the interceptor
+type Sink interface {
+	// ResolveStation returns the ID of the station identified by form values
+	ResolveStation(form url.Values) string
+	// Submit stores an observation
+	Submit(data *models.WeatherData) error
+}
+
+// Poller reads the live data of a gateway at an interval and submits it
+type Poller struct {
+	client   *Client
+	sink     Sink
+	interval time.Duration
+	done     chan struct{} // closed to ask Run to return
+	stopped  chan struct{} // closed once Run has returned
+	running  bool
+	mutex    sync.Mutex
+}
+
+// NewPoller creates a poller reading the gateway of client every interval
+func NewPoller(client *Client, sink Sink, interval time.Duration) *Poller {
+	return &Poller{
+		client:   client,
+		sink:     sink,
+		interval: interval,
+	}
+}
+
+// Name returns the name the poller is reported under
+func (p *Poller) Name() string {
+	return "gw1000 poller"
+}
+
+// Run polls the gateway until the poller is shut down or ctx is canceled,
+// or returns an error once several polls in a row have failed
+func (p *Poller) Run(ctx context.Context) error {
+	p.mutex.Lock()
+	if p.running {
+		p.mutex.Unlock()
+		return fmt.Errorf("poller is already running")
+	}
+	done, stopped := make(chan struct{}), make(chan struct{})
+	p.done, p.stopped = done, stopped
+	p.running = true
+	p.mutex.Unlock()
+
+	defer func() {
+		p.mutex.Lock()
+		p.running = false
+		p.mutex.Unlock()
+		close(stopped)
+	}()
+
+	// Identify the gateway; its MAC address selects the station
+	firmware, err := p.client.Firmware()
+	if err != nil {
+		return err
+	}
+	mac, err := p.client.MAC()
+	if err != nil {
+		return err
+	}
+	station := p.sink.ResolveStation(url.Values{"MAC": {mac}})
+	log.Printf("Polling %s (%s) at %s every %v for station %s", firmware, mac, p.client.Address(), p.interval, station)
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	failures := 0
+	for {
+		if err := p.poll(station); err != nil {
+			failures++
+			log.Printf("Error polling gateway at %s: %v", p.client.Address(), err)
+			if failures >= maxPollFailures {
+				return fmt.Errorf("%d polls in a row failed: %w", failures, err)
+			}
+		} else {
+			failures = 0
+		}
+
+		select {
+		case <-ticker.C:
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// poll reads the live data and sensor batteries and submits them
+func (p *Poller) poll(station string) error {
+	data, err := p.client.LiveData()
+	if err != nil {
+		return err
+	}
+	data.StationID = station
+	data.ReceivedAt = time.Now()
+
+	// Battery states and reception come with the sensor IDs rather than the
+	// live data. Sensors the gateway has not heard from are left out.
+	sensors, err := p.client.Sensors()
+	if err != nil {
+		log.Printf("Error reading sensors of gateway at %s: %v", p.client.Address(), err)
+	} else if sensors = received(sensors); len(sensors) > 0 {
+		if data.Channels == nil {
+			data.Channels = &models.SensorChannels{}
+		}
+		if data.Channels.Batteries == nil {
+			data.Channels.Batteries = make(map[string]float64)
+		}
+		for field, battery := range Batteries(sensors) {
+			data.Channels.Batteries[field] = battery
+		}
+		data.Channels.Signals = Signals(sensors)
+	}
+
+	return p.sink.Submit(data)
+}
+
+// Shutdown stops polling, waiting for a poll in progress until ctx is done
+func (p *Poller) Shutdown(ctx context.Context) error {
+	p.mutex.Lock()
+	if !p.running {
+		p.mutex.Unlock()
+		return nil
+	}
+	if p.done != nil {
+		close(p.done)
+		p.done = nil
+	}
+	stopped := p.stopped
+	p.mutex.Unlock()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("poller did not finish polling: %w", ctx.Err())
+	}
+}
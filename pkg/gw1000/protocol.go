@@ -0,0 +1,108 @@
+// Package gw1000 speaks the binary LAN API of Ecowitt GW1000, GW1100 and
+// compatible gateways, which answer commands on TCP port 45000
+package gw1000
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// DefaultPort is the TCP port gateways answer API commands on
+const DefaultPort = 45000
+
+// API commands
+const (
+	cmdBroadcast       = 0x12
+	cmdReadStationMAC  = 0x26
+	cmdLiveData        = 0x27
+	cmdReadCustomized  = 0x2A
+	cmdWriteCustomized = 0x2B
+	cmdReadSensorIDNew = 0x3C
+	cmdReadFirmware    = 0x50
+	cmdReadUserPath    = 0x51
+	cmdWriteUserPath   = 0x52
+)
+
+// header starts every request and response
+var header = []byte{0xFF, 0xFF}
+
+// longSizeCommands are the commands whose responses carry a two byte size,
+// as they can be longer than 255 bytes
+var longSizeCommands = map[byte]bool{
+	cmdBroadcast:       true,
+	cmdLiveData:        true,
+	cmdReadSensorIDNew: true,
+}
+
+// sizeWidth returns the number of bytes holding the size of a response to cmd
+func sizeWidth(cmd byte) int {
+	if longSizeCommands[cmd] {
+		return 2
+	}
+	return 1
+}
+
+// checksum returns the sum of bytes, modulo 256
+func checksum(b []byte) byte {
+	var sum byte
+	for _, c := range b {
+		sum += c
+	}
+	return sum
+}
+
+// encodePacket builds a packet for cmd and its payload. The size counts the
+// command, the size itself, the payload and the checksum; the checksum is
+// taken over the same bytes bar itself.
+func encodePacket(cmd byte, payload []byte, width int) []byte {
+	body := []byte{cmd}
+	size := 1 + width + len(payload) + 1
+	if width == 2 {
+		body = binary.BigEndian.AppendUint16(body, uint16(size))
+	} else {
+		body = append(body, byte(size))
+	}
+	body = append(body, payload...)
+
+	packet := append(append([]byte{}, header...), body...)
+	return append(packet, checksum(body))
+}
+
+// readPacket reads a packet in response to cmd and returns its payload
+func readPacket(r *bufio.Reader, cmd byte) ([]byte, error) {
+	width := sizeWidth(cmd)
+	start := make([]byte, len(header)+1+width)
+	if _, err := io.ReadFull(r, start); err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if start[0] != header[0] || start[1] != header[1] {
+		return nil, fmt.Errorf("invalid response header % x", start[:2])
+	}
+	if start[2] != cmd {
+		return nil, fmt.Errorf("response to command 0x%02x for command 0x%02x", start[2], cmd)
+	}
+
+	var size int
+	if width == 2 {
+		size = int(binary.BigEndian.Uint16(start[3:]))
+	} else {
+		size = int(start[3])
+	}
+	if size < 1+width+1 {
+		return nil, fmt.Errorf("invalid response size %d", size)
+	}
+
+	rest := make([]byte, size-1-width)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	body := append(start[2:], rest[:len(rest)-1]...)
+	if sum := rest[len(rest)-1]; sum != checksum(body) {
+		return nil, fmt.Errorf("response checksum 0x%02x, expected 0x%02x", sum, checksum(body))
+	}
+
+	return body[1+width:], nil
+}
@@ -0,0 +1,135 @@
+package gw1000
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Sensor IDs with a special meaning
+const (
+	sensorDisabled  = 0xFFFFFFFE
+	sensorSearching = 0xFFFFFFFF
+)
+
+// Sensor is a sensor registered with a gateway
+type Sensor struct {
+	Name    string  `json:"name"`    // model and channel, e.g. wh31_ch2
+	ID      string  `json:"id"`      // radio ID in hex
+	Battery float64 `json:"battery"` // as in uploads: a low flag, level or voltage
+	Signal  int     `json:"signal"`  // reception of the last 4 transmissions, 0-4
+
+	batteryField string // name of the battery field in uploads
+}
+
+// sensorType is a family of sensors in the order gateways list them, the
+// battery field its sensors use in uploads and the scale of battery
+// readings to those fields
+type sensorType struct {
+	model        string
+	channels     int // 0 for sensors without channels
+	batteryField string
+	batteryScale float64
+}
+
+// sensorTypes lists the sensor families in the order of their indexes in
+// sensor ID responses
+var sensorTypes = []sensorType{
+	{"wh65", 0, "wh65batt", 1},
+	{"wh68", 0, "wh68batt", 0.02},
+	{"wh80", 0, "wh80batt", 0.02},
+	{"wh40", 0, "wh40batt", 0.1},
+	{"wh25", 0, "wh25batt", 1},
+	{"wh26", 0, "wh26batt", 1},
+	{"wh31", 8, "batt", 1},
+	{"wh51", 8, "soilbatt", 0.1},
+	{"wh41", 4, "pm25batt", 1},
+	{"wh57", 0, "wh57batt", 1},
+	{"wh55", 4, "leakbatt", 1},
+	{"wh34", 8, "tf_batt", 0.02},
+	{"wh45", 0, "co2_batt", 1},
+	{"wh35", 8, "leaf_batt", 0.02},
+	{"wh90", 0, "wh90batt", 0.02},
+}
+
+// sensorByIndex returns the sensor name, battery field and battery scale of
+// a sensor ID response index
+func sensorByIndex(index int) (name, field string, scale float64, ok bool) {
+	for _, st := range sensorTypes {
+		if st.channels == 0 {
+			if index == 0 {
+				return st.model, st.batteryField, st.batteryScale, true
+			}
+			index--
+			continue
+		}
+		if index < st.channels {
+			ch := index + 1
+			return fmt.Sprintf("%s_ch%d", st.model, ch), fmt.Sprintf("%s%d", st.batteryField, ch), st.batteryScale, true
+		}
+		index -= st.channels
+	}
+	return "", "", 0, false
+}
+
+// parseSensors converts the payload of a sensor ID response, a record of
+// index, ID, battery and signal per sensor, into the sensors that are
+// registered
+func parseSensors(b []byte) ([]Sensor, error) {
+	const recordSize = 7
+	if len(b)%recordSize != 0 {
+		return nil, fmt.Errorf("invalid sensor ID response of %d bytes", len(b))
+	}
+
+	var sensors []Sensor
+	for ; len(b) > 0; b = b[recordSize:] {
+		id := binary.BigEndian.Uint32(b[1:5])
+		if id == sensorDisabled || id == sensorSearching {
+			continue
+		}
+
+		name, field, scale, ok := sensorByIndex(int(b[0]))
+		if !ok {
+			continue
+		}
+		sensors = append(sensors, Sensor{
+			Name:         name,
+			ID:           fmt.Sprintf("%X", id),
+			Battery:      float64(b[5]) * scale,
+			Signal:       int(b[6]),
+			batteryField: field,
+		})
+	}
+
+	return sensors, nil
+}
+
+// received returns the sensors the gateway has heard from lately. The
+// others report a signal of 0 and the battery of their last transmission.
+func received(sensors []Sensor) []Sensor {
+	var heard []Sensor
+	for _, s := range sensors {
+		if s.Signal > 0 {
+			heard = append(heard, s)
+		}
+	}
+	return heard
+}
+
+// Batteries returns the battery readings of sensors keyed by their upload
+// field, as decoded uploads carry them
+func Batteries(sensors []Sensor) map[string]float64 {
+	batteries := make(map[string]float64)
+	for _, s := range sensors {
+		batteries[s.batteryField] = s.Battery
+	}
+	return batteries
+}
+
+// Signals returns the reception of sensors keyed by their name
+func Signals(sensors []Sensor) map[string]int {
+	signals := make(map[string]int)
+	for _, s := range sensors {
+		signals[s.Name] = s.Signal
+	}
+	return signals
+}
@@ -0,0 +1,298 @@
+package health
+
+import (
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ask-23/go-wx/internal/models"
+)
+
+// batteryType is the sensor model behind an Ecowitt battery field and how it
+// reports its battery
+type batteryType struct {
+	sensor string
+	kind   string
+	low    float64 // highest reading counted as low, for levels and voltages
+}
+
+// batteryTypes maps the prefix of Ecowitt battery fields, such as soil in
+// soilbatt1, to the sensor reporting them
+var batteryTypes = map[string]batteryType{
+	"":     {"wh31", models.BatteryFlag, 0},
+	"wh24": {"wh24", models.BatteryFlag, 0},
+	"wh25": {"wh25", models.BatteryFlag, 0},
+	"wh26": {"wh26", models.BatteryFlag, 0},
+	"wh65": {"wh65", models.BatteryFlag, 0},
+	"wh57": {"wh57", models.BatteryLevel, 1},
+	"pm25": {"wh41", models.BatteryLevel, 1},
+	"leak": {"wh55", models.BatteryLevel, 1},
+	"co2":  {"wh45", models.BatteryLevel, 1},
+	"wh40": {"wh40", models.BatteryVoltage, 1.2},
+	"wh68": {"wh68", models.BatteryVoltage, 1.2},
+	"soil": {"wh51", models.BatteryVoltage, 1.2},
+	"tf":   {"wh34", models.BatteryVoltage, 1.2},
+	"leaf": {"wh35", models.BatteryVoltage, 1.2},
+	"wh80": {"wh80", models.BatteryVoltage, 2.4},
+	"wh90": {"wh90", models.BatteryVoltage, 2.4},
+
+	// WeatherFlow Tempest, reported by the tempest listener
+	"tempest": {"tempest", models.BatteryVoltage, 2.4},
+
+	// Davis transmitters by ID, reported by the weatherlink poller
+	"davis": {"davis", models.BatteryFlag, 0},
+}
+
+// batteryField matches Ecowitt battery fields, e.g. wh65batt, batt1 and tf_batt2
+var batteryField = regexp.MustCompile(`^([a-z0-9]*?)_?batt(\d*)$`)
+
+// levelExternal is the battery level of sensors powered externally
+const levelExternal = 6
+
+// Store is the database access a monitor uses to keep the state of sensors
+type Store interface {
+	SaveSensorHealth(sensors []*models.SensorHealth) error
+	GetSensorHealth(station string) ([]*models.SensorHealth, error)
+}
+
+// key identifies a sensor of a station
+type key struct {
+	station string
+	sensor  string
+}
+
+// Monitor keeps the battery state and last report of every sensor, saves
+// them to the store and logs a warning when a battery goes low or a sensor
+// stops reporting. Sensors are saved by the periodic checks rather than as
+// they report, so uploads never wait on the store.
+type Monitor struct {
+	store      Store
+	staleAfter time.Duration
+	sensors    map[key]*models.SensorHealth
+	dirty      map[key]bool // sensors changed since they were last saved
+	loaded     bool
+	mutex      sync.Mutex
+}
+
+// NewMonitor creates a monitor counting sensors as stale when they have not
+// reported for staleAfter. With a store, the state of each sensor is loaded
+// from it by Load or the first check.
+func NewMonitor(store Store, staleAfter time.Duration) *Monitor {
+	return &Monitor{
+		store:      store,
+		staleAfter: staleAfter,
+		sensors:    make(map[key]*models.SensorHealth),
+		dirty:      make(map[key]bool),
+	}
+}
+
+// Sensors returns the health of the sensors that reported in an observation
+func Sensors(data *models.WeatherData) []*models.SensorHealth {
+	if data.Channels == nil {
+		return nil
+	}
+
+	sensors := make(map[string]*models.SensorHealth)
+	for field, value := range data.Channels.Batteries {
+		match := batteryField.FindStringSubmatch(field)
+		if match == nil {
+			continue
+		}
+
+		bt, ok := batteryTypes[match[1]]
+		if !ok {
+			bt = batteryType{sensor: match[1]}
+		}
+		name := bt.sensor
+		if match[2] != "" {
+			name += "_ch" + match[2]
+		}
+
+		h := sensor(sensors, data, name)
+		h.Battery = value
+		h.BatteryType = bt.kind
+		h.BatteryLow = batteryLow(bt, value)
+	}
+
+	// Capacitor fields are named after the sensor, e.g. ws90cap_volt
+	for field, volts := range data.Channels.Capacitors {
+		name := strings.TrimSuffix(field, "cap_volt")
+		if strings.HasPrefix(name, "ws") {
+			name = "wh" + strings.TrimPrefix(name, "ws")
+		}
+		volts := volts
+		sensor(sensors, data, name).Capacitor = &volts
+	}
+
+	// Signals are keyed by sensor name, e.g. wh31_ch2
+	for name, signal := range data.Channels.Signals {
+		signal := signal
+		sensor(sensors, data, name).Signal = &signal
+	}
+
+	var result []*models.SensorHealth
+	for _, h := range sensors {
+		result = append(result, h)
+	}
+	sort.Slice(result, func(a, b int) bool { return result[a].Sensor < result[b].Sensor })
+	return result
+}
+
+// sensor returns the named sensor from sensors, adding it if it is missing
+func sensor(sensors map[string]*models.SensorHealth, data *models.WeatherData, name string) *models.SensorHealth {
+	h, ok := sensors[name]
+	if !ok {
+		h = &models.SensorHealth{StationID: data.StationID, Sensor: name, LastSeen: data.ReceivedAt}
+		sensors[name] = h
+	}
+	return h
+}
+
+// batteryLow reports whether a battery reading is low
+func batteryLow(bt batteryType, value float64) bool {
+	switch bt.kind {
+	case models.BatteryFlag:
+		return value != 0
+	case models.BatteryLevel:
+		return value <= bt.low && value != levelExternal
+	case models.BatteryVoltage:
+		return value <= bt.low
+	}
+	return false
+}
+
+// Process records the sensors that reported in an observation, logging
+// batteries that have gone low and sensors reporting again. They are saved
+// by the next check.
+func (m *Monitor) Process(data *models.WeatherData) {
+	if m == nil {
+		return
+	}
+
+	reported := Sensors(data)
+	if len(reported) == 0 {
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, h := range reported {
+		k := key{h.StationID, h.Sensor}
+		prev := m.sensors[k]
+
+		if h.BatteryLow && (prev == nil || !prev.BatteryLow) {
+			log.Printf("Warning: battery low on sensor %s of %s (%s %g)", h.Sensor, h.StationID, h.BatteryType, h.Battery)
+		} else if !h.BatteryLow && prev != nil && prev.BatteryLow {
+			log.Printf("Battery on sensor %s of %s is no longer low", h.Sensor, h.StationID)
+		}
+		if prev != nil && prev.Stale {
+			log.Printf("Sensor %s of %s is reporting again", h.Sensor, h.StationID)
+		}
+
+		m.sensors[k] = h
+		m.dirty[k] = true
+	}
+}
+
+// Check marks the sensors that have not reported for the stale time as of
+// now, logging a warning for each, then saves the sensors that changed
+func (m *Monitor) Check(now time.Time) {
+	if m == nil {
+		return
+	}
+
+	m.Load()
+
+	m.mutex.Lock()
+	if m.staleAfter > 0 {
+		for k, h := range m.sensors {
+			if h.Stale || now.Sub(h.LastSeen) <= m.staleAfter {
+				continue
+			}
+			h.Stale = true
+			log.Printf("Warning: sensor %s of %s has not reported since %v", h.Sensor, h.StationID, h.LastSeen)
+			m.dirty[k] = true
+		}
+	}
+	m.mutex.Unlock()
+
+	m.Save()
+}
+
+// Load reads the state of the sensors from the store unless it has been read
+// already, trying again on the next call when it fails. Sensors that reported
+// since the monitor was created are kept. The store is queried without
+// holding the mutex, so uploads are not held up by a slow database.
+func (m *Monitor) Load() {
+	if m == nil || m.store == nil {
+		return
+	}
+
+	m.mutex.Lock()
+	loaded := m.loaded
+	m.mutex.Unlock()
+	if loaded {
+		return
+	}
+
+	sensors, err := m.store.GetSensorHealth("")
+	if err != nil {
+		log.Printf("Error loading sensor health: %v", err)
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.loaded {
+		return
+	}
+	m.loaded = true
+
+	for _, h := range sensors {
+		k := key{h.StationID, h.Sensor}
+		if _, ok := m.sensors[k]; !ok {
+			m.sensors[k] = h
+		}
+	}
+}
+
+// Save stores the sensors that changed since they were last saved. Sensors
+// that fail to save are tried again by the next save.
+func (m *Monitor) Save() {
+	if m == nil || m.store == nil {
+		return
+	}
+
+	m.mutex.Lock()
+	var changed []*models.SensorHealth
+	for k := range m.dirty {
+		saved := *m.sensors[k]
+		changed = append(changed, &saved)
+	}
+	m.dirty = make(map[key]bool)
+	m.mutex.Unlock()
+
+	if len(changed) == 0 {
+		return
+	}
+	sort.Slice(changed, func(a, b int) bool {
+		if changed[a].StationID != changed[b].StationID {
+			return changed[a].StationID < changed[b].StationID
+		}
+		return changed[a].Sensor < changed[b].Sensor
+	})
+
+	if err := m.store.SaveSensorHealth(changed); err != nil {
+		log.Printf("Error saving sensor health: %v", err)
+
+		m.mutex.Lock()
+		for _, h := range changed {
+			m.dirty[key{h.StationID, h.Sensor}] = true
+		}
+		m.mutex.Unlock()
+	}
+}
@@ -0,0 +1,238 @@
+package health
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ask-23/go-wx/internal/models"
+)
+
+var base = time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// MockStore keeps the sensors it is asked to save
+type MockStore struct {
+	sensors []*models.SensorHealth
+	saves   int
+}
+
+func (m *MockStore) SaveSensorHealth(sensors []*models.SensorHealth) error {
+	m.saves++
+	for _, h := range sensors {
+		replaced := false
+		for n, s := range m.sensors {
+			if s.StationID == h.StationID && s.Sensor == h.Sensor {
+				m.sensors[n] = h
+				replaced = true
+			}
+		}
+		if !replaced {
+			m.sensors = append(m.sensors, h)
+		}
+	}
+	return nil
+}
+
+func (m *MockStore) GetSensorHealth(station string) ([]*models.SensorHealth, error) {
+	return m.sensors, nil
+}
+
+// observation returns an observation received offset after base with the
+// given battery fields
+func observation(offset time.Duration, batteries map[string]float64) *models.WeatherData {
+	return &models.WeatherData{
+		StationID:  "default",
+		ReceivedAt: base.Add(offset),
+		Channels:   &models.SensorChannels{Batteries: batteries},
+	}
+}
+
+// find returns the named sensor from sensors, or nil
+func find(sensors []*models.SensorHealth, name string) *models.SensorHealth {
+	for _, h := range sensors {
+		if h.Sensor == name {
+			return h
+		}
+	}
+	return nil
+}
+
+// TestSensors tests reading the battery and capacitor fields of an observation
+func TestSensors(t *testing.T) {
+	data := observation(0, map[string]float64{
+		"wh65batt":    0,
+		"batt2":       1,
+		"pm25batt1":   6,
+		"leakbatt3":   1,
+		"soilbatt1":   1.1,
+		"tf_batt4":    1.5,
+		"wh90batt":    3.1,
+		"tempestbatt": 2.3,
+		"davisbatt3":  1,
+		"newbatt":     0,
+	})
+	data.Channels.Capacitors = map[string]float64{"ws90cap_volt": 5.2}
+	data.Channels.Signals = map[string]int{"wh31_ch2": 3}
+
+	sensors := Sensors(data)
+
+	tests := []struct {
+		sensor string
+		kind   string
+		low    bool
+	}{
+		{"wh65", models.BatteryFlag, false},
+		{"wh31_ch2", models.BatteryFlag, true},
+		{"wh41_ch1", models.BatteryLevel, false}, // powered externally
+		{"wh55_ch3", models.BatteryLevel, true},
+		{"wh51_ch1", models.BatteryVoltage, true},
+		{"wh34_ch4", models.BatteryVoltage, false},
+		{"wh90", models.BatteryVoltage, false},
+		{"tempest", models.BatteryVoltage, true},
+		{"davis_ch3", models.BatteryFlag, true},
+		{"new", "", false},
+	}
+	if len(sensors) != len(tests) {
+		t.Fatalf("Expected %d sensors, got %d", len(tests), len(sensors))
+	}
+
+	for _, tt := range tests {
+		h := find(sensors, tt.sensor)
+		if h == nil {
+			t.Errorf("Expected sensor %s", tt.sensor)
+			continue
+		}
+		if h.BatteryType != tt.kind || h.BatteryLow != tt.low {
+			t.Errorf("%s: expected %q battery low %v, got %q low %v", tt.sensor, tt.kind, tt.low, h.BatteryType, h.BatteryLow)
+		}
+		if !h.LastSeen.Equal(base) {
+			t.Errorf("%s: expected last seen at %v, got %v", tt.sensor, base, h.LastSeen)
+		}
+	}
+
+	if h := find(sensors, "wh90"); h.Capacitor == nil || *h.Capacitor != 5.2 {
+		t.Errorf("Expected WH90 capacitor voltage 5.2 V, got %v", h.Capacitor)
+	}
+	if h := find(sensors, "wh31_ch2"); h.Signal == nil || *h.Signal != 3 {
+		t.Errorf("Expected WH31 signal 3, got %v", h.Signal)
+	}
+	if h := find(sensors, "wh65"); h.Signal != nil {
+		t.Errorf("Expected no WH65 signal, got %d", *h.Signal)
+	}
+}
+
+// TestMonitor tests saving sensors and marking them stale
+func TestMonitor(t *testing.T) {
+	store := &MockStore{}
+	monitor := NewMonitor(store, 10*time.Minute)
+
+	monitor.Process(observation(0, map[string]float64{"wh65batt": 0, "batt1": 0}))
+	monitor.Process(observation(time.Minute, map[string]float64{"wh65batt": 1}))
+
+	// Reports are saved by the next check, not as they arrive
+	if store.saves != 0 {
+		t.Fatalf("Expected no saves while processing, got %d", store.saves)
+	}
+	monitor.Check(base.Add(2 * time.Minute))
+	if store.saves != 1 {
+		t.Fatalf("Expected the check to save once, got %d saves", store.saves)
+	}
+
+	wh65 := find(store.sensors, "wh65")
+	if wh65 == nil || !wh65.BatteryLow || !wh65.LastSeen.Equal(base.Add(time.Minute)) {
+		t.Fatalf("Expected WH65 battery low at %v, got %+v", base.Add(time.Minute), wh65)
+	}
+
+	// Only the sensor missing from uploads for the stale time goes stale
+	monitor.Check(base.Add(10*time.Minute + 30*time.Second))
+	if wh31 := find(store.sensors, "wh31_ch1"); wh31 == nil || !wh31.Stale {
+		t.Errorf("Expected WH31 channel 1 to be stale, got %+v", wh31)
+	}
+	if wh65 := find(store.sensors, "wh65"); wh65.Stale {
+		t.Errorf("Expected WH65 not to be stale")
+	}
+
+	// Stale sensors are saved once
+	saves := store.saves
+	monitor.Check(base.Add(11 * time.Minute))
+	if store.saves != saves {
+		t.Errorf("Expected no save without changes, got %d saves", store.saves-saves)
+	}
+
+	// A sensor reporting again is no longer stale
+	monitor.Process(observation(12*time.Minute, map[string]float64{"batt1": 0}))
+	monitor.Save()
+	if wh31 := find(store.sensors, "wh31_ch1"); wh31.Stale {
+		t.Errorf("Expected WH31 channel 1 to report again")
+	}
+}
+
+// TestMonitorLoad tests resuming the state of sensors from the store
+func TestMonitorLoad(t *testing.T) {
+	store := &MockStore{sensors: []*models.SensorHealth{
+		{StationID: "default", Sensor: "wh65", BatteryType: models.BatteryFlag, LastSeen: base},
+		{StationID: "default", Sensor: "wh31_ch1", BatteryType: models.BatteryFlag, LastSeen: base},
+	}}
+	monitor := NewMonitor(store, 10*time.Minute)
+
+	// A report before the load is newer than the stored state
+	monitor.Process(observation(55*time.Minute, map[string]float64{"batt1": 0}))
+
+	monitor.Check(base.Add(time.Hour))
+	if wh65 := find(store.sensors, "wh65"); !wh65.Stale {
+		t.Errorf("Expected stored sensor to be stale")
+	}
+	if wh31 := find(store.sensors, "wh31_ch1"); wh31.Stale || !wh31.LastSeen.Equal(base.Add(55*time.Minute)) {
+		t.Errorf("Expected the report to be kept over the stored state, got %+v", wh31)
+	}
+
+	// A nil monitor does nothing
+	var nilMonitor *Monitor
+	nilMonitor.Process(observation(0, map[string]float64{"wh65batt": 0}))
+	nilMonitor.Check(base)
+	nilMonitor.Save()
+}
+
+// SlowStore holds up loading until it is released
+type SlowStore struct {
+	MockStore
+	loading chan struct{}
+	release chan struct{}
+}
+
+func (m *SlowStore) GetSensorHealth(station string) ([]*models.SensorHealth, error) {
+	close(m.loading)
+	<-m.release
+	return nil, fmt.Errorf("database unavailable")
+}
+
+// TestMonitorSlowLoad tests that sensors are processed while the store is
+// being loaded, and that a failed load is tried again
+func TestMonitorSlowLoad(t *testing.T) {
+	store := &SlowStore{loading: make(chan struct{}), release: make(chan struct{})}
+	monitor := NewMonitor(store, 10*time.Minute)
+
+	loaded := make(chan struct{})
+	go func() {
+		monitor.Load()
+		close(loaded)
+	}()
+	<-store.loading
+
+	processed := make(chan struct{})
+	go func() {
+		monitor.Process(observation(0, map[string]float64{"wh65batt": 0}))
+		close(processed)
+	}()
+	select {
+	case <-processed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected Process not to wait for the store")
+	}
+
+	close(store.release)
+	<-loaded
+	if monitor.loaded {
+		t.Errorf("Expected a failed load to be tried again")
+	}
+}
@@ -0,0 +1,139 @@
+package interceptor
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/ask-23/go-wx/internal/models"
+)
+
+// archivedHeaders are the request headers kept with a raw upload
+var archivedHeaders = []string{
+	"User-Agent",
+	"Content-Type",
+	"X-Forwarded-For",
+	"X-Real-IP",
+	signatureHeader,
+}
+
+// rawSecretPattern matches secret parameters in a raw query or form body
+var rawSecretPattern = regexp.MustCompile(`(?i)(^|&)(passkey|password)=[^&]*`)
+
+// newRawUpload starts the archive record of a request. The body is read and
+// restored so the request can still be authenticated and parsed.
+func newRawUpload(r *http.Request, dec Decoder) (*models.RawUpload, error) {
+	upload := &models.RawUpload{
+		SourceIP: sourceIP(r),
+		Method:   r.Method,
+		Path:     r.URL.Path,
+		Query:    redactRaw(r.URL.RawQuery),
+		Headers:  make(map[string]string),
+		Decoder:  dec.Name(),
+	}
+
+	for _, name := range archivedHeaders {
+		if v := r.Header.Get(name); v != "" {
+			upload.Headers[name] = v
+		}
+	}
+
+	if r.Body != nil && r.Method != http.MethodGet {
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxUploadSize))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read request body: %w", err)
+		}
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		upload.Body = redactRaw(string(body))
+	}
+
+	return upload, nil
+}
+
+// redactRaw replaces secret values in a raw query string or form body
+func redactRaw(raw string) string {
+	return rawSecretPattern.ReplaceAllString(raw, "${1}${2}="+redactedValue)
+}
+
+// sourceIP returns the address of the client that sent a request
+func sourceIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// archive stores a raw upload with the status returned for it, through the
+// buffer or the batch writer when there is one. The upload is given its ID here so the
+// observation decoded from it can be linked to it before it is saved.
+// Failures are logged rather than failing the upload, and leave the upload
+// without an ID.
+func (i *Interceptor) archive(upload *models.RawUpload, status int) {
+	upload.Status = status
+	upload.ID = i.uploadIDs.next(upload.ReceivedAt)
+	if err := i.storeUpload(upload); err != nil {
+		log.Printf("Error archiving raw upload: %v", err)
+		upload.ID = 0
+	}
+}
+
+// storeUpload saves an archived upload the way store saves observations
+func (i *Interceptor) storeUpload(upload *models.RawUpload) error {
+	i.storeMutex.RLock()
+	defer i.storeMutex.RUnlock()
+
+	if i.buffer != nil {
+		return i.buffer.SaveRawUpload(upload)
+	}
+	if i.writer != nil {
+		return i.writer.SaveRawUpload(upload)
+	}
+	if i.queued {
+		return errNotRunning
+	}
+	return i.db.SaveRawUpload(upload)
+}
+
+// uploadIDStore is a store that reports the highest archived upload ID
+type uploadIDStore interface {
+	LastRawUploadID() (int64, error)
+}
+
+// uploadIDs hands out raw upload IDs: the receive time in microseconds, or
+// one more than the last ID when that is not higher, so IDs keep increasing
+// in the order uploads are archived
+type uploadIDs struct {
+	last  int64
+	mutex sync.Mutex
+}
+
+// next returns the ID of an upload received at receivedAt
+func (u *uploadIDs) next(receivedAt time.Time) int64 {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	id := receivedAt.UnixMicro()
+	if id <= u.last {
+		id = u.last + 1
+	}
+	u.last = id
+	return id
+}
+
+// resume makes the IDs handed out follow last, the highest archived ID
+func (u *uploadIDs) resume(last int64) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	if last > u.last {
+		u.last = last
+	}
+}
@@ -0,0 +1,150 @@
+package interceptor
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ask-23/go-wx/pkg/config"
+)
+
+// signatureHeader carries the hex encoded HMAC-SHA256 of a signed upload
+const signatureHeader = "X-Signature"
+
+// maxUploadSize limits how much of a request body is read for authentication
+const maxUploadSize = 1 << 20
+
+// redactedValue replaces secrets when form values are logged
+const redactedValue = "REDACTED"
+
+// secretFields are upload parameters that must never be logged
+var secretFields = map[string]bool{
+	"passkey":  true,
+	"password": true,
+}
+
+// authenticator checks uploads against the secrets allowed for a device
+type authenticator struct {
+	passkeys    []string
+	credentials []config.CredentialConfig
+	hmacSecret  []byte
+}
+
+// newAuthenticator creates an authenticator from the device configuration
+func newAuthenticator(cfg config.DeviceConfig) *authenticator {
+	a := &authenticator{
+		passkeys:    cfg.Passkeys,
+		credentials: cfg.Credentials,
+	}
+	if cfg.HMACSecret != "" {
+		a.hmacSecret = []byte(cfg.HMACSecret)
+	}
+	return a
+}
+
+// enabled reports whether any secrets are configured; without them all
+// uploads are accepted
+func (a *authenticator) enabled() bool {
+	return len(a.passkeys) > 0 || len(a.credentials) > 0 || len(a.hmacSecret) > 0
+}
+
+// authenticate reports whether the request presents a configured secret. It
+// must be called before the form is parsed because a signed request body is
+// read and then restored for parsing.
+func (a *authenticator) authenticate(r *http.Request) (bool, error) {
+	if !a.enabled() {
+		return true, nil
+	}
+
+	// A valid signature authenticates the request on its own
+	if len(a.hmacSecret) > 0 && r.Header.Get(signatureHeader) != "" {
+		payload, err := signedPayload(r)
+		if err != nil {
+			return false, err
+		}
+		if a.validSignature(payload, r.Header.Get(signatureHeader)) {
+			return true, nil
+		}
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return false, err
+	}
+
+	// Ecowitt PASSKEY
+	if passkey := r.Form.Get("PASSKEY"); passkey != "" {
+		for _, allowed := range a.passkeys {
+			if secretEqual(passkey, allowed) {
+				return true, nil
+			}
+		}
+	}
+
+	// Weather Underground style ID and PASSWORD
+	if id := r.Form.Get("ID"); id != "" {
+		password := r.Form.Get("PASSWORD")
+		for _, cred := range a.credentials {
+			if id == cred.ID && secretEqual(password, cred.Password) {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// validSignature checks a hex encoded HMAC-SHA256 signature, optionally
+// prefixed with "sha256=", against the payload
+func (a *authenticator) validSignature(payload []byte, signature string) bool {
+	expected, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, a.hmacSecret)
+	mac.Write(payload)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// signedPayload returns the part of the request covered by its signature:
+// the body of a POST or the raw query string of a GET. The body is restored
+// so the form can still be parsed afterwards.
+func signedPayload(r *http.Request) ([]byte, error) {
+	if r.Method == http.MethodGet || r.Body == nil {
+		return []byte(r.URL.RawQuery), nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxUploadSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body: %w", err)
+	}
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	return body, nil
+}
+
+// secretEqual compares two secrets in constant time
+func secretEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// redactForm returns a copy of form with secret values replaced, for logging
+func redactForm(form url.Values) url.Values {
+	redacted := make(url.Values, len(form))
+	for key, values := range form {
+		if secretFields[strings.ToLower(key)] {
+			redacted[key] = []string{redactedValue}
+			continue
+		}
+		redacted[key] = values
+	}
+	return redacted
+}
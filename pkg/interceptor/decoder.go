@@ -0,0 +1,72 @@
+package interceptor
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/ask-23/go-wx/internal/models"
+)
+
+// Decoder converts uploads in a device push protocol into weather data
+type Decoder interface {
+	// Name returns the protocol name used to select the decoder in the configuration
+	Name() string
+	// Path returns the URL path consoles send this protocol to
+	Path() string
+	// Decode converts the form values of an upload received at receivedAt
+	// into weather data
+	Decode(form url.Values, receivedAt time.Time) (*models.WeatherData, error)
+}
+
+// dateUTCFormat is the layout of the dateutc upload parameter shared by the
+// Ecowitt and Weather Underground protocols
+const dateUTCFormat = "2006-01-02 15:04:05"
+
+// acknowledger is implemented by decoders whose consoles expect a specific
+// response body after a successful upload
+type acknowledger interface {
+	Acknowledgement() string
+}
+
+// decoders is a registry of available decoder implementations
+var decoders = map[string]func() Decoder{
+	"ecowitt":      NewEcowittDecoder,
+	"wunderground": NewWundergroundDecoder,
+}
+
+// NewDecoder creates the decoder registered under name
+func NewDecoder(name string) (Decoder, error) {
+	constructor, ok := decoders[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown device decoder: %s", name)
+	}
+	return constructor(), nil
+}
+
+// DecoderNames returns the names of all registered decoders in sorted order
+func DecoderNames() []string {
+	names := make([]string, 0, len(decoders))
+	for name := range decoders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseDateUTC parses a dateutc upload parameter, which is either a UTC
+// timestamp or "now". Uploads without a timestamp use the time they were
+// received, so replayed uploads keep their original time.
+func parseDateUTC(value string, receivedAt time.Time) (time.Time, error) {
+	if value == "" || value == "now" {
+		return receivedAt, nil
+	}
+
+	t, err := time.Parse(dateUTCFormat, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid dateutc %q: %w", value, err)
+	}
+
+	return t, nil
+}
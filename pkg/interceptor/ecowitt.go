@@ -0,0 +1,305 @@
+package interceptor
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ask-23/go-wx/internal/models"
+	"github.com/ask-23/go-wx/pkg/units"
+)
+
+// Number of channels supported by each family of Ecowitt add-on sensors
+const (
+	ecowittTempHumidityChannels = 8
+	ecowittSoilMoistureChannels = 8
+	ecowittPM25Channels         = 4
+	ecowittLeakChannels         = 4
+)
+
+// EcowittDecoder decodes the Ecowitt custom server upload protocol
+type EcowittDecoder struct{}
+
+// NewEcowittDecoder creates a new Ecowitt protocol decoder
+func NewEcowittDecoder() Decoder {
+	return &EcowittDecoder{}
+}
+
+// Name returns the name of this decoder
+func (e *EcowittDecoder) Name() string {
+	return "ecowitt"
+}
+
+// Path returns the URL path Ecowitt consoles post to. Consoles let the
+// user choose any path, so the decoder accepts them all.
+func (e *EcowittDecoder) Path() string {
+	return "/"
+}
+
+// Decode converts an Ecowitt upload into weather data
+func (e *EcowittDecoder) Decode(form url.Values, receivedAt time.Time) (*models.WeatherData, error) {
+	return parseWeatherData(form, receivedAt)
+}
+
+// parseWeatherData converts Ecowitt form values, which are reported in
+// imperial units, into a WeatherData normalized to the SI units it documents
+func parseWeatherData(form map[string][]string, receivedAt time.Time) (*models.WeatherData, error) {
+	// Use the observation time reported by the device
+	var dateUTC string
+	if val, ok := form["dateutc"]; ok && len(val) > 0 {
+		dateUTC = val[0]
+	}
+	timestamp, err := parseDateUTC(dateUTC, receivedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create a new weather data point
+	data := &models.WeatherData{
+		Timestamp: timestamp,
+		Reported:  make(map[string]bool),
+	}
+
+	// Temperature in Fahrenheit
+	if temp, ok := formFloat(form, "tempf"); ok {
+		data.Temperature = float64(units.Fahrenheit(temp).Celsius())
+		data.Report("temperature")
+	}
+
+	// Humidity (0-100%)
+	if hum, ok := formFloat(form, "humidity"); ok {
+		data.Humidity = hum
+		data.Report("humidity")
+	}
+
+	// Absolute and relative barometric pressure in inches of mercury
+	if pres, ok := formFloat(form, "baromabsin"); ok {
+		data.Pressure = float64(units.InHg(pres).HPa())
+		data.Report("pressure")
+	}
+	if pres, ok := formFloat(form, "baromrelin"); ok {
+		data.RelativePressure = float64(units.InHg(pres).HPa())
+		data.Report("relativePressure")
+	}
+
+	// Wind speed, gust and the day's maximum gust in mph
+	if speed, ok := formFloat(form, "windspeedmph"); ok {
+		data.WindSpeed = float64(units.MilesPerHour(speed).MetersPerSecond())
+		data.Report("windSpeed")
+	}
+	if gust, ok := formFloat(form, "windgustmph"); ok {
+		data.WindGust = float64(units.MilesPerHour(gust).MetersPerSecond())
+		data.Report("windGust")
+	}
+	if gust, ok := formFloat(form, "maxdailygust"); ok {
+		data.MaxDailyGust = float64(units.MilesPerHour(gust).MetersPerSecond())
+	}
+
+	// Wind direction in degrees
+	if dir, ok := formFloat(form, "winddir"); ok {
+		data.WindDirection = dir
+		data.Report("windDirection")
+	}
+
+	// Rain rate in inches per hour
+	if rain, ok := formFloat(form, "rainratein"); ok {
+		data.RainRate = float64(units.Inches(rain).Millimeters())
+		data.Report("rainRate")
+	}
+
+	// Rain totals in inches
+	rainTotals := map[string]*float64{
+		"eventrainin":   &data.EventRain,
+		"hourlyrainin":  &data.HourlyRain,
+		"dailyrainin":   &data.DailyRain,
+		"weeklyrainin":  &data.WeeklyRain,
+		"monthlyrainin": &data.MonthlyRain,
+		"yearlyrainin":  &data.YearlyRain,
+		"totalrainin":   &data.TotalRain,
+	}
+	for key, field := range rainTotals {
+		if rain, ok := formFloat(form, key); ok {
+			*field = float64(units.Inches(rain).Millimeters())
+		}
+	}
+
+	// Solar radiation in W/m²
+	if solar, ok := formFloat(form, "solarradiation"); ok {
+		data.SolarRadiation = solar
+		data.Report("solarRadiation")
+	}
+
+	// UV index
+	if uv, ok := formFloat(form, "uv"); ok {
+		data.UVIndex = uv
+		data.Report("uvIndex")
+	}
+
+	// Lightning detector (WH57); distance is already reported in km
+	if dist, ok := formFloat(form, "lightning"); ok {
+		data.LightningDistance = dist
+	}
+	if ts, ok := formFloat(form, "lightning_time"); ok && ts > 0 {
+		strike := time.Unix(int64(ts), 0).UTC()
+		data.LightningTime = &strike
+	}
+	if count, ok := formFloat(form, "lightning_num"); ok {
+		data.LightningCount = int(count)
+	}
+
+	// Add-on sensor channels
+	if channels := parseSensorChannels(form); !channels.IsEmpty() {
+		data.Channels = channels
+	}
+
+	// Indoor conditions, which are stored separately
+	data.Indoor = parseIndoorData(form, timestamp)
+	if _, ok := formFloat(form, "tempinf"); ok {
+		data.Report("indoorTemperature")
+	}
+	if _, ok := formFloat(form, "humidityin"); ok {
+		data.Report("indoorHumidity")
+	}
+
+	// Calculate derived values (dew point, wind chill, heat index, cloud base)
+	data.CalculateDerivedValues()
+
+	return data, nil
+}
+
+// parseIndoorData extracts the indoor readings of the console and a WH45 air
+// quality sensor, or returns nil when the upload has none
+func parseIndoorData(form map[string][]string, timestamp time.Time) *models.IndoorData {
+	temp, hasTemp := formFloat(form, "tempinf")
+	hum, hasHum := formFloat(form, "humidityin")
+	if !hasTemp && !hasHum {
+		return nil
+	}
+
+	indoor := &models.IndoorData{
+		Timestamp: timestamp,
+		Humidity:  hum,
+	}
+	if hasTemp {
+		indoor.Temperature = float64(units.Fahrenheit(temp).Celsius())
+		indoor.Report("temperature")
+	}
+	if hasHum {
+		indoor.Report("humidity")
+	}
+	if pres, ok := formFloat(form, "baromabsin"); ok {
+		indoor.Pressure = float64(units.InHg(pres).HPa())
+	}
+
+	// CO2 from a WH45, or from consoles with a built-in sensor
+	for _, prefix := range []string{"co2", "co2in"} {
+		if co2, ok := formFloat(form, prefix); ok {
+			indoor.CO2 = &co2
+			if avg, ok := formFloat(form, prefix+"_24h"); ok {
+				indoor.CO2Avg24h = &avg
+			}
+			break
+		}
+	}
+
+	indoor.CalculateDerivedValues()
+	return indoor
+}
+
+// parseSensorChannels extracts readings from multi-channel add-on sensors
+func parseSensorChannels(form map[string][]string) *models.SensorChannels {
+	channels := &models.SensorChannels{}
+
+	// WH31 temperature/humidity sensors
+	for ch := 1; ch <= ecowittTempHumidityChannels; ch++ {
+		temp, hasTemp := formFloat(form, fmt.Sprintf("temp%df", ch))
+		hum, hasHum := formFloat(form, fmt.Sprintf("humidity%d", ch))
+		if !hasTemp && !hasHum {
+			continue
+		}
+		reading := models.TempHumidityChannel{Channel: ch, Humidity: hum}
+		if hasTemp {
+			reading.Temperature = float64(units.Fahrenheit(temp).Celsius())
+		}
+		channels.TempHumidity = append(channels.TempHumidity, reading)
+	}
+
+	// WH51 soil moisture sensors
+	for ch := 1; ch <= ecowittSoilMoistureChannels; ch++ {
+		if moisture, ok := formFloat(form, fmt.Sprintf("soilmoisture%d", ch)); ok {
+			channels.SoilMoisture = append(channels.SoilMoisture, models.SoilMoistureChannel{
+				Channel:  ch,
+				Moisture: moisture,
+			})
+		}
+	}
+
+	// WH41/WH43 PM2.5 sensors
+	for ch := 1; ch <= ecowittPM25Channels; ch++ {
+		pm25, ok := formFloat(form, fmt.Sprintf("pm25_ch%d", ch))
+		if !ok {
+			continue
+		}
+		avg, _ := formFloat(form, fmt.Sprintf("pm25_avg_24h_ch%d", ch))
+		channels.PM25 = append(channels.PM25, models.PM25Channel{
+			Channel: ch,
+			PM25:    pm25,
+			PM25Avg: avg,
+		})
+	}
+
+	// WH55 leak detectors
+	for ch := 1; ch <= ecowittLeakChannels; ch++ {
+		if leak, ok := formFloat(form, fmt.Sprintf("leak_ch%d", ch)); ok {
+			channels.Leak = append(channels.Leak, models.LeakChannel{
+				Channel: ch,
+				Leak:    leak != 0,
+			})
+		}
+	}
+
+	// Battery fields, e.g. wh65batt, batt1, soilbatt1, pm25batt1
+	for key := range form {
+		if !strings.Contains(key, "batt") {
+			continue
+		}
+		if level, ok := formFloat(form, key); ok {
+			if channels.Batteries == nil {
+				channels.Batteries = make(map[string]float64)
+			}
+			channels.Batteries[key] = level
+		}
+	}
+
+	// Supercapacitor voltage of solar powered sensors, e.g. ws90cap_volt
+	for key := range form {
+		if !strings.HasSuffix(key, "cap_volt") {
+			continue
+		}
+		if volts, ok := formFloat(form, key); ok {
+			if channels.Capacitors == nil {
+				channels.Capacitors = make(map[string]float64)
+			}
+			channels.Capacitors[key] = volts
+		}
+	}
+
+	return channels
+}
+
+// formFloat returns the first value for key parsed as a float
+func formFloat(form map[string][]string, key string) (float64, bool) {
+	val, ok := form[key]
+	if !ok || len(val) == 0 {
+		return 0, false
+	}
+
+	f, err := strconv.ParseFloat(val[0], 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return f, true
+}
@@ -1,79 +1,406 @@
 package interceptor
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"strconv"
+	"net/url"
+	"path"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/ask-23/go-wx/internal/models"
+	"github.com/ask-23/go-wx/pkg/aggregate"
 	"github.com/ask-23/go-wx/pkg/config"
 	"github.com/ask-23/go-wx/pkg/database"
+	"github.com/ask-23/go-wx/pkg/health"
+	"github.com/ask-23/go-wx/pkg/qc"
+	"github.com/ask-23/go-wx/pkg/rain"
+	"github.com/ask-23/go-wx/pkg/wal"
+	"github.com/ask-23/go-wx/pkg/wind"
 )
 
+// Store is the subset of database operations the interceptor needs
+type Store interface {
+	SaveWeatherData(data *models.WeatherData) error
+	SaveRawUpload(upload *models.RawUpload) error
+}
+
+// errNotRunning is returned for observations that arrive while the
+// interceptor is not running
+var errNotRunning = errors.New("interceptor is not running")
+
 // Interceptor represents a service that listens for and processes weather data
 type Interceptor struct {
 	config     *config.CollectorConfig
-	db         *database.Database
-	server     *http.Server
+	db         Store
+	listeners  []listener
+	ingest     []Decoder // decoders served on the web server
+	auth       *authenticator
+	stations   *stationResolver
+	qc         *qc.Checker
+	rain       *rain.Tracker
+	wind       *wind.Tracker
+	health     *health.Monitor
+	aggregator *aggregate.Aggregator
+	uploadIDs  uploadIDs
+	queued     bool                  // observations go through the buffer or a batch writer
+	buffer     *wal.Buffer           // set while running
+	writer     *database.BatchWriter // set while running
+	storeMutex sync.RWMutex          // guards buffer and writer
+	state      *runState             // nil unless running
+	runMutex   sync.Mutex
 	latestData *models.WeatherData
 	mutex      sync.RWMutex
-	running    bool
+}
+
+// listener is an HTTP port and the decoders it serves
+type listener struct {
+	port     int
+	decoders []Decoder
 }
 
 // NewInterceptor creates a new data interceptor
-func NewInterceptor(cfg config.CollectorConfig, db *database.Database) (*Interceptor, error) {
+func NewInterceptor(cfg config.CollectorConfig, db Store) (*Interceptor, error) {
+	var listeners []listener
+	for _, lc := range cfg.Listeners {
+		l := listener{port: lc.Port}
+		paths := make(map[string]string)
+		for _, name := range lc.Decoders {
+			dec, err := NewDecoder(strings.ToLower(name))
+			if err != nil {
+				return nil, fmt.Errorf("listener on port %d: %w", lc.Port, err)
+			}
+			if other, ok := paths[dec.Path()]; ok {
+				return nil, fmt.Errorf("listener on port %d: decoders %s and %s both use path %s",
+					lc.Port, other, dec.Name(), dec.Path())
+			}
+			paths[dec.Path()] = dec.Name()
+			l.decoders = append(l.decoders, dec)
+		}
+		listeners = append(listeners, l)
+	}
+
+	var ingest []Decoder
+	for _, name := range cfg.Ingest.Decoders {
+		dec, err := NewDecoder(strings.ToLower(name))
+		if err != nil {
+			return nil, fmt.Errorf("ingest: %w", err)
+		}
+		ingest = append(ingest, dec)
+	}
+
+	checker, err := qc.NewChecker(cfg.QC)
+	if err != nil {
+		return nil, fmt.Errorf("invalid QC configuration: %w", err)
+	}
+
+	// Resume rain totals and wind statistics from the database when it can provide them
+	rainStore, _ := db.(rain.Store)
+	tracker := rain.NewTracker(rainStore, time.Month(cfg.Rain.YearStart))
+	windStore, _ := db.(wind.Store)
+
+	// Keep sensor health in the database when it can store it
+	healthStore, _ := db.(health.Store)
+
+	// Queue observations ahead of the database while running
+	_, batched := db.(database.BatchStore)
+
+	// Combine uploads into archive records of the collector interval
+	var aggregator *aggregate.Aggregator
+	if cfg.Interval > 0 {
+		aggregator = aggregate.NewAggregator(time.Duration(cfg.Interval) * time.Second)
+	}
+
 	return &Interceptor{
 		config:     &cfg,
 		db:         db,
+		listeners:  listeners,
+		ingest:     ingest,
+		auth:       newAuthenticator(cfg.Device),
+		stations:   newStationResolver(cfg.Stations),
+		qc:         checker,
+		rain:       tracker,
+		wind:       wind.NewTracker(windStore),
+		health:     health.NewMonitor(healthStore, time.Duration(cfg.Health.StaleAfter)*time.Second),
+		aggregator: aggregator,
+		queued:     cfg.Buffer.Dir != "" || batched,
 		latestData: &models.WeatherData{},
 		mutex:      sync.RWMutex{},
-		running:    false,
 	}, nil
 }
 
-// Start begins listening for incoming weather data
-func (i *Interceptor) Start() error {
-	if i.running {
-		return fmt.Errorf("interceptor is already running")
+// Run starts listening for incoming weather data and blocks until the
+// interceptor is shut down or one of its listeners fails. Canceling ctx
+// shuts the interceptor down, giving the uploads in progress and the
+// queued observations a moment to be stored.
+func (i *Interceptor) Run(ctx context.Context) error {
+	state, err := i.start()
+	if err != nil {
+		return err
 	}
 
-	// Set up the HTTP server
-	mux := http.NewServeMux()
-	mux.HandleFunc("/", i.handleWeatherData)
+	select {
+	case <-state.stopped:
+		return nil
+	case err := <-state.failed:
+		// Give the other listeners a moment to finish their uploads, and
+		// restart only once what they received is stored
+		drain, cancel := context.WithTimeout(context.Background(), drainTimeout)
+		defer cancel()
+		i.Shutdown(drain)
+		<-state.stopped
+		return err
+	case <-ctx.Done():
+		// ctx is already done, so drain with a context of its own
+		drain, cancel := context.WithTimeout(context.Background(), drainTimeout)
+		defer cancel()
+		i.Shutdown(drain)
+		return nil
+	}
+}
 
-	addr := fmt.Sprintf(":%d", i.config.Device.Port)
-	i.server = &http.Server{
-		Addr:    addr,
-		Handler: mux,
+// drainTimeout is how long the uploads in progress are given to finish when
+// the interceptor stops without being shut down: when ctx is canceled or
+// another listener fails
+const drainTimeout = 5 * time.Second
+
+// runState is the state of a running interceptor
+type runState struct {
+	servers  []*http.Server
+	failed   chan error     // errors of listeners that stopped serving
+	done     chan struct{}  // closed to stop the background goroutines
+	stopped  chan struct{}  // closed once shutdown is complete
+	wg       sync.WaitGroup // background goroutines
+	stopping bool           // set once shutdown has begun, under runMutex
+	err      error          // first shutdown error, set before stopped is closed
+}
+
+// start opens the buffer and starts the listeners and background goroutines
+func (i *Interceptor) start() (*runState, error) {
+	i.runMutex.Lock()
+	defer i.runMutex.Unlock()
+
+	if i.state != nil {
+		return nil, fmt.Errorf("interceptor is already running")
 	}
 
-	i.running = true
+	// Open the buffer observations are written to before the database
+	var buffer *wal.Buffer
+	var writer *database.BatchWriter
+	if i.config.Buffer.Dir != "" {
+		var err error
+		buffer, err = wal.Open(i.config.Buffer.Dir, i.db, i.config.Buffer.MaxSegmentSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open buffer: %w", err)
+		}
+		buffer.Start()
+	} else if store, ok := i.db.(database.BatchStore); ok {
+		// Otherwise queue observations and save them in batches
+		writer = database.NewBatchWriter(store, i.config.Batch)
+		writer.Start()
+	}
 
-	// Start the server in a goroutine
-	go func() {
-		log.Printf("Starting interceptor on port %d", i.config.Device.Port)
-		if err := i.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Printf("Server error: %v", err)
+	// Hand out upload IDs after those already archived, whatever the clock says
+	if store, ok := i.db.(uploadIDStore); ok {
+		last, err := store.LastRawUploadID()
+		if err != nil {
+			log.Printf("Error reading the last raw upload ID: %v", err)
 		}
+		i.uploadIDs.resume(last)
+	}
+
+	i.storeMutex.Lock()
+	i.buffer = buffer
+	i.writer = writer
+	i.storeMutex.Unlock()
+
+	state := &runState{
+		failed:  make(chan error, len(i.listeners)),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	for _, l := range i.listeners {
+		// Set up the HTTP server with a handler for each decoder
+		mux := http.NewServeMux()
+		for _, dec := range l.decoders {
+			mux.Handle(dec.Path(), i.handler(dec))
+		}
+
+		server := &http.Server{
+			Addr:    fmt.Sprintf(":%d", l.port),
+			Handler: mux,
+		}
+		state.servers = append(state.servers, server)
+
+		// Start the server in a goroutine
+		go func(port int, names []string) {
+			log.Printf("Starting interceptor on port %d for %s", port, strings.Join(names, ", "))
+			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				state.failed <- fmt.Errorf("listener on port %d: %w", port, err)
+			}
+		}(l.port, decoderNames(l.decoders))
+	}
+
+	if len(i.ingest) > 0 {
+		var paths []string
+		for path := range i.Routes() {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		log.Printf("Accepting uploads on the web server at %s", strings.Join(paths, ", "))
+	}
+
+	// Store archive records once their interval is over
+	if i.aggregator != nil {
+		state.wg.Add(1)
+		go func() {
+			defer state.wg.Done()
+			i.closeRecords(state.done)
+		}()
+	}
+
+	// Look for sensors that have stopped reporting
+	state.wg.Add(1)
+	go func() {
+		defer state.wg.Done()
+		i.checkSensors(state.done)
 	}()
 
-	return nil
+	i.state = state
+	return state, nil
 }
 
-// Stop stops the interceptor
-func (i *Interceptor) Stop() error {
-	if !i.running {
+// Shutdown stops accepting uploads and waits for those in progress to be
+// handled, then stores the archive records in progress and the observations
+// still queued. Uploads still in progress when ctx is done are dropped, and
+// Shutdown returns while the rest is stored in the background.
+func (i *Interceptor) Shutdown(ctx context.Context) error {
+	i.runMutex.Lock()
+	state := i.state
+	if state == nil {
+		i.runMutex.Unlock()
 		return nil
 	}
+	if !state.stopping {
+		state.stopping = true
+		go i.stop(ctx, state)
+	}
+	i.runMutex.Unlock()
+
+	select {
+	case <-state.stopped:
+		return state.err
+	case <-ctx.Done():
+		return fmt.Errorf("interceptor did not finish storing observations: %w", ctx.Err())
+	}
+}
+
+// stop shuts down a running interceptor for Shutdown. Storing carries on
+// after ctx is done, as what is not stored now is lost; the interceptor can
+// start again once it is complete.
+func (i *Interceptor) stop(ctx context.Context, state *runState) {
+	// Shutdown the HTTP servers, closing those that do not drain in time
+	var firstErr error
+	for _, server := range state.servers {
+		if err := server.Shutdown(ctx); err != nil {
+			server.Close()
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to drain uploads: %w", err)
+			}
+		}
+	}
+
+	// Stop the background goroutines before storing what they would have
+	close(state.done)
+	state.wg.Wait()
+	i.health.Save()
+
+	// Store the archive records in progress
+	if i.aggregator != nil {
+		for _, record := range i.aggregator.Flush() {
+			i.saveRecord(record)
+		}
+	}
+
+	// Refuse observations from now on, waiting for those being stored
+	i.storeMutex.Lock()
+	buffer, writer := i.buffer, i.writer
+	i.buffer, i.writer = nil, nil
+	i.storeMutex.Unlock()
 
-	i.running = false
+	// Stop draining; what is left is drained after the next start
+	if buffer != nil {
+		if depth := buffer.Depth(); depth > 0 {
+			log.Printf("Stopping with %d observations left in the buffer", depth)
+		}
+		if err := buffer.Stop(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
 
-	// Shutdown the HTTP server
-	return i.server.Close()
+	// Save the observations still queued
+	if writer != nil {
+		writer.Stop()
+	}
+
+	i.runMutex.Lock()
+	i.state = nil
+	i.runMutex.Unlock()
+
+	state.err = firstErr
+	close(state.stopped)
+}
+
+// Routes returns the handlers of the decoders served on the web server by
+// their path, the ingest path followed by the decoder name
+func (i *Interceptor) Routes() map[string]http.Handler {
+	routes := make(map[string]http.Handler)
+	for _, dec := range i.ingest {
+		routes[path.Join(i.config.Ingest.Path, dec.Name())] = i.handler(dec)
+	}
+	return routes
+}
+
+// Endpoint returns where uploads of the protocol decoded by decoder are
+// accepted: on the web server, reported as port 0, or else on the first
+// listener serving it. ok is false when neither accepts them.
+func (i *Interceptor) Endpoint(decoder string) (port int, urlPath string, ok bool) {
+	for _, dec := range i.ingest {
+		if dec.Name() == decoder {
+			return 0, path.Join(i.config.Ingest.Path, dec.Name()), true
+		}
+	}
+	for _, l := range i.listeners {
+		for _, dec := range l.decoders {
+			if dec.Name() == decoder {
+				return l.port, dec.Path(), true
+			}
+		}
+	}
+	return 0, "", false
+}
+
+// Name returns the name the interceptor is reported under
+func (i *Interceptor) Name() string {
+	return "interceptor"
+}
+
+// BufferDepth returns the number of observations waiting in the buffer to
+// be saved to the database
+func (i *Interceptor) BufferDepth() int {
+	i.storeMutex.RLock()
+	defer i.storeMutex.RUnlock()
+
+	if i.buffer == nil {
+		return 0
+	}
+	return i.buffer.Depth()
 }
 
 // GetLatestData returns the most recent weather data
@@ -86,112 +413,354 @@ func (i *Interceptor) GetLatestData() *models.WeatherData {
 	return &data
 }
 
-// handleWeatherData processes incoming weather data from the Ecowitt device
-func (i *Interceptor) handleWeatherData(w http.ResponseWriter, r *http.Request) {
-	// Ensure it's a POST request (most Ecowitt devices use POST)
+// handler returns an HTTP handler that decodes uploads with dec
+func (i *Interceptor) handler(dec Decoder) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i.handleWeatherData(w, r, dec)
+	})
+}
+
+// handleWeatherData processes incoming weather data from a weather station console
+func (i *Interceptor) handleWeatherData(w http.ResponseWriter, r *http.Request, dec Decoder) {
+	// Ensure it's a POST or GET request (most Ecowitt devices use POST)
 	if r.Method != http.MethodPost && r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
+	receivedAt := time.Now()
+
+	// Capture the upload as sent before anything consumes the body
+	upload, err := newRawUpload(r, dec)
+	if err != nil {
+		http.Error(w, "Error reading request", http.StatusBadRequest)
+		return
+	}
+	upload.ReceivedAt = receivedAt
+
+	// Check the upload presents an allowed secret. Rejected uploads are not
+	// archived so unauthenticated clients cannot fill the archive.
+	ok, err := i.auth.authenticate(r)
+	if err != nil {
+		http.Error(w, "Error reading request", http.StatusBadRequest)
+		return
+	}
+	if !ok {
+		log.Printf("Rejected unauthenticated %s upload from %s", dec.Name(), r.RemoteAddr)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Refuse the upload while it could not be stored, when the interceptor
+	// is not running or observations arrive faster than they are saved,
+	// before it is archived or counted, so the console can resend it
+	if err := i.ready(); err != nil {
+		log.Printf("Refusing %s upload from %s: %v", dec.Name(), r.RemoteAddr, err)
+		w.Header().Set("Retry-After", "10")
+		http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
 	// Parse the form data
 	if err := r.ParseForm(); err != nil {
+		i.archive(upload, http.StatusBadRequest)
 		http.Error(w, "Error parsing form data", http.StatusBadRequest)
 		return
 	}
 
-	// Debug: log all form values
-	log.Printf("Received weather data: %v", r.Form)
+	// Debug: log all form values, without secrets
+	log.Printf("Received weather data: %v", redactForm(r.Form))
 
-	// Process the data based on device type
-	switch strings.ToLower(i.config.Device.Type) {
-	case "ecowitt":
-		i.processEcowittData(r.Form)
-	default:
-		log.Printf("Unsupported device type: %s", i.config.Device.Type)
+	// Identify the station that sent the upload
+	upload.StationID = i.ResolveStation(r.Form)
+
+	// Run the upload through the ingest steps
+	data, err := i.Ingest(dec, r.Form, upload.StationID, receivedAt)
+	if err != nil {
+		i.archive(upload, http.StatusBadRequest)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Archive the upload and link the observation to it
+	i.archive(upload, http.StatusOK)
+	data.RawUploadID = upload.ID
+
+	// Record the batteries of the sensors that reported
+	i.health.Process(data)
+
+	// The upload has been counted, so a resend would count it twice. An
+	// observation lost to a queue that filled up since the check above can
+	// be recovered by replaying the archive.
+	if err := i.processData(data); err != nil {
+		if errors.Is(err, database.ErrQueueFull) {
+			log.Printf("Dropped observation from upload %d, which can be replayed: %v", upload.ID, err)
+		} else {
+			log.Printf("Error saving weather data: %v", err)
+		}
 	}
 
 	// Send a response
+	ack := "OK"
+	if a, ok := dec.(acknowledger); ok {
+		ack = a.Acknowledgement()
+	}
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("OK"))
+	w.Write([]byte(ack))
 }
 
-// processEcowittData extracts weather data from Ecowitt format
-func (i *Interceptor) processEcowittData(form map[string][]string) {
-	// Create a new weather data point
-	data := &models.WeatherData{
-		Timestamp: time.Now(),
+// Ingest runs an upload from station through every step short of storing
+// it: decoding, the clock skew check, quality control, rain accounting and
+// wind statistics. Derived values are calculated by the decoder and again
+// after quality control. Replayed uploads go through the same steps as live
+// ones.
+func (i *Interceptor) Ingest(dec Decoder, form url.Values, station string, receivedAt time.Time) (*models.WeatherData, error) {
+	// Decode the upload using the device protocol
+	data, err := dec.Decode(form, receivedAt)
+	if err != nil {
+		log.Printf("Error decoding %s data: %v", dec.Name(), err)
+		return nil, fmt.Errorf("error decoding weather data: %w", err)
 	}
 
-	// Extract values from the form data
-	// Ecowitt devices typically use keys like "tempf", "humidity", etc.
+	if err := i.prepare(data, station, receivedAt); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
 
-	// Temperature in Fahrenheit
-	if val, ok := form["tempf"]; ok && len(val) > 0 {
-		if temp, err := strconv.ParseFloat(val[0], 64); err == nil {
-			data.Temperature = temp
-		}
+// Submit stores an observation read from a device rather than uploaded by
+// it, such as by polling a gateway, after the same steps as uploads. The
+// observation belongs to the default station unless it names another.
+func (i *Interceptor) Submit(data *models.WeatherData) error {
+	station := data.StationID
+	if station == "" {
+		station = models.DefaultStationID
+	}
+	receivedAt := data.ReceivedAt
+	if receivedAt.IsZero() {
+		receivedAt = time.Now()
 	}
 
-	// Humidity (0-100%)
-	if val, ok := form["humidity"]; ok && len(val) > 0 {
-		if hum, err := strconv.ParseFloat(val[0], 64); err == nil {
-			data.Humidity = hum
-		}
+	if err := i.ready(); err != nil {
+		return err
+	}
+	if err := i.prepare(data, station, receivedAt); err != nil {
+		return err
 	}
 
-	// Barometric pressure in millibars
-	if val, ok := form["baromabsin"]; ok && len(val) > 0 {
-		if pres, err := strconv.ParseFloat(val[0], 64); err == nil {
-			data.Pressure = pres
-		}
+	// Record the batteries of the sensors that reported
+	i.health.Process(data)
+
+	return i.processData(data)
+}
+
+// prepare runs decoded weather data from station through the clock skew
+// check, quality control, rain accounting and wind statistics
+func (i *Interceptor) prepare(data *models.WeatherData, station string, receivedAt time.Time) error {
+	data.StationID = station
+
+	// Compare the device clock with ours
+	data.ReceivedAt = receivedAt
+	if data.Indoor != nil {
+		data.Indoor.StationID = station
+		data.Indoor.ReceivedAt = receivedAt
+	}
+	if err := i.checkClockSkew(data); err != nil {
+		return err
 	}
 
-	// Wind speed in mph
-	if val, ok := form["windspeedmph"]; ok && len(val) > 0 {
-		if speed, err := strconv.ParseFloat(val[0], 64); err == nil {
-			data.WindSpeed = speed
-		}
+	// Flag implausible measurements; they are still stored
+	i.qc.Check(data)
+	if len(data.QCFlags) > 0 {
+		log.Printf("Observation from %s at %v failed quality control: %v",
+			data.StationID, data.Timestamp, data.QCFlags)
+	}
+	if data.Indoor != nil && len(data.Indoor.QCFlags) > 0 {
+		log.Printf("Indoor readings from %s at %v failed quality control: %v",
+			data.StationID, data.Timestamp, data.Indoor.QCFlags)
 	}
 
-	// Wind direction in degrees
-	if val, ok := form["winddir"]; ok && len(val) > 0 {
-		if dir, err := strconv.ParseFloat(val[0], 64); err == nil {
-			data.WindDirection = dir
-		}
+	// Calculate the derived values again without the flagged measurements
+	data.CalculateDerivedValues()
+	if data.Indoor != nil {
+		data.Indoor.CalculateDerivedValues()
 	}
 
-	// Rain in inches
-	if val, ok := form["rainratein"]; ok && len(val) > 0 {
-		if rain, err := strconv.ParseFloat(val[0], 64); err == nil {
-			data.Rain = rain
+	// Work out the rain fallen from the console's counters
+	i.rain.Process(data)
+
+	// Average the wind and keep the peak gusts
+	i.wind.Process(data)
+
+	return nil
+}
+
+// ResolveStation returns the ID of the station that sent an upload
+func (i *Interceptor) ResolveStation(form url.Values) string {
+	return i.stations.resolve(form)
+}
+
+// processData records decoded weather data as the latest reading and stores
+// it, or with aggregation the archive records it completes
+func (i *Interceptor) processData(data *models.WeatherData) error {
+	// Update the latest data
+	i.mutex.Lock()
+	i.latestData = data
+	i.mutex.Unlock()
+
+	if i.aggregator == nil {
+		return i.store(data)
+	}
+
+	var firstErr error
+	for _, record := range i.aggregator.Add(data) {
+		if err := i.store(record); err != nil && firstErr == nil {
+			firstErr = err
 		}
 	}
+	return firstErr
+}
+
+// Aggregate adds an observation to the archive records and returns the
+// records it completes. Without aggregation the observation is its own
+// record.
+func (i *Interceptor) Aggregate(data *models.WeatherData) []*models.WeatherData {
+	if i.aggregator == nil {
+		return []*models.WeatherData{data}
+	}
+	return i.aggregator.Add(data)
+}
+
+// RecordInterval returns the length of the archive records, or 0 without
+// aggregation
+func (i *Interceptor) RecordInterval() time.Duration {
+	if i.aggregator == nil {
+		return 0
+	}
+	return i.aggregator.Interval()
+}
 
-	// UV index
-	if val, ok := form["uv"]; ok && len(val) > 0 {
-		if uv, err := strconv.ParseFloat(val[0], 64); err == nil {
-			data.UVIndex = uv
+// FlushRecords returns the archive records in progress
+func (i *Interceptor) FlushRecords() []*models.WeatherData {
+	if i.aggregator == nil {
+		return nil
+	}
+	return i.aggregator.Flush()
+}
+
+// closeRecords stores the archive records whose interval is over until done is closed
+func (i *Interceptor) closeRecords(done chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			for _, record := range i.aggregator.Due(now) {
+				i.saveRecord(record)
+			}
+		case <-done:
+			return
 		}
 	}
+}
 
-	// Cloud base (if available)
-	if val, ok := form["cloudbase"]; ok && len(val) > 0 {
-		if cloud, err := strconv.ParseFloat(val[0], 64); err == nil {
-			data.CloudBase = cloud
+// checkSensors loads the state of the sensors, then checks for stale
+// sensors and saves those that changed every minute until done is closed
+func (i *Interceptor) checkSensors(done chan struct{}) {
+	i.health.Load()
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			i.health.Check(now)
+		case <-done:
+			return
 		}
 	}
+}
 
-	// Calculate derived values (dew point, wind chill, heat index)
-	data.CalculateDerivedValues()
+// ready returns an error when an observation could not be stored: when the
+// interceptor is not running to queue it, or when the batch writer has no
+// room for it. Uploads are refused with it before any state changes.
+func (i *Interceptor) ready() error {
+	i.storeMutex.RLock()
+	defer i.storeMutex.RUnlock()
 
-	// Update the latest data
-	i.mutex.Lock()
-	i.latestData = data
-	i.mutex.Unlock()
+	if i.queued && i.buffer == nil && i.writer == nil {
+		return errNotRunning
+	}
+	if i.writer != nil && i.writer.Full() {
+		return database.ErrQueueFull
+	}
+	return nil
+}
+
+// saveRecord stores an archive record, logging failures
+func (i *Interceptor) saveRecord(record *models.WeatherData) {
+	if err := i.store(record); err != nil {
+		log.Printf("Error saving archive record for %s at %v: %v", record.StationID, record.Timestamp, err)
+	}
+}
+
+// store saves an observation through the buffer or the batch writer when
+// there is one, or directly to the database when observations are not
+// queued. Queued observations are refused while the interceptor is not
+// running rather than written around the queue.
+func (i *Interceptor) store(data *models.WeatherData) error {
+	i.storeMutex.RLock()
+	defer i.storeMutex.RUnlock()
+
+	var store wal.Store = i.db
+	if i.buffer != nil {
+		store = i.buffer
+	} else if i.writer != nil {
+		store = i.writer
+	} else if i.queued {
+		return errNotRunning
+	}
+	return store.SaveWeatherData(data)
+}
+
+// checkClockSkew flags observations whose device timestamp is further from
+// the receive time than the configured maximum skew. When rejection is
+// enabled an error is returned instead.
+func (i *Interceptor) checkClockSkew(data *models.WeatherData) error {
+	if i.config.MaxClockSkew <= 0 {
+		return nil
+	}
+
+	skew := data.ReceivedAt.Sub(data.Timestamp)
+	if skew < 0 {
+		skew = -skew
+	}
+
+	maxSkew := time.Duration(i.config.MaxClockSkew) * time.Second
+	if skew <= maxSkew {
+		return nil
+	}
+
+	if i.config.RejectSkewed {
+		log.Printf("Rejecting observation from %s at %v: device clock is off by %v (received %v)",
+			data.StationID, data.Timestamp, skew.Round(time.Second), data.ReceivedAt)
+		return fmt.Errorf("observation timestamp is outside the allowed clock skew of %v", maxSkew)
+	}
+
+	log.Printf("Flagging observation from %s at %v: device clock is off by %v (received %v)",
+		data.StationID, data.Timestamp, skew.Round(time.Second), data.ReceivedAt)
+	data.ClockSkewed = true
+
+	return nil
+}
 
-	// Save to database
-	if err := i.db.SaveWeatherData(data); err != nil {
-		log.Printf("Error saving weather data: %v", err)
+// decoderNames returns the names of the given decoders
+func decoderNames(decs []Decoder) []string {
+	names := make([]string, len(decs))
+	for n, dec := range decs {
+		names[n] = dec.Name()
 	}
+	return names
 }
@@ -2,20 +2,32 @@ package interceptor
 
 import (
 	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/ask-23/go-wx/internal/models"
 	"github.com/ask-23/go-wx/pkg/config"
+	"github.com/ask-23/go-wx/pkg/database"
+	"github.com/ask-23/go-wx/pkg/wal"
 )
 
 // MockDatabase implements a mock of the database interface for testing
 type MockDatabase struct {
 	SavedData *models.WeatherData
 	SaveCalls int
+	Uploads   []*models.RawUpload
 }
 
 func (m *MockDatabase) SaveWeatherData(data *models.WeatherData) error {
@@ -24,11 +36,19 @@ func (m *MockDatabase) SaveWeatherData(data *models.WeatherData) error {
 	return nil
 }
 
-func (m *MockDatabase) GetLatestWeatherData() (*models.WeatherData, error) {
+func (m *MockDatabase) SaveRawUpload(upload *models.RawUpload) error {
+	m.Uploads = append(m.Uploads, upload)
+	if upload.ID == 0 {
+		upload.ID = int64(len(m.Uploads))
+	}
+	return nil
+}
+
+func (m *MockDatabase) GetLatestWeatherData(station string) (*models.WeatherData, error) {
 	return m.SavedData, nil
 }
 
-func (m *MockDatabase) GetWeatherDataRange(start, end time.Time) ([]*models.WeatherData, error) {
+func (m *MockDatabase) GetWeatherDataRange(station string, start, end time.Time) ([]*models.WeatherData, error) {
 	return []*models.WeatherData{m.SavedData}, nil
 }
 
@@ -36,39 +56,32 @@ func (m *MockDatabase) Close() error {
 	return nil
 }
 
-// MockPublisher implements a mock of the publisher interface for testing
-type MockPublisher struct {
-	PublishedData *models.WeatherData
-	PublishCalls  int
-}
-
-func (m *MockPublisher) Publish(data *models.WeatherData) error {
-	m.PublishedData = data
-	m.PublishCalls++
-	return nil
-}
-
 // TestInterceptorHandleEcowittData tests the Ecowitt data interceptor
 func TestInterceptorHandleEcowittData(t *testing.T) {
-	// Create mock database and publisher
+	// Create mock database
 	mockDB := &MockDatabase{}
-	mockPub := &MockPublisher{}
 
 	// Create a test config
-	cfg := config.InterceptorConfig{
-		Port:     8000,
-		Address:  "0.0.0.0",
+	cfg := config.CollectorConfig{
+		Type: "interceptor",
+		Device: config.DeviceConfig{
+			Type: "ecowitt",
+			Port: 8000,
+		},
+		Listeners: []config.ListenerConfig{
+			{Port: 8000, Decoders: []string{"ecowitt"}},
+		},
 		Interval: 60,
 	}
 
 	// Create a new interceptor
-	interceptor, err := NewInterceptor(cfg, mockDB, []Publisher{mockPub})
+	interceptor, err := NewInterceptor(cfg, mockDB)
 	if err != nil {
 		t.Fatalf("Failed to create interceptor: %v", err)
 	}
 
 	// Create a test server
-	server := httptest.NewServer(http.HandlerFunc(interceptor.handleEcowittData))
+	server := httptest.NewServer(interceptor.handler(NewEcowittDecoder()))
 	defer server.Close()
 
 	// Create a form data payload simulating an Ecowitt device
@@ -88,6 +101,7 @@ func TestInterceptorHandleEcowittData(t *testing.T) {
 	formData.Set("monthlyrainin", "2.5")
 	formData.Set("yearlyrainin", "10.0")
 	formData.Set("solarradiation", "850.5")
+	formData.Set("rainratein", "0.1")
 	formData.Set("uv", "5")
 
 	// Send a POST request with the form data
@@ -102,6 +116,19 @@ func TestInterceptorHandleEcowittData(t *testing.T) {
 		t.Errorf("Expected status code 200, got %d", resp.StatusCode)
 	}
 
+	// The observation is held until its archive record is complete
+	if mockDB.SaveCalls != 0 {
+		t.Errorf("Expected no saves before the interval ends, got %d", mockDB.SaveCalls)
+	}
+
+	// An upload from the next interval completes the record
+	formData.Set("dateutc", "2023-05-01 12:01:00")
+	resp, err = http.Post(server.URL, "application/x-www-form-urlencoded", bytes.NewBufferString(formData.Encode()))
+	if err != nil {
+		t.Fatalf("Failed to send POST request: %v", err)
+	}
+	defer resp.Body.Close()
+
 	// Verify that the data was saved to the database
 	if mockDB.SaveCalls != 1 {
 		t.Errorf("Expected 1 call to SaveWeatherData, got %d", mockDB.SaveCalls)
@@ -111,6 +138,9 @@ func TestInterceptorHandleEcowittData(t *testing.T) {
 	if mockDB.SavedData == nil {
 		t.Fatalf("SavedData is nil, expected WeatherData object")
 	}
+	if expected := time.Date(2023, 5, 1, 12, 1, 0, 0, time.UTC); !mockDB.SavedData.Timestamp.Equal(expected) {
+		t.Errorf("Expected record ending at %v, got %v", expected, mockDB.SavedData.Timestamp)
+	}
 
 	// Use approximate comparison for floating point values
 	// Convert Fahrenheit to Celsius: (F - 32) * 5/9
@@ -139,13 +169,21 @@ func TestInterceptorHandleEcowittData(t *testing.T) {
 		t.Errorf("Expected wind direction 180°, got %.1f°", mockDB.SavedData.WindDirection)
 	}
 
-	// Verify that the data was published
-	if mockPub.PublishCalls != 1 {
-		t.Errorf("Expected 1 call to Publish, got %d", mockPub.PublishCalls)
+	// Convert inches to mm: in * 25.4
+	expectedRain := 0.1 * 25.4
+	if !approximatelyEqual(mockDB.SavedData.RainRate, expectedRain, 0.01) {
+		t.Errorf("Expected rain rate %.2f mm/h, got %.2f mm/h", expectedRain, mockDB.SavedData.RainRate)
+	}
+
+	// Derived values must be computed from the metric values, so the dew
+	// point has to be below the Celsius temperature
+	if mockDB.SavedData.DewPoint >= mockDB.SavedData.Temperature {
+		t.Errorf("Expected dew point below %.2f°C, got %.2f°C", mockDB.SavedData.Temperature, mockDB.SavedData.DewPoint)
 	}
 
-	if mockPub.PublishedData == nil {
-		t.Fatalf("PublishedData is nil, expected WeatherData object")
+	// The latest data should match what was stored
+	if latest := interceptor.GetLatestData(); latest.Temperature != mockDB.SavedData.Temperature {
+		t.Errorf("Expected latest temperature %.2f°C, got %.2f°C", mockDB.SavedData.Temperature, latest.Temperature)
 	}
 }
 
@@ -157,6 +195,7 @@ func TestParseWeatherData(t *testing.T) {
 		"tempf":          {"70.5"},
 		"humidity":       {"45"},
 		"baromrelin":     {"29.92"},
+		"baromabsin":     {"29.92"},
 		"winddir":        {"180"},
 		"windspeedmph":   {"5.5"},
 		"windgustmph":    {"8.0"},
@@ -166,7 +205,7 @@ func TestParseWeatherData(t *testing.T) {
 	}
 
 	// Parse the weather data
-	data, err := parseWeatherData(formData)
+	data, err := parseWeatherData(formData, time.Now())
 	if err != nil {
 		t.Fatalf("Failed to parse weather data: %v", err)
 	}
@@ -197,7 +236,7 @@ func TestParseWeatherData(t *testing.T) {
 	}
 
 	// Parse the incomplete weather data
-	dataMissing, err := parseWeatherData(formDataMissing)
+	dataMissing, err := parseWeatherData(formDataMissing, time.Now())
 	if err != nil {
 		t.Fatalf("Failed to parse incomplete weather data: %v", err)
 	}
@@ -220,3 +259,1196 @@ func approximatelyEqual(a, b, tolerance float64) bool {
 	}
 	return diff <= tolerance
 }
+
+// TestParseExtendedEcowittData tests the add-on sensor and extended fields
+func TestParseExtendedEcowittData(t *testing.T) {
+	formData := map[string][]string{
+		"tempinf":          {"72.0"},
+		"humidityin":       {"40"},
+		"baromrelin":       {"30.01"},
+		"windgustmph":      {"10.0"},
+		"maxdailygust":     {"15.0"},
+		"solarradiation":   {"500.5"},
+		"dailyrainin":      {"0.5"},
+		"yearlyrainin":     {"10.0"},
+		"temp1f":           {"50.0"},
+		"humidity1":        {"65"},
+		"soilmoisture2":    {"33"},
+		"pm25_ch1":         {"12.0"},
+		"pm25_avg_24h_ch1": {"9.5"},
+		"leak_ch3":         {"1"},
+		"lightning":        {"14"},
+		"lightning_time":   {"1682942400"},
+		"lightning_num":    {"3"},
+		"wh65batt":         {"0"},
+		"batt1":            {"1"},
+		"ws90cap_volt":     {"5.2"},
+		"co2":              {"612"},
+		"co2_24h":          {"580"},
+	}
+
+	data, err := parseWeatherData(formData, time.Now())
+	if err != nil {
+		t.Fatalf("Failed to parse weather data: %v", err)
+	}
+
+	if data.Indoor == nil {
+		t.Fatalf("Expected indoor data, got nil")
+	}
+	if !approximatelyEqual(data.Indoor.Temperature, (72.0-32)*5/9, 0.01) {
+		t.Errorf("Expected indoor temperature 22.22°C, got %.2f°C", data.Indoor.Temperature)
+	}
+	if data.Indoor.CO2 == nil || *data.Indoor.CO2 != 612 || data.Indoor.CO2Avg24h == nil || *data.Indoor.CO2Avg24h != 580 {
+		t.Errorf("Expected CO2 612 ppm with 24h average 580 ppm, got %v and %v", data.Indoor.CO2, data.Indoor.CO2Avg24h)
+	}
+	if data.Indoor.DewPoint == 0 || data.Indoor.AbsoluteHumidity == 0 {
+		t.Errorf("Expected indoor derived values to be calculated")
+	}
+
+	if !approximatelyEqual(data.RelativePressure, 30.01*33.86389, 0.1) {
+		t.Errorf("Expected relative pressure %.2f hPa, got %.2f hPa", 30.01*33.86389, data.RelativePressure)
+	}
+
+	if !approximatelyEqual(data.WindGust, 10.0*0.44704, 0.01) {
+		t.Errorf("Expected wind gust %.2f m/s, got %.2f m/s", 10.0*0.44704, data.WindGust)
+	}
+
+	if !approximatelyEqual(data.DailyRain, 12.7, 0.01) {
+		t.Errorf("Expected daily rain 12.7 mm, got %.2f mm", data.DailyRain)
+	}
+
+	if !approximatelyEqual(data.YearlyRain, 254.0, 0.01) {
+		t.Errorf("Expected yearly rain 254.0 mm, got %.2f mm", data.YearlyRain)
+	}
+
+	if data.LightningCount != 3 || data.LightningDistance != 14 {
+		t.Errorf("Expected 3 strikes at 14 km, got %d at %.1f km", data.LightningCount, data.LightningDistance)
+	}
+
+	if data.LightningTime == nil || data.LightningTime.Unix() != 1682942400 {
+		t.Errorf("Expected lightning time 1682942400, got %v", data.LightningTime)
+	}
+
+	if data.Channels == nil {
+		t.Fatalf("Expected add-on sensor channels, got nil")
+	}
+
+	if len(data.Channels.TempHumidity) != 1 || data.Channels.TempHumidity[0].Channel != 1 ||
+		!approximatelyEqual(data.Channels.TempHumidity[0].Temperature, 10.0, 0.01) {
+		t.Errorf("Expected channel 1 at 10.0°C, got %+v", data.Channels.TempHumidity)
+	}
+
+	if len(data.Channels.SoilMoisture) != 1 || data.Channels.SoilMoisture[0].Channel != 2 {
+		t.Errorf("Expected soil moisture on channel 2, got %+v", data.Channels.SoilMoisture)
+	}
+
+	if len(data.Channels.PM25) != 1 || data.Channels.PM25[0].PM25Avg != 9.5 {
+		t.Errorf("Expected PM2.5 24h average 9.5, got %+v", data.Channels.PM25)
+	}
+
+	if len(data.Channels.Leak) != 1 || !data.Channels.Leak[0].Leak {
+		t.Errorf("Expected leak on channel 3, got %+v", data.Channels.Leak)
+	}
+
+	if len(data.Channels.Batteries) != 2 || data.Channels.Batteries["batt1"] != 1 {
+		t.Errorf("Expected two battery fields, got %v", data.Channels.Batteries)
+	}
+
+	if data.Channels.Capacitors["ws90cap_volt"] != 5.2 {
+		t.Errorf("Expected capacitor voltage 5.2 V, got %v", data.Channels.Capacitors)
+	}
+}
+
+// TestParseIndoorHumidityOnly tests that indoor derived values are not
+// calculated without an indoor temperature
+func TestParseIndoorHumidityOnly(t *testing.T) {
+	formData := map[string][]string{
+		"tempf":      {"72.5"},
+		"humidity":   {"45"},
+		"humidityin": {"40"},
+	}
+
+	data, err := parseWeatherData(formData, time.Now())
+	if err != nil {
+		t.Fatalf("Failed to parse weather data: %v", err)
+	}
+
+	if data.Indoor == nil || data.Indoor.Humidity != 40 {
+		t.Fatalf("Expected indoor humidity 40%%, got %+v", data.Indoor)
+	}
+	if data.Indoor.DewPoint != 0 || data.Indoor.AbsoluteHumidity != 0 {
+		t.Errorf("Expected no indoor derived values without a temperature, got dew point %.2f and absolute humidity %.2f",
+			data.Indoor.DewPoint, data.Indoor.AbsoluteHumidity)
+	}
+}
+
+// TestDecoderRegistry tests decoder lookup and listener validation
+func TestDecoderRegistry(t *testing.T) {
+	dec, err := NewDecoder("ecowitt")
+	if err != nil {
+		t.Fatalf("Failed to create ecowitt decoder: %v", err)
+	}
+	if dec.Name() != "ecowitt" {
+		t.Errorf("Expected decoder name 'ecowitt', got '%s'", dec.Name())
+	}
+
+	if _, err := NewDecoder("nonexistent"); err == nil {
+		t.Errorf("Expected error for unknown decoder, got nil")
+	}
+
+	// Unknown decoders in a listener are a configuration error
+	cfg := config.CollectorConfig{
+		Listeners: []config.ListenerConfig{{Port: 8000, Decoders: []string{"nonexistent"}}},
+	}
+	if _, err := NewInterceptor(cfg, &MockDatabase{}); err == nil {
+		t.Errorf("Expected error for listener with unknown decoder, got nil")
+	}
+
+	// Two decoders on one listener cannot share a path
+	cfg.Listeners[0].Decoders = []string{"ecowitt", "ecowitt"}
+	if _, err := NewInterceptor(cfg, &MockDatabase{}); err == nil {
+		t.Errorf("Expected error for listener with conflicting decoder paths, got nil")
+	}
+}
+
+// TestWundergroundUpload tests decoding a Weather Underground protocol GET upload
+func TestWundergroundUpload(t *testing.T) {
+	mockDB := &MockDatabase{}
+
+	cfg := config.CollectorConfig{
+		Listeners: []config.ListenerConfig{
+			{Port: 8000, Decoders: []string{"ecowitt", "wunderground"}},
+		},
+	}
+
+	interceptor, err := NewInterceptor(cfg, mockDB)
+	if err != nil {
+		t.Fatalf("Failed to create interceptor: %v", err)
+	}
+
+	server := httptest.NewServer(interceptor.handler(NewWundergroundDecoder()))
+	defer server.Close()
+
+	query := url.Values{}
+	query.Set("ID", "KTEST123")
+	query.Set("PASSWORD", "secret")
+	query.Set("action", "updateraw")
+	query.Set("dateutc", "2023-05-01 12:00:00")
+	query.Set("tempf", "70.5")
+	query.Set("humidity", "45")
+	query.Set("baromin", "29.92")
+	query.Set("windspeedmph", "5.5")
+	query.Set("winddir", "180")
+	query.Set("rainin", "0.1")
+	query.Set("dailyrainin", "0.5")
+	query.Set("UV", "3")
+
+	resp, err := http.Get(server.URL + "/weatherstation/updateweatherstation.php?" + query.Encode())
+	if err != nil {
+		t.Fatalf("Failed to send GET request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status code 200, got %d", resp.StatusCode)
+	}
+
+	body := new(bytes.Buffer)
+	body.ReadFrom(resp.Body)
+	if body.String() != "success" {
+		t.Errorf("Expected response body 'success', got '%s'", body.String())
+	}
+
+	if mockDB.SavedData == nil {
+		t.Fatalf("SavedData is nil, expected WeatherData object")
+	}
+
+	expected := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	if !mockDB.SavedData.Timestamp.Equal(expected) {
+		t.Errorf("Expected timestamp %v, got %v", expected, mockDB.SavedData.Timestamp)
+	}
+
+	if !approximatelyEqual(mockDB.SavedData.Temperature, (70.5-32)*5/9, 0.01) {
+		t.Errorf("Expected temperature %.2f°C, got %.2f°C", (70.5-32)*5/9, mockDB.SavedData.Temperature)
+	}
+
+	if !approximatelyEqual(mockDB.SavedData.RelativePressure, 29.92*33.86389, 0.1) {
+		t.Errorf("Expected pressure %.2f hPa, got %.2f hPa", 29.92*33.86389, mockDB.SavedData.RelativePressure)
+	}
+
+	if !approximatelyEqual(mockDB.SavedData.HourlyRain, 2.54, 0.01) {
+		t.Errorf("Expected hourly rain 2.54 mm, got %.2f mm", mockDB.SavedData.HourlyRain)
+	}
+
+	if mockDB.SavedData.UVIndex != 3 {
+		t.Errorf("Expected UV index 3, got %.1f", mockDB.SavedData.UVIndex)
+	}
+
+	// The upload has no gust, which must not contradict the wind speed
+	if len(mockDB.SavedData.QCFlags) != 0 {
+		t.Errorf("Expected no QC flags, got %v", mockDB.SavedData.QCFlags)
+	}
+
+	if mockDB.SavedData.StationID != models.DefaultStationID {
+		t.Errorf("Expected station %s, got %s", models.DefaultStationID, mockDB.SavedData.StationID)
+	}
+}
+
+// TestQualityControl tests that implausible measurements are stored with flags
+func TestQualityControl(t *testing.T) {
+	mockDB := &MockDatabase{}
+
+	cfg := config.CollectorConfig{
+		Listeners: []config.ListenerConfig{{Port: 8000, Decoders: []string{"ecowitt"}}},
+	}
+
+	interceptor, err := NewInterceptor(cfg, mockDB)
+	if err != nil {
+		t.Fatalf("Failed to create interceptor: %v", err)
+	}
+
+	server := httptest.NewServer(interceptor.handler(NewEcowittDecoder()))
+	defer server.Close()
+
+	formData := url.Values{}
+	formData.Set("tempf", "-40")
+	formData.Set("humidity", "0")
+	formData.Set("baromabsin", "29.5")
+	resp, err := http.PostForm(server.URL, formData)
+	if err != nil {
+		t.Fatalf("Failed to send POST request: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", resp.StatusCode)
+	}
+	if mockDB.SaveCalls != 1 {
+		t.Fatalf("Expected flagged observation to be saved, got %d saves", mockDB.SaveCalls)
+	}
+	if !mockDB.SavedData.Flagged("temperature") || !mockDB.SavedData.Flagged("humidity") {
+		t.Errorf("Expected temperature and humidity to be flagged, got %v", mockDB.SavedData.QCFlags)
+	}
+	if mockDB.SavedData.Flagged("pressure") {
+		t.Errorf("Expected pressure not to be flagged, got %v", mockDB.SavedData.QCFlags)
+	}
+
+	// QC can be turned off
+	cfg.QC.Disabled = true
+	if interceptor, err = NewInterceptor(cfg, mockDB); err != nil {
+		t.Fatalf("Failed to create interceptor: %v", err)
+	}
+	if interceptor.qc != nil {
+		t.Errorf("Expected no QC checker when disabled")
+	}
+}
+
+// TestQueueFull tests that uploads are refused while the write queue is full
+func TestQueueFull(t *testing.T) {
+	cfg := config.CollectorConfig{
+		Listeners: []config.ListenerConfig{{Port: 8000, Decoders: []string{"ecowitt"}}},
+	}
+
+	mockDB := &MockDatabase{}
+	interceptor, err := NewInterceptor(cfg, mockDB)
+	if err != nil {
+		t.Fatalf("Failed to create interceptor: %v", err)
+	}
+
+	// A writer that is not started keeps everything queued, here the archived
+	// upload and the observation of the first upload
+	writer := database.NewBatchWriter(nil, config.BatchConfig{QueueSize: 2})
+	interceptor.writer = writer
+
+	server := httptest.NewServer(interceptor.handler(NewEcowittDecoder()))
+	defer server.Close()
+
+	formData := url.Values{}
+	formData.Set("tempf", "70")
+	for n, expected := range []int{http.StatusOK, http.StatusServiceUnavailable} {
+		resp, err := http.PostForm(server.URL, formData)
+		if err != nil {
+			t.Fatalf("Failed to send POST request: %v", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != expected {
+			t.Errorf("Expected status code %d for upload %d, got %d", expected, n+1, resp.StatusCode)
+		}
+	}
+
+	// The archive is queued with the observation rather than saved while
+	// the console waits, and the refused upload left no trace
+	if len(mockDB.Uploads) != 0 {
+		t.Errorf("Expected the archived upload to be queued, got %d saved directly", len(mockDB.Uploads))
+	}
+	if !writer.Full() {
+		t.Errorf("Expected the accepted upload and its observation to fill the queue")
+	}
+}
+
+// TestRawUploadArchive tests that uploads are archived as sent and linked to
+// their observation
+func TestRawUploadArchive(t *testing.T) {
+	mockDB := &MockDatabase{}
+
+	cfg := config.CollectorConfig{
+		Listeners: []config.ListenerConfig{{Port: 8000, Decoders: []string{"ecowitt"}}},
+		Device:    config.DeviceConfig{Passkeys: []string{"SECRETKEY"}},
+	}
+
+	interceptor, err := NewInterceptor(cfg, mockDB)
+	if err != nil {
+		t.Fatalf("Failed to create interceptor: %v", err)
+	}
+
+	server := httptest.NewServer(interceptor.handler(NewEcowittDecoder()))
+	defer server.Close()
+
+	body := "PASSKEY=SECRETKEY&dateutc=2023-05-01+12%3A00%3A00&tempf=70.5&humidity=45"
+	req, _ := http.NewRequest(http.MethodPost, server.URL+"/data/report/", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", "GW1000")
+	req.Header.Set("Authorization", "Bearer token")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to send POST request: %v", err)
+	}
+	resp.Body.Close()
+
+	if len(mockDB.Uploads) != 1 {
+		t.Fatalf("Expected 1 archived upload, got %d", len(mockDB.Uploads))
+	}
+	upload := mockDB.Uploads[0]
+
+	expectedBody := "PASSKEY=REDACTED&dateutc=2023-05-01+12%3A00%3A00&tempf=70.5&humidity=45"
+	if upload.Body != expectedBody {
+		t.Errorf("Expected archived body %q, got %q", expectedBody, upload.Body)
+	}
+	if upload.Path != "/data/report/" || upload.Method != http.MethodPost || upload.Decoder != "ecowitt" {
+		t.Errorf("Expected POST /data/report/ for ecowitt, got %s %s for %s", upload.Method, upload.Path, upload.Decoder)
+	}
+	if upload.SourceIP != "127.0.0.1" {
+		t.Errorf("Expected source IP 127.0.0.1, got %s", upload.SourceIP)
+	}
+	if upload.Headers["User-Agent"] != "GW1000" {
+		t.Errorf("Expected User-Agent header to be archived, got %v", upload.Headers)
+	}
+	if _, ok := upload.Headers["Authorization"]; ok {
+		t.Errorf("Expected Authorization header not to be archived")
+	}
+	if upload.Status != http.StatusOK || upload.ReceivedAt.IsZero() {
+		t.Errorf("Expected status 200 and a receive time, got %d at %v", upload.Status, upload.ReceivedAt)
+	}
+
+	if mockDB.SavedData.RawUploadID != upload.ID {
+		t.Errorf("Expected observation linked to upload %d, got %d", upload.ID, mockDB.SavedData.RawUploadID)
+	}
+
+	// Uploads that fail to decode are archived with their status
+	resp, err = http.PostForm(server.URL, url.Values{"PASSKEY": {"SECRETKEY"}, "dateutc": {"yesterday"}})
+	if err != nil {
+		t.Fatalf("Failed to send POST request: %v", err)
+	}
+	resp.Body.Close()
+	if len(mockDB.Uploads) != 2 || mockDB.Uploads[1].Status != http.StatusBadRequest {
+		t.Errorf("Expected undecodable upload to be archived with status 400")
+	}
+
+	// Unauthenticated uploads are not archived
+	resp, err = http.PostForm(server.URL, url.Values{"PASSKEY": {"WRONG"}})
+	if err != nil {
+		t.Fatalf("Failed to send POST request: %v", err)
+	}
+	resp.Body.Close()
+	if len(mockDB.Uploads) != 2 {
+		t.Errorf("Expected unauthenticated upload not to be archived, got %d uploads", len(mockDB.Uploads))
+	}
+}
+
+// TestRedactRaw tests redaction of secrets in raw query strings and bodies
+func TestRedactRaw(t *testing.T) {
+	raw := "ID=KTX1&PASSWORD=hunter2&tempf=70&passkey=abc"
+	expected := "ID=KTX1&PASSWORD=REDACTED&tempf=70&passkey=REDACTED"
+	if redacted := redactRaw(raw); redacted != expected {
+		t.Errorf("Expected %q, got %q", expected, redacted)
+	}
+}
+
+// TestStationResolver tests mapping uploads to stations
+func TestStationResolver(t *testing.T) {
+	// Without mappings everything belongs to the default station
+	single := newStationResolver(nil)
+	if id := single.resolve(url.Values{"PASSKEY": {"ABC"}}); id != models.DefaultStationID {
+		t.Errorf("Expected default station, got %s", id)
+	}
+
+	resolver := newStationResolver([]config.StationMapping{
+		{ID: "backyard", Passkey: "ABC"},
+		{ID: "roof", MAC: "AA:BB:CC:DD:EE:FF"},
+		{ID: "garden", UploadID: "KTX1"},
+	})
+
+	tests := []struct {
+		name     string
+		form     url.Values
+		expected string
+	}{
+		{"passkey", url.Values{"PASSKEY": {"ABC"}}, "backyard"},
+		{"mac", url.Values{"MAC": {"aa-bb-cc-dd-ee-ff"}}, "roof"},
+		{"upload id", url.Values{"ID": {"KTX1"}}, "garden"},
+		{"unmapped mac", url.Values{"mac": {"11:22:33:44:55:66"}}, "mac-112233445566"},
+		{"unmapped id", url.Values{"ID": {"KTX9"}}, "KTX9"},
+		{"no identifiers", url.Values{}, models.DefaultStationID},
+	}
+
+	for _, tt := range tests {
+		if id := resolver.resolve(tt.form); id != tt.expected {
+			t.Errorf("%s: expected station %s, got %s", tt.name, tt.expected, id)
+		}
+	}
+
+	// Unmapped passkeys are hashed rather than exposed
+	id := resolver.resolve(url.Values{"PASSKEY": {"SECRET"}})
+	if !strings.HasPrefix(id, "pk-") || strings.Contains(id, "SECRET") {
+		t.Errorf("Expected a hashed passkey station ID, got %s", id)
+	}
+	if other := resolver.resolve(url.Values{"PASSKEY": {"SECRET"}}); other != id {
+		t.Errorf("Expected a stable station ID, got %s and %s", id, other)
+	}
+}
+
+// TestParseDateUTC tests parsing of the dateutc parameter
+func TestParseDateUTC(t *testing.T) {
+	receivedAt := time.Date(2023, 5, 1, 12, 0, 30, 0, time.UTC)
+	now, err := parseDateUTC("now", receivedAt)
+	if err != nil {
+		t.Fatalf("Failed to parse dateutc=now: %v", err)
+	}
+	if !now.Equal(receivedAt) {
+		t.Errorf("Expected dateutc=now to use the receive time %v, got %v", receivedAt, now)
+	}
+
+	if _, err := parseDateUTC("yesterday", receivedAt); err == nil {
+		t.Errorf("Expected error for invalid dateutc, got nil")
+	}
+}
+
+// TestClockSkew tests flagging and rejection of observations with a skewed device clock
+func TestClockSkew(t *testing.T) {
+	mockDB := &MockDatabase{}
+
+	cfg := config.CollectorConfig{
+		Listeners:    []config.ListenerConfig{{Port: 8000, Decoders: []string{"ecowitt"}}},
+		MaxClockSkew: 300,
+	}
+
+	interceptor, err := NewInterceptor(cfg, mockDB)
+	if err != nil {
+		t.Fatalf("Failed to create interceptor: %v", err)
+	}
+
+	server := httptest.NewServer(interceptor.handler(NewEcowittDecoder()))
+	defer server.Close()
+
+	post := func(dateUTC time.Time) int {
+		formData := url.Values{}
+		formData.Set("dateutc", dateUTC.UTC().Format("2006-01-02 15:04:05"))
+		formData.Set("tempf", "70.5")
+		resp, err := http.PostForm(server.URL, formData)
+		if err != nil {
+			t.Fatalf("Failed to send POST request: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// An observation within the tolerance keeps the device timestamp
+	deviceTime := time.Now().Add(-time.Minute).Truncate(time.Second)
+	if status := post(deviceTime); status != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", status)
+	}
+	if !mockDB.SavedData.Timestamp.Equal(deviceTime) {
+		t.Errorf("Expected device timestamp %v, got %v", deviceTime, mockDB.SavedData.Timestamp)
+	}
+	if mockDB.SavedData.ReceivedAt.IsZero() {
+		t.Errorf("Expected receive time to be recorded")
+	}
+	if mockDB.SavedData.ClockSkewed {
+		t.Errorf("Expected observation within tolerance not to be flagged")
+	}
+
+	// An observation outside the tolerance is flagged but stored
+	if status := post(time.Now().Add(-time.Hour)); status != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", status)
+	}
+	if !mockDB.SavedData.ClockSkewed {
+		t.Errorf("Expected skewed observation to be flagged")
+	}
+
+	// With rejection enabled it is not stored at all
+	interceptor.config.RejectSkewed = true
+	calls := mockDB.SaveCalls
+	if status := post(time.Now().Add(time.Hour)); status != http.StatusBadRequest {
+		t.Errorf("Expected status code 400, got %d", status)
+	}
+	if mockDB.SaveCalls != calls {
+		t.Errorf("Expected skewed observation not to be saved")
+	}
+}
+
+// TestUploadAuthentication tests PASSKEY, ID/PASSWORD and HMAC upload authentication
+func TestUploadAuthentication(t *testing.T) {
+	mockDB := &MockDatabase{}
+
+	cfg := config.CollectorConfig{
+		Device: config.DeviceConfig{
+			Passkeys:    []string{"ABCDEF0123456789"},
+			Credentials: []config.CredentialConfig{{ID: "KTEST123", Password: "secret"}},
+			HMACSecret:  "shared-secret",
+		},
+		Listeners: []config.ListenerConfig{{Port: 8000, Decoders: []string{"ecowitt", "wunderground"}}},
+	}
+
+	interceptor, err := NewInterceptor(cfg, mockDB)
+	if err != nil {
+		t.Fatalf("Failed to create interceptor: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", interceptor.handler(NewEcowittDecoder()))
+	mux.Handle("/weatherstation/updateweatherstation.php", interceptor.handler(NewWundergroundDecoder()))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	post := func(form url.Values, signature string) int {
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/data/report/", bytes.NewBufferString(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if signature != "" {
+			req.Header.Set("X-Signature", signature)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to send POST request: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	form := url.Values{}
+	form.Set("tempf", "70.5")
+
+	// Missing and wrong PASSKEYs are rejected
+	if status := post(form, ""); status != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without PASSKEY, got %d", status)
+	}
+	form.Set("PASSKEY", "WRONG")
+	if status := post(form, ""); status != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for wrong PASSKEY, got %d", status)
+	}
+	if mockDB.SaveCalls != 0 {
+		t.Errorf("Expected rejected uploads not to be saved, got %d saves", mockDB.SaveCalls)
+	}
+
+	// An allowed PASSKEY is accepted
+	form.Set("PASSKEY", "ABCDEF0123456789")
+	if status := post(form, ""); status != http.StatusOK {
+		t.Errorf("Expected 200 for allowed PASSKEY, got %d", status)
+	}
+
+	// A valid HMAC signature of the body is accepted without a PASSKEY
+	form.Del("PASSKEY")
+	mac := hmac.New(sha256.New, []byte("shared-secret"))
+	mac.Write([]byte(form.Encode()))
+	if status := post(form, "sha256="+hex.EncodeToString(mac.Sum(nil))); status != http.StatusOK {
+		t.Errorf("Expected 200 for valid signature, got %d", status)
+	}
+	if status := post(form, "sha256=00ff"); status != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for invalid signature, got %d", status)
+	}
+
+	// Weather Underground credentials
+	query := url.Values{}
+	query.Set("ID", "KTEST123")
+	query.Set("PASSWORD", "wrong")
+	query.Set("tempf", "70.5")
+	resp, err := http.Get(server.URL + "/weatherstation/updateweatherstation.php?" + query.Encode())
+	if err != nil {
+		t.Fatalf("Failed to send GET request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for wrong password, got %d", resp.StatusCode)
+	}
+
+	query.Set("PASSWORD", "secret")
+	resp, err = http.Get(server.URL + "/weatherstation/updateweatherstation.php?" + query.Encode())
+	if err != nil {
+		t.Fatalf("Failed to send GET request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected 200 for valid credentials, got %d", resp.StatusCode)
+	}
+}
+
+// TestRedactForm tests that secrets are removed from logged form values
+func TestRedactForm(t *testing.T) {
+	form := url.Values{}
+	form.Set("PASSKEY", "ABCDEF0123456789")
+	form.Set("PASSWORD", "secret")
+	form.Set("tempf", "70.5")
+
+	redacted := redactForm(form)
+
+	if redacted.Get("PASSKEY") != "REDACTED" || redacted.Get("PASSWORD") != "REDACTED" {
+		t.Errorf("Expected secrets to be redacted, got %v", redacted)
+	}
+
+	if redacted.Get("tempf") != "70.5" {
+		t.Errorf("Expected other values to be kept, got %v", redacted)
+	}
+
+	if form.Get("PASSKEY") != "ABCDEF0123456789" {
+		t.Errorf("Expected the original form to be unchanged")
+	}
+}
+
+// TestIndoorData tests that archive records carry the latest indoor reading
+// of their interval
+func TestIndoorData(t *testing.T) {
+	mockDB := &MockDatabase{}
+	cfg := config.CollectorConfig{
+		Listeners: []config.ListenerConfig{{Port: 8000, Decoders: []string{"ecowitt"}}},
+		Interval:  60,
+	}
+	interceptor, err := NewInterceptor(cfg, mockDB)
+	if err != nil {
+		t.Fatalf("Failed to create interceptor: %v", err)
+	}
+	server := httptest.NewServer(interceptor.handler(NewEcowittDecoder()))
+	defer server.Close()
+
+	for n, ts := range []string{"2023-05-01 12:00:00", "2023-05-01 12:00:30", "2023-05-01 12:01:00"} {
+		formData := url.Values{}
+		formData.Set("dateutc", ts)
+		formData.Set("tempf", "50")
+		formData.Set("tempinf", "70.5")
+		formData.Set("humidityin", fmt.Sprint(45+5*n))
+		resp, err := http.Post(server.URL, "application/x-www-form-urlencoded", strings.NewReader(formData.Encode()))
+		if err != nil {
+			t.Fatalf("Failed to send POST request: %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	if mockDB.SaveCalls != 1 {
+		t.Fatalf("Expected 1 archive record, got %d", mockDB.SaveCalls)
+	}
+	indoor := mockDB.SavedData.Indoor
+	if indoor == nil {
+		t.Fatalf("Expected the archive record to carry indoor readings")
+	}
+	if indoor.StationID != models.DefaultStationID || indoor.ReceivedAt.IsZero() {
+		t.Errorf("Expected indoor reading from the default station with a receive time, got %+v", indoor)
+	}
+	if !approximatelyEqual(indoor.Temperature, (70.5-32)*5/9, 0.01) || indoor.Humidity != 50 {
+		t.Errorf("Expected 21.39°C and 50%%, got %.2f°C and %.0f%%", indoor.Temperature, indoor.Humidity)
+	}
+}
+
+// freePort returns a TCP port nothing is listening on
+func freePort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// TestShutdown tests that shutting down stores the archive record in progress
+func TestShutdown(t *testing.T) {
+	mockDB := &MockDatabase{}
+	port := freePort(t)
+	cfg := config.CollectorConfig{
+		Listeners: []config.ListenerConfig{{Port: port, Decoders: []string{"ecowitt"}}},
+		Interval:  60,
+	}
+	interceptor, err := NewInterceptor(cfg, mockDB)
+	if err != nil {
+		t.Fatalf("Failed to create interceptor: %v", err)
+	}
+
+	result := make(chan error)
+	go func() { result <- interceptor.Run(context.Background()) }()
+
+	// Retry until the listener is up
+	formData := url.Values{}
+	formData.Set("dateutc", "2023-05-01 12:00:00")
+	formData.Set("tempf", "70.5")
+	uploadURL := fmt.Sprintf("http://127.0.0.1:%d/", port)
+	for attempt := 0; ; attempt++ {
+		resp, err := http.Post(uploadURL, "application/x-www-form-urlencoded", strings.NewReader(formData.Encode()))
+		if err == nil {
+			resp.Body.Close()
+			break
+		}
+		if attempt == 100 {
+			t.Fatalf("Failed to send POST request: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := interceptor.Shutdown(context.Background()); err != nil {
+		t.Errorf("Expected shutdown to succeed, got %v", err)
+	}
+	if err := <-result; err != nil {
+		t.Errorf("Expected run to return nil, got %v", err)
+	}
+	if mockDB.SaveCalls != 1 {
+		t.Errorf("Expected the record in progress to be saved, got %d saves", mockDB.SaveCalls)
+	}
+
+	// Shutting down again does nothing
+	if err := interceptor.Shutdown(context.Background()); err != nil {
+		t.Errorf("Expected a second shutdown to succeed, got %v", err)
+	}
+}
+
+// MockSlowDatabase holds up saving observations until released
+type MockSlowDatabase struct {
+	MockDatabase
+	release chan struct{}
+}
+
+func (m *MockSlowDatabase) SaveWeatherData(data *models.WeatherData) error {
+	<-m.release
+	return m.MockDatabase.SaveWeatherData(data)
+}
+
+// TestShutdownTimeout tests that shutting down returns once ctx is done,
+// while the archive record in progress is still being stored
+func TestShutdownTimeout(t *testing.T) {
+	mockDB := &MockSlowDatabase{release: make(chan struct{})}
+	port := freePort(t)
+	cfg := config.CollectorConfig{
+		Listeners: []config.ListenerConfig{{Port: port, Decoders: []string{"ecowitt"}}},
+		Interval:  60,
+	}
+	interceptor, err := NewInterceptor(cfg, mockDB)
+	if err != nil {
+		t.Fatalf("Failed to create interceptor: %v", err)
+	}
+
+	result := make(chan error)
+	go func() { result <- interceptor.Run(context.Background()) }()
+
+	// Retry until the listener is up
+	formData := url.Values{}
+	formData.Set("dateutc", "2023-05-01 12:00:00")
+	formData.Set("tempf", "70.5")
+	uploadURL := fmt.Sprintf("http://127.0.0.1:%d/", port)
+	for attempt := 0; ; attempt++ {
+		resp, err := http.Post(uploadURL, "application/x-www-form-urlencoded", strings.NewReader(formData.Encode()))
+		if err == nil {
+			resp.Body.Close()
+			break
+		}
+		if attempt == 100 {
+			t.Fatalf("Failed to send POST request: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := interceptor.Shutdown(ctx); err == nil {
+		t.Errorf("Expected shutdown to time out while storing")
+	}
+
+	// The record is still stored, and the run returns once it is
+	close(mockDB.release)
+	if err := <-result; err != nil {
+		t.Errorf("Expected run to return nil, got %v", err)
+	}
+	if mockDB.SaveCalls != 1 {
+		t.Errorf("Expected the record in progress to be saved, got %d saves", mockDB.SaveCalls)
+	}
+}
+
+// TestCancelDrains tests that canceling the run still lets an upload in
+// progress finish and be stored
+func TestCancelDrains(t *testing.T) {
+	mockDB := &MockDatabase{}
+	port := freePort(t)
+	cfg := config.CollectorConfig{
+		Listeners: []config.ListenerConfig{{Port: port, Decoders: []string{"ecowitt"}}},
+		Interval:  -1,
+	}
+	interceptor, err := NewInterceptor(cfg, mockDB)
+	if err != nil {
+		t.Fatalf("Failed to create interceptor: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error)
+	go func() { result <- interceptor.Run(ctx) }()
+
+	// Wait until the listener is up
+	addr := fmt.Sprintf("127.0.0.1:%d", port)
+	for attempt := 0; ; attempt++ {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			break
+		}
+		if attempt == 100 {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Start an upload and cancel while its body is still being sent
+	body, writer := io.Pipe()
+	response := make(chan *http.Response, 1)
+	go func() {
+		resp, err := http.Post("http://"+addr+"/", "application/x-www-form-urlencoded", body)
+		if err != nil {
+			response <- nil
+			return
+		}
+		resp.Body.Close()
+		response <- resp
+	}()
+	writer.Write([]byte("dateutc=2023-05-01+12%3A00%3A00"))
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	writer.Write([]byte("&tempf=70.5"))
+	writer.Close()
+
+	if resp := <-response; resp == nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected the upload in progress to succeed, got %v", resp)
+	}
+	if err := <-result; err != nil {
+		t.Errorf("Expected run to return nil, got %v", err)
+	}
+	if mockDB.SaveCalls != 1 {
+		t.Errorf("Expected the upload in progress to be stored, got %d saves", mockDB.SaveCalls)
+	}
+}
+
+// MockBatchDatabase saves observations in batches
+type MockBatchDatabase struct {
+	MockDatabase
+	mutex sync.Mutex
+	saved int
+}
+
+func (m *MockBatchDatabase) SaveWeatherDataBatch(batch []*models.WeatherData) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.saved += len(batch)
+	m.SavedData = batch[len(batch)-1]
+	return nil
+}
+
+func (m *MockBatchDatabase) SaveRawUploadBatch(batch []*models.RawUpload) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.Uploads = append(m.Uploads, batch...)
+	return nil
+}
+
+// TestNotRunning tests that observations queued ahead of the database are
+// refused while the interceptor is not running instead of saved directly
+func TestNotRunning(t *testing.T) {
+	mockDB := &MockBatchDatabase{}
+	cfg := config.CollectorConfig{Interval: -1, Batch: config.BatchConfig{QueueSize: 10}}
+	interceptor, err := NewInterceptor(cfg, mockDB)
+	if err != nil {
+		t.Fatalf("Failed to create interceptor: %v", err)
+	}
+	server := httptest.NewServer(interceptor.handler(NewEcowittDecoder()))
+	defer server.Close()
+
+	post := func() int {
+		formData := url.Values{}
+		formData.Set("tempf", "70")
+		resp, err := http.PostForm(server.URL, formData)
+		if err != nil {
+			t.Fatalf("Failed to send POST request: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if status := post(); status != http.StatusServiceUnavailable {
+		t.Errorf("Expected status code 503 before running, got %d", status)
+	}
+	if err := interceptor.Submit(&models.WeatherData{Temperature: 20}); err == nil {
+		t.Errorf("Expected Submit to fail before running")
+	}
+
+	result := make(chan error)
+	go func() { result <- interceptor.Run(context.Background()) }()
+	for attempt := 0; interceptor.ready() != nil; attempt++ {
+		if attempt == 100 {
+			t.Fatalf("Interceptor did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if status := post(); status != http.StatusOK {
+		t.Errorf("Expected status code 200 while running, got %d", status)
+	}
+
+	if err := interceptor.Shutdown(context.Background()); err != nil {
+		t.Errorf("Expected shutdown to succeed, got %v", err)
+	}
+	if err := <-result; err != nil {
+		t.Errorf("Expected run to return nil, got %v", err)
+	}
+
+	if status := post(); status != http.StatusServiceUnavailable {
+		t.Errorf("Expected status code 503 after shutdown, got %d", status)
+	}
+	if mockDB.SaveCalls != 0 || mockDB.saved != 1 {
+		t.Errorf("Expected 1 batched save and no direct saves, got %d and %d", mockDB.saved, mockDB.SaveCalls)
+	}
+	if len(mockDB.Uploads) != 1 {
+		t.Fatalf("Expected only the accepted upload to be archived, got %d", len(mockDB.Uploads))
+	}
+	if id := mockDB.Uploads[0].ID; id == 0 || mockDB.SavedData.RawUploadID != id {
+		t.Errorf("Expected the batched observation linked to batched upload %d, got %d", id, mockDB.SavedData.RawUploadID)
+	}
+}
+
+// TestListenerFailure tests that a listener failing to serve ends the run
+func TestListenerFailure(t *testing.T) {
+	taken, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer taken.Close()
+
+	cfg := config.CollectorConfig{
+		Listeners: []config.ListenerConfig{{Port: taken.Addr().(*net.TCPAddr).Port, Decoders: []string{"ecowitt"}}},
+	}
+	interceptor, err := NewInterceptor(cfg, &MockDatabase{})
+	if err != nil {
+		t.Fatalf("Failed to create interceptor: %v", err)
+	}
+
+	if err := interceptor.Run(context.Background()); err == nil {
+		t.Errorf("Expected an error when the port is taken")
+	}
+}
+
+// TestIngestRoutes tests serving decoders on the web server under the ingest path
+func TestIngestRoutes(t *testing.T) {
+	mockDB := &MockDatabase{}
+	cfg := config.CollectorConfig{
+		Ingest:   config.IngestConfig{Path: "/ingest/", Decoders: []string{"ecowitt", "wunderground"}},
+		Interval: -1,
+	}
+	interceptor, err := NewInterceptor(cfg, mockDB)
+	if err != nil {
+		t.Fatalf("Failed to create interceptor: %v", err)
+	}
+
+	routes := interceptor.Routes()
+	if len(routes) != 2 || routes["/ingest/ecowitt"] == nil || routes["/ingest/wunderground"] == nil {
+		t.Fatalf("Expected routes for /ingest/ecowitt and /ingest/wunderground, got %v", routes)
+	}
+
+	mux := http.NewServeMux()
+	for path, handler := range routes {
+		mux.Handle(path, handler)
+	}
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	// Run without listeners of its own to store uploads
+	result := make(chan error)
+	go func() { result <- interceptor.Run(context.Background()) }()
+
+	formData := url.Values{}
+	formData.Set("dateutc", "2023-05-01 12:00:00")
+	formData.Set("tempf", "70.5")
+	resp, err := http.Post(server.URL+"/ingest/ecowitt", "application/x-www-form-urlencoded", strings.NewReader(formData.Encode()))
+	if err != nil {
+		t.Fatalf("Failed to send POST request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status code 200, got %d", resp.StatusCode)
+	}
+
+	resp, err = http.Get(server.URL + "/weatherstation/updateweatherstation.php?tempf=70")
+	if err != nil {
+		t.Fatalf("Failed to send GET request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected decoder paths not to be served, got status %d", resp.StatusCode)
+	}
+
+	if err := interceptor.Shutdown(context.Background()); err != nil {
+		t.Errorf("Expected shutdown to succeed, got %v", err)
+	}
+	if err := <-result; err != nil {
+		t.Errorf("Expected run to return nil, got %v", err)
+	}
+	if mockDB.SaveCalls != 1 {
+		t.Errorf("Expected 1 call to SaveWeatherData, got %d", mockDB.SaveCalls)
+	}
+}
+
+// TestSubmit tests storing an observation read from a device
+func TestSubmit(t *testing.T) {
+	mockDB := &MockDatabase{}
+	interceptor, err := NewInterceptor(config.CollectorConfig{Interval: -1}, mockDB)
+	if err != nil {
+		t.Fatalf("Failed to create interceptor: %v", err)
+	}
+
+	data := &models.WeatherData{Timestamp: time.Now(), Temperature: 21}
+	if err := interceptor.Submit(data); err != nil {
+		t.Fatalf("Failed to submit observation: %v", err)
+	}
+
+	if mockDB.SaveCalls != 1 {
+		t.Fatalf("Expected 1 call to SaveWeatherData, got %d", mockDB.SaveCalls)
+	}
+	if saved := mockDB.SavedData; saved.StationID != models.DefaultStationID || saved.ReceivedAt.IsZero() {
+		t.Errorf("Expected the default station and a receive time, got %q at %v", saved.StationID, saved.ReceivedAt)
+	}
+	if latest := interceptor.GetLatestData(); latest.Temperature != 21 {
+		t.Errorf("Expected the latest temperature 21, got %v", latest.Temperature)
+	}
+}
+
+// TestEndpoint tests finding where uploads of a protocol are accepted
+func TestEndpoint(t *testing.T) {
+	cfg := config.CollectorConfig{
+		Listeners: []config.ListenerConfig{{Port: 8000, Decoders: []string{"ecowitt", "wunderground"}}},
+		Ingest:    config.IngestConfig{Path: "/ingest", Decoders: []string{"ecowitt"}},
+		Interval:  -1,
+	}
+	interceptor, err := NewInterceptor(cfg, &MockDatabase{})
+	if err != nil {
+		t.Fatalf("Failed to create interceptor: %v", err)
+	}
+
+	// The web server is preferred over a listener of its own
+	if port, path, ok := interceptor.Endpoint("ecowitt"); !ok || port != 0 || path != "/ingest/ecowitt" {
+		t.Errorf("Expected ecowitt uploads on the web server at /ingest/ecowitt, got %d %s %v", port, path, ok)
+	}
+	if port, path, ok := interceptor.Endpoint("wunderground"); !ok || port != 8000 || path != "/weatherstation/updateweatherstation.php" {
+		t.Errorf("Expected wunderground uploads on port 8000, got %d %s %v", port, path, ok)
+	}
+	if _, _, ok := interceptor.Endpoint("davis"); ok {
+		t.Errorf("Expected no endpoint for an unknown protocol")
+	}
+}
+
+// TestUploadIDs tests that upload IDs keep increasing, after those already
+// archived and when uploads share a receive time
+func TestUploadIDs(t *testing.T) {
+	var ids uploadIDs
+	now := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	first := ids.next(now)
+	if first != now.UnixMicro() {
+		t.Errorf("Expected the receive time in microseconds, got %d", first)
+	}
+	if second := ids.next(now); second != first+1 {
+		t.Errorf("Expected %d for an upload received at the same time, got %d", first+1, second)
+	}
+
+	ids.resume(first + 100)
+	if id := ids.next(now); id != first+101 {
+		t.Errorf("Expected %d after the archived uploads, got %d", first+101, id)
+	}
+}
+
+// UnavailableDatabase fails every save, as while the database is down
+type UnavailableDatabase struct {
+	MockDatabase
+}
+
+func (m *UnavailableDatabase) SaveWeatherData(data *models.WeatherData) error {
+	return fmt.Errorf("database unavailable")
+}
+
+func (m *UnavailableDatabase) SaveRawUpload(upload *models.RawUpload) error {
+	return fmt.Errorf("database unavailable")
+}
+
+// TestBufferedArchive tests that uploads archived while the database is down
+// are kept in the buffer with their observations and saved once it is back
+func TestBufferedArchive(t *testing.T) {
+	cfg := config.CollectorConfig{
+		Interval: -1,
+		Buffer:   config.BufferConfig{Dir: t.TempDir()},
+	}
+	interceptor, err := NewInterceptor(cfg, &UnavailableDatabase{})
+	if err != nil {
+		t.Fatalf("Failed to create interceptor: %v", err)
+	}
+	server := httptest.NewServer(interceptor.handler(NewEcowittDecoder()))
+	defer server.Close()
+
+	result := make(chan error)
+	go func() { result <- interceptor.Run(context.Background()) }()
+	for attempt := 0; interceptor.ready() != nil; attempt++ {
+		if attempt == 100 {
+			t.Fatalf("Interceptor did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	formData := url.Values{}
+	formData.Set("tempf", "70")
+	resp, err := http.PostForm(server.URL, formData)
+	if err != nil {
+		t.Fatalf("Failed to send POST request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status code 200 while the database is down, got %d", resp.StatusCode)
+	}
+
+	if err := interceptor.Shutdown(context.Background()); err != nil {
+		t.Errorf("Expected shutdown to succeed, got %v", err)
+	}
+	if err := <-result; err != nil {
+		t.Errorf("Expected run to return nil, got %v", err)
+	}
+
+	// Drain what was buffered once the database is back
+	mockDB := &MockDatabase{}
+	buffer, err := wal.Open(cfg.Buffer.Dir, mockDB, 0)
+	if err != nil {
+		t.Fatalf("Failed to open buffer: %v", err)
+	}
+	buffer.Start()
+	for attempt := 0; buffer.Depth() > 0; attempt++ {
+		if attempt == 100 {
+			t.Fatalf("Buffer did not drain, %d left", buffer.Depth())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	buffer.Stop()
+
+	if len(mockDB.Uploads) != 1 || mockDB.SaveCalls != 1 {
+		t.Fatalf("Expected the upload and its observation, got %d uploads and %d observations",
+			len(mockDB.Uploads), mockDB.SaveCalls)
+	}
+	if mockDB.SavedData.RawUploadID == 0 || mockDB.SavedData.RawUploadID != mockDB.Uploads[0].ID {
+		t.Errorf("Expected the observation linked to upload %d, got %d", mockDB.Uploads[0].ID, mockDB.SavedData.RawUploadID)
+	}
+}
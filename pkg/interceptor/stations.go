@@ -0,0 +1,75 @@
+package interceptor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"strings"
+
+	"github.com/ask-23/go-wx/internal/models"
+	"github.com/ask-23/go-wx/pkg/config"
+)
+
+// stationResolver works out which station sent an upload
+type stationResolver struct {
+	mappings []config.StationMapping
+}
+
+// newStationResolver creates a resolver for the configured station mappings
+func newStationResolver(mappings []config.StationMapping) *stationResolver {
+	return &stationResolver{mappings: mappings}
+}
+
+// resolve returns the station ID for an upload. Without any mappings every
+// upload belongs to the default station. Otherwise the upload's PASSKEY, MAC
+// or ID is matched against the mappings, and unmatched uploads get an ID
+// derived from the first identifier they carry.
+func (s *stationResolver) resolve(form url.Values) string {
+	if len(s.mappings) == 0 {
+		return models.DefaultStationID
+	}
+
+	passkey := form.Get("PASSKEY")
+	mac := normalizeMAC(formValue(form, "MAC", "mac"))
+	uploadID := form.Get("ID")
+
+	for _, st := range s.mappings {
+		switch {
+		case passkey != "" && st.Passkey == passkey:
+			return st.ID
+		case mac != "" && normalizeMAC(st.MAC) == mac:
+			return st.ID
+		case uploadID != "" && st.UploadID == uploadID:
+			return st.ID
+		}
+	}
+
+	switch {
+	case passkey != "":
+		// The PASSKEY doubles as an upload secret, so only a hash of it is
+		// exposed as the station ID
+		sum := sha256.Sum256([]byte(passkey))
+		return "pk-" + hex.EncodeToString(sum[:6])
+	case mac != "":
+		return "mac-" + mac
+	case uploadID != "":
+		return uploadID
+	}
+
+	return models.DefaultStationID
+}
+
+// normalizeMAC lower cases a MAC address and strips its separators
+func normalizeMAC(mac string) string {
+	return strings.NewReplacer(":", "", "-", "").Replace(strings.ToLower(mac))
+}
+
+// formValue returns the first non-empty value among keys
+func formValue(form url.Values, keys ...string) string {
+	for _, key := range keys {
+		if v := form.Get(key); v != "" {
+			return v
+		}
+	}
+	return ""
+}
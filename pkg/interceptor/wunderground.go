@@ -0,0 +1,125 @@
+package interceptor
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/ask-23/go-wx/internal/models"
+	"github.com/ask-23/go-wx/pkg/units"
+)
+
+// WundergroundDecoder decodes the Weather Underground upload protocol
+// (updateweatherstation.php), which many consoles support as their only
+// push format
+type WundergroundDecoder struct{}
+
+// NewWundergroundDecoder creates a new Weather Underground protocol decoder
+func NewWundergroundDecoder() Decoder {
+	return &WundergroundDecoder{}
+}
+
+// Name returns the name of this decoder
+func (wu *WundergroundDecoder) Name() string {
+	return "wunderground"
+}
+
+// Path returns the URL path of the Weather Underground upload endpoint
+func (wu *WundergroundDecoder) Path() string {
+	return "/weatherstation/updateweatherstation.php"
+}
+
+// Acknowledgement returns the response body Weather Underground clients expect
+func (wu *WundergroundDecoder) Acknowledgement() string {
+	return "success"
+}
+
+// Decode converts a Weather Underground upload into weather data
+func (wu *WundergroundDecoder) Decode(form url.Values, receivedAt time.Time) (*models.WeatherData, error) {
+	timestamp, err := parseDateUTC(form.Get("dateutc"), receivedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	data := &models.WeatherData{
+		Timestamp: timestamp,
+		Reported:  make(map[string]bool),
+	}
+
+	// Outdoor temperature and humidity
+	if temp, ok := formFloat(form, "tempf"); ok {
+		data.Temperature = float64(units.Fahrenheit(temp).Celsius())
+		data.Report("temperature")
+	}
+	if hum, ok := formFloat(form, "humidity"); ok {
+		data.Humidity = hum
+		data.Report("humidity")
+	}
+
+	// Indoor temperature and humidity, which are stored separately
+	temp, hasTemp := formFloat(form, "indoortempf")
+	hum, hasHum := formFloat(form, "indoorhumidity")
+	if hasTemp || hasHum {
+		data.Indoor = &models.IndoorData{Timestamp: timestamp, Humidity: hum}
+		if hasTemp {
+			data.Indoor.Temperature = float64(units.Fahrenheit(temp).Celsius())
+			data.Indoor.Report("temperature")
+			data.Report("indoorTemperature")
+		}
+		if hasHum {
+			data.Indoor.Report("humidity")
+			data.Report("indoorHumidity")
+		}
+		data.Indoor.CalculateDerivedValues()
+	}
+
+	// The protocol only carries sea level pressure, so it also stands in
+	// for the station pressure
+	if pres, ok := formFloat(form, "baromin"); ok {
+		data.RelativePressure = float64(units.InHg(pres).HPa())
+		data.Pressure = data.RelativePressure
+		data.Report("relativePressure", "pressure")
+	}
+
+	// Wind
+	if speed, ok := formFloat(form, "windspeedmph"); ok {
+		data.WindSpeed = float64(units.MilesPerHour(speed).MetersPerSecond())
+		data.Report("windSpeed")
+	}
+	if gust, ok := formFloat(form, "windgustmph"); ok {
+		data.WindGust = float64(units.MilesPerHour(gust).MetersPerSecond())
+		data.Report("windGust")
+	}
+	if dir, ok := formFloat(form, "winddir"); ok {
+		data.WindDirection = dir
+		data.Report("windDirection")
+	}
+
+	// Rain; rainin is the accumulation over the past hour
+	rainTotals := map[string]*float64{
+		"rainin":        &data.HourlyRain,
+		"dailyrainin":   &data.DailyRain,
+		"weeklyrainin":  &data.WeeklyRain,
+		"monthlyrainin": &data.MonthlyRain,
+		"yearlyrainin":  &data.YearlyRain,
+	}
+	for key, field := range rainTotals {
+		if rain, ok := formFloat(form, key); ok {
+			*field = float64(units.Inches(rain).Millimeters())
+		}
+	}
+
+	// Solar radiation and UV index
+	if solar, ok := formFloat(form, "solarradiation"); ok {
+		data.SolarRadiation = solar
+		data.Report("solarRadiation")
+	}
+	if uv, ok := formFloat(form, "UV"); ok {
+		data.UVIndex = uv
+		data.Report("uvIndex")
+	}
+
+	// Calculate derived values (dew point, wind chill, heat index, cloud base)
+	data.CalculateDerivedValues()
+
+	return data, nil
+}
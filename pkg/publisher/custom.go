@@ -35,7 +35,7 @@ func NewCustomPublisher(cfg config.PublisherConfig, db *database.Database) (Publ
 		method = "POST"
 	}
 
-	return &CustomPublisher{
+	pub := &CustomPublisher{
 		BasePublisher: BasePublisher{
 			config:  cfg,
 			db:      db,
@@ -44,28 +44,35 @@ func NewCustomPublisher(cfg config.PublisherConfig, db *database.Database) (Publ
 		url:     cfg.URL,
 		method:  method,
 		headers: cfg.Headers,
-	}, nil
+	}
+	pub.publishFn = pub.publish
+
+	return pub, nil
 }
 
-// CustomWeatherData is a struct for formatting weather data for the custom API
+// CustomWeatherData is a struct for formatting weather data for the custom API.
+// Measurements left out because they failed quality control are omitted.
 type CustomWeatherData struct {
-	Timestamp     string  `json:"timestamp"`
-	Temperature   float64 `json:"temperature"`
-	Humidity      float64 `json:"humidity"`
-	Pressure      float64 `json:"pressure"`
-	WindSpeed     float64 `json:"wind_speed"`
-	WindDirection float64 `json:"wind_direction"`
-	Rain          float64 `json:"rain"`
-	UVIndex       float64 `json:"uv_index"`
-	DewPoint      float64 `json:"dew_point"`
-	WindChill     float64 `json:"wind_chill"`
-	HeatIndex     float64 `json:"heat_index"`
+	Station       string         `json:"station"`
+	Timestamp     string         `json:"timestamp"`
+	Temperature   *float64       `json:"temperature,omitempty"`
+	Humidity      *float64       `json:"humidity,omitempty"`
+	Pressure      *float64       `json:"pressure,omitempty"`
+	WindSpeed     *float64       `json:"wind_speed,omitempty"`
+	WindDirection *float64       `json:"wind_direction,omitempty"`
+	RainRate      *float64       `json:"rain_rate,omitempty"`
+	RainDay       *float64       `json:"rain_day,omitempty"`
+	UVIndex       *float64       `json:"uv_index,omitempty"`
+	DewPoint      *float64       `json:"dew_point,omitempty"`
+	WindChill     *float64       `json:"wind_chill,omitempty"`
+	HeatIndex     *float64       `json:"heat_index,omitempty"`
+	QCFlags       models.QCFlags `json:"qc_flags,omitempty"`
 }
 
 // publish sends weather data to a custom endpoint
 func (c *CustomPublisher) publish() error {
 	// Get the latest weather data from the database
-	data, err := c.db.GetLatestWeatherData()
+	data, err := c.db.GetLatestWeatherData(c.config.Station)
 	if err != nil {
 		return fmt.Errorf("failed to get latest weather data: %w", err)
 	}
@@ -75,8 +82,13 @@ func (c *CustomPublisher) publish() error {
 		return fmt.Errorf("weather data is too old for publishing (timestamp: %v)", data.Timestamp)
 	}
 
+	return c.send(data)
+}
+
+// send posts a single observation to the custom endpoint
+func (c *CustomPublisher) send(data *models.WeatherData) error {
 	// Format the data for the custom API
-	formattedData := formatWeatherData(data)
+	formattedData := formatWeatherData(data, func(field string) bool { return c.include(data, field) })
 
 	// Convert to JSON
 	jsonData, err := json.Marshal(formattedData)
@@ -120,19 +132,30 @@ func (c *CustomPublisher) publish() error {
 	return nil
 }
 
-// formatWeatherData converts the internal weather data model to a format suitable for the custom API
-func formatWeatherData(data *models.WeatherData) CustomWeatherData {
+// formatWeatherData converts the internal weather data model to a format suitable for the custom API,
+// keeping only the measurements include accepts
+func formatWeatherData(data *models.WeatherData, include func(field string) bool) CustomWeatherData {
+	value := func(field string, v float64) *float64 {
+		if !include(field) {
+			return nil
+		}
+		return &v
+	}
+
 	return CustomWeatherData{
+		Station:       data.StationID,
 		Timestamp:     data.Timestamp.UTC().Format(time.RFC3339),
-		Temperature:   data.Temperature,
-		Humidity:      data.Humidity,
-		Pressure:      data.Pressure,
-		WindSpeed:     data.WindSpeed,
-		WindDirection: data.WindDirection,
-		Rain:          data.Rain,
-		UVIndex:       data.UVIndex,
-		DewPoint:      data.DewPoint,
-		WindChill:     data.WindChill,
-		HeatIndex:     data.HeatIndex,
+		Temperature:   value("temperature", data.Temperature),
+		Humidity:      value("humidity", data.Humidity),
+		Pressure:      value("pressure", data.Pressure),
+		WindSpeed:     value("windSpeed", data.WindSpeed),
+		WindDirection: value("windDirection", data.WindDirection),
+		RainRate:      value("rainRate", data.RainRate),
+		RainDay:       value("rainDay", data.RainDay),
+		UVIndex:       value("uvIndex", data.UVIndex),
+		DewPoint:      value("dewPoint", data.DewPoint),
+		WindChill:     value("windChill", data.WindChill),
+		HeatIndex:     value("heatIndex", data.HeatIndex),
+		QCFlags:       data.QCFlags,
 	}
 }
@@ -1,19 +1,21 @@
 package publisher
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"sync"
 	"time"
 
+	"github.com/ask-23/go-wx/internal/models"
 	"github.com/ask-23/go-wx/pkg/config"
 	"github.com/ask-23/go-wx/pkg/database"
 )
 
 // Publisher defines the interface for a service that publishes weather data
 type Publisher interface {
-	Start() error
-	Stop() error
+	Run(ctx context.Context) error
+	Shutdown(ctx context.Context) error
 	Name() string
 }
 
@@ -58,63 +60,74 @@ func InitializePublishers(configs []config.PublisherConfig, db *database.Databas
 type BasePublisher struct {
 	config       config.PublisherConfig
 	db           *database.Database
-	ticker       *time.Ticker
-	done         chan struct{}
-	wg           sync.WaitGroup
+	done         chan struct{} // closed to ask Run to return
+	stopped      chan struct{} // closed once Run has returned
 	running      bool
 	publisherMux sync.Mutex
+	publishFn    func() error
 }
 
-// Start begins the publishing process
-func (b *BasePublisher) Start() error {
+// Run publishes at the configured interval until the publisher is shut down
+// or ctx is canceled. A publish in progress is finished before it returns.
+func (b *BasePublisher) Run(ctx context.Context) error {
 	b.publisherMux.Lock()
-	defer b.publisherMux.Unlock()
-
 	if b.running {
+		b.publisherMux.Unlock()
 		return fmt.Errorf("publisher is already running")
 	}
-
-	// Set up ticker for periodic publishing
-	interval := time.Duration(b.config.Interval) * time.Second
-	b.ticker = time.NewTicker(interval)
-	b.done = make(chan struct{})
+	done, stopped := make(chan struct{}), make(chan struct{})
+	b.done, b.stopped = done, stopped
 	b.running = true
+	b.publisherMux.Unlock()
 
-	b.wg.Add(1)
-	go func() {
-		defer b.wg.Done()
-		for {
-			select {
-			case <-b.ticker.C:
-				if err := b.publish(); err != nil {
-					log.Printf("Error publishing to %s: %v", b.config.Name, err)
-				}
-			case <-b.done:
-				return
-			}
-		}
+	defer func() {
+		b.publisherMux.Lock()
+		b.running = false
+		b.publisherMux.Unlock()
+		close(stopped)
 	}()
 
+	// Set up ticker for periodic publishing
+	ticker := time.NewTicker(time.Duration(b.config.Interval) * time.Second)
+	defer ticker.Stop()
+
 	log.Printf("Started publisher: %s with interval %d seconds", b.config.Name, b.config.Interval)
-	return nil
+	for {
+		select {
+		case <-ticker.C:
+			if err := b.publish(); err != nil {
+				log.Printf("Error publishing to %s: %v", b.config.Name, err)
+			}
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
 }
 
-// Stop stops the publishing process
-func (b *BasePublisher) Stop() error {
+// Shutdown stops the publishing process, waiting for a publish in progress
+// until ctx is done
+func (b *BasePublisher) Shutdown(ctx context.Context) error {
 	b.publisherMux.Lock()
-	defer b.publisherMux.Unlock()
-
 	if !b.running {
+		b.publisherMux.Unlock()
 		return nil
 	}
+	if b.done != nil {
+		close(b.done)
+		b.done = nil
+	}
+	stopped := b.stopped
+	b.publisherMux.Unlock()
 
-	b.ticker.Stop()
-	close(b.done)
-	b.wg.Wait()
-	b.running = false
-
-	log.Printf("Stopped publisher: %s", b.config.Name)
-	return nil
+	select {
+	case <-stopped:
+		log.Printf("Stopped publisher: %s", b.config.Name)
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("publisher %s did not finish publishing: %w", b.config.Name, ctx.Err())
+	}
 }
 
 // Name returns the name of this publisher
@@ -122,8 +135,19 @@ func (b *BasePublisher) Name() string {
 	return b.config.Name
 }
 
-// publish is a placeholder for the actual publishing mechanism
-// It should be overridden by specific publisher implementations
+// publish runs the publishing mechanism of the embedding implementation.
+// Go has no virtual methods, so implementations register their own publish
+// method through publishFn when they are constructed.
 func (b *BasePublisher) publish() error {
-	return fmt.Errorf("publish not implemented")
+	if b.publishFn == nil {
+		return fmt.Errorf("publish not implemented")
+	}
+	return b.publishFn()
+}
+
+// include reports whether a measurement, named as in its JSON field, should
+// be published. Measurements that failed quality control are left out when
+// the publisher is configured to skip them.
+func (b *BasePublisher) include(data *models.WeatherData, field string) bool {
+	return !b.config.SkipFlagged || !data.Flagged(field)
 }
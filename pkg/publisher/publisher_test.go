@@ -1,6 +1,7 @@
 package publisher
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -44,18 +45,19 @@ func TestCustomPublisher(t *testing.T) {
 	defer testServer.Close()
 
 	// Create a custom publisher config
-	cfg := config.CustomPublisherConfig{
+	cfg := config.PublisherConfig{
+		Name:   "custom",
 		URL:    testServer.URL,
 		Method: "POST",
 		Headers: map[string]string{
 			"Content-Type": "application/json",
 			"X-API-Key":    "test-api-key",
 		},
-		Format: "json",
+		Interval: 600,
 	}
 
 	// Create a custom publisher
-	pub, err := NewCustomPublisher(cfg)
+	pub, err := NewCustomPublisher(cfg, nil)
 	if err != nil {
 		t.Fatalf("Failed to create custom publisher: %v", err)
 	}
@@ -69,7 +71,8 @@ func TestCustomPublisher(t *testing.T) {
 		Pressure:      1012.5,
 		WindSpeed:     5.5,
 		WindDirection: 180.0,
-		Rain:          0.0,
+		RainRate:      0.0,
+		RainDay:       12.7,
 		UVIndex:       5.0,
 		CloudBase:     1500.0,
 		DewPoint:      9.5,
@@ -78,7 +81,7 @@ func TestCustomPublisher(t *testing.T) {
 	}
 
 	// Publish the data
-	err = pub.Publish(data)
+	err = pub.(*CustomPublisher).send(data)
 	if err != nil {
 		t.Fatalf("Failed to publish data: %v", err)
 	}
@@ -101,6 +104,10 @@ func TestCustomPublisher(t *testing.T) {
 		t.Errorf("Expected pressure 1012.5, got %v", receivedData["pressure"])
 	}
 
+	if receivedData["rain_rate"] != 0.0 || receivedData["rain_day"] != 12.7 {
+		t.Errorf("Expected rain rate 0 and daily rain 12.7, got %v and %v", receivedData["rain_rate"], receivedData["rain_day"])
+	}
+
 	// Check that timestamp was formatted correctly (should be a string in RFC3339 format)
 	if timestamp, ok := receivedData["timestamp"].(string); !ok {
 		t.Errorf("Expected timestamp to be a string, got %T", receivedData["timestamp"])
@@ -144,32 +151,36 @@ func TestWundergroundPublisher(t *testing.T) {
 	serverURL := strings.TrimPrefix(testServer.URL, "http://")
 
 	// Create a Weather Underground publisher config
-	cfg := config.WundergroundConfig{
+	cfg := config.PublisherConfig{
+		Name:      "wunderground",
 		StationID: "KTEST123",
-		Password:  "testpassword",
-		BaseURL:   fmt.Sprintf("http://%s", serverURL), // Use the test server
+		APIKey:    "testpassword",
+		URL:       fmt.Sprintf("http://%s/weatherstation/updateweatherstation.php", serverURL), // Use the test server
+		Interval:  300,
 	}
 
 	// Create a Weather Underground publisher
-	pub, err := NewWundergroundPublisher(cfg)
+	pub, err := NewWundergroundPublisher(cfg, nil)
 	if err != nil {
 		t.Fatalf("Failed to create Weather Underground publisher: %v", err)
 	}
 
 	// Create a test weather data (using Imperial units to match WU expectations)
 	data := &models.WeatherData{
-		Timestamp:     time.Now(),
-		Temperature:   21.5, // Celsius
-		Humidity:      45.0,
-		Pressure:      1012.5, // hPa
-		WindSpeed:     5.5,    // m/s
-		WindDirection: 180.0,
-		Rain:          2.5, // mm
-		UVIndex:       5.0,
+		Timestamp:        time.Now(),
+		Temperature:      21.5, // Celsius
+		Humidity:         45.0,
+		Pressure:         1012.5, // hPa, at the station
+		RelativePressure: 1020.3, // hPa, at sea level
+		WindSpeed:        5.5,    // m/s
+		WindDirection:    180.0,
+		RainHour:         1.0, // mm in the clock hour so far
+		RainDay:          12.7,
+		UVIndex:          5.0,
 	}
 
 	// Publish the data
-	err = pub.Publish(data)
+	err = pub.(*WundergroundPublisher).send(data, 5.08) // mm in the past 60 minutes
 	if err != nil {
 		t.Fatalf("Failed to publish data: %v", err)
 	}
@@ -194,9 +205,9 @@ func TestWundergroundPublisher(t *testing.T) {
 		t.Errorf("Query string missing or incorrect tempf parameter, got: %s", requestQuery)
 	}
 
-	// Check for pressure in inches (1012.5 hPa = 29.89 inHg)
-	expectedInHg := 1012.5 / 33.86389
-	if !strings.Contains(requestQuery, fmt.Sprintf("baromin=%.2f", expectedInHg)) {
+	// Check for the sea level pressure in inches (1020.3 hPa = 30.13 inHg)
+	expectedInHg := 1020.3 / 33.86389
+	if !strings.Contains(requestQuery, fmt.Sprintf("baromin=%.3f", expectedInHg)) {
 		t.Errorf("Query string missing or incorrect baromin parameter, got: %s", requestQuery)
 	}
 
@@ -206,89 +217,142 @@ func TestWundergroundPublisher(t *testing.T) {
 		t.Errorf("Query string missing or incorrect windspeedmph parameter, got: %s", requestQuery)
 	}
 
-	// Check for rainfall in inches (2.5 mm = 0.098 in)
-	expectedInches := 2.5 / 25.4
-	if !strings.Contains(requestQuery, fmt.Sprintf("rainin=%.3f", expectedInches)) {
+	// Check for the rain of the past 60 minutes in inches (5.08 mm = 0.20 in)
+	if !strings.Contains(requestQuery, "rainin=0.20") {
 		t.Errorf("Query string missing or incorrect rainin parameter, got: %s", requestQuery)
 	}
+	if !strings.Contains(requestQuery, "dailyrainin=0.50") {
+		t.Errorf("Query string missing or incorrect dailyrainin parameter, got: %s", requestQuery)
+	}
 }
 
-// TestMultiPublisher tests the multi-publisher implementation
-func TestMultiPublisher(t *testing.T) {
-	// Create mock publishers to track calls
-	mockPub1 := &mockPublisher{t: t, name: "Mock1"}
-	mockPub2 := &mockPublisher{t: t, name: "Mock2"}
-
-	// Create a multi-publisher with the mocks
-	multiPub := NewMultiPublisher([]Publisher{mockPub1, mockPub2})
+// TestPublisherDispatch tests that the base publisher runs the implementation's publish method
+func TestPublisherDispatch(t *testing.T) {
+	pub, err := NewCustomPublisher(config.PublisherConfig{Name: "custom", URL: "http://localhost"}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create custom publisher: %v", err)
+	}
 
-	// Create a test weather data
-	data := &models.WeatherData{
-		Timestamp:     time.Now(),
-		Temperature:   21.5,
-		Humidity:      45.0,
-		Pressure:      1012.5,
-		WindSpeed:     5.5,
-		WindDirection: 180.0,
-		Rain:          0.0,
-		UVIndex:       5.0,
+	calls := 0
+	pub.(*CustomPublisher).publishFn = func() error {
+		calls++
+		return nil
 	}
 
-	// Publish the data using the multi-publisher
-	err := multiPub.Publish(data)
-	if err != nil {
-		t.Fatalf("Failed to publish data: %v", err)
+	if err := pub.(*CustomPublisher).BasePublisher.publish(); err != nil {
+		t.Fatalf("publish returned error: %v", err)
 	}
 
-	// Verify that both mock publishers were called
-	if mockPub1.callCount != 1 {
-		t.Errorf("Expected Mock1 to be called once, got %d calls", mockPub1.callCount)
+	if calls != 1 {
+		t.Errorf("Expected implementation publish to be called once, got %d calls", calls)
 	}
+}
 
-	if mockPub2.callCount != 1 {
-		t.Errorf("Expected Mock2 to be called once, got %d calls", mockPub2.callCount)
+// TestPublisherShutdown tests that shutting down waits for a publish in progress
+func TestPublisherShutdown(t *testing.T) {
+	pub, err := NewCustomPublisher(config.PublisherConfig{Name: "custom", URL: "http://localhost", Interval: 1}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create custom publisher: %v", err)
 	}
 
-	// Test error handling when one publisher fails
-	mockPub1.shouldError = true
+	started := make(chan struct{})
+	release := make(chan struct{})
+	finished := false
+	pub.(*CustomPublisher).publishFn = func() error {
+		close(started)
+		<-release
+		finished = true
+		return nil
+	}
 
-	// Publish again, with Mock1 set to return an error
-	err = multiPub.Publish(data)
+	result := make(chan error)
+	go func() { result <- pub.Run(context.Background()) }()
+	<-started
 
-	// The overall operation should still succeed (errors are logged, not returned)
-	if err != nil {
-		t.Fatalf("Expected success despite one publisher failing, got error: %v", err)
+	// A publisher runs once at a time
+	if err := pub.Run(context.Background()); err == nil {
+		t.Errorf("Expected an error running a publisher twice")
 	}
 
-	// Verify call counts
-	if mockPub1.callCount != 2 {
-		t.Errorf("Expected Mock1 to be called twice, got %d calls", mockPub1.callCount)
+	// Shutting down gives up on a publish that does not finish in time
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := pub.Shutdown(ctx); err == nil {
+		t.Errorf("Expected an error shutting down during a stuck publish")
 	}
 
-	if mockPub2.callCount != 2 {
-		t.Errorf("Expected Mock2 to be called twice, got %d calls", mockPub2.callCount)
+	close(release)
+	if err := pub.Shutdown(context.Background()); err != nil {
+		t.Errorf("Expected shutdown to succeed, got %v", err)
+	}
+	if !finished {
+		t.Errorf("Expected the publish in progress to finish")
+	}
+	if err := <-result; err != nil {
+		t.Errorf("Expected run to return nil, got %v", err)
 	}
 }
 
-// mockPublisher implements the Publisher interface for testing
-type mockPublisher struct {
-	t           *testing.T
-	name        string
-	callCount   int
-	shouldError bool
-}
+// TestSkipFlagged tests that measurements failing quality control are left out
+func TestSkipFlagged(t *testing.T) {
+	var requestQuery string
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestQuery = r.URL.RawQuery
+		w.Write([]byte("success"))
+	}))
+	defer testServer.Close()
 
-func (m *mockPublisher) Publish(data *models.WeatherData) error {
-	m.callCount++
+	data := &models.WeatherData{
+		Timestamp:   time.Now(),
+		Temperature: -40,
+		Humidity:    0,
+		Pressure:    1012.5,
+	}
+	data.Flag("temperature", "consistency")
+	data.Flag("humidity", "range")
+	data.Flag("rainRate", "range")
+
+	cfg := config.PublisherConfig{
+		Name:        "wunderground",
+		StationID:   "KTEST123",
+		APIKey:      "testpassword",
+		URL:         testServer.URL,
+		SkipFlagged: true,
+	}
+	pub, err := NewWundergroundPublisher(cfg, nil)
+	if err != nil {
+		t.Fatalf("Failed to create Weather Underground publisher: %v", err)
+	}
+	if err := pub.(*WundergroundPublisher).send(data, 1); err != nil {
+		t.Fatalf("Failed to publish data: %v", err)
+	}
 
-	if m.shouldError {
-		return fmt.Errorf("%s publisher error", m.name)
+	if strings.Contains(requestQuery, "tempf=") || strings.Contains(requestQuery, "humidity=") {
+		t.Errorf("Expected flagged measurements to be skipped, got: %s", requestQuery)
+	}
+	if !strings.Contains(requestQuery, "rainin=0.04") || !strings.Contains(requestQuery, "dailyrainin=") {
+		t.Errorf("Expected the rain totals to be published despite a flagged rate, got: %s", requestQuery)
+	}
+	expectedInHg := 1012.5 / 33.86389
+	if !strings.Contains(requestQuery, fmt.Sprintf("baromin=%.3f", expectedInHg)) {
+		t.Errorf("Expected the station pressure without a sea level pressure, got: %s", requestQuery)
 	}
 
-	// Verify the data
-	if data == nil {
-		m.t.Errorf("%s received nil data", m.name)
+	// Without skip_flagged everything is published
+	formatted := formatWeatherData(data, func(string) bool { return true })
+	if formatted.Temperature == nil || formatted.QCFlags["temperature"] != "consistency" {
+		t.Errorf("Expected flagged temperature and its flag to be included, got %+v", formatted)
 	}
 
-	return nil
+	custom, err := NewCustomPublisher(config.PublisherConfig{Name: "custom", URL: "http://localhost", SkipFlagged: true}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create custom publisher: %v", err)
+	}
+	formatted = formatWeatherData(data, func(field string) bool { return custom.(*CustomPublisher).include(data, field) })
+	if formatted.Temperature != nil || formatted.Humidity != nil {
+		t.Errorf("Expected flagged measurements to be omitted, got %+v", formatted)
+	}
+	if formatted.Pressure == nil || *formatted.Pressure != 1012.5 {
+		t.Errorf("Expected pressure 1012.5, got %v", formatted.Pressure)
+	}
 }
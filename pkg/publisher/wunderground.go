@@ -8,15 +8,21 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/ask-23/go-wx/internal/models"
 	"github.com/ask-23/go-wx/pkg/config"
 	"github.com/ask-23/go-wx/pkg/database"
+	"github.com/ask-23/go-wx/pkg/units"
 )
 
+// wundergroundURL is the Weather Underground personal weather station upload endpoint
+const wundergroundURL = "https://weatherstation.wunderground.com/weatherstation/updateweatherstation.php"
+
 // WundergroundPublisher publishes weather data to Weather Underground
 type WundergroundPublisher struct {
 	BasePublisher
 	stationID string
 	apiKey    string
+	baseURL   string
 }
 
 // NewWundergroundPublisher creates a new Weather Underground publisher
@@ -29,7 +35,13 @@ func NewWundergroundPublisher(cfg config.PublisherConfig, db *database.Database)
 		return nil, fmt.Errorf("Weather Underground publisher requires api_key")
 	}
 
-	return &WundergroundPublisher{
+	// Allow the upload endpoint to be overridden, e.g. for a relay
+	baseURL := cfg.URL
+	if baseURL == "" {
+		baseURL = wundergroundURL
+	}
+
+	pub := &WundergroundPublisher{
 		BasePublisher: BasePublisher{
 			config:  cfg,
 			db:      db,
@@ -37,13 +49,17 @@ func NewWundergroundPublisher(cfg config.PublisherConfig, db *database.Database)
 		},
 		stationID: cfg.StationID,
 		apiKey:    cfg.APIKey,
-	}, nil
+		baseURL:   baseURL,
+	}
+	pub.publishFn = pub.publish
+
+	return pub, nil
 }
 
 // publish sends weather data to Weather Underground
 func (w *WundergroundPublisher) publish() error {
 	// Get the latest weather data from the database
-	data, err := w.db.GetLatestWeatherData()
+	data, err := w.db.GetLatestWeatherData(w.config.Station)
 	if err != nil {
 		return fmt.Errorf("failed to get latest weather data: %w", err)
 	}
@@ -53,9 +69,19 @@ func (w *WundergroundPublisher) publish() error {
 		return fmt.Errorf("weather data is too old for publishing (timestamp: %v)", data.Timestamp)
 	}
 
-	// Build the Weather Underground API URL
-	baseURL := "https://weatherstation.wunderground.com/weatherstation/updateweatherstation.php"
+	// The protocol's rainin is the rain over the past 60 minutes
+	rainLastHour, err := w.db.SumRainFall(data.StationID, data.Timestamp.Add(-time.Hour), data.Timestamp)
+	if err != nil {
+		return fmt.Errorf("failed to get the rain of the past hour: %w", err)
+	}
+
+	return w.send(data, rainLastHour)
+}
 
+// send uploads a single observation to Weather Underground with the rain of
+// the past 60 minutes in millimeters, converting the stored SI units into
+// the imperial units the upload protocol expects
+func (w *WundergroundPublisher) send(data *models.WeatherData, rainLastHour float64) error {
 	// Create the query parameters
 	params := url.Values{}
 	params.Set("ID", w.stationID)
@@ -64,21 +90,46 @@ func (w *WundergroundPublisher) publish() error {
 	params.Set("action", "updateraw")
 
 	// Add weather data
-	params.Set("tempf", strconv.FormatFloat(data.Temperature, 'f', 1, 64))
-	params.Set("humidity", strconv.FormatFloat(data.Humidity, 'f', 1, 64))
-	params.Set("baromin", strconv.FormatFloat(data.Pressure*0.02953, 'f', 3, 64)) // Convert mbar to inHg
-	params.Set("windspeedmph", strconv.FormatFloat(data.WindSpeed, 'f', 1, 64))
-	params.Set("winddir", strconv.FormatFloat(data.WindDirection, 'f', 0, 64))
-	params.Set("rainin", strconv.FormatFloat(data.Rain, 'f', 2, 64))
-	params.Set("dewptf", strconv.FormatFloat(data.DewPoint, 'f', 1, 64))
+	if w.include(data, "temperature") {
+		params.Set("tempf", strconv.FormatFloat(float64(units.Celsius(data.Temperature).Fahrenheit()), 'f', 1, 64))
+	}
+	if w.include(data, "humidity") {
+		params.Set("humidity", strconv.FormatFloat(data.Humidity, 'f', 1, 64))
+	}
+	// The protocol's baromin is the sea level pressure; fall back to the
+	// station pressure from consoles that do not report it
+	if data.RelativePressure != 0 {
+		if w.include(data, "relativePressure") {
+			params.Set("baromin", strconv.FormatFloat(float64(units.HPa(data.RelativePressure).InHg()), 'f', 3, 64))
+		}
+	} else if w.include(data, "pressure") {
+		params.Set("baromin", strconv.FormatFloat(float64(units.HPa(data.Pressure).InHg()), 'f', 3, 64))
+	}
+	if w.include(data, "windSpeed") {
+		params.Set("windspeedmph", strconv.FormatFloat(float64(units.MetersPerSecond(data.WindSpeed).MilesPerHour()), 'f', 1, 64))
+	}
+	if w.include(data, "windDirection") {
+		params.Set("winddir", strconv.FormatFloat(data.WindDirection, 'f', 0, 64))
+	}
+	// The rain totals come from the counters rather than the rate, so a
+	// flagged rate does not hide them
+	if w.include(data, "rainFall") {
+		params.Set("rainin", strconv.FormatFloat(float64(units.Millimeters(rainLastHour).Inches()), 'f', 2, 64))
+	}
+	if w.include(data, "rainDay") {
+		params.Set("dailyrainin", strconv.FormatFloat(float64(units.Millimeters(data.RainDay).Inches()), 'f', 2, 64))
+	}
+	if w.include(data, "dewPoint") {
+		params.Set("dewptf", strconv.FormatFloat(float64(units.Celsius(data.DewPoint).Fahrenheit()), 'f', 1, 64))
+	}
 
 	// Add UV index if available
-	if data.UVIndex > 0 {
+	if data.UVIndex > 0 && w.include(data, "uvIndex") {
 		params.Set("UV", strconv.FormatFloat(data.UVIndex, 'f', 1, 64))
 	}
 
 	// Make the HTTP request
-	apiURL := baseURL + "?" + params.Encode()
+	apiURL := w.baseURL + "?" + params.Encode()
 
 	// Use a client with timeout
 	client := &http.Client{
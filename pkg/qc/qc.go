@@ -0,0 +1,339 @@
+package qc
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ask-23/go-wx/internal/models"
+	"github.com/ask-23/go-wx/pkg/config"
+)
+
+// Names of the quality control checks recorded in QC flags
+const (
+	CheckRange       = "range"       // outside the plausible range
+	CheckSpike       = "spike"       // changed too much since the previous reading
+	CheckStuck       = "stuck"       // unchanged for longer than the stuck period
+	CheckConsistency = "consistency" // contradicts another measurement
+	CheckDerived     = "derived"     // calculated from a flagged measurement
+)
+
+// Limit bounds the plausible values of a measurement
+type Limit struct {
+	Min     float64
+	Max     float64
+	MaxStep float64 // largest change between consecutive readings; 0 disables the step check
+	Persist bool    // whether an unchanging value indicates a stuck sensor
+}
+
+// DefaultLimits are the limits applied to each checked measurement, keyed by
+// the measurement's JSON name
+var DefaultLimits = map[string]Limit{
+	"temperature":      {Min: -60, Max: 65, MaxStep: 10, Persist: true},
+	"humidity":         {Min: 1, Max: 100, MaxStep: 40},
+	"pressure":         {Min: 700, Max: 1100, MaxStep: 10, Persist: true},
+	"relativePressure": {Min: 850, Max: 1100, MaxStep: 10, Persist: true},
+	"windSpeed":        {Min: 0, Max: 75, MaxStep: 30},
+	"windGust":         {Min: 0, Max: 100, MaxStep: 40},
+	"windDirection":    {Min: 0, Max: 360},
+	"rainRate":         {Min: 0, Max: 500},
+	"solarRadiation":   {Min: 0, Max: 1800},
+	"uvIndex":          {Min: 0, Max: 20},
+
+	"indoorTemperature": {Min: -40, Max: 60, MaxStep: 10},
+	"indoorHumidity":    {Min: 0, Max: 100},
+}
+
+// fields maps measurement names to the WeatherData fields they check
+var fields = map[string]func(data *models.WeatherData) float64{
+	"temperature":      func(d *models.WeatherData) float64 { return d.Temperature },
+	"humidity":         func(d *models.WeatherData) float64 { return d.Humidity },
+	"pressure":         func(d *models.WeatherData) float64 { return d.Pressure },
+	"relativePressure": func(d *models.WeatherData) float64 { return d.RelativePressure },
+	"windSpeed":        func(d *models.WeatherData) float64 { return d.WindSpeed },
+	"windGust":         func(d *models.WeatherData) float64 { return d.WindGust },
+	"windDirection":    func(d *models.WeatherData) float64 { return d.WindDirection },
+	"rainRate":         func(d *models.WeatherData) float64 { return d.RainRate },
+	"solarRadiation":   func(d *models.WeatherData) float64 { return d.SolarRadiation },
+	"uvIndex":          func(d *models.WeatherData) float64 { return d.UVIndex },
+}
+
+// indoorField is an indoor reading checked against the limits of a measurement
+type indoorField struct {
+	name  string // JSON name of the reading on IndoorData, as used in its QC flags
+	value func(d *models.IndoorData) float64
+}
+
+// indoorFields maps measurement names to the IndoorData readings they check
+var indoorFields = map[string]indoorField{
+	"indoorTemperature": {"temperature", func(d *models.IndoorData) float64 { return d.Temperature }},
+	"indoorHumidity":    {"humidity", func(d *models.IndoorData) float64 { return d.Humidity }},
+}
+
+// derived lists the measurements each derived value is calculated from
+var derived = map[string][]string{
+	"dewPoint":  {"temperature", "humidity"},
+	"windChill": {"temperature", "windSpeed"},
+	"heatIndex": {"temperature", "humidity"},
+	"cloudBase": {"temperature", "humidity"},
+}
+
+// indoorDerived lists the indoor readings each derived indoor value is calculated from
+var indoorDerived = map[string][]string{
+	"dewPoint":         {"temperature", "humidity"},
+	"absoluteHumidity": {"temperature", "humidity"},
+}
+
+// lostSensorTemperature is reported by Ecowitt consoles when they lose
+// contact with a temperature sensor (-40°F, which is also -40°C)
+const lostSensorTemperature = -40
+
+// reading is a measurement value and the time it was observed
+type reading struct {
+	value float64
+	time  time.Time
+}
+
+// history is the state kept between observations from one station
+type history struct {
+	good    map[string]reading // last reading that passed every check
+	changed map[string]reading // value and time of the last change
+}
+
+// Checker runs quality control checks on observations and flags the
+// measurements that fail them
+type Checker struct {
+	limits      map[string]Limit
+	maxGap      time.Duration
+	stuckPeriod time.Duration
+	stations    map[string]*history
+	mutex       sync.Mutex
+}
+
+// NewChecker creates a checker from the quality control configuration. It
+// returns nil when quality control is disabled; a nil checker passes every
+// observation.
+func NewChecker(cfg config.QCConfig) (*Checker, error) {
+	if cfg.Disabled {
+		return nil, nil
+	}
+
+	limits := make(map[string]Limit, len(DefaultLimits))
+	for name, limit := range DefaultLimits {
+		limits[name] = limit
+	}
+
+	// Apply the configured overrides
+	for name, override := range cfg.Limits {
+		limit, ok := limits[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown QC measurement: %s (known: %v)", name, Names())
+		}
+		if override.Min != nil {
+			limit.Min = *override.Min
+		}
+		if override.Max != nil {
+			limit.Max = *override.Max
+		}
+		if override.MaxStep != nil {
+			limit.MaxStep = *override.MaxStep
+		}
+		if limit.Min > limit.Max {
+			return nil, fmt.Errorf("QC limits for %s have min %v above max %v", name, limit.Min, limit.Max)
+		}
+		limits[name] = limit
+	}
+
+	return &Checker{
+		limits:      limits,
+		maxGap:      time.Duration(cfg.MaxGap) * time.Second,
+		stuckPeriod: time.Duration(cfg.StuckPeriod) * time.Second,
+		stations:    make(map[string]*history),
+	}, nil
+}
+
+// Names returns the names of the checked measurements in sorted order
+func Names() []string {
+	names := make([]string, 0, len(DefaultLimits))
+	for name := range DefaultLimits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Check runs every quality control check on data, recording failures in its
+// QC flags. Only the measurements the device reported are checked. Readings
+// are compared with earlier observations from the same station, so
+// observations should be checked in the order they were taken.
+func (c *Checker) Check(data *models.WeatherData) {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	h, ok := c.stations[data.StationID]
+	if !ok {
+		h = &history{
+			good:    make(map[string]reading),
+			changed: make(map[string]reading),
+		}
+		c.stations[data.StationID] = h
+	}
+
+	c.checkRanges(data)
+	checkConsistency(data)
+	c.checkSteps(data, h)
+	c.checkPersistence(data, h)
+	c.checkIndoor(data, h)
+
+	// Remember the readings that passed for the next observation
+	for name, value := range fields {
+		if data.HasReported(name) && !data.Flagged(name) {
+			h.good[name] = reading{value(data), data.Timestamp}
+		}
+	}
+
+	// Values calculated from flagged measurements are unreliable too
+	FlagDerived(data)
+}
+
+// FlagDerived flags the derived values of data calculated from flagged measurements
+func FlagDerived(data *models.WeatherData) {
+	for name, inputs := range derived {
+		for _, input := range inputs {
+			if data.Flagged(input) {
+				data.Flag(name, CheckDerived)
+				break
+			}
+		}
+	}
+}
+
+// checkRanges flags measurements outside their plausible range
+func (c *Checker) checkRanges(data *models.WeatherData) {
+	for name, value := range fields {
+		if !data.HasReported(name) {
+			continue
+		}
+		limit := c.limits[name]
+		v := value(data)
+		if v < limit.Min || v > limit.Max || math.IsNaN(v) {
+			data.Flag(name, CheckRange)
+		}
+	}
+}
+
+// checkConsistency flags measurements that contradict each other
+func checkConsistency(data *models.WeatherData) {
+	// A gust is never slower than the average wind speed
+	if reported(data, "windGust", "windSpeed") && data.WindGust < data.WindSpeed {
+		data.Flag("windGust", CheckConsistency)
+	}
+
+	// A lost sensor reads -40 degrees with no humidity
+	if reported(data, "temperature", "humidity") &&
+		data.Temperature == lostSensorTemperature && data.Humidity <= 1 {
+		data.Flag("temperature", CheckConsistency)
+		data.Flag("humidity", CheckConsistency)
+	}
+}
+
+// checkSteps flags measurements that jumped too far from the last good
+// reading. Readings further apart than the maximum gap are not compared, so a
+// genuine shift is accepted once the gap has passed.
+func (c *Checker) checkSteps(data *models.WeatherData, h *history) {
+	for name, value := range fields {
+		limit := c.limits[name]
+		if limit.MaxStep <= 0 || !data.HasReported(name) || data.Flagged(name) {
+			continue
+		}
+		prev, ok := h.good[name]
+		if !ok {
+			continue
+		}
+		gap := data.Timestamp.Sub(prev.time)
+		if gap <= 0 || gap > c.maxGap {
+			continue
+		}
+		if math.Abs(value(data)-prev.value) > limit.MaxStep {
+			data.Flag(name, CheckSpike)
+		}
+	}
+}
+
+// checkPersistence flags measurements that have not changed for longer than
+// the stuck period
+func (c *Checker) checkPersistence(data *models.WeatherData, h *history) {
+	if c.stuckPeriod <= 0 {
+		return
+	}
+
+	for name, value := range fields {
+		if !c.limits[name].Persist || !data.HasReported(name) {
+			continue
+		}
+		v := value(data)
+		last, ok := h.changed[name]
+		if !ok || v != last.value || data.Timestamp.Before(last.time) {
+			h.changed[name] = reading{v, data.Timestamp}
+			continue
+		}
+		if data.Timestamp.Sub(last.time) > c.stuckPeriod {
+			data.Flag(name, CheckStuck)
+		}
+	}
+}
+
+// checkIndoor runs the range and step checks on the indoor readings of data,
+// flagging failures on the indoor readings under their own names
+func (c *Checker) checkIndoor(data *models.WeatherData, h *history) {
+	indoor := data.Indoor
+	if indoor == nil {
+		return
+	}
+
+	for name, f := range indoorFields {
+		if !data.HasReported(name) {
+			continue
+		}
+		limit := c.limits[name]
+		v := f.value(indoor)
+		if v < limit.Min || v > limit.Max || math.IsNaN(v) {
+			indoor.Flag(f.name, CheckRange)
+			continue
+		}
+
+		if prev, ok := h.good[name]; ok && limit.MaxStep > 0 {
+			gap := indoor.Timestamp.Sub(prev.time)
+			if gap > 0 && gap <= c.maxGap && math.Abs(v-prev.value) > limit.MaxStep {
+				indoor.Flag(f.name, CheckSpike)
+				continue
+			}
+		}
+		h.good[name] = reading{v, indoor.Timestamp}
+	}
+
+	for name, inputs := range indoorDerived {
+		for _, input := range inputs {
+			if indoor.Flagged(input) {
+				indoor.Flag(name, CheckDerived)
+				break
+			}
+		}
+	}
+}
+
+// reported reports whether data reported every one of the named measurements
+func reported(data *models.WeatherData, names ...string) bool {
+	for _, name := range names {
+		if !data.HasReported(name) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,282 @@
+package qc
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/ask-23/go-wx/internal/models"
+	"github.com/ask-23/go-wx/pkg/config"
+)
+
+// goodData returns a plausible observation taken at ts
+func goodData(ts time.Time) *models.WeatherData {
+	return &models.WeatherData{
+		StationID:        models.DefaultStationID,
+		Timestamp:        ts,
+		Temperature:      20,
+		Humidity:         50,
+		Pressure:         1000,
+		RelativePressure: 1013,
+		WindSpeed:        3,
+		WindGust:         5,
+		WindDirection:    180,
+	}
+}
+
+// newTestChecker creates a checker with the default windows
+func newTestChecker(t *testing.T) *Checker {
+	checker, err := NewChecker(config.QCConfig{MaxGap: 900, StuckPeriod: 3600})
+	if err != nil {
+		t.Fatalf("NewChecker returned error: %v", err)
+	}
+	return checker
+}
+
+// TestRangeCheck tests flagging of implausible values
+func TestRangeCheck(t *testing.T) {
+	checker := newTestChecker(t)
+
+	data := goodData(time.Now())
+	checker.Check(data)
+	if len(data.QCFlags) != 0 {
+		t.Errorf("Expected no flags for plausible data, got %v", data.QCFlags)
+	}
+
+	data = goodData(time.Now())
+	data.Humidity = 0
+	data.SolarRadiation = 5000
+	checker.Check(data)
+
+	if data.QCFlags["humidity"] != CheckRange {
+		t.Errorf("Expected humidity range flag, got %v", data.QCFlags)
+	}
+	if data.QCFlags["solarRadiation"] != CheckRange {
+		t.Errorf("Expected solar radiation range flag, got %v", data.QCFlags)
+	}
+
+	// Derived values follow the measurements they use
+	if data.QCFlags["dewPoint"] != CheckDerived {
+		t.Errorf("Expected dew point derived flag, got %v", data.QCFlags)
+	}
+
+	// The failing value is kept
+	if data.Humidity != 0 {
+		t.Errorf("Expected humidity to be kept, got %.1f", data.Humidity)
+	}
+}
+
+// TestSpikeCheck tests flagging of sudden jumps between readings
+func TestSpikeCheck(t *testing.T) {
+	checker := newTestChecker(t)
+	start := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	checker.Check(goodData(start))
+
+	spike := goodData(start.Add(time.Minute))
+	spike.Temperature = 35
+	checker.Check(spike)
+	if spike.QCFlags["temperature"] != CheckSpike {
+		t.Errorf("Expected temperature spike flag, got %v", spike.QCFlags)
+	}
+
+	// The next reading is compared with the last good one
+	back := goodData(start.Add(2 * time.Minute))
+	back.Temperature = 20.5
+	checker.Check(back)
+	if back.Flagged("temperature") {
+		t.Errorf("Expected no flag after the spike, got %v", back.QCFlags)
+	}
+
+	// Readings after a long gap are not compared
+	later := goodData(start.Add(time.Hour))
+	later.Temperature = 35
+	checker.Check(later)
+	if later.Flagged("temperature") {
+		t.Errorf("Expected no flag after a gap, got %v", later.QCFlags)
+	}
+
+	// Other stations keep their own history
+	other := goodData(start.Add(61 * time.Minute))
+	other.StationID = "roof"
+	other.Temperature = 5
+	checker.Check(other)
+	if other.Flagged("temperature") {
+		t.Errorf("Expected no flag for another station's first reading, got %v", other.QCFlags)
+	}
+}
+
+// TestPersistenceCheck tests flagging of stuck sensors
+func TestPersistenceCheck(t *testing.T) {
+	checker := newTestChecker(t)
+	start := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	var data *models.WeatherData
+	for m := 0; m <= 90; m += 5 {
+		data = goodData(start.Add(time.Duration(m) * time.Minute))
+		data.Pressure = 1000 + float64(m)/10
+		checker.Check(data)
+	}
+
+	if data.QCFlags["temperature"] != CheckStuck {
+		t.Errorf("Expected temperature stuck flag after 90 minutes, got %v", data.QCFlags)
+	}
+	if data.Flagged("pressure") {
+		t.Errorf("Expected changing pressure not to be flagged, got %v", data.QCFlags)
+	}
+	if data.Flagged("humidity") {
+		t.Errorf("Expected humidity to be exempt from the persistence check, got %v", data.QCFlags)
+	}
+}
+
+// TestConsistencyCheck tests flagging of contradictory measurements
+func TestConsistencyCheck(t *testing.T) {
+	checker := newTestChecker(t)
+
+	data := goodData(time.Now())
+	data.WindSpeed = 10
+	data.WindGust = 4
+	checker.Check(data)
+	if data.QCFlags["windGust"] != CheckConsistency {
+		t.Errorf("Expected wind gust consistency flag, got %v", data.QCFlags)
+	}
+
+	data = goodData(time.Now())
+	data.Temperature = -40
+	data.Humidity = 1
+	checker.Check(data)
+	if data.QCFlags["temperature"] != CheckConsistency {
+		t.Errorf("Expected lost sensor temperature flag, got %v", data.QCFlags)
+	}
+}
+
+// TestUnreportedMeasurements tests that measurements the device did not
+// report are not checked
+func TestUnreportedMeasurements(t *testing.T) {
+	checker := newTestChecker(t)
+
+	// No gust or humidity, which are left at 0
+	data := goodData(time.Now())
+	data.WindGust = 0
+	data.Humidity = 0
+	data.Report("temperature", "pressure", "relativePressure", "windSpeed", "windDirection")
+	checker.Check(data)
+	if len(data.QCFlags) != 0 {
+		t.Errorf("Expected no flags for unreported measurements, got %v", data.QCFlags)
+	}
+
+	// A reported value is still checked
+	data = goodData(time.Now())
+	data.Humidity = 0
+	data.Report("humidity")
+	checker.Check(data)
+	if data.QCFlags["humidity"] != CheckRange {
+		t.Errorf("Expected humidity range flag, got %v", data.QCFlags)
+	}
+	if data.Flagged("windGust") {
+		t.Errorf("Expected unreported wind gust not to be flagged, got %v", data.QCFlags)
+	}
+}
+
+// TestDerivedFromFlagged tests that the derived values recalculated after the
+// checks leave out flagged measurements and stay finite
+func TestDerivedFromFlagged(t *testing.T) {
+	checker := newTestChecker(t)
+
+	// A humidity of 0 has no dew point
+	data := goodData(time.Now())
+	data.Humidity = 0
+	checker.Check(data)
+	data.CalculateDerivedValues()
+	for name, value := range map[string]float64{
+		"dewPoint": data.DewPoint, "heatIndex": data.HeatIndex,
+		"windChill": data.WindChill, "cloudBase": data.CloudBase,
+	} {
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			t.Errorf("Expected finite %s, got %v", name, value)
+		}
+	}
+	if data.DewPoint != 0 || data.CloudBase != 0 {
+		t.Errorf("Expected no dew point or cloud base from flagged humidity, got %.1f and %.1f",
+			data.DewPoint, data.CloudBase)
+	}
+	if data.WindChill != data.Temperature {
+		t.Errorf("Expected the wind chill of the good temperature, got %.1f", data.WindChill)
+	}
+
+	// Nothing is calculated from a flagged temperature
+	data = goodData(time.Now())
+	data.Temperature = 90
+	checker.Check(data)
+	data.CalculateDerivedValues()
+	if !data.Flagged("temperature") {
+		t.Fatalf("Expected temperature range flag, got %v", data.QCFlags)
+	}
+	if data.DewPoint != 0 || data.HeatIndex != 0 || data.WindChill != 0 || data.CloudBase != 0 {
+		t.Errorf("Expected no derived values from flagged temperature, got dew point %.1f, heat index %.1f, wind chill %.1f and cloud base %.1f",
+			data.DewPoint, data.HeatIndex, data.WindChill, data.CloudBase)
+	}
+}
+
+// TestIndoorCheck tests the limits on indoor readings
+func TestIndoorCheck(t *testing.T) {
+	checker := newTestChecker(t)
+	now := time.Now()
+
+	data := goodData(now)
+	data.Indoor = &models.IndoorData{Timestamp: now, Temperature: 21, Humidity: 120}
+	checker.Check(data)
+	if data.Indoor.QCFlags["humidity"] != CheckRange {
+		t.Errorf("Expected indoor humidity range flag, got %v", data.Indoor.QCFlags)
+	}
+	if data.Indoor.QCFlags["dewPoint"] != CheckDerived {
+		t.Errorf("Expected indoor dew point derived flag, got %v", data.Indoor.QCFlags)
+	}
+	if data.Indoor.Flagged("temperature") || len(data.QCFlags) != 0 {
+		t.Errorf("Expected only indoor humidity flags, got %v and %v", data.Indoor.QCFlags, data.QCFlags)
+	}
+
+	// Indoor temperature jumps are checked apart from outdoor temperature
+	data = goodData(now.Add(time.Minute))
+	data.Indoor = &models.IndoorData{Timestamp: now.Add(time.Minute), Temperature: 35, Humidity: 40}
+	checker.Check(data)
+	if data.Indoor.QCFlags["temperature"] != CheckSpike {
+		t.Errorf("Expected indoor temperature spike flag, got %v", data.Indoor.QCFlags)
+	}
+}
+
+// TestNewChecker tests configuration of the checker
+func TestNewChecker(t *testing.T) {
+	checker, err := NewChecker(config.QCConfig{Disabled: true})
+	if err != nil || checker != nil {
+		t.Fatalf("Expected nil checker when disabled, got %v (%v)", checker, err)
+	}
+
+	// A nil checker passes everything
+	data := goodData(time.Now())
+	data.Humidity = 0
+	checker.Check(data)
+	if len(data.QCFlags) != 0 {
+		t.Errorf("Expected no flags from a disabled checker, got %v", data.QCFlags)
+	}
+
+	maxTemp := 30.0
+	checker, err = NewChecker(config.QCConfig{
+		Limits: map[string]config.QCLimitConfig{"temperature": {Max: &maxTemp}},
+	})
+	if err != nil {
+		t.Fatalf("NewChecker returned error: %v", err)
+	}
+	data = goodData(time.Now())
+	data.Temperature = 31
+	checker.Check(data)
+	if data.QCFlags["temperature"] != CheckRange {
+		t.Errorf("Expected overridden temperature range flag, got %v", data.QCFlags)
+	}
+
+	if _, err := NewChecker(config.QCConfig{
+		Limits: map[string]config.QCLimitConfig{"snow": {Max: &maxTemp}},
+	}); err == nil {
+		t.Errorf("Expected error for an unknown measurement")
+	}
+}
@@ -0,0 +1,209 @@
+package rain
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/ask-23/go-wx/internal/models"
+)
+
+// maxRate is the highest plausible rain rate in millimeters per hour. Larger
+// counter increases are treated as glitches rather than rain.
+const maxRate = 500
+
+// Store is the database access a tracker uses to resume a station's counters
+// and totals from the stored observations
+type Store interface {
+	GetWeatherDataBefore(station string, ts time.Time) (*models.WeatherData, error)
+	SumRainFall(station string, start, end time.Time) (float64, error)
+}
+
+// period is a span of time rain totals are kept for
+type period int
+
+const (
+	hour period = iota
+	day
+	month
+	rainYear
+	periods
+)
+
+// counters are the cumulative rain counters reported by a console
+type counters struct {
+	total float64
+	daily float64
+	event float64
+}
+
+// station is the rain state of one station
+type station struct {
+	last     *counters // counters of the previous observation, nil before the first
+	lastTime time.Time
+	starts   [periods]time.Time // start of the period each total covers
+	totals   [periods]float64
+}
+
+// Tracker turns the cumulative rain counters consoles report into the rain
+// fallen since the previous observation, and keeps running hourly, daily,
+// monthly and rain year totals of it in local time.
+//
+// The total counter is used when there is one, then the daily and event
+// counters. A counter that goes down has been reset or rolled over, so the
+// next counter is used for that observation; when none is left the counter
+// has counted up from zero since the reset.
+type Tracker struct {
+	store     Store
+	yearStart time.Month
+	location  *time.Location
+	stations  map[string]*station
+	mutex     sync.Mutex
+}
+
+// NewTracker creates a tracker whose rain year starts in yearStart. With a
+// store, the counters and totals of each station are resumed from the stored
+// observations before its first observation is processed.
+func NewTracker(store Store, yearStart time.Month) *Tracker {
+	if yearStart < time.January || yearStart > time.December {
+		yearStart = time.January
+	}
+	return &Tracker{
+		store:     store,
+		yearStart: yearStart,
+		location:  time.Local,
+		stations:  make(map[string]*station),
+	}
+}
+
+// Process sets the rain fallen since the previous observation from the same
+// station and the rain totals. Observations from a station should be
+// processed in the order they were taken.
+func (t *Tracker) Process(data *models.WeatherData) {
+	if t == nil {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	st, ok := t.stations[data.StationID]
+	if !ok {
+		st = t.resume(data.StationID, data.Timestamp)
+		t.stations[data.StationID] = st
+	}
+
+	current := &counters{total: data.TotalRain, daily: data.DailyRain, event: data.EventRain}
+	fallen := 0.0
+	if st.last != nil {
+		fallen = increment(st.last, current)
+
+		// Guard against counters that jump, e.g. after a console is reconfigured
+		hours := data.Timestamp.Sub(st.lastTime).Hours()
+		if limit := maxRate*hours + 1; fallen > limit {
+			log.Printf("Ignoring rain counter jump of %.1f mm from %s at %v", fallen, data.StationID, data.Timestamp)
+			fallen = 0
+		}
+	}
+	if data.Timestamp.After(st.lastTime) || st.last == nil {
+		st.last = current
+		st.lastTime = data.Timestamp
+	}
+
+	// Start new totals for the periods that have ended
+	for p := period(0); p < periods; p++ {
+		start := t.periodStart(p, data.Timestamp)
+		if !start.Equal(st.starts[p]) {
+			st.starts[p] = start
+			st.totals[p] = 0
+		}
+		st.totals[p] += fallen
+	}
+
+	data.RainFall = fallen
+	data.RainHour = st.totals[hour]
+	data.RainDay = st.totals[day]
+	data.RainMonth = st.totals[month]
+	data.RainYear = st.totals[rainYear]
+}
+
+// increment returns the rain fallen between two sets of counter readings
+func increment(last, current *counters) float64 {
+	readings := [][2]float64{
+		{last.total, current.total},
+		{last.daily, current.daily},
+		{last.event, current.event},
+	}
+
+	for _, r := range readings {
+		// Skip counters the console does not report
+		if r[0] == 0 && r[1] == 0 {
+			continue
+		}
+		if r[1] >= r[0] {
+			return r[1] - r[0]
+		}
+	}
+
+	// Every counter went down, so they all count from zero again
+	for _, r := range readings {
+		if r[0] != 0 || r[1] != 0 {
+			return r[1]
+		}
+	}
+	return 0
+}
+
+// resume returns the state of a station from its stored observations before ts
+func (t *Tracker) resume(id string, ts time.Time) *station {
+	st := &station{}
+	if t.store == nil {
+		return st
+	}
+
+	prev, err := t.store.GetWeatherDataBefore(id, ts)
+	if err != nil {
+		log.Printf("Error resuming rain totals for %s: %v", id, err)
+		return st
+	}
+	if prev == nil {
+		return st
+	}
+
+	st.last = &counters{total: prev.TotalRain, daily: prev.DailyRain, event: prev.EventRain}
+	st.lastTime = prev.Timestamp
+
+	for p := period(0); p < periods; p++ {
+		start := t.periodStart(p, prev.Timestamp)
+		total, err := t.store.SumRainFall(id, start, prev.Timestamp)
+		if err != nil {
+			log.Printf("Error resuming rain totals for %s: %v", id, err)
+			return &station{last: st.last, lastTime: st.lastTime}
+		}
+		st.starts[p] = start
+		st.totals[p] = total
+	}
+
+	return st
+}
+
+// periodStart returns the start of the period containing ts. Rain at the
+// start of a period fell before it, so totals cover (start, start+period].
+func (t *Tracker) periodStart(p period, ts time.Time) time.Time {
+	local := ts.In(t.location).Add(-time.Nanosecond)
+	year, mon, dayOfMonth := local.Date()
+
+	switch p {
+	case hour:
+		return time.Date(year, mon, dayOfMonth, local.Hour(), 0, 0, 0, t.location)
+	case day:
+		return time.Date(year, mon, dayOfMonth, 0, 0, 0, 0, t.location)
+	case month:
+		return time.Date(year, mon, 1, 0, 0, 0, 0, t.location)
+	default:
+		if mon < t.yearStart {
+			year--
+		}
+		return time.Date(year, t.yearStart, 1, 0, 0, 0, 0, t.location)
+	}
+}
@@ -0,0 +1,153 @@
+package rain
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/ask-23/go-wx/internal/models"
+)
+
+// MockStore returns a fixed previous observation and rain sum
+type MockStore struct {
+	prev *models.WeatherData
+	sum  float64
+	sums int
+}
+
+func (m *MockStore) GetWeatherDataBefore(station string, ts time.Time) (*models.WeatherData, error) {
+	return m.prev, nil
+}
+
+func (m *MockStore) SumRainFall(station string, start, end time.Time) (float64, error) {
+	m.sums++
+	return m.sum, nil
+}
+
+// newTestTracker creates a tracker working in UTC
+func newTestTracker(store Store, yearStart time.Month) *Tracker {
+	t := NewTracker(store, yearStart)
+	t.location = time.UTC
+	return t
+}
+
+// observation returns an observation with the given counters
+func observation(ts time.Time, total, daily, event float64) *models.WeatherData {
+	return &models.WeatherData{
+		StationID: "default",
+		Timestamp: ts,
+		TotalRain: total,
+		DailyRain: daily,
+		EventRain: event,
+	}
+}
+
+func approximatelyEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+// TestIncrement tests choosing a counter and detecting resets
+func TestIncrement(t *testing.T) {
+	tests := []struct {
+		name     string
+		last     counters
+		current  counters
+		expected float64
+	}{
+		{"total counter", counters{100, 5, 5}, counters{101.5, 6.5, 6.5}, 1.5},
+		{"daily reset at midnight", counters{100, 5, 5}, counters{100.5, 0.5, 5.5}, 0.5},
+		{"total rolled over", counters{9999, 5, 5}, counters{0.5, 6, 6}, 1},
+		{"daily only", counters{0, 5, 0}, counters{0, 7, 0}, 2},
+		{"daily only reset", counters{0, 5, 0}, counters{0, 1, 0}, 1},
+		{"no counters", counters{}, counters{}, 0},
+	}
+
+	for _, tt := range tests {
+		last, current := tt.last, tt.current
+		if got := increment(&last, &current); !approximatelyEqual(got, tt.expected) {
+			t.Errorf("%s: expected %.2f mm, got %.2f mm", tt.name, tt.expected, got)
+		}
+	}
+}
+
+// TestTracker tests the rain fallen and the totals across period boundaries
+func TestTracker(t *testing.T) {
+	tracker := newTestTracker(nil, time.October)
+	start := time.Date(2023, 9, 30, 23, 50, 0, 0, time.UTC)
+
+	steps := []struct {
+		offset   time.Duration
+		total    float64
+		daily    float64
+		fallen   float64
+		day      float64
+		rainYear float64
+	}{
+		{0, 100, 10, 0, 0, 0},                         // first observation sets the baseline
+		{5 * time.Minute, 101, 11, 1, 1, 1},           // 23:55
+		{10 * time.Minute, 102, 12, 1, 2, 2},          // midnight still counts for the old day
+		{15 * time.Minute, 103.5, 1.5, 1.5, 1.5, 1.5}, // new day, month and rain year
+	}
+
+	for n, step := range steps {
+		data := observation(start.Add(step.offset), step.total, step.daily, 0)
+		tracker.Process(data)
+
+		if !approximatelyEqual(data.RainFall, step.fallen) {
+			t.Errorf("Step %d: expected %.2f mm fallen, got %.2f mm", n, step.fallen, data.RainFall)
+		}
+		if !approximatelyEqual(data.RainDay, step.day) {
+			t.Errorf("Step %d: expected %.2f mm today, got %.2f mm", n, step.day, data.RainDay)
+		}
+		if !approximatelyEqual(data.RainYear, step.rainYear) {
+			t.Errorf("Step %d: expected %.2f mm this rain year, got %.2f mm", n, step.rainYear, data.RainYear)
+		}
+	}
+}
+
+// TestCounterJump tests that implausible counter increases are ignored
+func TestCounterJump(t *testing.T) {
+	tracker := newTestTracker(nil, time.January)
+	start := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tracker.Process(observation(start, 10, 0, 0))
+	data := observation(start.Add(time.Minute), 500, 0, 0)
+	tracker.Process(data)
+
+	if data.RainFall != 0 {
+		t.Errorf("Expected counter jump to be ignored, got %.2f mm", data.RainFall)
+	}
+}
+
+// TestResume tests resuming counters and totals from the store
+func TestResume(t *testing.T) {
+	prevTime := time.Date(2023, 5, 1, 11, 55, 0, 0, time.UTC)
+	store := &MockStore{
+		prev: observation(prevTime, 100, 4, 0),
+		sum:  4,
+	}
+	tracker := newTestTracker(store, time.January)
+
+	data := observation(prevTime.Add(10*time.Minute), 101, 5, 0)
+	tracker.Process(data)
+
+	if !approximatelyEqual(data.RainFall, 1) {
+		t.Errorf("Expected 1 mm fallen since the stored observation, got %.2f mm", data.RainFall)
+	}
+	if !approximatelyEqual(data.RainDay, 5) || !approximatelyEqual(data.RainYear, 5) {
+		t.Errorf("Expected resumed totals of 5 mm, got day %.2f mm and year %.2f mm", data.RainDay, data.RainYear)
+	}
+
+	// The stored hour ended at noon, so the new hour starts from zero
+	if !approximatelyEqual(data.RainHour, 1) {
+		t.Errorf("Expected 1 mm this hour, got %.2f mm", data.RainHour)
+	}
+
+	if store.sums != 4 {
+		t.Errorf("Expected the store to be asked once per period, got %d", store.sums)
+	}
+	tracker.Process(observation(prevTime.Add(15*time.Minute), 101, 5, 0))
+	if store.sums != 4 {
+		t.Errorf("Expected totals to be resumed only once, got %d sums", store.sums)
+	}
+}
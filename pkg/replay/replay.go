@@ -0,0 +1,350 @@
+package replay
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/ask-23/go-wx/internal/models"
+	"github.com/ask-23/go-wx/pkg/interceptor"
+)
+
+// Store is the database access replay needs
+type Store interface {
+	FindWeatherData(rawUploadID int64, station string, timestamp time.Time) (*models.WeatherData, error)
+	ReplaceWeatherData(data *models.WeatherData) error
+}
+
+// Result counts what happened to the replayed uploads
+type Result struct {
+	Replayed  int // uploads decoded into observations
+	Changed   int // observations that differ from the stored row, or are new
+	Unchanged int // observations identical to the stored row
+	Skipped   int // uploads that could not be decoded
+	Recovered int // uploads rejected when received that decode now
+	Partial   int // archive records only partly covered by the uploads, left as stored
+}
+
+// Replayer feeds archived uploads back through the interceptor's ingest steps
+type Replayer struct {
+	interceptor *interceptor.Interceptor
+	store       Store
+	dryRun      bool
+	out         io.Writer
+}
+
+// NewReplayer creates a replayer that ingests with icpt and stores into
+// store. In a dry run nothing is stored and the differences from the stored
+// rows are written to out instead.
+func NewReplayer(icpt *interceptor.Interceptor, store Store, dryRun bool, out io.Writer) *Replayer {
+	return &Replayer{
+		interceptor: icpt,
+		store:       store,
+		dryRun:      dryRun,
+		out:         out,
+	}
+}
+
+// Run replays uploads in the order given, which should be the order they
+// were received so quality control compares readings as it did live.
+// Uploads rejected as undecodable when received are replayed too, so they
+// are recovered once the decoder has been fixed. With aggregation the
+// archive records built from the uploads are replayed, except those whose
+// interval is not wholly within the receive times from start to end the
+// uploads were selected by: they would lack the uploads outside the range,
+// so the stored records are kept. A zero start or end leaves the range open
+// on that side.
+func (r *Replayer) Run(uploads []*models.RawUpload, start, end time.Time) (Result, error) {
+	var result Result
+
+	// Receive time minus device time of each station's latest observation
+	offsets := make(map[string]time.Duration)
+
+	for _, upload := range uploads {
+		if !replayable(upload) {
+			continue
+		}
+
+		data, err := r.ingest(upload)
+		if err != nil {
+			log.Printf("Skipping raw upload %d received %v: %v", upload.ID, upload.ReceivedAt, err)
+			result.Skipped++
+			continue
+		}
+		result.Replayed++
+		if upload.Status == http.StatusBadRequest {
+			log.Printf("Recovered raw upload %d received %v, which was rejected then", upload.ID, upload.ReceivedAt)
+			result.Recovered++
+		}
+		offsets[data.StationID] = data.ReceivedAt.Sub(data.Timestamp)
+
+		for _, record := range r.interceptor.Aggregate(data) {
+			if err := r.replaceRecord(record, offsets[record.StationID], start, end, &result); err != nil {
+				return result, err
+			}
+		}
+	}
+
+	// Records still in progress are complete if the range covers them
+	for _, record := range r.interceptor.FlushRecords() {
+		if err := r.replaceRecord(record, offsets[record.StationID], start, end, &result); err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+// replayable reports whether an upload is replayed: those that were
+// accepted, those that were rejected as undecodable, and captured uploads
+// without a status
+func replayable(upload *models.RawUpload) bool {
+	switch upload.Status {
+	case 0, http.StatusOK, http.StatusBadRequest:
+		return true
+	default:
+		return false
+	}
+}
+
+// replaceRecord replaces an archive record when the receive times from
+// start to end cover its whole interval, allowing for the station's device
+// clock offset. Without aggregation every observation is covered.
+func (r *Replayer) replaceRecord(record *models.WeatherData, offset time.Duration, start, end time.Time, result *Result) error {
+	if interval := r.interceptor.RecordInterval(); interval > 0 {
+		// When the interval began and ended by the receiving clock
+		closed := record.Timestamp.Add(offset)
+		opened := closed.Add(-interval)
+		if (!start.IsZero() && opened.Before(start)) || (!end.IsZero() && closed.After(end)) {
+			log.Printf("Keeping the stored record for %s at %v, which the replayed range only partly covers",
+				record.StationID, record.Timestamp)
+			result.Partial++
+			return nil
+		}
+	}
+	return r.replace(record, result)
+}
+
+// replace stores a replayed observation in place of the stored one if they
+// differ, or in a dry run describes the differences
+func (r *Replayer) replace(data *models.WeatherData, result *Result) error {
+	existing, err := r.store.FindWeatherData(data.RawUploadID, data.StationID, data.Timestamp)
+	if err != nil {
+		return err
+	}
+
+	changes := Diff(existing, data)
+	if len(changes) == 0 {
+		result.Unchanged++
+		return nil
+	}
+	result.Changed++
+
+	if r.dryRun {
+		fmt.Fprintf(r.out, "%s %s", data.StationID, data.Timestamp.UTC().Format(time.RFC3339))
+		if data.RawUploadID != 0 {
+			fmt.Fprintf(r.out, " (upload %d)", data.RawUploadID)
+		}
+		fmt.Fprintln(r.out, ":")
+		for _, change := range changes {
+			fmt.Fprintf(r.out, "  %s\n", change)
+		}
+		return nil
+	}
+
+	return r.store.ReplaceWeatherData(data)
+}
+
+// ingest decodes an archived upload into an observation
+func (r *Replayer) ingest(upload *models.RawUpload) (*models.WeatherData, error) {
+	dec, err := interceptor.NewDecoder(upload.Decoder)
+	if err != nil {
+		return nil, err
+	}
+
+	form, err := uploadForm(upload)
+	if err != nil {
+		return nil, err
+	}
+
+	// Archived secrets are redacted, so keep the station recorded on receipt
+	station := upload.StationID
+	if station == "" {
+		station = r.interceptor.ResolveStation(form)
+	}
+
+	data, err := r.interceptor.Ingest(dec, form, station, upload.ReceivedAt)
+	if err != nil {
+		return nil, err
+	}
+	data.RawUploadID = upload.ID
+
+	return data, nil
+}
+
+// uploadForm returns the form values of an upload from its query and body,
+// as http.Request.ParseForm would
+func uploadForm(upload *models.RawUpload) (url.Values, error) {
+	form, err := url.ParseQuery(upload.Body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upload body: %w", err)
+	}
+
+	query, err := url.ParseQuery(upload.Query)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upload query: %w", err)
+	}
+	for key, values := range query {
+		form[key] = append(form[key], values...)
+	}
+
+	return form, nil
+}
+
+// ReadCapture reads a capture file of uploads, one JSON encoded raw upload
+// per line as stored in the archive. Captured uploads are not in the archive,
+// so their IDs are cleared and their observations are matched by station and
+// timestamp instead.
+func ReadCapture(reader io.Reader) ([]*models.RawUpload, error) {
+	var uploads []*models.RawUpload
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 2<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var upload models.RawUpload
+		if err := json.Unmarshal(scanner.Bytes(), &upload); err != nil {
+			return nil, fmt.Errorf("invalid upload on line %d: %w", line, err)
+		}
+		upload.ID = 0
+		uploads = append(uploads, &upload)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read capture: %w", err)
+	}
+
+	sort.SliceStable(uploads, func(a, b int) bool {
+		return uploads[a].ReceivedAt.Before(uploads[b].ReceivedAt)
+	})
+
+	return uploads, nil
+}
+
+// unstoredFields are calculated on ingest but not stored, so stored rows
+// never have them
+var unstoredFields = map[string]bool{
+	"dewPoint":  true,
+	"windChill": true,
+	"heatIndex": true,
+}
+
+// Diff describes how a replayed observation differs from the stored one, one
+// "field: old -> new" line per changed measurement in sorted order. A missing
+// stored observation differs in every field.
+func Diff(stored, replayed *models.WeatherData) []string {
+	if stored == nil {
+		return []string{"new observation"}
+	}
+
+	oldFields, err := fieldMap(stored)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	newFields, err := fieldMap(replayed)
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	names := make(map[string]bool)
+	for name := range oldFields {
+		names[name] = true
+	}
+	for name := range newFields {
+		names[name] = true
+	}
+
+	var changes []string
+	for name := range names {
+		if unstoredFields[name] {
+			continue
+		}
+		oldValue, newValue := oldFields[name], newFields[name]
+		if sameValue(oldValue, newValue) {
+			continue
+		}
+		changes = append(changes, fmt.Sprintf("%s: %v -> %v", name, oldValue, newValue))
+	}
+	sort.Strings(changes)
+
+	return changes
+}
+
+// fieldMap returns the JSON fields of an observation with times normalized
+// to the second in UTC, the precision they are stored with
+func fieldMap(data *models.WeatherData) (map[string]interface{}, error) {
+	normalized := *data
+	normalized.Timestamp = normalized.Timestamp.UTC().Truncate(time.Second)
+	normalized.ReceivedAt = normalized.ReceivedAt.UTC().Truncate(time.Second)
+	if normalized.LightningTime != nil {
+		t := normalized.LightningTime.UTC().Truncate(time.Second)
+		normalized.LightningTime = &t
+	}
+	if normalized.RainStartTime != nil {
+		t := normalized.RainStartTime.UTC().Truncate(time.Second)
+		normalized.RainStartTime = &t
+	}
+
+	b, err := json.Marshal(normalized)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode weather data: %w", err)
+	}
+
+	fields := make(map[string]interface{})
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, fmt.Errorf("failed to decode weather data: %w", err)
+	}
+
+	// Indoor readings are compared field by field too, as indoor.temperature
+	// and so on
+	if data.Indoor != nil {
+		indoor := *data.Indoor
+		indoor.Timestamp = indoor.Timestamp.UTC().Truncate(time.Second)
+		indoor.ReceivedAt = indoor.ReceivedAt.UTC().Truncate(time.Second)
+
+		b, err := json.Marshal(indoor)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode indoor data: %w", err)
+		}
+		indoorFields := make(map[string]interface{})
+		if err := json.Unmarshal(b, &indoorFields); err != nil {
+			return nil, fmt.Errorf("failed to decode indoor data: %w", err)
+		}
+		for name, value := range indoorFields {
+			fields["indoor."+name] = value
+		}
+	}
+
+	return fields, nil
+}
+
+// sameValue compares two decoded JSON values, allowing for the precision
+// lost storing floats in FLOAT columns
+func sameValue(a, b interface{}) bool {
+	fa, aok := a.(float64)
+	fb, bok := b.(float64)
+	if aok && bok {
+		return math.Abs(fa-fb) <= 1e-4*math.Max(1, math.Abs(fa))
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,363 @@
+package replay
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ask-23/go-wx/internal/models"
+	"github.com/ask-23/go-wx/pkg/config"
+	"github.com/ask-23/go-wx/pkg/interceptor"
+)
+
+// MockStore keeps observations keyed by raw upload ID, or by timestamp for
+// observations without one
+type MockStore struct {
+	rows     map[string]*models.WeatherData
+	replaced int
+}
+
+func newMockStore() *MockStore {
+	return &MockStore{rows: make(map[string]*models.WeatherData)}
+}
+
+func key(rawUploadID int64, station string, timestamp time.Time) string {
+	if rawUploadID != 0 {
+		return fmt.Sprintf("upload %d", rawUploadID)
+	}
+	return station + timestamp.UTC().String()
+}
+
+func (m *MockStore) FindWeatherData(rawUploadID int64, station string, timestamp time.Time) (*models.WeatherData, error) {
+	return m.rows[key(rawUploadID, station, timestamp)], nil
+}
+
+func (m *MockStore) ReplaceWeatherData(data *models.WeatherData) error {
+	m.rows[key(data.RawUploadID, data.StationID, data.Timestamp)] = data
+	m.replaced++
+	return nil
+}
+
+// newTestInterceptor creates an interceptor for ingesting replayed uploads
+func newTestInterceptor(t *testing.T) *interceptor.Interceptor {
+	icpt, err := interceptor.NewInterceptor(config.CollectorConfig{}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create interceptor: %v", err)
+	}
+	return icpt
+}
+
+// testUploads returns two archived Ecowitt uploads and a rejected one
+func testUploads() []*models.RawUpload {
+	received := time.Date(2023, 5, 1, 12, 0, 5, 0, time.UTC)
+	return []*models.RawUpload{
+		{
+			ID:         1,
+			ReceivedAt: received,
+			Method:     "POST",
+			Body:       "PASSKEY=REDACTED&dateutc=2023-05-01+12%3A00%3A00&tempf=70.5&humidity=45",
+			Decoder:    "ecowitt",
+			StationID:  "backyard",
+			Status:     200,
+		},
+		{
+			ID:         2,
+			ReceivedAt: received.Add(time.Minute),
+			Method:     "GET",
+			Query:      "ID=KTX1&PASSWORD=REDACTED&dateutc=2023-05-01+12%3A01%3A00&tempf=71&humidity=44",
+			Decoder:    "wunderground",
+			StationID:  "backyard",
+			Status:     200,
+		},
+		{
+			ID:         3,
+			ReceivedAt: received.Add(2 * time.Minute),
+			Body:       "dateutc=yesterday",
+			Decoder:    "ecowitt",
+			Status:     400,
+		},
+	}
+}
+
+// TestReplay tests that replaying stores each observation once
+func TestReplay(t *testing.T) {
+	store := newMockStore()
+	replayer := NewReplayer(newTestInterceptor(t), store, false, nil)
+
+	result, err := replayer.Run(testUploads(), time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	if result.Replayed != 2 || result.Changed != 2 {
+		t.Errorf("Expected 2 replayed and changed uploads, got %+v", result)
+	}
+
+	data := store.rows[key(1, "", time.Time{})]
+	if data == nil {
+		t.Fatalf("Expected observation for upload 1 to be stored")
+	}
+	if data.StationID != "backyard" || data.RawUploadID != 1 {
+		t.Errorf("Expected backyard observation linked to upload 1, got %s/%d", data.StationID, data.RawUploadID)
+	}
+	if !data.ReceivedAt.Equal(time.Date(2023, 5, 1, 12, 0, 5, 0, time.UTC)) {
+		t.Errorf("Expected the archived receive time, got %v", data.ReceivedAt)
+	}
+	if data.Temperature < 21.3 || data.Temperature > 21.4 {
+		t.Errorf("Expected temperature 21.4°C, got %.2f°C", data.Temperature)
+	}
+
+	// Replaying again finds identical rows and stores nothing
+	result, err = NewReplayer(newTestInterceptor(t), store, false, nil).Run(testUploads(), time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	if result.Unchanged != 2 || result.Changed != 0 || store.replaced != 2 {
+		t.Errorf("Expected an idempotent replay, got %+v with %d replacements", result, store.replaced)
+	}
+}
+
+// TestReplayRecovered tests that uploads rejected as undecodable when they
+// were received are stored once they decode, and skipped while they do not
+func TestReplayRecovered(t *testing.T) {
+	store := newMockStore()
+	received := time.Date(2023, 5, 1, 12, 0, 5, 0, time.UTC)
+	uploads := testUploads()[2:]
+	uploads = append(uploads, &models.RawUpload{
+		ID:         4,
+		ReceivedAt: received,
+		Body:       "dateutc=now&tempf=70.5&humidity=45",
+		Decoder:    "ecowitt",
+		StationID:  "backyard",
+		Status:     400, // rejected by a release that could not decode dateutc=now
+	})
+
+	result, err := NewReplayer(newTestInterceptor(t), store, false, nil).Run(uploads, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	if result.Replayed != 1 || result.Recovered != 1 || result.Changed != 1 || result.Skipped != 1 {
+		t.Errorf("Expected 1 recovered upload and 1 still skipped, got %+v", result)
+	}
+
+	data := store.rows[key(4, "", time.Time{})]
+	if data == nil {
+		t.Fatalf("Expected an observation for the recovered upload")
+	}
+	if !data.Timestamp.Equal(received) {
+		t.Errorf("Expected the receive time as the observation time, got %v", data.Timestamp)
+	}
+}
+
+// TestReplayDryRun tests that a dry run reports differences without storing
+func TestReplayDryRun(t *testing.T) {
+	store := newMockStore()
+	stored := &models.WeatherData{
+		StationID:   "backyard",
+		Timestamp:   time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC),
+		ReceivedAt:  time.Date(2023, 5, 1, 12, 0, 5, 0, time.UTC),
+		Temperature: 70.5, // stored unconverted by an older release
+		Humidity:    45,
+		RawUploadID: 1,
+	}
+	store.rows[key(1, "", time.Time{})] = stored
+
+	var out bytes.Buffer
+	result, err := NewReplayer(newTestInterceptor(t), store, true, &out).Run(testUploads()[:1], time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+
+	if result.Changed != 1 || store.replaced != 0 {
+		t.Errorf("Expected 1 changed and nothing stored, got %+v with %d replacements", result, store.replaced)
+	}
+	if !strings.Contains(out.String(), "temperature: 70.5 -> 21.38") {
+		t.Errorf("Expected temperature difference in output, got:\n%s", out.String())
+	}
+	if strings.Contains(out.String(), "humidity") {
+		t.Errorf("Expected unchanged humidity not to be reported, got:\n%s", out.String())
+	}
+}
+
+// TestReplayDateNow tests that uploads timestamped "now" keep the time they
+// were received rather than the time of the replay
+func TestReplayDateNow(t *testing.T) {
+	store := newMockStore()
+	upload := testUploads()[0]
+	upload.Body = "PASSKEY=REDACTED&dateutc=now&tempf=70.5&humidity=45"
+
+	if _, err := NewReplayer(newTestInterceptor(t), store, false, nil).Run([]*models.RawUpload{upload}, time.Time{}, time.Time{}); err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+
+	data := store.rows[key(1, "", time.Time{})]
+	if data == nil {
+		t.Fatalf("Expected observation for upload 1 to be stored")
+	}
+	if !data.Timestamp.Equal(upload.ReceivedAt) {
+		t.Errorf("Expected timestamp %v, got %v", upload.ReceivedAt, data.Timestamp)
+	}
+	if data.ClockSkewed {
+		t.Errorf("Expected no clock skew for an upload timestamped now")
+	}
+}
+
+// TestReplayAggregated tests replaying uploads into archive records
+func TestReplayAggregated(t *testing.T) {
+	icpt, err := interceptor.NewInterceptor(config.CollectorConfig{Interval: 300}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create interceptor: %v", err)
+	}
+
+	store := newMockStore()
+	result, err := NewReplayer(icpt, store, false, nil).Run(testUploads(), time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	if result.Replayed != 2 || result.Changed != 1 {
+		t.Errorf("Expected 2 uploads replayed into 1 record, got %+v", result)
+	}
+
+	end := time.Date(2023, 5, 1, 12, 5, 0, 0, time.UTC)
+	data := store.rows[key(0, "backyard", end)]
+	if data == nil {
+		t.Fatalf("Expected a record ending at %v, got %v", end, store.rows)
+	}
+	if data.Temperature < 21.5 || data.Temperature > 21.55 {
+		t.Errorf("Expected mean temperature 21.53°C, got %.2f°C", data.Temperature)
+	}
+	if data.FirstRawUploadID != 1 || data.LastRawUploadID != 2 {
+		t.Errorf("Expected the record linked to uploads 1 to 2, got %d to %d", data.FirstRawUploadID, data.LastRawUploadID)
+	}
+}
+
+// TestReplayIndoor tests that replaying backfills the indoor readings of
+// stored observations
+func TestReplayIndoor(t *testing.T) {
+	uploads := testUploads()[:1]
+	uploads[0].Body += "&tempinf=68&humidityin=40"
+
+	store := newMockStore()
+	if _, err := NewReplayer(newTestInterceptor(t), store, false, nil).Run(uploads, time.Time{}, time.Time{}); err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+
+	// An observation stored without its indoor reading is replaced
+	stored := *store.rows[key(1, "", time.Time{})]
+	stored.Indoor = nil
+	store.rows[key(1, "", time.Time{})] = &stored
+
+	var out bytes.Buffer
+	result, err := NewReplayer(newTestInterceptor(t), store, true, &out).Run(uploads, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	if result.Changed != 1 || !strings.Contains(out.String(), "indoor.humidity: <nil> -> 40") {
+		t.Errorf("Expected the missing indoor reading to be reported, got %+v:\n%s", result, out.String())
+	}
+
+	result, err = NewReplayer(newTestInterceptor(t), store, false, nil).Run(uploads, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	indoor := store.rows[key(1, "", time.Time{})].Indoor
+	if result.Changed != 1 || indoor == nil || indoor.Humidity != 40 || indoor.StationID != "backyard" {
+		t.Errorf("Expected the indoor reading of the backyard station to be stored, got %+v and %+v", result, indoor)
+	}
+
+	// Once stored, the reading is unchanged
+	result, err = NewReplayer(newTestInterceptor(t), store, false, nil).Run(uploads, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	if result.Unchanged != 1 {
+		t.Errorf("Expected an unchanged replay, got %+v", result)
+	}
+}
+
+// TestReplayPartialRecords tests that records the replayed range only partly
+// covers are left as stored
+func TestReplayPartialRecords(t *testing.T) {
+	newInterceptor := func() *interceptor.Interceptor {
+		icpt, err := interceptor.NewInterceptor(config.CollectorConfig{Interval: 300}, nil)
+		if err != nil {
+			t.Fatalf("Failed to create interceptor: %v", err)
+		}
+		return icpt
+	}
+
+	// The range starts after the record's interval began or ends before it ended
+	ranges := [][2]time.Time{
+		{time.Date(2023, 5, 1, 12, 0, 30, 0, time.UTC), time.Time{}},
+		{time.Time{}, time.Date(2023, 5, 1, 12, 3, 0, 0, time.UTC)},
+	}
+	for _, r := range ranges {
+		store := newMockStore()
+		result, err := NewReplayer(newInterceptor(), store, false, nil).Run(testUploads(), r[0], r[1])
+		if err != nil {
+			t.Fatalf("Run returned error: %v", err)
+		}
+		if result.Partial != 1 || store.replaced != 0 {
+			t.Errorf("Expected the record from %v to %v to be kept, got %+v with %d replacements",
+				r[0], r[1], result, store.replaced)
+		}
+	}
+
+	// The interval ends five seconds late by the receiving clock
+	store := newMockStore()
+	start := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	result, err := NewReplayer(newInterceptor(), store, false, nil).Run(testUploads(), start, start.Add(5*time.Minute+5*time.Second))
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	if result.Partial != 0 || store.replaced != 1 {
+		t.Errorf("Expected the covered record to be replaced, got %+v with %d replacements", result, store.replaced)
+	}
+}
+
+// TestReadCapture tests reading a capture file
+func TestReadCapture(t *testing.T) {
+	capture := `{"id":7,"receivedAt":"2023-05-01T12:01:00Z","body":"tempf=71","decoder":"ecowitt"}
+
+{"id":8,"receivedAt":"2023-05-01T12:00:00Z","body":"tempf=70","decoder":"ecowitt"}
+`
+	uploads, err := ReadCapture(strings.NewReader(capture))
+	if err != nil {
+		t.Fatalf("ReadCapture returned error: %v", err)
+	}
+	if len(uploads) != 2 {
+		t.Fatalf("Expected 2 uploads, got %d", len(uploads))
+	}
+	if uploads[0].Body != "tempf=70" {
+		t.Errorf("Expected uploads in receive order, got %q first", uploads[0].Body)
+	}
+	if uploads[0].ID != 0 || uploads[1].ID != 0 {
+		t.Errorf("Expected captured upload IDs to be cleared")
+	}
+
+	if _, err := ReadCapture(strings.NewReader("not json\n")); err == nil {
+		t.Errorf("Expected error for an invalid capture")
+	}
+}
+
+// TestDiff tests comparison of stored and replayed observations
+func TestDiff(t *testing.T) {
+	ts := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	stored := &models.WeatherData{Timestamp: ts, Temperature: 21.38889}
+	replayed := &models.WeatherData{Timestamp: ts.Local(), Temperature: 21.388888, DewPoint: 8}
+
+	if changes := Diff(stored, replayed); len(changes) != 0 {
+		t.Errorf("Expected no differences, got %v", changes)
+	}
+
+	replayed.Humidity = 45
+	changes := Diff(stored, replayed)
+	if len(changes) != 1 || changes[0] != "humidity: 0 -> 45" {
+		t.Errorf("Expected humidity difference, got %v", changes)
+	}
+
+	if changes := Diff(nil, replayed); len(changes) != 1 {
+		t.Errorf("Expected a new observation, got %v", changes)
+	}
+}
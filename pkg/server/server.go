@@ -1,12 +1,15 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"html/template"
 	"log"
+	"math"
 	"net/http"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/ask-23/go-wx/internal/models"
@@ -16,23 +19,43 @@ import (
 
 // Server represents a web server for weather data
 type Server struct {
-	config  *config.ServerConfig
-	db      *database.Database
-	server  *http.Server
-	station *config.StationConfig
+	config   *config.ServerConfig
+	db       *database.Database
+	server   *http.Server // nil unless running
+	stations []models.WeatherStation
+	handlers map[string]http.Handler // added with Handle
+	mutex    sync.Mutex
 }
 
-// NewServer creates a new web server
-func NewServer(cfg config.ServerConfig, db *database.Database) (*Server, error) {
+// NewServer creates a new web server for the given stations. The first
+// station is shown when a request does not select one.
+func NewServer(cfg config.ServerConfig, stations []models.WeatherStation, db *database.Database) (*Server, error) {
+	if len(stations) == 0 {
+		return nil, fmt.Errorf("web server requires at least one station")
+	}
+
 	return &Server{
-		config:  &cfg,
-		db:      db,
-		station: &cfg.Station,
+		config:   &cfg,
+		db:       db,
+		stations: stations,
 	}, nil
 }
 
-// Start begins serving the web interface
-func (s *Server) Start() error {
+// Handle registers a handler for pattern alongside the web interface. It
+// takes effect the next time the server is run.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.handlers == nil {
+		s.handlers = make(map[string]http.Handler)
+	}
+	s.handlers[pattern] = handler
+}
+
+// Run starts the web server and blocks until it is shut down or fails.
+// Canceling ctx closes the server without waiting for requests in progress.
+func (s *Server) Run(ctx context.Context) error {
 	// Create a new HTTP server
 	mux := http.NewServeMux()
 
@@ -40,28 +63,64 @@ func (s *Server) Start() error {
 	mux.HandleFunc("/", s.handleHome)
 	mux.HandleFunc("/api/current", s.handleCurrentData)
 	mux.HandleFunc("/api/history", s.handleHistoryData)
+	mux.HandleFunc("/api/stations", s.handleStations)
+	mux.HandleFunc("/api/sensors", s.handleSensors)
+	mux.HandleFunc("/api/indoor", s.handleIndoorData)
+	mux.HandleFunc("/api/indoor/history", s.handleIndoorHistory)
 
 	// Serve static files
 	staticDir := "/static/"
 	mux.Handle(staticDir, http.StripPrefix(staticDir, http.FileServer(http.Dir("web/static"))))
 
+	// Register the handlers of other components, such as upload decoders
+	s.mutex.Lock()
+	for pattern, handler := range s.handlers {
+		mux.Handle(pattern, handler)
+	}
+	s.mutex.Unlock()
+
 	// Configure the server
 	addr := fmt.Sprintf("%s:%d", s.config.Address, s.config.Port)
-	s.server = &http.Server{
+	server := &http.Server{
 		Addr:    addr,
 		Handler: mux,
 	}
 
+	s.mutex.Lock()
+	if s.server != nil {
+		s.mutex.Unlock()
+		return fmt.Errorf("web server is already running")
+	}
+	s.server = server
+	s.mutex.Unlock()
+
+	// Close the server when ctx is canceled
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-ctx.Done():
+			server.Close()
+		case <-finished:
+		}
+	}()
+
 	// Start the server
 	log.Printf("Starting web server on %s", addr)
 	var err error
 	if s.config.SSL.Enabled {
-		err = s.server.ListenAndServeTLS(s.config.SSL.CertFile, s.config.SSL.KeyFile)
+		err = server.ListenAndServeTLS(s.config.SSL.CertFile, s.config.SSL.KeyFile)
 	} else {
-		err = s.server.ListenAndServe()
+		err = server.ListenAndServe()
+	}
+
+	s.mutex.Lock()
+	if s.server == server {
+		s.server = nil
 	}
+	s.mutex.Unlock()
 
-	// If we get here, there was an error
+	// If we get here without a shutdown, there was an error
 	if err != http.ErrServerClosed {
 		return fmt.Errorf("web server error: %w", err)
 	}
@@ -69,14 +128,29 @@ func (s *Server) Start() error {
 	return nil
 }
 
-// Stop stops the web server
-func (s *Server) Stop() error {
-	if s.server != nil {
-		return s.server.Close()
+// Shutdown stops the web server, waiting for the requests in progress until
+// ctx is done
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mutex.Lock()
+	server := s.server
+	s.server = nil
+	s.mutex.Unlock()
+
+	if server == nil {
+		return nil
+	}
+	if err := server.Shutdown(ctx); err != nil {
+		server.Close()
+		return fmt.Errorf("failed to drain web requests: %w", err)
 	}
 	return nil
 }
 
+// Name returns the name the web server is reported under
+func (s *Server) Name() string {
+	return "web server"
+}
+
 // handleHome serves the main dashboard page
 func (s *Server) handleHome(w http.ResponseWriter, r *http.Request) {
 	// Ensure we're only handling the root path
@@ -85,8 +159,10 @@ func (s *Server) handleHome(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	station := s.station(r.URL.Query().Get("station"))
+
 	// Get the latest weather data
-	data, err := s.db.GetLatestWeatherData()
+	data, err := s.db.GetLatestWeatherData(station.ID)
 	if err != nil {
 		http.Error(w, "Error retrieving weather data", http.StatusInternalServerError)
 		log.Printf("Error retrieving weather data: %v", err)
@@ -96,26 +172,36 @@ func (s *Server) handleHome(w http.ResponseWriter, r *http.Request) {
 	// Get historical data for the graphs
 	end := time.Now()
 	start := end.Add(-24 * time.Hour)
-	history, err := s.db.GetWeatherDataRange(start, end)
+	history, err := s.db.GetWeatherDataRange(station.ID, start, end)
 	if err != nil {
 		http.Error(w, "Error retrieving historical data", http.StatusInternalServerError)
 		log.Printf("Error retrieving historical data: %v", err)
 		return
 	}
 
+	// Indoor conditions are shown when the station reports them
+	indoor, err := s.db.GetLatestIndoorData(station.ID)
+	if err != nil {
+		log.Printf("Error retrieving indoor data: %v", err)
+	}
+
 	// Prepare template data
 	templateData := struct {
-		Current *models.WeatherData
-		History []*models.WeatherData
-		Station *config.StationConfig
+		Current  *models.WeatherData
+		Indoor   *models.IndoorData
+		History  []*models.WeatherData
+		Station  models.WeatherStation
+		Stations []models.WeatherStation
 	}{
-		Current: data,
-		History: history,
-		Station: s.station,
+		Current:  data,
+		Indoor:   indoor,
+		History:  history,
+		Station:  station,
+		Stations: s.stations,
 	}
 
 	// Parse and execute the template
-	tmpl, err := template.ParseFiles(filepath.Join("web/templates", "dashboard.html"))
+	tmpl, err := template.New("dashboard.html").Funcs(templateFuncs).ParseFiles(filepath.Join("web/templates", "dashboard.html"))
 	if err != nil {
 		http.Error(w, "Error loading template", http.StatusInternalServerError)
 		log.Printf("Error loading template: %v", err)
@@ -129,10 +215,50 @@ func (s *Server) handleHome(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// templateFuncs are the helper functions available to the dashboard template
+var templateFuncs = template.FuncMap{
+	"getWindDirection": getWindDirection,
+	"deref":            deref,
+}
+
+// deref returns the value of an optional measurement, or 0 when it is missing
+func deref(v *float64) float64 {
+	if v == nil {
+		return 0
+	}
+	return *v
+}
+
+// getWindDirection converts a wind direction in degrees to a compass point
+func getWindDirection(degrees float64) string {
+	directions := []string{"N", "NNE", "NE", "ENE", "E", "ESE", "SE", "SSE", "S", "SSW", "SW", "WSW", "W", "WNW", "NW", "NNW"}
+	index := int(math.Round(degrees/22.5)) % len(directions)
+	if index < 0 {
+		index += len(directions)
+	}
+	return directions[index]
+}
+
+// station returns the station with the given ID, or the default station when
+// id is empty. Stations that are not configured are returned by ID alone.
+func (s *Server) station(id string) models.WeatherStation {
+	if id == "" {
+		return s.stations[0]
+	}
+	for _, st := range s.stations {
+		if st.ID == id {
+			return st
+		}
+	}
+	return models.WeatherStation{ID: id, Name: id}
+}
+
 // handleCurrentData returns the current weather data as JSON
 func (s *Server) handleCurrentData(w http.ResponseWriter, r *http.Request) {
+	station := s.station(r.URL.Query().Get("station"))
+
 	// Get the latest weather data
-	data, err := s.db.GetLatestWeatherData()
+	data, err := s.db.GetLatestWeatherData(station.ID)
 	if err != nil {
 		http.Error(w, "Error retrieving weather data", http.StatusInternalServerError)
 		log.Printf("Error retrieving weather data: %v", err)
@@ -172,7 +298,8 @@ func (s *Server) handleHistoryData(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get historical data for the specified range
-	history, err := s.db.GetWeatherDataRange(startTime, endTime)
+	station := s.station(r.Form.Get("station"))
+	history, err := s.db.GetWeatherDataRange(station.ID, startTime, endTime)
 	if err != nil {
 		http.Error(w, "Error retrieving historical data", http.StatusInternalServerError)
 		log.Printf("Error retrieving historical data: %v", err)
@@ -189,3 +316,126 @@ func (s *Server) handleHistoryData(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+// handleStations returns the configured stations and any others with stored
+// weather data as JSON
+func (s *Server) handleStations(w http.ResponseWriter, r *http.Request) {
+	ids, err := s.db.GetStationIDs()
+	if err != nil {
+		http.Error(w, "Error retrieving stations", http.StatusInternalServerError)
+		log.Printf("Error retrieving stations: %v", err)
+		return
+	}
+
+	stations := mergeStations(s.stations, ids)
+
+	// Set content type
+	w.Header().Set("Content-Type", "application/json")
+
+	// Write JSON response
+	if err := json.NewEncoder(w).Encode(stations); err != nil {
+		http.Error(w, "Error encoding JSON", http.StatusInternalServerError)
+		log.Printf("Error encoding JSON: %v", err)
+		return
+	}
+}
+
+// handleIndoorData returns the latest indoor data as JSON, or null when the
+// station reports none
+func (s *Server) handleIndoorData(w http.ResponseWriter, r *http.Request) {
+	station := s.station(r.URL.Query().Get("station"))
+
+	data, err := s.db.GetLatestIndoorData(station.ID)
+	if err != nil {
+		http.Error(w, "Error retrieving indoor data", http.StatusInternalServerError)
+		log.Printf("Error retrieving indoor data: %v", err)
+		return
+	}
+
+	// Set content type
+	w.Header().Set("Content-Type", "application/json")
+
+	// Write JSON response
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		http.Error(w, "Error encoding JSON", http.StatusInternalServerError)
+		log.Printf("Error encoding JSON: %v", err)
+		return
+	}
+}
+
+// handleIndoorHistory returns historical indoor data as JSON, over the last
+// 24 hours unless start and end are given
+func (s *Server) handleIndoorHistory(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	endTime := time.Now()
+	startTime := endTime.Add(-24 * time.Hour)
+
+	if t, err := time.Parse(time.RFC3339, r.Form.Get("start")); err == nil {
+		startTime = t
+	}
+	if t, err := time.Parse(time.RFC3339, r.Form.Get("end")); err == nil {
+		endTime = t
+	}
+
+	station := s.station(r.Form.Get("station"))
+	history, err := s.db.GetIndoorDataRange(station.ID, startTime, endTime)
+	if err != nil {
+		http.Error(w, "Error retrieving indoor history", http.StatusInternalServerError)
+		log.Printf("Error retrieving indoor history: %v", err)
+		return
+	}
+	if history == nil {
+		history = []*models.IndoorData{}
+	}
+
+	// Set content type
+	w.Header().Set("Content-Type", "application/json")
+
+	// Write JSON response
+	if err := json.NewEncoder(w).Encode(history); err != nil {
+		http.Error(w, "Error encoding JSON", http.StatusInternalServerError)
+		log.Printf("Error encoding JSON: %v", err)
+		return
+	}
+}
+
+// handleSensors returns the battery state and last report of each sensor as
+// JSON, for every station unless one is selected
+func (s *Server) handleSensors(w http.ResponseWriter, r *http.Request) {
+	sensors, err := s.db.GetSensorHealth(r.URL.Query().Get("station"))
+	if err != nil {
+		http.Error(w, "Error retrieving sensor health", http.StatusInternalServerError)
+		log.Printf("Error retrieving sensor health: %v", err)
+		return
+	}
+	if sensors == nil {
+		sensors = []*models.SensorHealth{}
+	}
+
+	// Set content type
+	w.Header().Set("Content-Type", "application/json")
+
+	// Write JSON response
+	if err := json.NewEncoder(w).Encode(sensors); err != nil {
+		http.Error(w, "Error encoding JSON", http.StatusInternalServerError)
+		log.Printf("Error encoding JSON: %v", err)
+		return
+	}
+}
+
+// mergeStations appends a station for each ID that is not already configured
+func mergeStations(configured []models.WeatherStation, ids []string) []models.WeatherStation {
+	stations := append([]models.WeatherStation(nil), configured...)
+	known := make(map[string]bool, len(configured))
+	for _, st := range configured {
+		known[st.ID] = true
+	}
+	for _, id := range ids {
+		if !known[id] {
+			stations = append(stations, models.WeatherStation{ID: id, Name: id})
+			known[id] = true
+		}
+	}
+	return stations
+}
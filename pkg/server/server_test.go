@@ -1,13 +1,18 @@
 package server_test
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 	"time"
 
 	"github.com/ask-23/go-wx/internal/models"
+	"github.com/ask-23/go-wx/pkg/config"
+	"github.com/ask-23/go-wx/pkg/server"
 )
 
 // MockWeatherData returns a sample weather data record for testing
@@ -19,7 +24,7 @@ func MockWeatherData() *models.WeatherData {
 		Pressure:      1012.5,
 		WindSpeed:     8.0,
 		WindDirection: 180.0,
-		Rain:          0.0,
+		RainRate:      0.0,
 		UVIndex:       5.0,
 		CloudBase:     4500.0,
 		DewPoint:      50.0,
@@ -75,3 +80,55 @@ func TestJSONResponse(t *testing.T) {
 		t.Errorf("Expected humidity 45.0, got %.1f", responseData.Humidity)
 	}
 }
+
+// TestRunShutdown tests running the web server until it is shut down
+func TestRunShutdown(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+
+	cfg := config.ServerConfig{Address: "127.0.0.1", Port: port}
+	srv, err := server.NewServer(cfg, []models.WeatherStation{{ID: models.DefaultStationID}}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	// A port in use fails the run
+	if err := srv.Run(context.Background()); err == nil {
+		t.Errorf("Expected an error when the port is taken")
+	}
+	l.Close()
+
+	// Handlers of other components are served alongside the web interface
+	srv.Handle("/ingest/ecowitt", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	result := make(chan error)
+	go func() { result <- srv.Run(context.Background()) }()
+
+	// Retry until the server is up
+	for attempt := 0; ; attempt++ {
+		resp, err := http.Post(fmt.Sprintf("http://127.0.0.1:%d/ingest/ecowitt", port), "text/plain", nil)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusNoContent {
+				t.Errorf("Expected the added handler to respond, got status %d", resp.StatusCode)
+			}
+			break
+		}
+		if attempt == 100 {
+			t.Fatalf("Failed to reach server: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Errorf("Expected shutdown to succeed, got %v", err)
+	}
+	if err := <-result; err != nil {
+		t.Errorf("Expected run to return nil, got %v", err)
+	}
+}
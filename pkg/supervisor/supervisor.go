@@ -0,0 +1,186 @@
+// Package supervisor runs the long-running components of go-wx, restarting
+// those that fail and shutting them down gracefully
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Component is a long-running part of go-wx, such as the interceptor, the
+// web server or a publisher
+type Component interface {
+	Name() string
+
+	// Run runs the component until it is shut down, returning nil, or until
+	// it fails. Canceling ctx stops it promptly: its work in progress is
+	// abandoned, or given a short grace period of the component's own.
+	Run(ctx context.Context) error
+
+	// Shutdown stops the component, draining its work in progress until ctx
+	// is done
+	Shutdown(ctx context.Context) error
+}
+
+// Backoff bounds the delay before restarting a component that failed
+const (
+	minBackoff = time.Second
+	maxBackoff = time.Minute
+)
+
+// Supervisor runs components and restarts those that fail
+type Supervisor struct {
+	components      []Component
+	shutdownTimeout time.Duration
+	minBackoff      time.Duration
+	maxBackoff      time.Duration
+}
+
+// New creates a supervisor for components, giving each up to
+// shutdownTimeout to drain its work when shutting down
+func New(shutdownTimeout time.Duration, components ...Component) *Supervisor {
+	return &Supervisor{
+		components:      components,
+		shutdownTimeout: shutdownTimeout,
+		minBackoff:      minBackoff,
+		maxBackoff:      maxBackoff,
+	}
+}
+
+// restartPoll is how often a component that started while it was being shut
+// down is asked again to shut down, until its run returns
+const restartPoll = 100 * time.Millisecond
+
+// runState is what the supervising goroutines share during a run
+type runState struct {
+	mutex    sync.Mutex
+	stopping bool
+	stopped  chan struct{}   // closed once stopping is set
+	done     []chan struct{} // per component, closed when its current run returns
+}
+
+// Run runs every component until ctx is canceled. A component that fails is
+// restarted after a delay that doubles with each consecutive failure. Once
+// ctx is canceled the components are shut down in the reverse order they
+// were given, and the errors of those that could not drain are returned.
+func (s *Supervisor) Run(ctx context.Context) error {
+	// Components keep running while they drain; runCtx stops them for good
+	runCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	state := &runState{
+		stopped: make(chan struct{}),
+		done:    make([]chan struct{}, len(s.components)),
+	}
+	var wg sync.WaitGroup
+	for n, c := range s.components {
+		wg.Add(1)
+		go func(n int, c Component) {
+			defer wg.Done()
+			s.supervise(runCtx, state, n, c)
+		}(n, c)
+	}
+
+	<-ctx.Done()
+
+	// No component starts again from here on
+	state.mutex.Lock()
+	state.stopping = true
+	close(state.stopped)
+	state.mutex.Unlock()
+
+	var errs []error
+	for n := len(s.components) - 1; n >= 0; n-- {
+		c := s.components[n]
+		shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), s.shutdownTimeout)
+		if err := s.shutdown(shutdownCtx, state, n, c); err != nil {
+			log.Printf("Error shutting down %s: %v", c.Name(), err)
+			errs = append(errs, fmt.Errorf("%s: %w", c.Name(), err))
+		} else {
+			log.Printf("Stopped %s", c.Name())
+		}
+		cancelShutdown()
+	}
+
+	cancel()
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// shutdown shuts down component n. A run that began just before stopping
+// was set may not have started the component when it is first shut down,
+// so it is shut down again until that run returns or ctx is done.
+func (s *Supervisor) shutdown(ctx context.Context, state *runState, n int, c Component) error {
+	state.mutex.Lock()
+	done := state.done[n]
+	state.mutex.Unlock()
+
+	err := c.Shutdown(ctx)
+	if done == nil {
+		return err
+	}
+	for {
+		select {
+		case <-done:
+			return err
+		case <-ctx.Done():
+			return err
+		case <-time.After(restartPoll):
+			err = c.Shutdown(ctx)
+		}
+	}
+}
+
+// supervise runs component n, restarting it when it fails, until the run
+// is stopping
+func (s *Supervisor) supervise(ctx context.Context, state *runState, n int, c Component) {
+	backoff := s.minBackoff
+	for {
+		// Check for stopping and record the run under the same lock, so a
+		// component is never started after it has been shut down
+		state.mutex.Lock()
+		if state.stopping {
+			state.mutex.Unlock()
+			return
+		}
+		done := make(chan struct{})
+		state.done[n] = done
+		state.mutex.Unlock()
+
+		started := time.Now()
+		err := c.Run(ctx)
+		close(done)
+
+		select {
+		case <-state.stopped:
+			return
+		default:
+		}
+
+		if err == nil {
+			err = errors.New("stopped unexpectedly")
+		}
+
+		// A component that ran for a while before failing starts over
+		if time.Since(started) > s.maxBackoff {
+			backoff = s.minBackoff
+		}
+
+		log.Printf("Error running %s: %v; restarting in %v", c.Name(), err, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-state.stopped:
+			return
+		}
+
+		backoff *= 2
+		if backoff > s.maxBackoff {
+			backoff = s.maxBackoff
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// MockComponent fails a number of times before running until it is shut down
+type MockComponent struct {
+	name     string
+	failures int
+	drain    time.Duration // time taken to drain on shutdown
+	starting time.Duration // time taken to start running
+	log      *[]string
+	runs     int
+	stop     chan struct{}
+	canceled bool // whether the last run was stopped by its context
+	mutex    sync.Mutex
+}
+
+func (m *MockComponent) Name() string {
+	return m.name
+}
+
+func (m *MockComponent) Run(ctx context.Context) error {
+	m.mutex.Lock()
+	m.runs++
+	if m.runs <= m.failures {
+		m.mutex.Unlock()
+		return errors.New("listener failed")
+	}
+	m.mutex.Unlock()
+
+	time.Sleep(m.starting)
+
+	m.mutex.Lock()
+	m.stop = make(chan struct{})
+	stop := m.stop
+	m.mutex.Unlock()
+
+	select {
+	case <-stop:
+	case <-ctx.Done():
+		m.mutex.Lock()
+		m.canceled = true
+		m.mutex.Unlock()
+	}
+	return nil
+}
+
+func (m *MockComponent) Shutdown(ctx context.Context) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	*m.log = append(*m.log, m.name)
+	if m.stop != nil {
+		close(m.stop)
+		m.stop = nil
+	}
+
+	select {
+	case <-time.After(m.drain):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// running reports whether the component is running
+func (m *MockComponent) running() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.stop != nil
+}
+
+// newSupervisor returns a supervisor restarting components without delay
+func newSupervisor(timeout time.Duration, components ...Component) *Supervisor {
+	s := New(timeout, components...)
+	s.minBackoff = time.Millisecond
+	s.maxBackoff = 10 * time.Millisecond
+	return s
+}
+
+// waitFor waits for condition to hold
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for components")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestRestart tests that a failing component is restarted
+func TestRestart(t *testing.T) {
+	var order []string
+	failing := &MockComponent{name: "interceptor", failures: 3, log: &order}
+	steady := &MockComponent{name: "web server", log: &order}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error)
+	go func() { result <- newSupervisor(time.Second, failing, steady).Run(ctx) }()
+
+	waitFor(t, func() bool { return failing.running() && steady.running() })
+	if failing.runs != 4 {
+		t.Errorf("Expected the failing component to run 4 times, got %d", failing.runs)
+	}
+	if steady.runs != 1 {
+		t.Errorf("Expected the steady component to run once, got %d", steady.runs)
+	}
+
+	cancel()
+	if err := <-result; err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+
+	// Components are shut down in reverse order
+	if len(order) != 2 || order[0] != "web server" || order[1] != "interceptor" {
+		t.Errorf("Expected shutdown of web server then interceptor, got %v", order)
+	}
+}
+
+// TestShutdownTimeout tests that a component that cannot drain in time is reported
+func TestShutdownTimeout(t *testing.T) {
+	var order []string
+	slow := &MockComponent{name: "publisher", drain: time.Minute, log: &order}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error)
+	go func() { result <- newSupervisor(10*time.Millisecond, slow).Run(ctx) }()
+
+	waitFor(t, slow.running)
+	cancel()
+
+	err := <-result
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected a deadline error, got %v", err)
+	}
+}
+
+// TestStopDuringBackoff tests that a component waiting to be restarted is
+// not started again once shutdown has begun
+func TestStopDuringBackoff(t *testing.T) {
+	var order []string
+	failing := &MockComponent{name: "interceptor", failures: 1, log: &order}
+
+	s := newSupervisor(time.Second, failing)
+	s.minBackoff = 50 * time.Millisecond
+	s.maxBackoff = 50 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error)
+	go func() { result <- s.Run(ctx) }()
+
+	// Cancel while the failed component waits out its backoff
+	waitFor(t, func() bool {
+		failing.mutex.Lock()
+		defer failing.mutex.Unlock()
+		return failing.runs == 1
+	})
+	cancel()
+	if err := <-result; err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+
+	// Well past the backoff, the component has not been started again
+	time.Sleep(100 * time.Millisecond)
+	failing.mutex.Lock()
+	defer failing.mutex.Unlock()
+	if failing.runs != 1 || failing.stop != nil {
+		t.Errorf("Expected no restart after shutdown, got %d runs", failing.runs)
+	}
+}
+
+// TestStopWhileStarting tests that a component still starting when it is
+// first shut down is shut down again once it runs, rather than left running
+// until the supervisor gives up on it
+func TestStopWhileStarting(t *testing.T) {
+	var order []string
+	slow := &MockComponent{name: "poller", starting: 50 * time.Millisecond, log: &order}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error)
+	go func() { result <- newSupervisor(time.Second, slow).Run(ctx) }()
+
+	waitFor(t, func() bool {
+		slow.mutex.Lock()
+		defer slow.mutex.Unlock()
+		return slow.runs == 1
+	})
+	cancel()
+	if err := <-result; err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+
+	slow.mutex.Lock()
+	defer slow.mutex.Unlock()
+	if slow.canceled || slow.stop != nil {
+		t.Errorf("Expected the component to be shut down, not canceled")
+	}
+	if len(order) < 2 {
+		t.Errorf("Expected the component to be shut down again once running, got %v", order)
+	}
+}
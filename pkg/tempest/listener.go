@@ -0,0 +1,306 @@
+package tempest
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/ask-23/go-wx/internal/models"
+)
+
+// Sink receives the observations a listener decodes, such as the interceptor
+type Sink interface {
+	// ResolveStation returns the ID of the station identified by form values
+	ResolveStation(form url.Values) string
+	// Submit stores an observation
+	Submit(data *models.WeatherData) error
+}
+
+// rainEventDryTime is how long without rain ends a rain event
+const rainEventDryTime = 24 * time.Hour
+
+// device is the state kept between the messages of one Tempest. Hubs only
+// broadcast the rain of the last report interval, so the listener counts the
+// rain of the day and of the current rain event for the rain accounting,
+// like a console's counters.
+type device struct {
+	station    string
+	day        time.Time  // local day the daily counts cover
+	dailyRain  float64    // mm today
+	eventRain  float64    // mm since the rain started
+	rainStart  *time.Time // start of the current rain event
+	lastRain   time.Time  // last time rain fell or a rain event started
+	strikes    int        // lightning strikes today
+	lastStrike *strike
+	gust       float64 // highest rapid_wind speed since the last observation
+}
+
+// Listener receives the broadcasts of Tempest hubs and submits their
+// observations. Rapid wind readings and lightning and rain start events
+// are added to the next observation of the device.
+type Listener struct {
+	address  string
+	sink     Sink
+	altitude float64 // meters, to reduce the pressure to sea level
+	devices  map[string]*device
+	done     chan struct{} // closed to ask Run to return
+	stopped  chan struct{} // closed once Run has returned
+	running  bool
+	mutex    sync.Mutex
+}
+
+// NewListener creates a listener receiving broadcasts on address, e.g.
+// :50222, from devices at altitude meters
+func NewListener(address string, sink Sink, altitude float64) *Listener {
+	return &Listener{
+		address:  address,
+		sink:     sink,
+		altitude: altitude,
+		devices:  make(map[string]*device),
+	}
+}
+
+// Name returns the name the listener is reported under
+func (l *Listener) Name() string {
+	return "tempest listener"
+}
+
+// Run receives broadcasts until the listener is shut down or ctx is canceled
+func (l *Listener) Run(ctx context.Context) error {
+	l.mutex.Lock()
+	if l.running {
+		l.mutex.Unlock()
+		return fmt.Errorf("tempest listener is already running")
+	}
+	done, stopped := make(chan struct{}), make(chan struct{})
+	l.done, l.stopped = done, stopped
+	l.running = true
+	l.mutex.Unlock()
+
+	defer func() {
+		l.mutex.Lock()
+		l.running = false
+		l.mutex.Unlock()
+		close(stopped)
+	}()
+
+	addr, err := net.ResolveUDPAddr("udp4", l.address)
+	if err != nil {
+		return fmt.Errorf("invalid tempest listener address: %w", err)
+	}
+	conn, err := net.ListenUDP("udp4", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for Tempest broadcasts: %w", err)
+	}
+
+	// Close the socket when asked to stop, which ends the read below
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		case <-finished:
+		}
+		conn.Close()
+	}()
+
+	log.Printf("Listening for Tempest broadcasts on %s", conn.LocalAddr())
+	buf := make([]byte, 4096)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-done:
+				return nil
+			case <-ctx.Done():
+				return nil
+			default:
+				return fmt.Errorf("failed to receive Tempest broadcast: %w", err)
+			}
+		}
+		l.handle(buf[:n], time.Now())
+	}
+}
+
+// handle processes a broadcast received at receivedAt
+func (l *Listener) handle(b []byte, receivedAt time.Time) {
+	msg, err := parseMessage(b)
+	if err != nil {
+		log.Printf("Ignoring Tempest broadcast: %v", err)
+		return
+	}
+
+	d, ok := l.devices[msg.SerialNumber]
+	if !ok {
+		d = &device{station: l.sink.ResolveStation(url.Values{"ID": {msg.SerialNumber}})}
+		l.devices[msg.SerialNumber] = d
+		log.Printf("Receiving broadcasts of Tempest %s for station %s", msg.SerialNumber, d.station)
+	}
+
+	switch msg.Type {
+	case typeObservation:
+		observations, err := msg.observations()
+		if err != nil {
+			log.Printf("Ignoring observation of Tempest %s: %v", msg.SerialNumber, err)
+			return
+		}
+		for _, obs := range observations {
+			data := l.observe(d, obs)
+			data.ReceivedAt = receivedAt
+			if err := l.sink.Submit(data); err != nil {
+				log.Printf("Error storing observation of Tempest %s at %v: %v", msg.SerialNumber, data.Timestamp, err)
+			}
+		}
+
+	case typeRapidWind:
+		wind, err := msg.rapidWind()
+		if err != nil {
+			log.Printf("Ignoring rapid wind of Tempest %s: %v", msg.SerialNumber, err)
+			return
+		}
+		if wind.speed > d.gust {
+			d.gust = wind.speed
+		}
+
+	case typeStrike:
+		s, err := msg.strike()
+		if err != nil {
+			log.Printf("Ignoring lightning strike of Tempest %s: %v", msg.SerialNumber, err)
+			return
+		}
+		d.lastStrike = &s
+
+	case typeRainStart:
+		start, err := msg.rainStart()
+		if err != nil {
+			log.Printf("Ignoring rain start of Tempest %s: %v", msg.SerialNumber, err)
+			return
+		}
+		// A new rain event counts from zero
+		d.eventRain = 0
+		d.rainStart = &start
+		d.lastRain = start
+		log.Printf("Rain started at station %s at %v", d.station, start)
+	}
+}
+
+// observe converts an observation of d into weather data, updating the
+// counts kept for the device
+func (l *Listener) observe(d *device, obs observation) *models.WeatherData {
+	data := &models.WeatherData{StationID: d.station, Timestamp: obs.time, Reported: make(map[string]bool)}
+
+	// Start the daily counts at local midnight
+	y, m, day := obs.time.Local().Date()
+	if today := time.Date(y, m, day, 0, 0, 0, 0, time.Local); !today.Equal(d.day) {
+		d.day = today
+		d.dailyRain = 0
+		d.strikes = 0
+	}
+
+	// Readings the device left out stay at 0 but are not reported
+	readings := []struct {
+		index int
+		name  string
+		field *float64
+	}{
+		{obsTemperature, "temperature", &data.Temperature},
+		{obsHumidity, "humidity", &data.Humidity},
+		{obsWindAvg, "windSpeed", &data.WindSpeed},
+		{obsWindDirection, "windDirection", &data.WindDirection},
+		{obsUV, "uvIndex", &data.UVIndex},
+		{obsSolarRadiation, "solarRadiation", &data.SolarRadiation},
+		{obsWindGust, "windGust", &data.WindGust},
+	}
+	for _, r := range readings {
+		if v, ok := obs.value(r.index); ok {
+			*r.field = v
+			data.Report(r.name)
+		}
+	}
+	if pressure, ok := obs.value(obsPressure); ok {
+		data.Pressure = pressure
+		data.RelativePressure = seaLevelPressure(pressure, l.altitude)
+		data.Report("pressure", "relativePressure")
+	}
+
+	// The rapid wind readings may have caught a stronger gust
+	if d.gust > data.WindGust {
+		data.WindGust = d.gust
+		data.Report("windGust")
+	}
+	d.gust = 0
+
+	// A rain event ends after a dry day, as on consoles, so it does not run
+	// on when the evt_precip of the next one is missed
+	rain, ok := obs.value(obsRain)
+	if (d.eventRain > 0 || d.rainStart != nil) && obs.time.Sub(d.lastRain) >= rainEventDryTime {
+		d.eventRain = 0
+		d.rainStart = nil
+	}
+	if rain > 0 {
+		d.lastRain = obs.time
+	}
+	d.dailyRain += rain
+	d.eventRain += rain
+	data.DailyRain = d.dailyRain
+	data.EventRain = d.eventRain
+	if d.rainStart != nil {
+		rainStart := *d.rainStart
+		data.RainStartTime = &rainStart
+	}
+	data.RainRate = rain / obs.reportInterval.Hours()
+	if ok {
+		data.Report("rainRate")
+	}
+
+	// Strike events give the time of the last strike; the observation counts
+	// them and stands in for an event that was missed
+	if count, _ := obs.value(obsStrikeCount); count > 0 {
+		d.strikes += int(count)
+		if d.lastStrike == nil || d.lastStrike.time.Before(obs.time.Add(-obs.reportInterval)) {
+			distance, _ := obs.value(obsStrikeDistance)
+			d.lastStrike = &strike{time: obs.time, distance: distance}
+		}
+	}
+	data.LightningCount = d.strikes
+	if d.lastStrike != nil {
+		strikeTime := d.lastStrike.time
+		data.LightningTime = &strikeTime
+		data.LightningDistance = d.lastStrike.distance
+	}
+
+	if battery, ok := obs.value(obsBattery); ok {
+		data.Channels = &models.SensorChannels{Batteries: map[string]float64{"tempestbatt": battery}}
+	}
+
+	data.CalculateDerivedValues()
+	return data
+}
+
+// Shutdown stops receiving broadcasts, waiting for the observation in
+// progress to be submitted until ctx is done
+func (l *Listener) Shutdown(ctx context.Context) error {
+	l.mutex.Lock()
+	if !l.running {
+		l.mutex.Unlock()
+		return nil
+	}
+	if l.done != nil {
+		close(l.done)
+		l.done = nil
+	}
+	stopped := l.stopped
+	l.mutex.Unlock()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("tempest listener did not finish: %w", ctx.Err())
+	}
+}
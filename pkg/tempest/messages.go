@@ -0,0 +1,154 @@
+// Package tempest receives the JSON messages WeatherFlow Tempest hubs
+// broadcast on the local network over UDP port 50222
+package tempest
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+)
+
+// DefaultPort is the UDP port hubs broadcast on
+const DefaultPort = 50222
+
+// Message types handled; hubs broadcast others, such as hub_status, too
+const (
+	typeObservation = "obs_st"
+	typeRapidWind   = "rapid_wind"
+	typeStrike      = "evt_strike"
+	typeRainStart   = "evt_precip"
+)
+
+// message is a broadcast of a hub. The values of each type are arrays,
+// with nulls for readings the device has not got.
+type message struct {
+	SerialNumber string       `json:"serial_number"` // device that took the readings, e.g. ST-00012345
+	Type         string       `json:"type"`
+	HubSN        string       `json:"hub_sn"`
+	Obs          [][]*float64 `json:"obs"` // obs_st observations
+	Ob           []*float64   `json:"ob"`  // rapid_wind reading
+	Evt          []*float64   `json:"evt"` // evt_strike and evt_precip event
+}
+
+// Indexes of the values of an obs_st observation
+const (
+	obsTime = iota
+	obsWindLull
+	obsWindAvg
+	obsWindGust
+	obsWindDirection
+	obsWindInterval
+	obsPressure
+	obsTemperature
+	obsHumidity
+	obsIlluminance
+	obsUV
+	obsSolarRadiation
+	obsRain
+	obsPrecipitationType
+	obsStrikeDistance
+	obsStrikeCount
+	obsBattery
+	obsReportInterval
+	obsValues
+)
+
+// observation is an obs_st observation: wind in m/s, pressure at the station
+// in hPa, temperature in °C, rain in mm over the report interval and the
+// battery in volts
+type observation struct {
+	time           time.Time
+	values         []*float64
+	reportInterval time.Duration
+}
+
+// value returns the reading at index and whether the device reported it
+func (o *observation) value(index int) (float64, bool) {
+	if index >= len(o.values) || o.values[index] == nil {
+		return 0, false
+	}
+	return *o.values[index], true
+}
+
+// rapidWind is a rapid_wind reading, taken every few seconds
+type rapidWind struct {
+	time      time.Time
+	speed     float64 // m/s
+	direction float64 // degrees
+}
+
+// strike is an evt_strike lightning strike
+type strike struct {
+	time     time.Time
+	distance float64 // km
+}
+
+// parseMessage decodes a broadcast
+func parseMessage(b []byte) (*message, error) {
+	var msg message
+	if err := json.Unmarshal(b, &msg); err != nil {
+		return nil, fmt.Errorf("invalid message: %w", err)
+	}
+	if msg.SerialNumber == "" {
+		return nil, fmt.Errorf("message without serial number")
+	}
+	return &msg, nil
+}
+
+// observations returns the observations of an obs_st message
+func (m *message) observations() ([]observation, error) {
+	var result []observation
+	for _, values := range m.Obs {
+		if len(values) < obsValues {
+			return nil, fmt.Errorf("obs_st observation with %d values", len(values))
+		}
+		if values[obsTime] == nil {
+			return nil, fmt.Errorf("obs_st observation without a time")
+		}
+
+		obs := observation{time: epoch(*values[obsTime]), values: values, reportInterval: time.Minute}
+		if minutes, ok := obs.value(obsReportInterval); ok && minutes > 0 {
+			obs.reportInterval = time.Duration(minutes) * time.Minute
+		}
+		result = append(result, obs)
+	}
+	return result, nil
+}
+
+// rapidWind returns the reading of a rapid_wind message: time, speed and
+// direction
+func (m *message) rapidWind() (rapidWind, error) {
+	if len(m.Ob) < 3 || m.Ob[0] == nil || m.Ob[1] == nil || m.Ob[2] == nil {
+		return rapidWind{}, fmt.Errorf("invalid rapid_wind reading")
+	}
+	return rapidWind{time: epoch(*m.Ob[0]), speed: *m.Ob[1], direction: *m.Ob[2]}, nil
+}
+
+// strike returns the strike of an evt_strike message: time, distance and
+// energy
+func (m *message) strike() (strike, error) {
+	if len(m.Evt) < 2 || m.Evt[0] == nil || m.Evt[1] == nil {
+		return strike{}, fmt.Errorf("invalid evt_strike event")
+	}
+	return strike{time: epoch(*m.Evt[0]), distance: *m.Evt[1]}, nil
+}
+
+// rainStart returns the time of an evt_precip message
+func (m *message) rainStart() (time.Time, error) {
+	if len(m.Evt) < 1 || m.Evt[0] == nil {
+		return time.Time{}, fmt.Errorf("invalid evt_precip event")
+	}
+	return epoch(*m.Evt[0]), nil
+}
+
+// epoch converts seconds since the Unix epoch to a time
+func epoch(seconds float64) time.Time {
+	return time.Unix(int64(seconds), 0).UTC()
+}
+
+// seaLevelPressure reduces the pressure at a station at altitude meters to
+// sea level with the international standard atmosphere
+func seaLevelPressure(pressure, altitude float64) float64 {
+	return pressure * math.Pow(1-0.0065*altitude/288.15, -5.255)
+}
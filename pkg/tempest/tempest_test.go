@@ -0,0 +1,220 @@
+package tempest
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ask-23/go-wx/internal/models"
+)
+
+// MockSink records submitted observations
+type MockSink struct {
+	submitted []*models.WeatherData
+	mutex     sync.Mutex
+}
+
+func (m *MockSink) ResolveStation(form url.Values) string {
+	return form.Get("ID")
+}
+
+func (m *MockSink) Submit(data *models.WeatherData) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.submitted = append(m.submitted, data)
+	return nil
+}
+
+func (m *MockSink) count() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return len(m.submitted)
+}
+
+// observationMessage is an obs_st broadcast taken at 1588948614 with 0.3 mm
+// of rain and 2 strikes during its minute
+const observationMessage = `{"serial_number":"ST-00000512","type":"obs_st","hub_sn":"HB-00013030",` +
+	`"obs":[[1588948614,0.18,0.22,2.7,144,6,1017.57,22.37,50.26,328,0.03,3,0.3,1,12,2,2.41,1]],"firmware_revision":129}`
+
+// approximatelyEqual compares floats allowing for rounding
+func approximatelyEqual(a, b float64) bool {
+	return math.Abs(a-b) < 0.01
+}
+
+// TestObservation tests converting observations into weather data
+func TestObservation(t *testing.T) {
+	sink := &MockSink{}
+	listener := NewListener(":50222", sink, 0)
+	receivedAt := time.Now()
+
+	listener.handle([]byte(observationMessage), receivedAt)
+	listener.handle([]byte(`{"serial_number":"ST-00000512","type":"hub_status","hub_sn":"HB-00013030"}`), receivedAt)
+	listener.handle([]byte(`not json`), receivedAt)
+	if sink.count() != 1 {
+		t.Fatalf("Expected 1 observation, got %d", sink.count())
+	}
+
+	data := sink.submitted[0]
+	if data.StationID != "ST-00000512" || !data.Timestamp.Equal(time.Unix(1588948614, 0)) || !data.ReceivedAt.Equal(receivedAt) {
+		t.Errorf("Expected station ST-00000512 at 1588948614, got %s at %v", data.StationID, data.Timestamp)
+	}
+
+	tests := []struct {
+		name     string
+		got      float64
+		expected float64
+	}{
+		{"temperature", data.Temperature, 22.37},
+		{"humidity", data.Humidity, 50.26},
+		{"pressure", data.Pressure, 1017.57},
+		{"relativePressure", data.RelativePressure, 1017.57},
+		{"windSpeed", data.WindSpeed, 0.22},
+		{"windGust", data.WindGust, 2.7},
+		{"windDirection", data.WindDirection, 144},
+		{"uvIndex", data.UVIndex, 0.03},
+		{"solarRadiation", data.SolarRadiation, 3},
+		{"dailyRain", data.DailyRain, 0.3},
+		{"rainRate", data.RainRate, 18},
+		{"lightningDistance", data.LightningDistance, 12},
+		{"battery", data.Channels.Batteries["tempestbatt"], 2.41},
+	}
+	for _, tt := range tests {
+		if !approximatelyEqual(tt.got, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.expected, tt.got)
+		}
+	}
+	if data.LightningCount != 2 || data.LightningTime == nil || !data.LightningTime.Equal(data.Timestamp) {
+		t.Errorf("Expected 2 strikes during the observation, got %d at %v", data.LightningCount, data.LightningTime)
+	}
+
+	// The counts keep adding up through the day
+	listener.handle([]byte(observationMessage), receivedAt)
+	if data := sink.submitted[1]; !approximatelyEqual(data.DailyRain, 0.6) || data.LightningCount != 4 {
+		t.Errorf("Expected 0.6 mm and 4 strikes today, got %v mm and %d strikes", data.DailyRain, data.LightningCount)
+	}
+}
+
+// TestEvents tests adding rapid wind readings and events to the next
+// observation
+func TestEvents(t *testing.T) {
+	sink := &MockSink{}
+	listener := NewListener(":50222", sink, 100)
+	receivedAt := time.Now()
+
+	listener.handle([]byte(observationMessage), receivedAt)
+	listener.handle([]byte(`{"serial_number":"ST-00000512","type":"rapid_wind","hub_sn":"HB-00013030","ob":[1588948650,6.5,128]}`), receivedAt)
+	listener.handle([]byte(`{"serial_number":"ST-00000512","type":"rapid_wind","hub_sn":"HB-00013030","ob":[1588948653,4.1,130]}`), receivedAt)
+	listener.handle([]byte(`{"serial_number":"ST-00000512","type":"evt_strike","hub_sn":"HB-00013030","evt":[1588948660,27,3848]}`), receivedAt)
+	listener.handle([]byte(`{"serial_number":"ST-00000512","type":"evt_precip","hub_sn":"HB-00013030","evt":[1588948665]}`), receivedAt)
+	listener.handle([]byte(`{"serial_number":"ST-00000512","type":"obs_st","hub_sn":"HB-00013030",`+
+		`"obs":[[1588948674,0.5,1.2,2.2,130,6,1017.5,22.3,50,300,0.03,3,0.1,1,27,1,2.41,1]]}`), receivedAt)
+	if sink.count() != 2 {
+		t.Fatalf("Expected 2 observations, got %d", sink.count())
+	}
+
+	data := sink.submitted[1]
+	if data.WindGust != 6.5 {
+		t.Errorf("Expected the rapid wind gust of 6.5 m/s, got %v", data.WindGust)
+	}
+	if data.LightningTime == nil || !data.LightningTime.Equal(time.Unix(1588948660, 0)) || data.LightningDistance != 27 {
+		t.Errorf("Expected the strike 27 km away at 1588948660, got %v km at %v", data.LightningDistance, data.LightningTime)
+	}
+	if data.LightningCount != 3 {
+		t.Errorf("Expected 3 strikes today, got %d", data.LightningCount)
+	}
+	if !approximatelyEqual(data.EventRain, 0.1) || !approximatelyEqual(data.DailyRain, 0.4) {
+		t.Errorf("Expected 0.1 mm since the rain started and 0.4 mm today, got %v and %v", data.EventRain, data.DailyRain)
+	}
+	if data.RainStartTime == nil || !data.RainStartTime.Equal(time.Unix(1588948665, 0)) {
+		t.Errorf("Expected the rain to have started at 1588948665, got %v", data.RainStartTime)
+	}
+	if sink.submitted[0].RainStartTime != nil {
+		t.Errorf("Expected no rain start before the event, got %v", sink.submitted[0].RainStartTime)
+	}
+	if !approximatelyEqual(data.RelativePressure, 1029.65) {
+		t.Errorf("Expected sea level pressure 1029.65 hPa at 100 m, got %v", data.RelativePressure)
+	}
+
+	// The gust is only carried to one observation
+	listener.handle([]byte(observationMessage), receivedAt)
+	if gust := sink.submitted[2].WindGust; gust != 2.7 {
+		t.Errorf("Expected gust 2.7 m/s, got %v", gust)
+	}
+}
+
+// rainObservation returns an observation message at ts with rain mm fallen
+func rainObservation(ts int64, rain float64) []byte {
+	return []byte(fmt.Sprintf(`{"serial_number":"ST-00000512","type":"obs_st","hub_sn":"HB-00013030",`+
+		`"obs":[[%d,0.18,0.22,2.7,144,6,1017.57,22.37,50.26,328,0.03,3,%g,1,12,2,2.41,1]]}`, ts, rain))
+}
+
+// TestRainEventEnd tests that a rain event ends after a dry day
+func TestRainEventEnd(t *testing.T) {
+	sink := &MockSink{}
+	listener := NewListener(":50222", sink, 100)
+	receivedAt := time.Now()
+	start := int64(1588948665)
+
+	listener.handle([]byte(fmt.Sprintf(`{"serial_number":"ST-00000512","type":"evt_precip","hub_sn":"HB-00013030","evt":[%d]}`, start)), receivedAt)
+	listener.handle(rainObservation(start+10, 0.5), receivedAt)
+	listener.handle(rainObservation(start+12*3600, 0), receivedAt)
+	if data := sink.submitted[1]; !approximatelyEqual(data.EventRain, 0.5) || data.RainStartTime == nil {
+		t.Errorf("Expected the rain event to go on through a dry spell, got %v mm since %v", data.EventRain, data.RainStartTime)
+	}
+
+	// A day without rain ends the event
+	listener.handle(rainObservation(start+25*3600, 0), receivedAt)
+	if data := sink.submitted[2]; data.EventRain != 0 || data.RainStartTime != nil {
+		t.Errorf("Expected the rain event to be over, got %v mm since %v", data.EventRain, data.RainStartTime)
+	}
+
+	// Rain without an event counts towards a new one
+	listener.handle(rainObservation(start+26*3600, 0.2), receivedAt)
+	if data := sink.submitted[3]; !approximatelyEqual(data.EventRain, 0.2) {
+		t.Errorf("Expected 0.2 mm in the new event, got %v", data.EventRain)
+	}
+}
+
+// TestListener tests receiving broadcasts over UDP
+func TestListener(t *testing.T) {
+	// Find a free port for the listener
+	probe, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	address := probe.LocalAddr().String()
+	probe.Close()
+
+	sink := &MockSink{}
+	listener := NewListener(address, sink, 0)
+	result := make(chan error)
+	go func() { result <- listener.Run(context.Background()) }()
+
+	conn, err := net.Dial("udp4", address)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	// Broadcast until the listener is up and has received one
+	deadline := time.Now().Add(5 * time.Second)
+	for sink.count() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for an observation")
+		}
+		conn.Write([]byte(observationMessage))
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := listener.Shutdown(context.Background()); err != nil {
+		t.Errorf("Expected shutdown to succeed, got %v", err)
+	}
+	if err := <-result; err != nil {
+		t.Errorf("Expected run to return nil, got %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+// Package units provides typed conversions between the imperial units sent by
+// weather station consoles and the SI units stored in models.WeatherData.
+package units
+
+// Conversion factors
+const (
+	hPaPerInHg = 33.86389
+	mpsPerMph  = 0.44704
+	mmPerInch  = 25.4
+)
+
+// Fahrenheit is a temperature in degrees Fahrenheit
+type Fahrenheit float64
+
+// Celsius converts the temperature to degrees Celsius
+func (f Fahrenheit) Celsius() Celsius {
+	return Celsius((float64(f) - 32) * 5 / 9)
+}
+
+// Celsius is a temperature in degrees Celsius
+type Celsius float64
+
+// Fahrenheit converts the temperature to degrees Fahrenheit
+func (c Celsius) Fahrenheit() Fahrenheit {
+	return Fahrenheit(float64(c)*9/5 + 32)
+}
+
+// InHg is a pressure in inches of mercury
+type InHg float64
+
+// HPa converts the pressure to hectopascals
+func (p InHg) HPa() HPa {
+	return HPa(float64(p) * hPaPerInHg)
+}
+
+// HPa is a pressure in hectopascals (equivalent to millibars)
+type HPa float64
+
+// InHg converts the pressure to inches of mercury
+func (p HPa) InHg() InHg {
+	return InHg(float64(p) / hPaPerInHg)
+}
+
+// MilesPerHour is a speed in miles per hour
+type MilesPerHour float64
+
+// MetersPerSecond converts the speed to meters per second
+func (s MilesPerHour) MetersPerSecond() MetersPerSecond {
+	return MetersPerSecond(float64(s) * mpsPerMph)
+}
+
+// MetersPerSecond is a speed in meters per second
+type MetersPerSecond float64
+
+// MilesPerHour converts the speed to miles per hour
+func (s MetersPerSecond) MilesPerHour() MilesPerHour {
+	return MilesPerHour(float64(s) / mpsPerMph)
+}
+
+// Inches is a length in inches, used for rainfall
+type Inches float64
+
+// Millimeters converts the length to millimeters
+func (l Inches) Millimeters() Millimeters {
+	return Millimeters(float64(l) * mmPerInch)
+}
+
+// Millimeters is a length in millimeters, used for rainfall
+type Millimeters float64
+
+// Inches converts the length to inches
+func (l Millimeters) Inches() Inches {
+	return Inches(float64(l) / mmPerInch)
+}
@@ -0,0 +1,53 @@
+package units
+
+import (
+	"math"
+	"testing"
+)
+
+// TestConversions tests the typed unit conversions against known reference values
+func TestConversions(t *testing.T) {
+	tests := []struct {
+		name      string
+		got       float64
+		expected  float64
+		tolerance float64
+	}{
+		{"Freezing F to C", float64(Fahrenheit(32).Celsius()), 0.0, 0.001},
+		{"Boiling F to C", float64(Fahrenheit(212).Celsius()), 100.0, 0.001},
+		{"Body C to F", float64(Celsius(37).Fahrenheit()), 98.6, 0.001},
+		{"Standard inHg to hPa", float64(InHg(29.92).HPa()), 1013.2, 0.1},
+		{"Standard hPa to inHg", float64(HPa(1013.25).InHg()), 29.92, 0.01},
+		{"mph to m/s", float64(MilesPerHour(10).MetersPerSecond()), 4.4704, 0.0001},
+		{"m/s to mph", float64(MetersPerSecond(4.4704).MilesPerHour()), 10.0, 0.0001},
+		{"in to mm", float64(Inches(1).Millimeters()), 25.4, 0.0001},
+		{"mm to in", float64(Millimeters(2.54).Inches()), 0.1, 0.0001},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if math.Abs(tc.got-tc.expected) > tc.tolerance {
+				t.Errorf("Expected %.4f±%.4f, got %.4f", tc.expected, tc.tolerance, tc.got)
+			}
+		})
+	}
+}
+
+// TestRoundTrip tests that converting there and back returns the original value
+func TestRoundTrip(t *testing.T) {
+	if v := Celsius(21.5).Fahrenheit().Celsius(); math.Abs(float64(v)-21.5) > 1e-9 {
+		t.Errorf("Temperature round trip failed, got %v", v)
+	}
+
+	if v := HPa(1012.5).InHg().HPa(); math.Abs(float64(v)-1012.5) > 1e-9 {
+		t.Errorf("Pressure round trip failed, got %v", v)
+	}
+
+	if v := MetersPerSecond(5.5).MilesPerHour().MetersPerSecond(); math.Abs(float64(v)-5.5) > 1e-9 {
+		t.Errorf("Speed round trip failed, got %v", v)
+	}
+
+	if v := Millimeters(2.5).Inches().Millimeters(); math.Abs(float64(v)-2.5) > 1e-9 {
+		t.Errorf("Rain round trip failed, got %v", v)
+	}
+}
@@ -0,0 +1,815 @@
+package wal
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ask-23/go-wx/internal/models"
+	"github.com/ask-23/go-wx/pkg/database"
+)
+
+const (
+	// DefaultSegmentSize is the size at which a new segment file is started
+	DefaultSegmentSize = 4 << 20
+
+	segmentExt     = ".wal"
+	checkpointName = "checkpoint"
+	deadLetterName = "dead-letter.jsonl"
+
+	// Each record is a big endian payload length and CRC-32 followed by the
+	// JSON encoded observation or archived upload
+	recordHeaderSize = 8
+	maxRecordSize    = 1 << 20
+
+	// maxBatchSize is the most records drained at once into a BatchStore
+	maxBatchSize = 100
+
+	// maxRejections is how many times the store may reject a record before
+	// it is moved to the dead letter file
+	maxRejections = 3
+)
+
+// Retry delays while the store is failing, doubling up to the maximum
+var (
+	minBackoff = time.Second
+	maxBackoff = time.Minute
+)
+
+// errNoRecord is returned when there is no complete record to read
+var errNoRecord = errors.New("no record")
+
+// Store is where buffered observations and archived uploads are drained to
+type Store interface {
+	SaveWeatherData(data *models.WeatherData) error
+	SaveRawUpload(upload *models.RawUpload) error
+}
+
+// BatchStore is a store that can save several observations, or several
+// archived uploads, at once. Buffers drain into a BatchStore in batches.
+type BatchStore interface {
+	SaveWeatherDataBatch(batch []*models.WeatherData) error
+	SaveRawUploadBatch(batch []*models.RawUpload) error
+}
+
+// item is a buffered observation or archived upload
+type item struct {
+	data   *models.WeatherData
+	upload *models.RawUpload
+}
+
+// payload returns the JSON encoding of the item in a record
+func (it item) payload() ([]byte, error) {
+	if it.upload != nil {
+		return json.Marshal(entry{RawUpload: it.upload})
+	}
+	return json.Marshal(entry{WeatherData: it.data, Indoor: it.data.Indoor})
+}
+
+// damagedError is returned for a record that is on disk but cannot be
+// read back, with the position reading can resume at after it
+type damagedError struct {
+	size   int64 // size of the record, or 0 when its length is damaged too
+	resume position
+	err    error
+}
+
+func (e *damagedError) Error() string {
+	return e.err.Error()
+}
+
+// deadLetter is a record moved out of the buffer because it could not be
+// saved, as a line of the dead letter file
+type deadLetter struct {
+	Time    time.Time       `json:"time"`
+	Segment uint64          `json:"segment"`
+	Offset  int64           `json:"offset"`
+	Error   string          `json:"error"`
+	Record  json.RawMessage `json:"record,omitempty"`  // the record the store rejected
+	Damaged []byte          `json:"damaged,omitempty"` // the bytes of a damaged record
+}
+
+// segmentWriter is the segment file observations are appended to
+type segmentWriter interface {
+	io.WriteCloser
+	io.Seeker
+	Sync() error
+	Truncate(size int64) error
+}
+
+// position is the location of a record in the segment files
+type position struct {
+	Segment uint64 `json:"segment"`
+	Offset  int64  `json:"offset"`
+}
+
+// Buffer is a durable queue of observations, and of the archived uploads they
+// were decoded from, in append-only segment files. It accepts them while the
+// database is unavailable and drains them into the store in the order they
+// were written, surviving restarts in between. Delivery is at least once: an
+// observation saved just before a crash may be saved again after the
+// restart, which the store ignores, as archived uploads carry their ID and
+// observations are unique by station and timestamp. Records the store keeps
+// rejecting, and records damaged on disk, are moved to a dead letter file in
+// the buffer directory so they do not hold up the records after them.
+type Buffer struct {
+	dir            string
+	store          Store
+	maxSegmentSize int64
+
+	mutex     sync.Mutex
+	writer    segmentWriter
+	writeSeg  uint64
+	writeSize int64
+	read      position
+	depth     int
+
+	notify chan struct{}
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// Open opens the buffer in dir, creating it if needed, and recovers any
+// observations that were not drained before the last shutdown
+func Open(dir string, store Store, maxSegmentSize int64) (*Buffer, error) {
+	if maxSegmentSize <= 0 {
+		maxSegmentSize = DefaultSegmentSize
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create buffer directory: %w", err)
+	}
+
+	b := &Buffer{
+		dir:            dir,
+		store:          store,
+		maxSegmentSize: maxSegmentSize,
+		notify:         make(chan struct{}, 1),
+	}
+
+	segments, err := b.segments()
+	if err != nil {
+		return nil, err
+	}
+	if len(segments) == 0 {
+		segments = []uint64{1}
+	}
+
+	// Start reading where the last drain stopped
+	b.read = b.loadCheckpoint()
+	if b.read.Segment < segments[0] {
+		b.read = position{Segment: segments[0]}
+	}
+
+	// A crash can leave a partly written record at the end of the last segment
+	b.writeSeg = segments[len(segments)-1]
+	if err := b.openWriter(); err != nil {
+		return nil, err
+	}
+
+	// Count the observations still to drain
+	for _, seg := range segments {
+		if seg < b.read.Segment {
+			continue
+		}
+		offset := int64(0)
+		if seg == b.read.Segment {
+			offset = b.read.Offset
+		}
+		n, _, err := scanSegment(b.segmentPath(seg), offset)
+		if err != nil {
+			b.writer.Close()
+			return nil, err
+		}
+		b.depth += n
+	}
+
+	if b.depth > 0 {
+		log.Printf("Recovered %d buffered observations from %s", b.depth, dir)
+	}
+
+	return b, nil
+}
+
+// entry is the encoding of a buffered observation, with the indoor readings
+// WeatherData leaves out of its JSON, or of an archived upload
+type entry struct {
+	*models.WeatherData
+	Indoor    *models.IndoorData `json:"indoor,omitempty"`
+	RawUpload *models.RawUpload  `json:"rawUpload,omitempty"`
+}
+
+// SaveWeatherData appends an observation to the buffer. It returns once the
+// observation is on disk; the drainer saves it to the store later.
+func (b *Buffer) SaveWeatherData(data *models.WeatherData) error {
+	payload, err := item{data: data}.payload()
+	if err != nil {
+		return fmt.Errorf("failed to encode buffered observation: %w", err)
+	}
+	if len(payload) > maxRecordSize {
+		return fmt.Errorf("buffered observation of %d bytes is too large", len(payload))
+	}
+	return b.append(payload)
+}
+
+// SaveRawUpload appends an archived upload, which must already have its ID,
+// to the buffer. It returns once the upload is on disk; the drainer saves it
+// to the store later.
+func (b *Buffer) SaveRawUpload(upload *models.RawUpload) error {
+	payload, err := item{upload: upload}.payload()
+	if err != nil {
+		return fmt.Errorf("failed to encode buffered upload: %w", err)
+	}
+	if len(payload) > maxRecordSize {
+		return fmt.Errorf("buffered upload of %d bytes is too large", len(payload))
+	}
+	return b.append(payload)
+}
+
+// append writes a record with payload to the end of the buffer
+func (b *Buffer) append(payload []byte) error {
+	record := make([]byte, recordHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	copy(record[recordHeaderSize:], payload)
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.writer == nil {
+		return fmt.Errorf("buffer is closed")
+	}
+
+	// Start a new segment once the current one is full
+	if b.writeSize > 0 && b.writeSize+int64(len(record)) > b.maxSegmentSize {
+		if err := b.writer.Close(); err != nil {
+			return fmt.Errorf("failed to close buffer segment: %w", err)
+		}
+		b.writeSeg++
+		if err := b.openWriter(); err != nil {
+			b.writer = nil
+			return err
+		}
+	}
+
+	if _, err := b.writer.Write(record); err != nil {
+		b.rewind()
+		return fmt.Errorf("failed to write buffer record: %w", err)
+	}
+	if err := b.writer.Sync(); err != nil {
+		b.rewind()
+		return fmt.Errorf("failed to sync buffer segment: %w", err)
+	}
+	b.writeSize += int64(len(record))
+	b.depth++
+
+	// Wake the drainer
+	select {
+	case b.notify <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// Depth returns the number of observations and archived uploads waiting to
+// be drained
+func (b *Buffer) Depth() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.depth
+}
+
+// Start begins draining buffered observations into the store
+func (b *Buffer) Start() {
+	b.done = make(chan struct{})
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		b.drain()
+	}()
+}
+
+// Stop stops draining and closes the buffer. Observations not yet drained
+// stay on disk for the next Open.
+func (b *Buffer) Stop() error {
+	if b.done != nil {
+		close(b.done)
+		b.wg.Wait()
+		b.done = nil
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.writer == nil {
+		return nil
+	}
+	err := b.writer.Close()
+	b.writer = nil
+	return err
+}
+
+// drain saves buffered records in order, retrying with backoff while the
+// store fails. A batch the store rejects is saved a record at a time, and a
+// record rejected maxRejections times or damaged on disk is moved to the
+// dead letter file and drained past.
+func (b *Buffer) drain() {
+	backoff := minBackoff
+	rejections := 0
+	var isolate position // records before it are saved one at a time
+
+	for {
+		b.mutex.Lock()
+		pos := b.read
+		b.mutex.Unlock()
+
+		batch, next, err := b.nextBatch(pos, before(pos, isolate))
+		if err == errNoRecord {
+			select {
+			case <-b.notify:
+				continue
+			case <-b.done:
+				return
+			}
+		}
+
+		var damaged *damagedError
+		discarded := false
+		switch {
+		case errors.As(err, &damaged):
+			err = b.discardDamaged(pos, damaged)
+			discarded = err == nil
+		case err == nil:
+			err = b.save(batch)
+			if err != nil && database.IsPermanent(err) {
+				if len(batch) > 1 {
+					log.Printf("Buffered batch of %d rejected, saving it a record at a time: %v", len(batch), err)
+					isolate = next
+					continue
+				}
+				if rejections++; rejections >= maxRejections {
+					err = b.discardRejected(pos, next, batch[0], err)
+					discarded = err == nil
+				}
+			}
+		}
+		if err != nil {
+			log.Printf("Error draining buffer, retrying in %v (%d buffered): %v", backoff, b.Depth(), err)
+			select {
+			case <-time.After(backoff):
+			case <-b.done:
+				return
+			}
+			backoff *= 2
+			if backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+			continue
+		}
+		rejections = 0
+		if discarded {
+			continue
+		}
+
+		if backoff > minBackoff {
+			log.Printf("Database available again, draining %d buffered observations", b.Depth()-len(batch))
+		}
+		backoff = minBackoff
+
+		if err := b.commit(next, len(batch)); err != nil {
+			log.Printf("Error recording buffer checkpoint: %v", err)
+		}
+	}
+}
+
+// discardRejected moves a record the store keeps rejecting to the dead
+// letter file and drains past it
+func (b *Buffer) discardRejected(pos, next position, it item, reason error) error {
+	payload, err := it.payload()
+	if err != nil {
+		return fmt.Errorf("failed to encode rejected buffer record: %w", err)
+	}
+	letter := deadLetter{Segment: pos.Segment, Offset: pos.Offset, Error: reason.Error(), Record: payload}
+	if err := b.writeDeadLetter(letter); err != nil {
+		return err
+	}
+
+	log.Printf("Moved buffer record at segment %d offset %d to %s after it was rejected %d times: %v",
+		pos.Segment, pos.Offset, deadLetterName, maxRejections, reason)
+	return b.commit(next, 1)
+}
+
+// discardDamaged moves the bytes of a damaged record to the dead letter
+// file and drains past them
+func (b *Buffer) discardDamaged(pos position, damaged *damagedError) error {
+	raw, err := readBytes(b.segmentPath(pos.Segment), pos.Offset, damaged.resume.Offset-pos.Offset)
+	if err != nil {
+		return err
+	}
+	letter := deadLetter{Segment: pos.Segment, Offset: pos.Offset, Error: damaged.Error(), Damaged: raw}
+	if err := b.writeDeadLetter(letter); err != nil {
+		return err
+	}
+
+	log.Printf("Moved %d damaged bytes of buffer segment %d at offset %d to %s: %v",
+		len(raw), pos.Segment, pos.Offset, deadLetterName, damaged)
+	return b.commit(damaged.resume, 1)
+}
+
+// writeDeadLetter appends a record to the dead letter file
+func (b *Buffer) writeDeadLetter(letter deadLetter) error {
+	letter.Time = time.Now().UTC()
+	line, err := json.Marshal(letter)
+	if err != nil {
+		return fmt.Errorf("failed to encode dead letter: %w", err)
+	}
+
+	f, err := os.OpenFile(filepath.Join(b.dir, deadLetterName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open dead letter file: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write dead letter: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync dead letter file: %w", err)
+	}
+	return nil
+}
+
+// save saves a batch of records of one kind to the store
+func (b *Buffer) save(batch []item) error {
+	store, batched := b.store.(BatchStore)
+
+	if batch[0].upload != nil {
+		if !batched {
+			return b.store.SaveRawUpload(batch[0].upload)
+		}
+		uploads := make([]*models.RawUpload, len(batch))
+		for n, it := range batch {
+			uploads[n] = it.upload
+		}
+		return store.SaveRawUploadBatch(uploads)
+	}
+
+	if !batched {
+		return b.store.SaveWeatherData(batch[0].data)
+	}
+	observations := make([]*models.WeatherData, len(batch))
+	for n, it := range batch {
+		observations[n] = it.data
+	}
+	return store.SaveWeatherDataBatch(observations)
+}
+
+// nextBatch reads the records of one kind from pos on, as many as the store
+// saves at once or just one when single, and returns them with the position
+// after them
+func (b *Buffer) nextBatch(pos position, single bool) ([]item, position, error) {
+	limit := 1
+	if _, ok := b.store.(BatchStore); ok && !single {
+		limit = maxBatchSize
+	}
+
+	var batch []item
+	for len(batch) < limit {
+		it, next, err := b.next(pos)
+		if err != nil {
+			if len(batch) == 0 {
+				return nil, pos, err
+			}
+			break
+		}
+		// A batch holds records of one kind
+		if len(batch) > 0 && (it.upload != nil) != (batch[0].upload != nil) {
+			break
+		}
+		batch = append(batch, it)
+		pos = next
+	}
+
+	return batch, pos, nil
+}
+
+// next reads the record at pos and returns it with the position after it,
+// moving on to the following segment at the end of a segment
+func (b *Buffer) next(pos position) (item, position, error) {
+	b.mutex.Lock()
+	writeSeg, writeSize := b.writeSeg, b.writeSize
+	b.mutex.Unlock()
+
+	for {
+		end := writeSize
+		if pos.Segment < writeSeg {
+			info, err := os.Stat(b.segmentPath(pos.Segment))
+			if err != nil && !os.IsNotExist(err) {
+				return item{}, pos, fmt.Errorf("failed to stat buffer segment: %w", err)
+			}
+			end = 0
+			if err == nil {
+				end = info.Size()
+			}
+		}
+
+		if pos.Offset < end {
+			it, size, err := readRecord(b.segmentPath(pos.Segment), pos.Offset)
+			if err == nil {
+				return it, position{pos.Segment, pos.Offset + size}, nil
+			}
+
+			// Resume after a damaged record, or when its length cannot be
+			// trusted, after the rest of the segment written so far
+			var damaged *damagedError
+			if errors.As(err, &damaged) {
+				damaged.resume = position{pos.Segment, end}
+				if damaged.size > 0 && pos.Offset+damaged.size <= end {
+					damaged.resume.Offset = pos.Offset + damaged.size
+				}
+			}
+			return item{}, pos, err
+		}
+
+		if pos.Segment >= writeSeg {
+			return item{}, pos, errNoRecord
+		}
+		pos = position{Segment: pos.Segment + 1}
+	}
+}
+
+// commit records that the n records before pos have been saved and
+// removes the segments before it
+func (b *Buffer) commit(pos position, n int) error {
+	b.mutex.Lock()
+	first := b.read.Segment
+	b.read = pos
+	b.depth -= n
+	if b.depth < 0 {
+		b.depth = 0 // damaged records may not have been counted
+	}
+	b.mutex.Unlock()
+
+	if err := b.saveCheckpoint(pos); err != nil {
+		return err
+	}
+
+	for seg := first; seg < pos.Segment; seg++ {
+		if err := os.Remove(b.segmentPath(seg)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove buffer segment: %w", err)
+		}
+	}
+	return nil
+}
+
+// openWriter opens the write segment for appending, truncating any partly
+// written record at its end
+func (b *Buffer) openWriter() error {
+	path := b.segmentPath(b.writeSeg)
+	_, valid, err := scanSegment(path, 0)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open buffer segment: %w", err)
+	}
+	if err := f.Truncate(valid); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to truncate buffer segment: %w", err)
+	}
+	if _, err := f.Seek(valid, io.SeekStart); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to seek buffer segment: %w", err)
+	}
+
+	b.writer = f
+	b.writeSize = valid
+	return nil
+}
+
+// rewind drops the part of a failed write that reached the write segment,
+// so the next record follows the last complete one. When the segment cannot
+// be cut back it is reopened, which truncates it at the first damaged
+// record. The caller holds the mutex.
+func (b *Buffer) rewind() {
+	err := b.writer.Truncate(b.writeSize)
+	if err == nil {
+		_, err = b.writer.Seek(b.writeSize, io.SeekStart)
+	}
+	if err == nil {
+		return
+	}
+
+	log.Printf("Reopening buffer segment %d after a failed write: %v", b.writeSeg, err)
+	b.writer.Close()
+	if err := b.openWriter(); err != nil {
+		log.Printf("Error reopening buffer segment %d: %v", b.writeSeg, err)
+		b.writer = nil
+	}
+}
+
+// segments returns the numbers of the segment files in order
+func (b *Buffer) segments() ([]uint64, error) {
+	entries, err := os.ReadDir(b.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read buffer directory: %w", err)
+	}
+
+	var segments []uint64
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		n, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, n)
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i] < segments[j] })
+
+	return segments, nil
+}
+
+// segmentPath returns the path of a segment file
+func (b *Buffer) segmentPath(segment uint64) string {
+	return filepath.Join(b.dir, fmt.Sprintf("%020d%s", segment, segmentExt))
+}
+
+// loadCheckpoint reads the drain position, which is the start when there is
+// no checkpoint yet. An unreadable checkpoint also drains from the start,
+// saving the records still on disk again rather than losing them.
+func (b *Buffer) loadCheckpoint() position {
+	var pos position
+	data, err := os.ReadFile(filepath.Join(b.dir, checkpointName))
+	if os.IsNotExist(err) {
+		return pos
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &pos)
+	}
+	if err != nil {
+		log.Printf("Draining buffer %s from its oldest segment, as its checkpoint is unreadable: %v", b.dir, err)
+		return position{}
+	}
+	return pos
+}
+
+// saveCheckpoint atomically replaces the drain position, syncing it to disk
+// before and after the replacement so a crash leaves the old or new one
+func (b *Buffer) saveCheckpoint(pos position) error {
+	data, err := json.Marshal(pos)
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(b.dir, checkpointName+".tmp")
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create buffer checkpoint: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write buffer checkpoint: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to sync buffer checkpoint: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close buffer checkpoint: %w", err)
+	}
+
+	if err := os.Rename(tmp, filepath.Join(b.dir, checkpointName)); err != nil {
+		return fmt.Errorf("failed to replace buffer checkpoint: %w", err)
+	}
+	return syncDir(b.dir)
+}
+
+// syncDir syncs a directory, making the files created and renamed in it durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open buffer directory: %w", err)
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync buffer directory: %w", err)
+	}
+	return nil
+}
+
+// readRecord reads the record at offset in a segment, returning the
+// observation or archived upload and the size of the record. A record that
+// cannot be read back is reported with a *damagedError.
+func readRecord(path string, offset int64) (item, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return item{}, 0, fmt.Errorf("failed to open buffer segment: %w", err)
+	}
+	defer f.Close()
+
+	payload, err := readPayload(f, offset)
+	if err != nil {
+		damaged := &damagedError{err: err}
+		if payload != nil {
+			damaged.size = recordHeaderSize + int64(len(payload))
+		}
+		return item{}, 0, damaged
+	}
+	size := recordHeaderSize + int64(len(payload))
+
+	e := entry{WeatherData: &models.WeatherData{}}
+	if err := json.Unmarshal(payload, &e); err != nil {
+		return item{}, 0, &damagedError{size: size, err: fmt.Errorf("invalid buffer record: %w", err)}
+	}
+	if e.RawUpload != nil {
+		return item{upload: e.RawUpload}, size, nil
+	}
+	e.WeatherData.Indoor = e.Indoor
+
+	return item{data: e.WeatherData}, size, nil
+}
+
+// readPayload reads and verifies the payload of the record at offset. The
+// payload is returned with a checksum error, as its length was read.
+func readPayload(r io.ReaderAt, offset int64) ([]byte, error) {
+	header := make([]byte, recordHeaderSize)
+	if _, err := r.ReadAt(header, offset); err != nil {
+		return nil, fmt.Errorf("incomplete record header: %w", err)
+	}
+
+	size := binary.BigEndian.Uint32(header[0:4])
+	if size > maxRecordSize {
+		return nil, fmt.Errorf("record size %d exceeds the maximum", size)
+	}
+
+	payload := make([]byte, size)
+	if _, err := r.ReadAt(payload, offset+recordHeaderSize); err != nil {
+		return nil, fmt.Errorf("incomplete record: %w", err)
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+		return payload, fmt.Errorf("record checksum mismatch")
+	}
+
+	return payload, nil
+}
+
+// readBytes reads size bytes at offset in a segment
+func readBytes(path string, offset, size int64) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open buffer segment: %w", err)
+	}
+	defer f.Close()
+
+	raw := make([]byte, size)
+	n, err := f.ReadAt(raw, offset)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read buffer segment: %w", err)
+	}
+	return raw[:n], nil
+}
+
+// before reports whether position a comes before b
+func before(a, b position) bool {
+	return a.Segment < b.Segment || (a.Segment == b.Segment && a.Offset < b.Offset)
+}
+
+// scanSegment counts the valid records in a segment from offset and returns
+// the offset where they end. A missing segment is empty.
+func scanSegment(path string, offset int64) (int, int64, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to open buffer segment: %w", err)
+	}
+	defer f.Close()
+
+	n := 0
+	for {
+		payload, err := readPayload(f, offset)
+		if err != nil {
+			return n, offset, nil
+		}
+		n++
+		offset += recordHeaderSize + int64(len(payload))
+	}
+}
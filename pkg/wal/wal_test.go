@@ -0,0 +1,579 @@
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ask-23/go-wx/internal/models"
+	"github.com/go-sql-driver/mysql"
+)
+
+func init() {
+	minBackoff = 10 * time.Millisecond
+	maxBackoff = 40 * time.Millisecond
+}
+
+// MockStore records saved observations after failing the given number of
+// saves, always rejecting those from the reject station
+type MockStore struct {
+	mutex    sync.Mutex
+	saved    []*models.WeatherData
+	uploads  []*models.RawUpload
+	failures int
+	attempts int
+	reject   string
+}
+
+func (m *MockStore) SaveWeatherData(data *models.WeatherData) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.attempts++
+	if m.failures > 0 {
+		m.failures--
+		return fmt.Errorf("database unavailable")
+	}
+	if err := m.rejected([]*models.WeatherData{data}); err != nil {
+		return err
+	}
+	m.saved = append(m.saved, data)
+	return nil
+}
+
+func (m *MockStore) SaveRawUpload(upload *models.RawUpload) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.attempts++
+	if m.failures > 0 {
+		m.failures--
+		return fmt.Errorf("database unavailable")
+	}
+	m.uploads = append(m.uploads, upload)
+	return nil
+}
+
+// rejected returns the error the database gives for a batch with an
+// observation from the reject station
+func (m *MockStore) rejected(batch []*models.WeatherData) error {
+	for _, data := range batch {
+		if m.reject != "" && data.StationID == m.reject {
+			return &mysql.MySQLError{Number: 1406, Message: "Data too long for column 'station'"}
+		}
+	}
+	return nil
+}
+
+func (m *MockStore) savedCount() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return len(m.saved)
+}
+
+// observation returns a test observation with temperature n
+func observation(n int) *models.WeatherData {
+	return &models.WeatherData{
+		StationID:   "default",
+		Timestamp:   time.Date(2023, 5, 1, 12, n, 0, 0, time.UTC),
+		Temperature: float64(n),
+	}
+}
+
+// waitForSaved waits until the store has n observations
+func waitForSaved(t *testing.T, store *MockStore, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for store.savedCount() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d saved observations, got %d", n, store.savedCount())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// TestBufferDrain tests that observations are retried until saved, in order
+func TestBufferDrain(t *testing.T) {
+	store := &MockStore{failures: 3}
+	b, err := Open(t.TempDir(), store, 0)
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	b.Start()
+	defer b.Stop()
+
+	for n := 0; n < 5; n++ {
+		if err := b.SaveWeatherData(observation(n)); err != nil {
+			t.Fatalf("SaveWeatherData returned error: %v", err)
+		}
+	}
+
+	waitForSaved(t, store, 5)
+	for n, data := range store.saved {
+		if data.Temperature != float64(n) {
+			t.Errorf("Expected observation %d in order, got temperature %.0f", n, data.Temperature)
+		}
+	}
+	if store.attempts != 8 {
+		t.Errorf("Expected 8 save attempts, got %d", store.attempts)
+	}
+	if depth := b.Depth(); depth != 0 {
+		t.Errorf("Expected empty buffer, got depth %d", depth)
+	}
+}
+
+// TestBufferRestart tests that undrained observations survive a restart
+func TestBufferRestart(t *testing.T) {
+	dir := t.TempDir()
+	store := &MockStore{}
+
+	b, err := Open(dir, store, 0)
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	for n := 0; n < 3; n++ {
+		b.SaveWeatherData(observation(n))
+	}
+	if depth := b.Depth(); depth != 3 {
+		t.Errorf("Expected depth 3, got %d", depth)
+	}
+	b.Stop()
+
+	// Reopen and drain
+	b, err = Open(dir, store, 0)
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	if depth := b.Depth(); depth != 3 {
+		t.Errorf("Expected depth 3 after restart, got %d", depth)
+	}
+	b.Start()
+	waitForSaved(t, store, 3)
+	b.Stop()
+
+	if store.saved[0].Timestamp != observation(0).Timestamp {
+		t.Errorf("Expected first observation first, got %v", store.saved[0].Timestamp)
+	}
+
+	// Drained observations are not saved again
+	b, err = Open(dir, store, 0)
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	defer b.Stop()
+	if depth := b.Depth(); depth != 0 {
+		t.Errorf("Expected empty buffer after draining, got depth %d", depth)
+	}
+}
+
+// TestBufferBadCheckpoint tests that an unreadable checkpoint drains the
+// buffer again from its oldest segment
+func TestBufferBadCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	store := &MockStore{}
+
+	b, err := Open(dir, store, 0)
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	for n := 0; n < 3; n++ {
+		b.SaveWeatherData(observation(n))
+	}
+	b.Start()
+	waitForSaved(t, store, 3)
+	b.Stop()
+
+	// A checkpoint torn by a crash
+	if err := os.WriteFile(filepath.Join(dir, checkpointName), []byte(`{"segm`), 0644); err != nil {
+		t.Fatalf("Failed to write checkpoint: %v", err)
+	}
+
+	b, err = Open(dir, store, 0)
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	if depth := b.Depth(); depth != 3 {
+		t.Errorf("Expected depth 3 with an unreadable checkpoint, got %d", depth)
+	}
+	b.Start()
+	waitForSaved(t, store, 6)
+	b.Stop()
+
+	if store.saved[3].Timestamp != observation(0).Timestamp {
+		t.Errorf("Expected first observation saved again, got %v", store.saved[3].Timestamp)
+	}
+}
+
+// TestBufferIndoor tests that indoor readings are buffered with their observation
+func TestBufferIndoor(t *testing.T) {
+	store := &MockStore{}
+	b, err := Open(t.TempDir(), store, 0)
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+
+	data := observation(0)
+	data.Indoor = &models.IndoorData{
+		StationID:   "default",
+		Timestamp:   data.Timestamp,
+		Temperature: 21.5,
+		Humidity:    40,
+	}
+	data.Indoor.Flag("humidity", "range")
+	if err := b.SaveWeatherData(data); err != nil {
+		t.Fatalf("SaveWeatherData returned error: %v", err)
+	}
+	b.Start()
+	waitForSaved(t, store, 1)
+	b.Stop()
+
+	indoor := store.saved[0].Indoor
+	if indoor == nil {
+		t.Fatalf("Expected indoor readings, got none")
+	}
+	if indoor.Temperature != 21.5 || indoor.Humidity != 40 {
+		t.Errorf("Expected indoor 21.5/40, got %.1f/%.1f", indoor.Temperature, indoor.Humidity)
+	}
+	if !indoor.Flagged("humidity") {
+		t.Errorf("Expected indoor humidity flag to survive the buffer")
+	}
+	if store.saved[0].Temperature != 0 {
+		t.Errorf("Expected temperature 0, got %.1f", store.saved[0].Temperature)
+	}
+}
+
+// TestBufferNoHumidity tests that an observation without a humidity reading
+// is buffered and saved
+func TestBufferNoHumidity(t *testing.T) {
+	store := &MockStore{}
+	b, err := Open(t.TempDir(), store, 0)
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+
+	data := observation(30)
+	data.CalculateDerivedValues()
+	if err := b.SaveWeatherData(data); err != nil {
+		t.Fatalf("SaveWeatherData returned error: %v", err)
+	}
+	b.Start()
+	waitForSaved(t, store, 1)
+	b.Stop()
+
+	saved := store.saved[0]
+	if saved.Temperature != 30 || saved.DewPoint != 0 || saved.CloudBase != 0 {
+		t.Errorf("Expected temperature 30 without dew point or cloud base, got %.1f, %.1f and %.1f",
+			saved.Temperature, saved.DewPoint, saved.CloudBase)
+	}
+}
+
+// MockBatchStore records the batches of each kind it is asked to save
+type MockBatchStore struct {
+	MockStore
+	kinds []string
+}
+
+func (m *MockBatchStore) SaveWeatherDataBatch(batch []*models.WeatherData) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if err := m.rejected(batch); err != nil {
+		return err
+	}
+	m.saved = append(m.saved, batch...)
+	m.kinds = append(m.kinds, fmt.Sprintf("%d observations", len(batch)))
+	return nil
+}
+
+func (m *MockBatchStore) SaveRawUploadBatch(batch []*models.RawUpload) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.uploads = append(m.uploads, batch...)
+	m.kinds = append(m.kinds, fmt.Sprintf("%d uploads", len(batch)))
+	return nil
+}
+
+// TestBufferUploads tests that archived uploads survive a restart and drain
+// in order with the observations linked to them, in batches of one kind
+func TestBufferUploads(t *testing.T) {
+	dir := t.TempDir()
+	store := &MockBatchStore{}
+
+	b, err := Open(dir, store, 0)
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	for n := 1; n <= 2; n++ {
+		if err := b.SaveRawUpload(&models.RawUpload{ID: int64(n), Body: "tempf=70", Status: 200}); err != nil {
+			t.Fatalf("SaveRawUpload returned error: %v", err)
+		}
+	}
+	data := observation(0)
+	data.RawUploadID = 2
+	b.SaveWeatherData(data)
+	b.Stop()
+
+	b, err = Open(dir, store, 0)
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	if depth := b.Depth(); depth != 3 {
+		t.Errorf("Expected 2 uploads and an observation after restart, got depth %d", depth)
+	}
+	b.Start()
+	waitForSaved(t, &store.MockStore, 1)
+	b.Stop()
+
+	if len(store.kinds) != 2 || store.kinds[0] != "2 uploads" || store.kinds[1] != "1 observations" {
+		t.Fatalf("Expected a batch of 2 uploads then one observation, got %v", store.kinds)
+	}
+	if upload := store.uploads[1]; upload.ID != 2 || upload.Body != "tempf=70" || upload.Status != 200 {
+		t.Errorf("Expected upload 2 as buffered, got %+v", upload)
+	}
+	if store.saved[0].RawUploadID != 2 {
+		t.Errorf("Expected the observation linked to upload 2, got %d", store.saved[0].RawUploadID)
+	}
+}
+
+// readDeadLetters reads the records moved to the dead letter file of dir
+func readDeadLetters(t *testing.T, dir string) []deadLetter {
+	t.Helper()
+	f, err := os.Open(filepath.Join(dir, deadLetterName))
+	if err != nil {
+		t.Fatalf("Failed to open dead letter file: %v", err)
+	}
+	defer f.Close()
+
+	var letters []deadLetter
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var letter deadLetter
+		if err := json.Unmarshal(scanner.Bytes(), &letter); err != nil {
+			t.Fatalf("Invalid dead letter: %v", err)
+		}
+		letters = append(letters, letter)
+	}
+	return letters
+}
+
+// TestBufferRejected tests that a record the store always rejects is moved
+// to the dead letter file instead of holding up the records after it
+func TestBufferRejected(t *testing.T) {
+	for _, store := range []Store{&MockStore{reject: "bad"}, &MockBatchStore{MockStore: MockStore{reject: "bad"}}} {
+		dir := t.TempDir()
+		b, err := Open(dir, store, 0)
+		if err != nil {
+			t.Fatalf("Open returned error: %v", err)
+		}
+
+		for n := 0; n < 3; n++ {
+			data := observation(n)
+			if n == 1 {
+				data.StationID = "bad"
+			}
+			b.SaveWeatherData(data)
+		}
+		b.Start()
+
+		var mock *MockStore
+		switch s := store.(type) {
+		case *MockStore:
+			mock = s
+		case *MockBatchStore:
+			mock = &s.MockStore
+		}
+		waitForSaved(t, mock, 2)
+		b.Stop()
+
+		if mock.saved[0].Temperature != 0 || mock.saved[1].Temperature != 2 {
+			t.Errorf("Expected observations 0 and 2 saved, got %.0f and %.0f", mock.saved[0].Temperature, mock.saved[1].Temperature)
+		}
+		letters := readDeadLetters(t, dir)
+		if len(letters) != 1 {
+			t.Fatalf("Expected 1 dead letter, got %d", len(letters))
+		}
+		var rejected models.WeatherData
+		if err := json.Unmarshal(letters[0].Record, &rejected); err != nil || rejected.Temperature != 1 {
+			t.Errorf("Expected observation 1 in the dead letter file, got %s", letters[0].Record)
+		}
+
+		// Nothing is left to drain after a restart
+		b, err = Open(dir, store, 0)
+		if err != nil {
+			t.Fatalf("Open returned error: %v", err)
+		}
+		if depth := b.Depth(); depth != 0 {
+			t.Errorf("Expected empty buffer after restart, got depth %d", depth)
+		}
+		b.Stop()
+	}
+}
+
+// TestBufferDamaged tests that a record damaged on disk in the segment being
+// written is moved to the dead letter file and drained past
+func TestBufferDamaged(t *testing.T) {
+	dir := t.TempDir()
+	store := &MockStore{}
+	b, err := Open(dir, store, 0)
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	defer b.Stop()
+
+	for n := 0; n < 3; n++ {
+		b.SaveWeatherData(observation(n))
+	}
+
+	// Flip a byte in the payload of the second record
+	path := b.segmentPath(b.writeSeg)
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("Failed to open segment: %v", err)
+	}
+	header := make([]byte, recordHeaderSize)
+	f.ReadAt(header, 0)
+	second := recordHeaderSize + int64(binary.BigEndian.Uint32(header[0:4]))
+	f.WriteAt([]byte{'X'}, second+recordHeaderSize+1)
+	f.Close()
+
+	b.Start()
+	waitForSaved(t, store, 2)
+
+	if store.saved[0].Temperature != 0 || store.saved[1].Temperature != 2 {
+		t.Errorf("Expected observations 0 and 2 saved, got %.0f and %.0f", store.saved[0].Temperature, store.saved[1].Temperature)
+	}
+	letters := readDeadLetters(t, dir)
+	if len(letters) != 1 || letters[0].Offset != second || len(letters[0].Damaged) == 0 {
+		t.Errorf("Expected the damaged record at offset %d in the dead letter file, got %+v", second, letters)
+	}
+}
+
+// shortWriter writes only part of the next record and fails
+type shortWriter struct {
+	segmentWriter
+}
+
+func (w shortWriter) Write(p []byte) (int, error) {
+	n, _ := w.segmentWriter.Write(p[:len(p)/2])
+	return n, io.ErrShortWrite
+}
+
+// TestBufferShortWrite tests that a failed write leaves nothing behind for
+// the records after it
+func TestBufferShortWrite(t *testing.T) {
+	dir := t.TempDir()
+	store := &MockStore{}
+	b, err := Open(dir, store, 0)
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+
+	b.SaveWeatherData(observation(0))
+	writer := b.writer
+	b.writer = shortWriter{writer}
+	if err := b.SaveWeatherData(observation(1)); err == nil {
+		t.Errorf("Expected an error for a short write")
+	}
+	b.writer = writer
+	if err := b.SaveWeatherData(observation(2)); err != nil {
+		t.Fatalf("SaveWeatherData returned error: %v", err)
+	}
+	if depth := b.Depth(); depth != 2 {
+		t.Errorf("Expected depth 2, got %d", depth)
+	}
+
+	b.Start()
+	waitForSaved(t, store, 2)
+	b.Stop()
+	if store.saved[1].Temperature != 2 {
+		t.Errorf("Expected the observation after the failed write, got temperature %.0f", store.saved[1].Temperature)
+	}
+
+	// Nothing is lost on reopening either
+	b, err = Open(dir, &MockStore{}, 0)
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	defer b.Stop()
+	b.SaveWeatherData(observation(3))
+	if depth := b.Depth(); depth != 1 {
+		t.Errorf("Expected depth 1 after reopening, got %d", depth)
+	}
+}
+
+// TestBufferTornWrite tests recovery from a partly written record
+func TestBufferTornWrite(t *testing.T) {
+	dir := t.TempDir()
+	store := &MockStore{}
+
+	b, err := Open(dir, store, 0)
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	b.SaveWeatherData(observation(0))
+	b.SaveWeatherData(observation(1))
+	b.Stop()
+
+	// Simulate a crash part way through writing a record
+	f, err := os.OpenFile(b.segmentPath(1), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("Failed to open segment: %v", err)
+	}
+	f.Write([]byte{0, 0, 1, 0, 1, 2})
+	f.Close()
+
+	b, err = Open(dir, store, 0)
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	defer b.Stop()
+	if depth := b.Depth(); depth != 2 {
+		t.Errorf("Expected the 2 complete records, got depth %d", depth)
+	}
+
+	b.SaveWeatherData(observation(2))
+	b.Start()
+	waitForSaved(t, store, 3)
+	if store.saved[2].Temperature != 2 {
+		t.Errorf("Expected record written after recovery to drain, got temperature %.0f", store.saved[2].Temperature)
+	}
+}
+
+// TestBufferSegments tests rotation and removal of drained segments
+func TestBufferSegments(t *testing.T) {
+	dir := t.TempDir()
+	store := &MockStore{}
+
+	b, err := Open(dir, store, 512)
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	for n := 0; n < 10; n++ {
+		b.SaveWeatherData(observation(n))
+	}
+
+	segments, _ := b.segments()
+	if len(segments) < 2 {
+		t.Fatalf("Expected several segments, got %d", len(segments))
+	}
+
+	b.Start()
+	waitForSaved(t, store, 10)
+	b.Stop()
+
+	segments, _ = b.segments()
+	if len(segments) != 1 {
+		t.Errorf("Expected drained segments to be removed, got %d left", len(segments))
+	}
+	for n, data := range store.saved {
+		if data.Temperature != float64(n) {
+			t.Errorf("Expected observation %d in order, got temperature %.0f", n, data.Temperature)
+		}
+	}
+}
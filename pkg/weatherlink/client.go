@@ -0,0 +1,84 @@
+package weatherlink
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// defaultTimeout bounds each request
+const defaultTimeout = 10 * time.Second
+
+// Client sends requests to the local API of a WeatherLink Live
+type Client struct {
+	baseURL string
+	http    *http.Client
+}
+
+// NewClient creates a client for the WeatherLink Live at address, a host
+// with an optional port, or a URL
+func NewClient(address string) *Client {
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	return &Client{
+		baseURL: strings.TrimSuffix(address, "/"),
+		http:    &http.Client{Timeout: defaultTimeout},
+	}
+}
+
+// Address returns the URL of the WeatherLink Live
+func (c *Client) Address() string {
+	return c.baseURL
+}
+
+// get requests path and returns the data of the response
+func (c *Client) get(path string) (json.RawMessage, error) {
+	resp, err := c.http.Get(c.baseURL + path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response to %s: %w", path, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned status %d", path, resp.StatusCode)
+	}
+	return decodeResponse(body)
+}
+
+// CurrentConditions returns the current conditions of every transmitter
+func (c *Client) CurrentConditions() (*Conditions, error) {
+	data, err := c.get("/v1/current_conditions")
+	if err != nil {
+		return nil, err
+	}
+	return parseConditions(data)
+}
+
+// StartRealTime asks the WeatherLink Live to broadcast wind and rain every
+// few seconds for duration and returns the UDP port it broadcasts on
+func (c *Client) StartRealTime(duration time.Duration) (int, error) {
+	data, err := c.get(fmt.Sprintf("/v1/real_time?duration=%d", int(duration.Seconds())))
+	if err != nil {
+		return 0, err
+	}
+
+	var broadcast struct {
+		Port     int `json:"broadcast_port"`
+		Duration int `json:"duration"`
+	}
+	if err := json.Unmarshal(data, &broadcast); err != nil {
+		return 0, fmt.Errorf("invalid real-time response: %w", err)
+	}
+	if broadcast.Port == 0 {
+		return 0, fmt.Errorf("real-time response without a broadcast port")
+	}
+	return broadcast.Port, nil
+}
@@ -0,0 +1,175 @@
+// Package weatherlink reads the local API of a Davis WeatherLink Live, which
+// serves the current conditions of its transmitters over HTTP and
+// broadcasts wind and rain in real time over UDP
+package weatherlink
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Data structure types of the records in the current conditions, one per
+// transmitter or sensor of the WeatherLink Live
+const (
+	typeISS       = 1 // ISS or a wireless temperature/humidity transmitter
+	typeSoilLeaf  = 2 // soil and leaf station
+	typeBarometer = 3 // barometer of the WeatherLink Live
+	typeInside    = 4 // inside temperature and humidity of the WeatherLink Live
+)
+
+// ISSConditions is a type 1 record: the readings of an ISS, or of a
+// temperature/humidity transmitter with only those set. Temperatures are
+// °F, wind mph and rain counts of the rain collector's size.
+type ISSConditions struct {
+	LSID        int64    `json:"lsid"`
+	TXID        int      `json:"txid"`
+	Temperature *float64 `json:"temp"`
+	Humidity    *float64 `json:"hum"`
+	DewPoint    *float64 `json:"dew_point"`
+	HeatIndex   *float64 `json:"heat_index"`
+	WindChill   *float64 `json:"wind_chill"`
+
+	WindSpeedLast      *float64 `json:"wind_speed_last"`
+	WindDirLast        *float64 `json:"wind_dir_last"`
+	WindSpeedAvg1Min   *float64 `json:"wind_speed_avg_last_1_min"`
+	WindDirAvg1Min     *float64 `json:"wind_dir_scalar_avg_last_1_min"`
+	WindSpeedAvg10Min  *float64 `json:"wind_speed_avg_last_10_min"`
+	WindSpeedHi2Min    *float64 `json:"wind_speed_hi_last_2_min"`
+	WindSpeedHi10Min   *float64 `json:"wind_speed_hi_last_10_min"`
+	WindDirAtHi10Min   *float64 `json:"wind_dir_at_hi_speed_last_10_min"`
+	RainSize           *int     `json:"rain_size"`      // see rainSizes
+	RainRateLast       *float64 `json:"rain_rate_last"` // counts per hour
+	RainfallDaily      *float64 `json:"rainfall_daily"`
+	RainfallMonthly    *float64 `json:"rainfall_monthly"`
+	RainfallYear       *float64 `json:"rainfall_year"`
+	RainStorm          *float64 `json:"rain_storm"`
+	RainStormStartAt   *int64   `json:"rain_storm_start_at"`
+	SolarRadiation     *float64 `json:"solar_rad"`
+	UVIndex            *float64 `json:"uv_index"`
+	ReceptionState     *int     `json:"rx_state"` // 0 synced, 1 rescanning, 2 lost
+	TransmitterBattery *int     `json:"trans_battery_flag"`
+}
+
+// SoilLeafConditions is a type 2 record: the readings of a soil and leaf
+// station. Temperatures are °F, soil moisture centibars and leaf wetness
+// 0 to 15.
+type SoilLeafConditions struct {
+	LSID               int64    `json:"lsid"`
+	TXID               int      `json:"txid"`
+	Temp1              *float64 `json:"temp_1"`
+	Temp2              *float64 `json:"temp_2"`
+	Temp3              *float64 `json:"temp_3"`
+	Temp4              *float64 `json:"temp_4"`
+	MoistSoil1         *float64 `json:"moist_soil_1"`
+	MoistSoil2         *float64 `json:"moist_soil_2"`
+	MoistSoil3         *float64 `json:"moist_soil_3"`
+	MoistSoil4         *float64 `json:"moist_soil_4"`
+	WetLeaf1           *float64 `json:"wet_leaf_1"`
+	WetLeaf2           *float64 `json:"wet_leaf_2"`
+	ReceptionState     *int     `json:"rx_state"`
+	TransmitterBattery *int     `json:"trans_battery_flag"`
+}
+
+// BarometerConditions is a type 3 record, in inches of mercury
+type BarometerConditions struct {
+	LSID     int64    `json:"lsid"`
+	SeaLevel *float64 `json:"bar_sea_level"`
+	Trend    *float64 `json:"bar_trend"` // change over the last 3 hours
+	Absolute *float64 `json:"bar_absolute"`
+}
+
+// InsideConditions is a type 4 record, in °F
+type InsideConditions struct {
+	LSID        int64    `json:"lsid"`
+	Temperature *float64 `json:"temp_in"`
+	Humidity    *float64 `json:"hum_in"`
+	DewPoint    *float64 `json:"dew_point_in"`
+	HeatIndex   *float64 `json:"heat_index_in"`
+}
+
+// Conditions are the current conditions of a WeatherLink Live and its
+// transmitters
+type Conditions struct {
+	DeviceID  string // did, the MAC address of the WeatherLink Live
+	Timestamp time.Time
+	ISS       []ISSConditions
+	SoilLeaf  []SoilLeafConditions
+	Barometer *BarometerConditions
+	Inside    *InsideConditions
+}
+
+// response is the envelope of every API response
+type response struct {
+	Data  json.RawMessage `json:"data"`
+	Error *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// conditionsData is the data of a current conditions response, and of a
+// real-time broadcast, which has the same layout with fewer fields
+type conditionsData struct {
+	DID        string            `json:"did"`
+	TS         int64             `json:"ts"`
+	Conditions []json.RawMessage `json:"conditions"`
+}
+
+// record is the part every record of the conditions has in common
+type record struct {
+	LSID              int64 `json:"lsid"`
+	DataStructureType int   `json:"data_structure_type"`
+}
+
+// decodeResponse returns the data of an API response, or its error
+func decodeResponse(b []byte) (json.RawMessage, error) {
+	var r response
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+	if r.Error != nil {
+		return nil, fmt.Errorf("WeatherLink Live error %d: %s", r.Error.Code, r.Error.Message)
+	}
+	return r.Data, nil
+}
+
+// parseConditions decodes current conditions, each record into the type of
+// its data structure. Records of other types, such as those of an AirLink,
+// are skipped.
+func parseConditions(b []byte) (*Conditions, error) {
+	var data conditionsData
+	if err := json.Unmarshal(b, &data); err != nil {
+		return nil, fmt.Errorf("invalid conditions: %w", err)
+	}
+
+	conditions := &Conditions{DeviceID: data.DID, Timestamp: time.Unix(data.TS, 0).UTC()}
+	for _, raw := range data.Conditions {
+		var r record
+		if err := json.Unmarshal(raw, &r); err != nil {
+			return nil, fmt.Errorf("invalid conditions record: %w", err)
+		}
+
+		var err error
+		switch r.DataStructureType {
+		case typeISS:
+			var iss ISSConditions
+			err = json.Unmarshal(raw, &iss)
+			conditions.ISS = append(conditions.ISS, iss)
+		case typeSoilLeaf:
+			var soilLeaf SoilLeafConditions
+			err = json.Unmarshal(raw, &soilLeaf)
+			conditions.SoilLeaf = append(conditions.SoilLeaf, soilLeaf)
+		case typeBarometer:
+			conditions.Barometer = &BarometerConditions{}
+			err = json.Unmarshal(raw, conditions.Barometer)
+		case typeInside:
+			conditions.Inside = &InsideConditions{}
+			err = json.Unmarshal(raw, conditions.Inside)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid record %d of type %d: %w", r.LSID, r.DataStructureType, err)
+		}
+	}
+	return conditions, nil
+}
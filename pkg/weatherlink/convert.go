@@ -0,0 +1,173 @@
+package weatherlink
+
+import (
+	"fmt"
+
+	"github.com/ask-23/go-wx/internal/models"
+	"github.com/ask-23/go-wx/pkg/units"
+)
+
+// rainSizes maps the rain_size of a rain collector to the depth of one
+// count in millimeters: 0.01", 0.2 mm, 0.1 mm and 0.001"
+var rainSizes = map[int]float64{
+	1: 0.254,
+	2: 0.2,
+	3: 0.1,
+	4: 0.0254,
+}
+
+// rainDepth converts rain counts of the collector size to millimeters
+func rainDepth(counts *float64, size *int) float64 {
+	if counts == nil || size == nil {
+		return 0
+	}
+	return *counts * rainSizes[*size]
+}
+
+// celsius converts a temperature in °F, returning 0 when it is missing
+func celsius(f *float64) float64 {
+	if f == nil {
+		return 0
+	}
+	return float64(units.Fahrenheit(*f).Celsius())
+}
+
+// metersPerSecond converts a speed in mph, returning 0 when it is missing
+func metersPerSecond(mph *float64) float64 {
+	if mph == nil {
+		return 0
+	}
+	return float64(units.MilesPerHour(*mph).MetersPerSecond())
+}
+
+// hPa converts a pressure in inches of mercury, returning 0 when it is missing
+func hPa(inHg *float64) float64 {
+	if inHg == nil {
+		return 0
+	}
+	return float64(units.InHg(*inHg).HPa())
+}
+
+// value returns v, or 0 when it is missing
+func value(v *float64) float64 {
+	if v == nil {
+		return 0
+	}
+	return *v
+}
+
+// iss returns the record of the ISS with the transmitter ID txid, or with 0
+// the first record with wind readings, which only an ISS has
+func (c *Conditions) iss(txid int) (*ISSConditions, error) {
+	for n := range c.ISS {
+		iss := &c.ISS[n]
+		if (txid == 0 && iss.WindSpeedLast != nil) || (txid != 0 && iss.TXID == txid) {
+			return iss, nil
+		}
+	}
+	if txid == 0 {
+		return nil, fmt.Errorf("no ISS in the current conditions")
+	}
+	return nil, fmt.Errorf("no transmitter %d in the current conditions", txid)
+}
+
+// WeatherData converts the conditions into weather data, with the outdoor
+// readings of the ISS on transmitter txid, or 0 for the first ISS. Other
+// temperature/humidity transmitters are reported as channels by their ID.
+func (c *Conditions) WeatherData(txid int) (*models.WeatherData, error) {
+	iss, err := c.iss(txid)
+	if err != nil {
+		return nil, err
+	}
+
+	data := &models.WeatherData{
+		Timestamp:      c.Timestamp,
+		Temperature:    celsius(iss.Temperature),
+		Humidity:       value(iss.Humidity),
+		WindSpeed:      metersPerSecond(iss.WindSpeedAvg1Min),
+		WindDirection:  value(iss.WindDirAvg1Min),
+		WindGust:       metersPerSecond(iss.WindSpeedHi2Min),
+		RainRate:       rainDepth(iss.RainRateLast, iss.RainSize),
+		DailyRain:      rainDepth(iss.RainfallDaily, iss.RainSize),
+		MonthlyRain:    rainDepth(iss.RainfallMonthly, iss.RainSize),
+		YearlyRain:     rainDepth(iss.RainfallYear, iss.RainSize),
+		EventRain:      rainDepth(iss.RainStorm, iss.RainSize),
+		SolarRadiation: value(iss.SolarRadiation),
+		UVIndex:        value(iss.UVIndex),
+	}
+
+	if c.Barometer != nil {
+		data.Pressure = hPa(c.Barometer.Absolute)
+		data.RelativePressure = hPa(c.Barometer.SeaLevel)
+	}
+
+	// Missing readings are left at 0 but not reported
+	data.Reported = make(map[string]bool)
+	readings := map[string]*float64{
+		"temperature":    iss.Temperature,
+		"humidity":       iss.Humidity,
+		"windSpeed":      iss.WindSpeedAvg1Min,
+		"windDirection":  iss.WindDirAvg1Min,
+		"windGust":       iss.WindSpeedHi2Min,
+		"rainRate":       iss.RainRateLast,
+		"solarRadiation": iss.SolarRadiation,
+		"uvIndex":        iss.UVIndex,
+	}
+	if c.Barometer != nil {
+		readings["pressure"] = c.Barometer.Absolute
+		readings["relativePressure"] = c.Barometer.SeaLevel
+	}
+	if c.Inside != nil {
+		readings["indoorTemperature"] = c.Inside.Temperature
+		readings["indoorHumidity"] = c.Inside.Humidity
+	}
+	for name, reading := range readings {
+		if reading != nil {
+			data.Report(name)
+		}
+	}
+
+	if c.Inside != nil {
+		data.Indoor = &models.IndoorData{
+			Timestamp:   c.Timestamp,
+			Temperature: celsius(c.Inside.Temperature),
+			Humidity:    value(c.Inside.Humidity),
+			Pressure:    data.Pressure,
+			Reported:    make(map[string]bool),
+		}
+		if c.Inside.Temperature != nil {
+			data.Indoor.Report("temperature")
+		}
+		if c.Inside.Humidity != nil {
+			data.Indoor.Report("humidity")
+		}
+		data.Indoor.CalculateDerivedValues()
+	}
+
+	// Every transmitter reports its battery; soil and leaf readings have no
+	// place in the model, as soil moisture is measured in centibars
+	channels := &models.SensorChannels{Batteries: make(map[string]float64)}
+	for _, other := range c.ISS {
+		if other.TransmitterBattery != nil {
+			channels.Batteries[fmt.Sprintf("davisbatt%d", other.TXID)] = float64(*other.TransmitterBattery)
+		}
+		if other.TXID != iss.TXID && other.Temperature != nil && other.Humidity != nil {
+			channels.TempHumidity = append(channels.TempHumidity, models.TempHumidityChannel{
+				Channel:     other.TXID,
+				Temperature: celsius(other.Temperature),
+				Humidity:    *other.Humidity,
+			})
+		}
+	}
+	for _, soilLeaf := range c.SoilLeaf {
+		if soilLeaf.TransmitterBattery != nil {
+			channels.Batteries[fmt.Sprintf("davisbatt%d", soilLeaf.TXID)] = float64(*soilLeaf.TransmitterBattery)
+		}
+	}
+	if !channels.IsEmpty() {
+		data.Channels = channels
+	}
+
+	data.CalculateDerivedValues()
+	return data, nil
+}
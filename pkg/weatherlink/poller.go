@@ -0,0 +1,281 @@
+package weatherlink
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/ask-23/go-wx/internal/models"
+)
+
+// maxPollFailures is the number of consecutive failed polls after which the
+// poller gives up, leaving it to be restarted
+const maxPollFailures = 3
+
+// realTimeDuration is how long each request asks for real-time broadcasts;
+// they are requested again once half of it has passed
+const realTimeDuration = 20 * time.Minute
+
+// realTimeMaxAge is how old the last real-time broadcast may be for its
+// readings to replace those of the current conditions
+const realTimeMaxAge = 30 * time.Second
+
+// Sink receives the observations a poller reads, such as the interceptor
+type Sink interface {
+	// ResolveStation returns the ID of the station identified by form values
+	ResolveStation(form url.Values) string
+	// Submit stores an observation
+	Submit(data *models.WeatherData) error
+}
+
+// live holds the readings of the real-time broadcasts
+type live struct {
+	received      time.Time
+	windSpeed     float64 // m/s
+	windDirection float64 // degrees
+	rainRate      float64 // mm/h
+	gust          float64 // highest wind speed since the last poll, m/s
+}
+
+// realTimeStream is the socket receiving real-time broadcasts
+type realTimeStream struct {
+	conn      *net.UDPConn
+	requested time.Time // when the broadcasts were last requested
+	wg        sync.WaitGroup
+}
+
+// Poller reads the current conditions of a WeatherLink Live at an interval
+// and submits them. With real-time broadcasts the latest wind and rain rate
+// replace the averages of the current conditions, and gusts between polls
+// are caught.
+type Poller struct {
+	client      *Client
+	sink        Sink
+	interval    time.Duration
+	transmitter int  // ID of the ISS transmitter; 0 for the first ISS
+	realTime    bool // receive real-time broadcasts
+	live        live
+	liveMutex   sync.Mutex
+	done        chan struct{} // closed to ask Run to return
+	stopped     chan struct{} // closed once Run has returned
+	running     bool
+	mutex       sync.Mutex
+}
+
+// NewPoller creates a poller reading the WeatherLink Live of client every
+// interval, with the outdoor readings of the ISS on transmitter, 0 for the
+// first ISS, and optionally the real-time broadcasts
+func NewPoller(client *Client, sink Sink, interval time.Duration, transmitter int, realTime bool) *Poller {
+	return &Poller{
+		client:      client,
+		sink:        sink,
+		interval:    interval,
+		transmitter: transmitter,
+		realTime:    realTime,
+	}
+}
+
+// Name returns the name the poller is reported under
+func (p *Poller) Name() string {
+	return "weatherlink poller"
+}
+
+// Run polls the WeatherLink Live until the poller is shut down or ctx is
+// canceled, or returns an error once several polls in a row have failed
+func (p *Poller) Run(ctx context.Context) error {
+	p.mutex.Lock()
+	if p.running {
+		p.mutex.Unlock()
+		return fmt.Errorf("poller is already running")
+	}
+	done, stopped := make(chan struct{}), make(chan struct{})
+	p.done, p.stopped = done, stopped
+	p.running = true
+	p.mutex.Unlock()
+
+	defer func() {
+		p.mutex.Lock()
+		p.running = false
+		p.mutex.Unlock()
+		close(stopped)
+	}()
+
+	// Identify the WeatherLink Live; its device ID selects the station
+	conditions, err := p.client.CurrentConditions()
+	if err != nil {
+		return err
+	}
+	station := p.sink.ResolveStation(url.Values{"MAC": {conditions.DeviceID}})
+	log.Printf("Polling WeatherLink Live %s at %s every %v for station %s", conditions.DeviceID, p.client.Address(), p.interval, station)
+
+	var stream *realTimeStream
+	if p.realTime {
+		stream = &realTimeStream{}
+		defer stream.close()
+	}
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	failures := 0
+	for {
+		if stream != nil {
+			if err := p.renew(stream); err != nil {
+				log.Printf("Error requesting real-time broadcasts of %s: %v", p.client.Address(), err)
+			}
+		}
+
+		if err := p.poll(station); err != nil {
+			failures++
+			log.Printf("Error polling WeatherLink Live at %s: %v", p.client.Address(), err)
+			if failures >= maxPollFailures {
+				return fmt.Errorf("%d polls in a row failed: %w", failures, err)
+			}
+		} else {
+			failures = 0
+		}
+
+		select {
+		case <-ticker.C:
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// poll reads the current conditions and submits them
+func (p *Poller) poll(station string) error {
+	conditions, err := p.client.CurrentConditions()
+	if err != nil {
+		return err
+	}
+	data, err := conditions.WeatherData(p.transmitter)
+	if err != nil {
+		return err
+	}
+	data.StationID = station
+	data.ReceivedAt = time.Now()
+
+	p.applyLive(data)
+
+	return p.sink.Submit(data)
+}
+
+// applyLive replaces the wind and rain rate of data with the readings of
+// recent real-time broadcasts
+func (p *Poller) applyLive(data *models.WeatherData) {
+	p.liveMutex.Lock()
+	defer p.liveMutex.Unlock()
+
+	if p.live.received.IsZero() || time.Since(p.live.received) > realTimeMaxAge {
+		return
+	}
+	data.WindSpeed = p.live.windSpeed
+	data.WindDirection = p.live.windDirection
+	data.RainRate = p.live.rainRate
+	data.Report("windSpeed", "windDirection", "rainRate")
+	if p.live.gust > data.WindGust {
+		data.WindGust = p.live.gust
+		data.Report("windGust")
+	}
+	// The latest reading stands until the next broadcast, so the next gust
+	// starts from it
+	p.live.gust = p.live.windSpeed
+
+	data.CalculateDerivedValues()
+}
+
+// renew requests real-time broadcasts when they are about to run out and
+// starts receiving them
+func (p *Poller) renew(stream *realTimeStream) error {
+	if stream.conn != nil && time.Since(stream.requested) < realTimeDuration/2 {
+		return nil
+	}
+
+	port, err := p.client.StartRealTime(realTimeDuration)
+	if err != nil {
+		return err
+	}
+	stream.requested = time.Now()
+
+	if stream.conn == nil {
+		conn, err := net.ListenUDP("udp4", &net.UDPAddr{Port: port})
+		if err != nil {
+			return fmt.Errorf("failed to listen for real-time broadcasts: %w", err)
+		}
+		stream.conn = conn
+		stream.wg.Add(1)
+		go func() {
+			defer stream.wg.Done()
+			p.receive(conn)
+		}()
+	}
+	return nil
+}
+
+// receive reads real-time broadcasts until conn is closed
+func (p *Poller) receive(conn *net.UDPConn) {
+	buf := make([]byte, 4096)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		conditions, err := parseConditions(buf[:n])
+		if err != nil {
+			log.Printf("Ignoring real-time broadcast: %v", err)
+			continue
+		}
+		iss, err := conditions.iss(p.transmitter)
+		if err != nil {
+			continue
+		}
+
+		p.liveMutex.Lock()
+		p.live.received = time.Now()
+		p.live.windSpeed = metersPerSecond(iss.WindSpeedLast)
+		p.live.windDirection = value(iss.WindDirLast)
+		p.live.rainRate = rainDepth(iss.RainRateLast, iss.RainSize)
+		if p.live.windSpeed > p.live.gust {
+			p.live.gust = p.live.windSpeed
+		}
+		p.liveMutex.Unlock()
+	}
+}
+
+// close stops receiving real-time broadcasts
+func (s *realTimeStream) close() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.wg.Wait()
+	}
+}
+
+// Shutdown stops polling, waiting for a poll in progress until ctx is done
+func (p *Poller) Shutdown(ctx context.Context) error {
+	p.mutex.Lock()
+	if !p.running {
+		p.mutex.Unlock()
+		return nil
+	}
+	if p.done != nil {
+		close(p.done)
+		p.done = nil
+	}
+	stopped := p.stopped
+	p.mutex.Unlock()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("poller did not finish polling: %w", ctx.Err())
+	}
+}
@@ -0,0 +1,279 @@
+package wind
+
+import (
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ask-23/go-wx/internal/models"
+)
+
+// Lengths of the windows wind speed and direction are averaged over
+const (
+	shortWindow = 2 * time.Minute
+	longWindow  = 10 * time.Minute
+)
+
+// Store is the database access a tracker uses to resume a station's recent
+// wind and peak gusts from the stored observations
+type Store interface {
+	GetWeatherDataBefore(station string, ts time.Time) (*models.WeatherData, error)
+	GetWeatherDataRange(station string, start, end time.Time) ([]*models.WeatherData, error)
+}
+
+// sample is the wind reported by one observation
+type sample struct {
+	time         time.Time
+	speed        float64
+	hasSpeed     bool
+	direction    float64
+	hasDirection bool
+}
+
+// peak is the highest gust of a period
+type peak struct {
+	start time.Time // start of the period, zero before the first gust
+	gust  float64
+	time  *time.Time
+}
+
+// station is the wind state of one station
+type station struct {
+	samples []sample // observations of the last longWindow, oldest first
+	hour    peak
+	day     peak
+}
+
+// Tracker keeps the recent wind of each station and sets the wind statistics
+// of its observations: the average speed over the last 2 and 10 minutes, the
+// 10 minute vector mean direction weighted by wind speed, and the highest gust
+// with its time during the current clock hour and day in local time.
+//
+// Measurements that failed quality control are left out.
+type Tracker struct {
+	store    Store
+	location *time.Location
+	stations map[string]*station
+	mutex    sync.Mutex
+}
+
+// NewTracker creates a tracker. With a store, the recent wind and peak gusts
+// of each station are resumed from the stored observations before its first
+// observation is processed.
+func NewTracker(store Store) *Tracker {
+	return &Tracker{
+		store:    store,
+		location: time.Local,
+		stations: make(map[string]*station),
+	}
+}
+
+// Process sets the wind statistics of an observation. Observations from a
+// station should be processed in the order they were taken.
+func (t *Tracker) Process(data *models.WeatherData) {
+	if t == nil {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	st, ok := t.stations[data.StationID]
+	if !ok {
+		st = t.resume(data.StationID, data.Timestamp)
+		t.stations[data.StationID] = st
+	}
+
+	st.add(newSample(data))
+
+	// Average over the windows ending at this observation
+	data.WindAvg2m = st.meanSpeed(data.Timestamp.Add(-shortWindow), data.Timestamp)
+	data.WindAvg10m = st.meanSpeed(data.Timestamp.Add(-longWindow), data.Timestamp)
+	data.WindDirAvg10m = st.meanDirection(data.Timestamp.Add(-longWindow), data.Timestamp)
+
+	// A gust is at least as strong as the wind it was measured with
+	gust := 0.0
+	if usable(data, "windGust") {
+		gust = data.WindGust
+	}
+	if usable(data, "windSpeed") {
+		gust = math.Max(gust, data.WindSpeed)
+	}
+
+	ts := data.Timestamp
+	st.hour.update(t.hourStart(ts), gust, ts)
+	st.day.update(t.dayStart(ts), gust, ts)
+
+	data.PeakGustHour, data.PeakGustHourTime = st.hour.gust, st.hour.time
+	data.PeakGustDay, data.PeakGustDayTime = st.day.gust, st.day.time
+}
+
+// newSample returns the wind of an observation that passed quality control
+func newSample(data *models.WeatherData) sample {
+	return sample{
+		time:         data.Timestamp,
+		speed:        data.WindSpeed,
+		hasSpeed:     usable(data, "windSpeed"),
+		direction:    data.WindDirection,
+		hasDirection: usable(data, "windDirection"),
+	}
+}
+
+// usable reports whether an observation reported field and it passed quality
+// control. Unreported wind decodes to zero, which is not a calm.
+func usable(data *models.WeatherData, field string) bool {
+	return data.HasReported(field) && !data.Flagged(field)
+}
+
+// add inserts a sample in time order and drops those too old to be averaged
+func (st *station) add(s sample) {
+	n := sort.Search(len(st.samples), func(i int) bool { return st.samples[i].time.After(s.time) })
+	st.samples = append(st.samples, sample{})
+	copy(st.samples[n+1:], st.samples[n:])
+	st.samples[n] = s
+
+	newest := st.samples[len(st.samples)-1].time
+	keep := sort.Search(len(st.samples), func(i int) bool {
+		return st.samples[i].time.After(newest.Add(-longWindow))
+	})
+	st.samples = st.samples[keep:]
+}
+
+// window returns the samples taken after start up to and including end
+func (st *station) window(start, end time.Time) []sample {
+	var samples []sample
+	for _, s := range st.samples {
+		if s.time.After(start) && !s.time.After(end) {
+			samples = append(samples, s)
+		}
+	}
+	return samples
+}
+
+// meanSpeed returns the average wind speed after start up to end
+func (st *station) meanSpeed(start, end time.Time) float64 {
+	sum, n := 0.0, 0
+	for _, s := range st.window(start, end) {
+		if s.hasSpeed {
+			sum += s.speed
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return sum / float64(n)
+}
+
+// meanDirection returns the vector mean wind direction after start up to
+// end, weighting each direction by its wind speed. When it was calm
+// throughout every direction counts equally.
+func (st *station) meanDirection(start, end time.Time) float64 {
+	var directions, speeds, ones []float64
+	for _, s := range st.window(start, end) {
+		if !s.hasDirection {
+			continue
+		}
+		directions = append(directions, s.direction)
+		speed := 0.0
+		if s.hasSpeed {
+			speed = s.speed
+		}
+		speeds = append(speeds, speed)
+		ones = append(ones, 1)
+	}
+
+	if direction, ok := VectorMean(directions, speeds); ok {
+		return direction
+	}
+	direction, _ := VectorMean(directions, ones)
+	return direction
+}
+
+// update starts a new peak when the period has changed and keeps the gust
+// if it is the highest of the period
+func (p *peak) update(start time.Time, gust float64, ts time.Time) {
+	if !start.Equal(p.start) {
+		*p = peak{start: start}
+	}
+	if p.time == nil || gust > p.gust {
+		p.gust = gust
+		p.time = &ts
+	}
+}
+
+// resume returns the state of a station from its stored observations before ts
+func (t *Tracker) resume(id string, ts time.Time) *station {
+	st := &station{}
+	if t.store == nil {
+		return st
+	}
+
+	prev, err := t.store.GetWeatherDataBefore(id, ts)
+	if err != nil {
+		log.Printf("Error resuming wind statistics for %s: %v", id, err)
+		return st
+	}
+	if prev == nil {
+		return st
+	}
+
+	// The stored peaks still hold if their period has not ended
+	if prev.PeakGustHourTime != nil {
+		st.hour = peak{t.hourStart(prev.Timestamp), prev.PeakGustHour, prev.PeakGustHourTime}
+	}
+	if prev.PeakGustDayTime != nil {
+		st.day = peak{t.dayStart(prev.Timestamp), prev.PeakGustDay, prev.PeakGustDayTime}
+	}
+
+	recent, err := t.store.GetWeatherDataRange(id, ts.Add(-longWindow), ts)
+	if err != nil {
+		log.Printf("Error resuming wind statistics for %s: %v", id, err)
+		return st
+	}
+	for _, d := range recent {
+		if d.Timestamp.Before(ts) {
+			st.add(newSample(d))
+		}
+	}
+
+	return st
+}
+
+// hourStart returns the start of the local clock hour containing ts. A gust
+// at the start of a period was measured before it, so peaks cover
+// (start, start+period].
+func (t *Tracker) hourStart(ts time.Time) time.Time {
+	local := ts.In(t.location).Add(-time.Nanosecond)
+	year, mon, day := local.Date()
+	return time.Date(year, mon, day, local.Hour(), 0, 0, 0, t.location)
+}
+
+// dayStart returns the start of the local day containing ts
+func (t *Tracker) dayStart(ts time.Time) time.Time {
+	year, mon, day := ts.In(t.location).Add(-time.Nanosecond).Date()
+	return time.Date(year, mon, day, 0, 0, 0, 0, t.location)
+}
+
+// VectorMean returns the mean of wind directions in degrees as vectors with
+// the given weights, so 350° and 10° average to 0° rather than 180°. It
+// returns false when the vectors cancel out.
+func VectorMean(directions, weights []float64) (float64, bool) {
+	var x, y float64
+	for n, direction := range directions {
+		rad := direction * math.Pi / 180
+		x += weights[n] * math.Sin(rad)
+		y += weights[n] * math.Cos(rad)
+	}
+	if math.Hypot(x, y) < 1e-9 {
+		return 0, false
+	}
+
+	direction := math.Atan2(x, y) * 180 / math.Pi
+	if direction < 0 {
+		direction += 360
+	}
+	return direction, true
+}
@@ -0,0 +1,223 @@
+package wind
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/ask-23/go-wx/internal/models"
+)
+
+var base = time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// MockStore returns a fixed previous observation and recent observations
+type MockStore struct {
+	prev   *models.WeatherData
+	recent []*models.WeatherData
+}
+
+func (m *MockStore) GetWeatherDataBefore(station string, ts time.Time) (*models.WeatherData, error) {
+	return m.prev, nil
+}
+
+func (m *MockStore) GetWeatherDataRange(station string, start, end time.Time) ([]*models.WeatherData, error) {
+	return m.recent, nil
+}
+
+// newTestTracker creates a tracker working in UTC
+func newTestTracker(store Store) *Tracker {
+	t := NewTracker(store)
+	t.location = time.UTC
+	return t
+}
+
+// observation returns an observation taken offset after base
+func observation(offset time.Duration, speed, gust, direction float64) *models.WeatherData {
+	return &models.WeatherData{
+		StationID:     "default",
+		Timestamp:     base.Add(offset),
+		WindSpeed:     speed,
+		WindGust:      gust,
+		WindDirection: direction,
+	}
+}
+
+func approximatelyEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+// angleDifference returns the difference between two directions in degrees
+func angleDifference(a, b float64) float64 {
+	d := math.Mod(math.Abs(a-b), 360)
+	return math.Min(d, 360-d)
+}
+
+// TestVectorMean tests averaging directions across north
+func TestVectorMean(t *testing.T) {
+	tests := []struct {
+		directions []float64
+		weights    []float64
+		expected   float64
+	}{
+		{[]float64{350, 10}, []float64{1, 1}, 0},
+		{[]float64{340, 350, 10, 20}, []float64{1, 1, 1, 1}, 0},
+		{[]float64{350, 20}, []float64{2, 1}, 359.9},
+		{[]float64{90, 180}, []float64{1, 1}, 135},
+	}
+
+	for _, tt := range tests {
+		direction, ok := VectorMean(tt.directions, tt.weights)
+		if !ok {
+			t.Errorf("%v: expected a mean direction", tt.directions)
+			continue
+		}
+		if angleDifference(direction, tt.expected) > 0.1 {
+			t.Errorf("%v: expected %.1f°, got %.1f°", tt.directions, tt.expected, direction)
+		}
+	}
+
+	if _, ok := VectorMean([]float64{0, 180}, []float64{1, 1}); ok {
+		t.Errorf("Expected opposite directions to cancel out")
+	}
+}
+
+// TestAverages tests the 2 and 10 minute averages
+func TestAverages(t *testing.T) {
+	tracker := newTestTracker(nil)
+
+	var data *models.WeatherData
+	for minute := 0; minute <= 12; minute++ {
+		direction := 350.0
+		if minute%2 == 1 {
+			direction = 10
+		}
+		data = observation(time.Duration(minute)*time.Minute, float64(minute), 0, direction)
+		tracker.Process(data)
+	}
+
+	// Minutes 11 and 12 are within 2 minutes, 3 to 12 within 10 minutes
+	if !approximatelyEqual(data.WindAvg2m, 11.5) {
+		t.Errorf("Expected 2 minute average 11.5 m/s, got %.2f m/s", data.WindAvg2m)
+	}
+	if !approximatelyEqual(data.WindAvg10m, 7.5) {
+		t.Errorf("Expected 10 minute average 7.5 m/s, got %.2f m/s", data.WindAvg10m)
+	}
+	if angleDifference(data.WindDirAvg10m, 0) > 1 {
+		t.Errorf("Expected 10 minute direction near 0°, got %.2f°", data.WindDirAvg10m)
+	}
+}
+
+// TestCalmDirection tests that directions count equally when it is calm
+func TestCalmDirection(t *testing.T) {
+	tracker := newTestTracker(nil)
+
+	tracker.Process(observation(0, 0, 0, 80))
+	data := observation(time.Minute, 0, 0, 100)
+	tracker.Process(data)
+
+	if !approximatelyEqual(data.WindDirAvg10m, 90) {
+		t.Errorf("Expected calm direction 90°, got %.2f°", data.WindDirAvg10m)
+	}
+}
+
+// TestPeakGust tests keeping the highest gust of the hour and day
+func TestPeakGust(t *testing.T) {
+	tracker := newTestTracker(nil)
+
+	steps := []struct {
+		offset   time.Duration
+		speed    float64
+		gust     float64
+		hour     float64
+		hourTime time.Duration
+		day      float64
+		dayTime  time.Duration
+	}{
+		{-10 * time.Minute, 2, 6, 6, -10 * time.Minute, 6, -10 * time.Minute},
+		{0, 3, 4, 6, -10 * time.Minute, 6, -10 * time.Minute}, // noon still counts for the old hour
+		{5 * time.Minute, 3, 5, 5, 5 * time.Minute, 6, -10 * time.Minute},
+		{10 * time.Minute, 7, 0, 7, 10 * time.Minute, 7, 10 * time.Minute}, // no gust reported
+		{15 * time.Minute, 2, 4, 7, 10 * time.Minute, 7, 10 * time.Minute},
+	}
+
+	for n, step := range steps {
+		data := observation(step.offset, step.speed, step.gust, 0)
+		tracker.Process(data)
+
+		if data.PeakGustHour != step.hour || data.PeakGustHourTime == nil ||
+			!data.PeakGustHourTime.Equal(base.Add(step.hourTime)) {
+			t.Errorf("Step %d: expected hourly peak %.1f m/s at %v, got %.1f m/s at %v",
+				n, step.hour, base.Add(step.hourTime), data.PeakGustHour, data.PeakGustHourTime)
+		}
+		if data.PeakGustDay != step.day || data.PeakGustDayTime == nil ||
+			!data.PeakGustDayTime.Equal(base.Add(step.dayTime)) {
+			t.Errorf("Step %d: expected daily peak %.1f m/s at %v, got %.1f m/s at %v",
+				n, step.day, base.Add(step.dayTime), data.PeakGustDay, data.PeakGustDayTime)
+		}
+	}
+}
+
+// TestFlagged tests that measurements failing quality control are left out
+func TestFlagged(t *testing.T) {
+	tracker := newTestTracker(nil)
+
+	tracker.Process(observation(0, 4, 6, 90))
+	data := observation(time.Minute, 80, 95, 270)
+	data.Flag("windSpeed", "range")
+	data.Flag("windGust", "range")
+	data.Flag("windDirection", "range")
+	tracker.Process(data)
+
+	if data.WindAvg2m != 4 || data.WindDirAvg10m != 90 || data.PeakGustDay != 6 {
+		t.Errorf("Expected flagged wind to be left out, got average %.1f m/s, direction %.1f°, peak %.1f m/s",
+			data.WindAvg2m, data.WindDirAvg10m, data.PeakGustDay)
+	}
+}
+
+// TestUnreported tests that observations without wind are not counted as calm
+func TestUnreported(t *testing.T) {
+	tracker := newTestTracker(nil)
+
+	reported := observation(0, 4, 6, 90)
+	reported.Report("windSpeed", "windGust", "windDirection")
+	tracker.Process(reported)
+
+	// An upload from an indoor sensor or without the outdoor array
+	data := observation(time.Minute, 0, 0, 0)
+	data.Report("temperature")
+	tracker.Process(data)
+
+	if data.WindAvg2m != 4 || data.WindAvg10m != 4 || data.WindDirAvg10m != 90 || data.PeakGustDay != 6 {
+		t.Errorf("Expected unreported wind to be left out, got averages %.1f and %.1f m/s, direction %.1f°, peak %.1f m/s",
+			data.WindAvg2m, data.WindAvg10m, data.WindDirAvg10m, data.PeakGustDay)
+	}
+}
+
+// TestResume tests resuming recent wind and peak gusts from the store
+func TestResume(t *testing.T) {
+	peakTime := base.Add(-2 * time.Hour)
+	prev := observation(-time.Minute, 4, 5, 0)
+	prev.PeakGustHour, prev.PeakGustHourTime = 5, &prev.Timestamp
+	prev.PeakGustDay, prev.PeakGustDayTime = 12, &peakTime
+
+	store := &MockStore{
+		prev:   prev,
+		recent: []*models.WeatherData{observation(-3*time.Minute, 2, 3, 0), prev},
+	}
+	tracker := newTestTracker(store)
+
+	data := observation(30*time.Second, 6, 7, 0)
+	tracker.Process(data)
+
+	if !approximatelyEqual(data.WindAvg2m, 5) || !approximatelyEqual(data.WindAvg10m, 4) {
+		t.Errorf("Expected averages of 5 and 4 m/s, got %.2f and %.2f m/s", data.WindAvg2m, data.WindAvg10m)
+	}
+	if data.PeakGustDay != 12 || !data.PeakGustDayTime.Equal(peakTime) {
+		t.Errorf("Expected resumed daily peak of 12 m/s, got %.1f m/s at %v", data.PeakGustDay, data.PeakGustDayTime)
+	}
+
+	// The stored hour ended at noon, so the new hour starts afresh
+	if data.PeakGustHour != 7 {
+		t.Errorf("Expected hourly peak of 7 m/s, got %.1f m/s", data.PeakGustHour)
+	}
+}
@@ -53,7 +53,7 @@ function initCharts(historyData) {
         data: {
             labels: labels,
             datasets: [{
-                label: 'Temperature (°F)',
+                label: 'Temperature (°C)',
                 data: historyData.map(item => item.temperature),
                 borderColor: 'rgb(255, 99, 132)',
                 backgroundColor: tempGradient,
@@ -86,8 +86,8 @@ function initCharts(historyData) {
         data: {
             labels: labels,
             datasets: [{
-                label: 'Wind Chill (°F)',
-                data: historyData.map(item => item.wind_chill),
+                label: 'Wind Chill (°C)',
+                data: historyData.map(item => item.windChill),
                 borderColor: 'rgb(75, 192, 192)',
                 backgroundColor: windChillGradient,
                 tension: 0.4,
@@ -119,7 +119,7 @@ function initCharts(historyData) {
         data: {
             labels: labels,
             datasets: [{
-                label: 'Pressure (mbar)',
+                label: 'Pressure (hPa)',
                 data: historyData.map(item => item.pressure),
                 borderColor: 'rgb(54, 162, 235)',
                 backgroundColor: baroGradient,
@@ -152,8 +152,8 @@ function initCharts(historyData) {
         data: {
             labels: labels,
             datasets: [{
-                label: 'Rain (in)',
-                data: historyData.map(item => item.rain),
+                label: 'Rain (mm)',
+                data: historyData.map(item => item.rainFall),
                 backgroundColor: rainGradient,
                 borderColor: 'rgb(153, 102, 255)',
                 borderWidth: 1
@@ -184,12 +184,20 @@ function initCharts(historyData) {
         data: {
             labels: labels,
             datasets: [{
-                label: 'Wind Speed (mph)',
-                data: historyData.map(item => item.wind_speed),
+                label: 'Wind Speed (m/s)',
+                data: historyData.map(item => item.windSpeed),
                 borderColor: 'rgb(255, 159, 64)',
                 backgroundColor: windSpeedGradient,
                 tension: 0.4,
                 fill: true
+            }, {
+                label: 'Gust (m/s)',
+                data: historyData.map(item => item.windGust),
+                borderColor: 'rgb(255, 99, 132)',
+                borderDash: [4, 4],
+                pointRadius: 0,
+                tension: 0.4,
+                fill: false
             }]
         },
         options: {
@@ -216,8 +224,8 @@ function initCharts(historyData) {
         data: {
             datasets: [{
                 data: historyData.map(item => ({
-                    x: Math.cos(item.wind_direction * Math.PI / 180) * item.wind_speed,
-                    y: Math.sin(item.wind_direction * Math.PI / 180) * item.wind_speed
+                    x: Math.cos(item.windDirection * Math.PI / 180) * item.windSpeed,
+                    y: Math.sin(item.windDirection * Math.PI / 180) * item.windSpeed
                 })),
                 backgroundColor: 'rgb(75, 192, 192)',
                 pointRadius: 3
@@ -264,7 +272,7 @@ function initCharts(historyData) {
             labels: labels,
             datasets: [{
                 label: 'UV Index',
-                data: historyData.map(item => item.uv_index),
+                data: historyData.map(item => item.uvIndex),
                 borderColor: 'rgb(255, 206, 86)',
                 backgroundColor: uvGradient,
                 tension: 0.4,
@@ -324,16 +332,28 @@ function initCharts(historyData) {
 
 // Set up periodic refresh
 function setupRefresh() {
-    // Refresh data every 5 minutes
+    // Refresh data for the displayed station every 5 minutes
+    const station = encodeURIComponent(document.body.dataset.station || '');
     setInterval(() => {
-        fetch('/api/current')
+        fetch(`/api/current?station=${station}`)
             .then(response => response.json())
             .then(data => {
                 updateCurrentValues(data);
             })
             .catch(error => console.error('Error fetching current data:', error));
+
+        if (document.getElementById('indoor')) {
+            fetch(`/api/indoor?station=${station}`)
+                .then(response => response.json())
+                .then(data => {
+                    if (data) {
+                        updateIndoorValues(data);
+                    }
+                })
+                .catch(error => console.error('Error fetching indoor data:', error));
+        }
             
-        fetch('/api/history')
+        fetch(`/api/history?station=${station}`)
             .then(response => response.json())
             .then(data => {
                 // Update charts with new data
@@ -346,16 +366,40 @@ function setupRefresh() {
 // Update current values on the dashboard
 function updateCurrentValues(data) {
     // Update all the dashboard panels with current data
-    document.querySelector('.panel:nth-child(1) .current-value').textContent = `${data.temperature.toFixed(1)}°F`;
+    document.querySelector('.panel:nth-child(1) .current-value').textContent = `${data.temperature.toFixed(1)}°C`;
     document.querySelector('.panel:nth-child(2) .current-value').textContent = `${Math.round(data.humidity)}%`;
-    document.querySelector('.panel:nth-child(3) .current-value').textContent = `${data.pressure.toFixed(1)} mbar`;
+    document.querySelector('.panel:nth-child(3) .current-value').textContent = `${data.pressure.toFixed(1)} hPa`;
     document.querySelector('.panel:nth-child(4) .current-value').textContent = 
-        `${data.wind_speed.toFixed(1)} mph ${getWindDirection(data.wind_direction)}`;
-    document.querySelector('.panel:nth-child(5) .current-value').textContent = `${data.wind_chill.toFixed(1)}°F`;
-    document.querySelector('.panel:nth-child(6) .current-value').textContent = `${data.heat_index.toFixed(1)}°F`;
-    document.querySelector('.panel:nth-child(7) .current-value').textContent = `${data.dew_point.toFixed(1)}°F`;
-    document.querySelector('.panel:nth-child(8) .current-value').textContent = `${data.uv_index.toFixed(1)}`;
-    document.querySelector('.panel:nth-child(9) .current-value').textContent = `${data.rain.toFixed(2)} in`;
+        `${data.windSpeed.toFixed(1)} m/s ${getWindDirection(data.windDirection)}`;
+    document.querySelector('.panel:nth-child(4) .wind-gust').textContent = `Gust: ${data.windGust.toFixed(1)} m/s`;
+    document.querySelector('.panel:nth-child(4) .wind-avg').textContent =
+        `2 min: ${data.windAvg2m.toFixed(1)} m/s, 10 min: ${data.windAvg10m.toFixed(1)} m/s ${getWindDirection(data.windDirAvg10m)}`;
+    document.querySelector('.panel:nth-child(5) .current-value').textContent = `${data.windChill.toFixed(1)}°C`;
+    document.querySelector('.panel:nth-child(6) .current-value').textContent = `${data.heatIndex.toFixed(1)}°C`;
+    document.querySelector('.panel:nth-child(7) .current-value').textContent = `${data.dewPoint.toFixed(1)}°C`;
+    document.querySelector('.panel:nth-child(8) .current-value').textContent = `${data.uvIndex.toFixed(1)}`;
+    document.querySelector('.panel:nth-child(9) .current-value').textContent = `${data.rainRate.toFixed(1)} mm/h`;
+    document.querySelector('.panel:nth-child(10) .current-value').textContent = `${data.rainDay.toFixed(1)} mm`;
+    document.querySelector('.panel:nth-child(11) .current-value').textContent = `${data.peakGustDay.toFixed(1)} m/s`;
+    document.querySelector('.panel:nth-child(11) .peak-day').textContent =
+        data.peakGustDayTime ? `At ${formatTimestamp(data.peakGustDayTime)}` : '';
+    document.querySelector('.panel:nth-child(11) .peak-hour').textContent =
+        `This hour: ${data.peakGustHour.toFixed(1)} m/s` + (data.peakGustHourTime ? ` at ${formatTimestamp(data.peakGustHourTime)}` : '');
+}
+
+// Update the indoor panels with the latest indoor data
+function updateIndoorValues(data) {
+    const panels = document.querySelectorAll('#indoor .panel');
+    panels[0].querySelector('.current-value').textContent = `${data.temperature.toFixed(1)}°C`;
+    panels[0].querySelector('.high-low span').textContent = `Dew point: ${data.dewPoint.toFixed(1)}°C`;
+    panels[1].querySelector('.current-value').textContent = `${Math.round(data.humidity)}%`;
+    panels[1].querySelector('.high-low span').textContent = `Absolute: ${data.absoluteHumidity.toFixed(1)} g/m³`;
+    if (panels[2] && data.co2 !== undefined) {
+        panels[2].querySelector('.current-value').textContent = `${Math.round(data.co2)} ppm`;
+        if (data.co2Avg24h !== undefined) {
+            panels[2].querySelector('.high-low span').textContent = `24 hour average: ${Math.round(data.co2Avg24h)} ppm`;
+        }
+    }
 }
 
 // Call setup functions when DOM is ready